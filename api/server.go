@@ -2,9 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/gregtusar/basis/internal/storage"
 	"github.com/gregtusar/basis/pkg/models"
 	"github.com/gregtusar/basis/pkg/trader"
 	"github.com/sirupsen/logrus"
@@ -12,13 +15,15 @@ import (
 
 type Server struct {
 	trader *trader.BasisTrader
+	store  storage.Repository
 	logger *logrus.Logger
 	port   string
 }
 
-func NewServer(trader *trader.BasisTrader, logger *logrus.Logger, port string) *Server {
+func NewServer(trader *trader.BasisTrader, store storage.Repository, logger *logrus.Logger, port string) *Server {
 	return &Server{
 		trader: trader,
+		store:  store,
 		logger: logger,
 		port:   port,
 	}
@@ -30,9 +35,17 @@ func (s *Server) Start() error {
 	// API endpoints
 	mux.HandleFunc("/api/health", s.handleHealth)
 	mux.HandleFunc("/api/basis/snapshots", s.handleBasisSnapshots)
+	mux.HandleFunc("/api/basis/history", s.handleBasisHistory)
 	mux.HandleFunc("/api/strategies", s.handleStrategies)
+	mux.HandleFunc("/api/strategy-types", s.handleStrategyTypes)
 	mux.HandleFunc("/api/positions", s.handlePositions)
 	mux.HandleFunc("/api/trades", s.handleTrades)
+	mux.HandleFunc("/api/ratelimits", s.handleRateLimits)
+	mux.HandleFunc("/api/orderbook", s.handleOrderBook)
+	mux.HandleFunc("/api/reconcile", s.handleReconcile)
+	mux.HandleFunc("/api/funding", s.handleFunding)
+	mux.HandleFunc("/api/instruments", s.handleInstruments)
+	mux.HandleFunc("/api/term-structure", s.handleTermStructure)
 	
 	// Enable CORS for Streamlit
 	handler := corsMiddleware(mux)
@@ -57,9 +70,25 @@ func corsMiddleware(next http.Handler) http.Handler {
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	feeds := s.trader.GetFeedStatus()
+
+	status := "healthy"
+	for _, feedStatus := range feeds {
+		if feedStatus != trader.FeedStatusUp {
+			status = "degraded"
+		}
+	}
+
+	tradingEnabled := s.trader.TradingEnabled()
+	if !tradingEnabled {
+		status = "degraded"
+	}
+
 	response := map[string]interface{}{
-		"status":    "healthy",
-		"timestamp": time.Now().UTC(),
+		"status":          status,
+		"feeds":           feeds,
+		"trading_enabled": tradingEnabled,
+		"timestamp":       time.Now().UTC(),
 	}
 	
 	s.writeJSON(w, http.StatusOK, response)
@@ -78,8 +107,13 @@ func (s *Server) handleBasisSnapshots(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleStrategies(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		// TODO: Implement get strategies
-		s.writeJSON(w, http.StatusOK, []models.BasisStrategy{})
+		strategies, err := s.store.ListStrategies(r.Context())
+		if err != nil {
+			s.logger.WithError(err).Error("Failed to list strategies")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, strategies)
 		
 	case http.MethodPost:
 		var strategy models.BasisStrategy
@@ -104,14 +138,23 @@ func (s *Server) handleStrategies(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleStrategyTypes returns the signal models strategies can pick by Type
+func (s *Server) handleStrategyTypes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, trader.StrategyTypes())
+}
+
 func (s *Server) handlePositions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	
-	// TODO: Implement get positions from trader
-	s.writeJSON(w, http.StatusOK, []models.Position{})
+	s.writeJSON(w, http.StatusOK, s.trader.GetPositionReport())
 }
 
 func (s *Server) handleTrades(w http.ResponseWriter, r *http.Request) {
@@ -120,8 +163,201 @@ func (s *Server) handleTrades(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// TODO: Implement get trades history
-	s.writeJSON(w, http.StatusOK, []models.BasisTrade{})
+	if id := r.URL.Query().Get("id"); id != "" {
+		// Live state first; the store holds trades from earlier runs
+		if trade, ok := s.trader.GetTrade(id); ok {
+			s.writeJSON(w, http.StatusOK, trade)
+			return
+		}
+
+		trade, err := s.store.GetTrade(r.Context(), id)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "trade not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.logger.WithError(err).Error("Failed to get trade")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, trade)
+		return
+	}
+
+	limit, err := queryInt(r, "limit", 100)
+	if err != nil {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	trades, err := s.store.ListTrades(r.Context(), storage.TradeQuery{
+		StrategyID: r.URL.Query().Get("strategy_id"),
+		Limit:      limit,
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list trades")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, trades)
+}
+
+func (s *Server) handleBasisHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := storage.SnapshotQuery{StrategyID: r.URL.Query().Get("strategy_id")}
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid since, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		query.Since = since
+	}
+
+	limit, err := queryInt(r, "limit", 1000)
+	if err != nil {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+	query.Limit = limit
+
+	snapshots, err := s.store.ListSnapshots(r.Context(), query)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list basis history")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, snapshots)
+}
+
+// handleFunding returns the latest funding rates of strategy futures and the
+// funding payments booked to strategies. Payments marked Estimated were
+// worked out from predicted rates, not reported by the exchange.
+func (s *Server) handleFunding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := storage.FundingQuery{StrategyID: r.URL.Query().Get("strategy_id")}
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid since, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		query.Since = since
+	}
+
+	payments, err := s.store.ListFundingPayments(r.Context(), query)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list funding payments")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rates":    s.trader.GetFundingRates(),
+		"payments": payments,
+	})
+}
+
+// handleInstruments returns the products the exchanges list, or one product
+// by symbol
+func (s *Server) handleInstruments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if symbol := r.URL.Query().Get("symbol"); symbol != "" {
+		s.writeJSON(w, http.StatusOK, s.trader.GetInstrument(symbol))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.trader.GetInstruments())
+}
+
+// handleTermStructure returns the basis of every listed future on an
+// underlying against a spot product
+func (s *Server) handleTermStructure(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	underlying, spot := r.URL.Query().Get("underlying"), r.URL.Query().Get("spot")
+	if underlying == "" || spot == "" {
+		http.Error(w, "underlying and spot are required", http.StatusBadRequest)
+		return
+	}
+
+	ts, err := s.trader.GetTermStructure(r.Context(), underlying, spot)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to get term structure")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, ts)
+}
+
+func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		result, ok := s.trader.GetReconcileResult()
+		if !ok {
+			http.Error(w, "reconciliation has not run", http.StatusNotFound)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, result)
+
+	case http.MethodPost:
+		// Accepting positions records futures mismatches as external
+		// inventory rather than halting trading on them
+		accept := r.URL.Query().Get("accept_positions") == "true"
+		s.writeJSON(w, http.StatusOK, s.trader.Reconcile(r.Context(), accept))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleRateLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.trader.GetRateLimitStats())
+}
+
+func (s *Server) handleOrderBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	symbol := r.URL.Query().Get("symbol")
+	if symbol == "" {
+		http.Error(w, "symbol is required", http.StatusBadRequest)
+		return
+	}
+
+	depth, err := queryInt(r, "depth", 10)
+	if err != nil {
+		http.Error(w, "invalid depth", http.StatusBadRequest)
+		return
+	}
+
+	book, ok := s.trader.GetOrderBook(symbol, depth)
+	if !ok {
+		http.Error(w, "order book unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, book)
 }
 
 func (s *Server) writeJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -132,6 +368,15 @@ func (s *Server) writeJSON(w http.ResponseWriter, status int, data interface{})
 	}
 }
 
+// queryInt reads an integer query parameter, returning def if it is absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
 func generateID() string {
 	return time.Now().Format("20060102150405")
 }
\ No newline at end of file
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gregtusar/basis/internal/config"
+	"github.com/gregtusar/basis/internal/storage"
+	"github.com/gregtusar/basis/pkg/backtest"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/gregtusar/basis/pkg/recording"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type backtestOptions struct {
+	data         string
+	recording    []string
+	fromDB       bool
+	strategyID   string
+	spotSymbol   string
+	futureSymbol string
+	targetBasis  float64
+	signal       string
+	targetCarry  float64
+	targetAnnual float64
+	rollDays     float64
+	strategyType string
+	params       string
+	minTradeSize float64
+	maxPosition  float64
+	rebalance    float64
+	chunk        float64
+	depth        float64
+	from         string
+	to           string
+	asJSON       bool
+	verbose      bool
+}
+
+func newBacktestCmd() *cobra.Command {
+	opts := &backtestOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "backtest",
+		Short: "Replay historical prices through a strategy and report its performance",
+		Long: `Replays spot and perpetual ticker data through the trader's strategy and
+execution logic in simulated time, filling orders against the replayed books
+with the paper-trading simulator. Data comes from a CSV file (--data), a
+market data recording made with "record" (--recording), or the basis history
+recorded in the database (--db).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBacktest(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.data, "data", "", "CSV of timestamp,symbol,bid,ask,last,bid_size,ask_size,funding_rate")
+	flags.StringSliceVar(&opts.recording, "recording", nil, "market data recording files or directories")
+	flags.BoolVar(&opts.fromDB, "db", false, "replay basis history recorded in the database")
+	flags.StringVar(&opts.strategyID, "strategy-id", "", "persisted strategy to test; its history is replayed with --db")
+	flags.StringVar(&opts.spotSymbol, "spot", "", "spot symbol")
+	flags.StringVar(&opts.futureSymbol, "future", "", "future symbol")
+	flags.Float64Var(&opts.targetBasis, "target-basis", 0, "basis percent to enter at (default trading.default_target_basis)")
+	flags.StringVar(&opts.signal, "signal", "", `enter on "basis" percent, expected annualized "carry" or "annualized_basis"`)
+	flags.Float64Var(&opts.targetCarry, "target-carry", 0, "annualized carry percent to enter at with --signal carry (default trading.default_target_carry)")
+	flags.Float64Var(&opts.targetAnnual, "target-annualized-basis", 0, "annualized basis percent to enter at with --signal annualized_basis (default trading.default_target_annualized_basis)")
+	flags.StringVar(&opts.strategyType, "type", "", "signal model to run, from those the trader registers (default threshold)")
+	flags.StringVar(&opts.params, "params", "", "signal model parameters, as JSON")
+	flags.Float64Var(&opts.rollDays, "roll-days", 0, "days before a dated future expires to roll into the next; 0 for the trader default")
+	flags.Float64Var(&opts.minTradeSize, "min-trade-size", 0, "size entry and exit signals are priced at (default trading.default_min_trade_size)")
+	flags.Float64Var(&opts.maxPosition, "max-position", 0, "largest position per leg (default trading.default_max_position)")
+	flags.Float64Var(&opts.rebalance, "rebalance-threshold", 0, "drift from the model's target, as a fraction of max position, before trading back (default trading.rebalance_threshold)")
+	flags.Float64Var(&opts.chunk, "rebalance-chunk", 0, "largest trade per step while rebalancing; 0 for the min trade size")
+	flags.Float64Var(&opts.depth, "depth", 0, "size at the touch when the data has none; 0 for unlimited")
+	flags.StringVar(&opts.from, "from", "", "replay from this RFC 3339 time")
+	flags.StringVar(&opts.to, "to", "", "replay up to this RFC 3339 time")
+	flags.BoolVar(&opts.asJSON, "json", false, "write the report as JSON")
+	flags.BoolVar(&opts.verbose, "verbose", false, "log trader activity to stderr")
+
+	return cmd
+}
+
+func runBacktest(ctx context.Context, opts *backtestOptions) error {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	from, err := parseOptionalTime(opts.from)
+	if err != nil {
+		return fmt.Errorf("invalid --from: %w", err)
+	}
+	to, err := parseOptionalTime(opts.to)
+	if err != nil {
+		return fmt.Errorf("invalid --to: %w", err)
+	}
+
+	run := backtest.Config{
+		SpotFees: backtest.Fees{
+			Maker: cfg.Coinbase.Paper.Spot.MakerFee,
+			Taker: cfg.Coinbase.Paper.Spot.TakerFee,
+		},
+		FutureFees: backtest.Fees{
+			Maker: cfg.Coinbase.Paper.Derivatives.MakerFee,
+			Taker: cfg.Coinbase.Paper.Derivatives.TakerFee,
+		},
+		OrderTimeout:       time.Duration(cfg.Trading.OrderTimeout) * time.Second,
+		MaxSlippage:        cfg.Trading.MaxSlippage,
+		RebalanceThreshold: cfg.Trading.RebalanceThreshold,
+		Depth:              opts.depth,
+		Carry:              carryModel(cfg.Trading.Carry),
+	}
+	run.Strategy.TargetBasis = cfg.Trading.DefaultTargetBasis
+	run.Strategy.TargetCarry = cfg.Trading.DefaultTargetCarry
+	run.Strategy.TargetAnnualizedBasis = cfg.Trading.DefaultTargetAnnualizedBasis
+	run.Strategy.MinTradeSize = cfg.Trading.DefaultMinTradeSize
+	run.Strategy.MaxPosition = cfg.Trading.DefaultMaxPosition
+
+	sources := 0
+	for _, set := range []bool{opts.data != "", len(opts.recording) > 0, opts.fromDB} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("--data, --recording and --db are mutually exclusive")
+	}
+
+	var events []backtest.Event
+	switch {
+	case opts.data != "":
+		f, err := os.Open(opts.data)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if events, err = backtest.LoadCSV(f); err != nil {
+			return err
+		}
+	case len(opts.recording) > 0:
+		reader, err := recording.Open(opts.recording...)
+		if err != nil {
+			return fmt.Errorf("failed to open recording: %w", err)
+		}
+		defer reader.Close()
+
+		if events, err = backtest.LoadRecording(reader); err != nil {
+			return err
+		}
+	case opts.fromDB:
+		store, err := storage.OpenSQLite(ctx, cfg.Database.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		defer store.Close()
+
+		if opts.strategyID == "" {
+			return fmt.Errorf("--db needs --strategy-id")
+		}
+		strategies, err := store.ListStrategies(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range strategies {
+			if s.ID == opts.strategyID {
+				run.Strategy = s
+			}
+		}
+		if run.Strategy.ID == "" {
+			return fmt.Errorf("strategy %s not found", opts.strategyID)
+		}
+
+		snapshots, err := store.ListSnapshots(ctx, storage.SnapshotQuery{StrategyID: opts.strategyID, Since: from})
+		if err != nil {
+			return err
+		}
+		events = backtest.EventsFromSnapshots(snapshots)
+	default:
+		return fmt.Errorf("one of --data, --recording or --db is required")
+	}
+	events = backtest.FilterEvents(events, from, to)
+
+	if opts.spotSymbol != "" {
+		run.Strategy.SpotSymbol = opts.spotSymbol
+	}
+	if opts.futureSymbol != "" {
+		run.Strategy.FutureSymbol = opts.futureSymbol
+	}
+	if opts.targetBasis != 0 {
+		run.Strategy.TargetBasis = opts.targetBasis
+	}
+	if opts.signal != "" {
+		run.Strategy.EntrySignal = models.EntrySignal(opts.signal)
+	}
+	if opts.targetCarry != 0 {
+		run.Strategy.TargetCarry = opts.targetCarry
+	}
+	if opts.targetAnnual != 0 {
+		run.Strategy.TargetAnnualizedBasis = opts.targetAnnual
+	}
+	run.Strategy.RollDays = opts.rollDays
+	if opts.strategyType != "" {
+		run.Strategy.Type = opts.strategyType
+	}
+	if opts.params != "" {
+		run.Strategy.Params = json.RawMessage(opts.params)
+	}
+	if opts.minTradeSize != 0 {
+		run.Strategy.MinTradeSize = opts.minTradeSize
+	}
+	if opts.maxPosition != 0 {
+		run.Strategy.MaxPosition = opts.maxPosition
+	}
+	if opts.rebalance != 0 {
+		run.Strategy.RebalanceThreshold = opts.rebalance
+	}
+	if opts.chunk != 0 {
+		run.Strategy.RebalanceChunk = opts.chunk
+	}
+
+	if opts.verbose {
+		run.Logger = logrus.New()
+		run.Logger.SetOutput(os.Stderr)
+	}
+
+	report, err := backtest.Run(ctx, run, events)
+	if err != nil {
+		return err
+	}
+
+	if opts.asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return report.WriteText(os.Stdout)
+}
+
+func parseOptionalTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
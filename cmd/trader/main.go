@@ -6,9 +6,11 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gregtusar/basis/api"
 	"github.com/gregtusar/basis/internal/config"
+	"github.com/gregtusar/basis/internal/storage"
 	"github.com/gregtusar/basis/pkg/coinbase"
 	"github.com/gregtusar/basis/pkg/trader"
 	"github.com/sirupsen/logrus"
@@ -29,6 +31,9 @@ func main() {
 	}
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
+	rootCmd.AddCommand(newBacktestCmd())
+	rootCmd.AddCommand(newRecordCmd())
+	rootCmd.AddCommand(newReplayCmd())
 	
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -60,15 +65,26 @@ func runTrader(cmd *cobra.Command, args []string) {
 	defer cancel()
 	
 	// Initialize Coinbase clients
+	rateLimits := rateLimitsFromConfig(cfg.Coinbase.RateLimits)
+	retryPolicy := coinbase.RetryPolicy{
+		MaxAttempts: cfg.Coinbase.Retry.MaxAttempts,
+		BaseDelay:   time.Duration(cfg.Coinbase.Retry.BaseDelayMs) * time.Millisecond,
+		MaxDelay:    time.Duration(cfg.Coinbase.Retry.MaxDelayMs) * time.Millisecond,
+	}
+
 	spotClient := coinbase.NewPrimeClient(
 		cfg.Coinbase.Spot.APIKey,
 		cfg.Coinbase.Spot.APISecret,
 		cfg.Coinbase.Spot.Passphrase,
+		cfg.Coinbase.Spot.PortfolioID,
 		cfg.Coinbase.Spot.Sandbox,
 	)
+	spotClient.SetRateLimiter(coinbase.NewRateLimiter(rateLimits))
+	spotClient.SetRetryPolicy(retryPolicy)
 	
 	// Create derivatives client based on auth type
 	var derivativesClient coinbase.Client
+	var derivativesAuth coinbase.Authenticator
 	if cfg.Coinbase.Derivatives.AuthType == "jwt" {
 		// Use JWT authentication
 		client, err := coinbase.NewAdvancedTradeClientJWT(
@@ -79,19 +95,101 @@ func runTrader(cmd *cobra.Command, args []string) {
 		if err != nil {
 			logger.WithError(err).Fatal("Failed to create JWT derivatives client")
 		}
+		client.SetRateLimiter(coinbase.NewRateLimiter(rateLimits))
+		client.SetRetryPolicy(retryPolicy)
 		derivativesClient = client
+		derivativesAuth = client.Authenticator()
 	} else {
 		// Use legacy authentication
-		derivativesClient = coinbase.NewAdvancedTradeClient(
+		client := coinbase.NewAdvancedTradeClient(
 			cfg.Coinbase.Derivatives.APIKey,
 			cfg.Coinbase.Derivatives.APISecret,
 			cfg.Coinbase.Derivatives.Passphrase,
 			cfg.Coinbase.Derivatives.Sandbox,
 		)
+		client.SetRateLimiter(coinbase.NewRateLimiter(rateLimits))
+		client.SetRetryPolicy(retryPolicy)
+		derivativesClient = client
+		derivativesAuth = client.Authenticator()
 	}
 	
+	// Simulate orders on paper legs; market data still comes from the exchange
+	var spotLeg coinbase.Client = spotClient
+	paperLatency := time.Duration(cfg.Coinbase.Paper.LatencyMs) * time.Millisecond
+	if paper := cfg.Coinbase.Paper.Spot; paper.Enabled {
+		spotLeg = coinbase.NewPaperClient(spotClient, coinbase.PaperConfig{
+			MakerFee: paper.MakerFee,
+			TakerFee: paper.TakerFee,
+			Latency:  paperLatency,
+		})
+		logger.Warn("Paper trading spot leg, orders will not be sent")
+	}
+	if paper := cfg.Coinbase.Paper.Derivatives; paper.Enabled {
+		derivativesClient = coinbase.NewPaperClient(derivativesClient, coinbase.PaperConfig{
+			MakerFee: paper.MakerFee,
+			TakerFee: paper.TakerFee,
+			Latency:  paperLatency,
+		})
+		// The user channel only reports exchange orders
+		derivativesAuth = nil
+		logger.Warn("Paper trading derivatives leg, orders will not be sent")
+	}
+
+	// Create market data websocket, supervised with the configured reconnect policy
+	wsClient := coinbase.NewWebSocketClient(cfg.Coinbase.WebSocket.URL, nil, logger)
+	wsClient.SetReconnectPolicy(
+		time.Duration(cfg.Coinbase.WebSocket.ReconnectDelay)*time.Second,
+		cfg.Coinbase.WebSocket.MaxReconnects,
+	)
+
 	// Create basis trader
-	basisTrader := trader.NewBasisTrader(spotClient, derivativesClient, logger)
+	basisTrader := trader.NewBasisTrader(spotLeg, derivativesClient, logger)
+	basisTrader.SetOrderTimeout(time.Duration(cfg.Trading.OrderTimeout) * time.Second)
+	basisTrader.SetMaxSlippage(cfg.Trading.MaxSlippage)
+	basisTrader.SetRebalanceThreshold(cfg.Trading.RebalanceThreshold)
+	basisTrader.SetMarketDataFeed(wsClient, cfg.Coinbase.WebSocket.TickerChannel)
+
+	// The Exchange feed does not list derivatives, so stream them from Advanced Trade
+	if wsClient.Feed() != coinbase.FeedAdvancedTrade && cfg.Coinbase.WebSocket.DerivativesURL != "" {
+		derivativesWS := coinbase.NewWebSocketClient(cfg.Coinbase.WebSocket.DerivativesURL, nil, logger)
+		derivativesWS.SetReconnectPolicy(
+			time.Duration(cfg.Coinbase.WebSocket.ReconnectDelay)*time.Second,
+			cfg.Coinbase.WebSocket.MaxReconnects,
+		)
+		if err := basisTrader.SetDerivativesMarketDataFeed(derivativesWS); err != nil {
+			logger.WithError(err).Fatal("Invalid derivatives market data configuration")
+		}
+	}
+	basisTrader.SetCarryModel(carryModel(cfg.Trading.Carry))
+
+	// Persist state to SQLite; without it nothing survives a restart
+	store, err := storage.OpenSQLite(ctx, cfg.Database.Path)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to open database")
+	}
+	defer store.Close()
+
+	basisTrader.SetStore(store)
+	basisTrader.SetSnapshotInterval(time.Duration(cfg.Database.SnapshotInterval) * time.Second)
+	if err := basisTrader.SetOrphanPolicy(trader.OrphanPolicy(cfg.Trading.OrphanOrderPolicy)); err != nil {
+		logger.WithError(err).Fatal("Invalid trading configuration")
+	}
+
+	// The Advanced Trade user channel only accepts JWTs and the Exchange one
+	// legacy keys; without a user channel orders are polled
+	if derivativesAuth != nil && cfg.Coinbase.WebSocket.UserURL != "" {
+		userWS := coinbase.NewWebSocketClient(cfg.Coinbase.WebSocket.UserURL, derivativesAuth, logger)
+		if err := userWS.CheckAuth(); err != nil {
+			logger.WithError(err).Fatal("Derivatives credentials cannot authenticate the user channel, set auth_type to jwt or clear websocket.user_url")
+		}
+		userWS.SetReconnectPolicy(
+			time.Duration(cfg.Coinbase.WebSocket.ReconnectDelay)*time.Second,
+			cfg.Coinbase.WebSocket.MaxReconnects,
+		)
+		basisTrader.SetUserFeed(userWS)
+	} else {
+		logger.Info("User channel unavailable, order status will be polled over REST")
+	}
 	
 	// Start the trader
 	if err := basisTrader.Start(ctx); err != nil {
@@ -99,7 +197,7 @@ func runTrader(cmd *cobra.Command, args []string) {
 	}
 	
 	// Start API server
-	apiServer := api.NewServer(basisTrader, logger, fmt.Sprintf("%d", cfg.Server.Port))
+	apiServer := api.NewServer(basisTrader, store, logger, fmt.Sprintf("%d", cfg.Server.Port))
 	go func() {
 		if err := apiServer.Start(); err != nil {
 			logger.WithError(err).Fatal("Failed to start API server")
@@ -120,4 +218,30 @@ func runTrader(cmd *cobra.Command, args []string) {
 	cancel()
 	
 	logger.Info("Basis trader stopped")
-}
\ No newline at end of file
+}
+
+func rateLimitsFromConfig(cfg config.RateLimitConfig) coinbase.RateLimits {
+	return coinbase.RateLimits{
+		Public: coinbase.EndpointRate{
+			RequestsPerSecond: cfg.Public.RequestsPerSecond,
+			Burst:             cfg.Public.Burst,
+		},
+		Private: coinbase.EndpointRate{
+			RequestsPerSecond: cfg.Private.RequestsPerSecond,
+			Burst:             cfg.Private.Burst,
+		},
+		Orders: coinbase.EndpointRate{
+			RequestsPerSecond: cfg.Orders.RequestsPerSecond,
+			Burst:             cfg.Orders.Burst,
+		},
+		MaxBackoff: time.Duration(cfg.MaxBackoff) * time.Second,
+	}
+}
+
+func carryModel(cfg config.CarryConfig) trader.CarryModel {
+	return trader.CarryModel{
+		Horizon:   time.Duration(cfg.HorizonHours) * time.Hour,
+		SpotFee:   cfg.SpotFee,
+		FutureFee: cfg.FutureFee,
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gregtusar/basis/internal/config"
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/recording"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// recordFlushInterval bounds how much of a recording a crash can lose
+const recordFlushInterval = time.Second
+
+type recordOptions struct {
+	dir      string
+	prefix   string
+	products []string
+}
+
+func newRecordCmd() *cobra.Command {
+	opts := &recordOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "record",
+		Short: "Record raw market data from the websocket feed to rotating files",
+		Long: `Subscribes to the ticker, level2 and matches channels of the market data
+websocket and writes every message, with its receive time and a sequence
+number, to gzip-compressed files that rotate by size and age. Recordings can
+be played back with "replay" or tested against with "backtest --recording".`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRecord(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.dir, "dir", "", "directory to write to (default recorder.dir)")
+	flags.StringVar(&opts.prefix, "prefix", "marketdata", "file name prefix")
+	flags.StringSliceVar(&opts.products, "product", nil, "product to record, repeatable (default recorder.products)")
+
+	return cmd
+}
+
+func runRecord(ctx context.Context, opts *recordOptions) error {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	if level, err := logrus.ParseLevel(cfg.Logging.Level); err == nil {
+		logger.SetLevel(level)
+	}
+
+	dir, products := cfg.Recorder.Dir, cfg.Recorder.Products
+	if opts.dir != "" {
+		dir = opts.dir
+	}
+	if len(opts.products) > 0 {
+		products = opts.products
+	}
+	if len(products) == 0 {
+		return fmt.Errorf("no products to record, set recorder.products or --product")
+	}
+
+	ws := coinbase.NewWebSocketClient(cfg.Coinbase.WebSocket.URL, nil, logger)
+	ws.SetReconnectPolicy(
+		time.Duration(cfg.Coinbase.WebSocket.ReconnectDelay)*time.Second,
+		cfg.Coinbase.WebSocket.MaxReconnects,
+	)
+
+	tickerChannel := cfg.Coinbase.WebSocket.TickerChannel
+	if tickerChannel == "" {
+		tickerChannel = "ticker"
+	}
+	channels := []string{tickerChannel, ws.Level2Channel(), ws.MatchesChannel()}
+
+	writer, err := recording.NewWriter(recording.WriterConfig{
+		Dir:      dir,
+		Prefix:   opts.prefix,
+		MaxBytes: int64(cfg.Recorder.MaxFileMB) << 20,
+		MaxAge:   time.Duration(cfg.Recorder.MaxFileMinutes) * time.Minute,
+		URL:      cfg.Coinbase.WebSocket.URL,
+		Channels: channels,
+		Products: products,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := writer.Close(); err != nil {
+			logger.WithError(err).Error("Failed to close recording")
+		}
+	}()
+
+	ws.OnMessage(func(received time.Time, msg coinbase.WSMessage) {
+		err := writer.Write(recording.Record{
+			Time:      received,
+			Channel:   msg.Key(),
+			ProductID: msg.ProductID,
+			Message:   msg.Message,
+		})
+		if err != nil {
+			logger.WithError(err).Error("Failed to record message")
+		}
+	})
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := ws.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect market data feed: %w", err)
+	}
+	defer ws.Close()
+	if err := ws.Subscribe(channels, products); err != nil {
+		return fmt.Errorf("failed to subscribe to market data: %w", err)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"dir":      dir,
+		"url":      cfg.Coinbase.WebSocket.URL,
+		"channels": channels,
+		"products": products,
+	}).Info("Recording market data. Press Ctrl+C to stop.")
+
+	flush := time.NewTicker(recordFlushInterval)
+	defer flush.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.WithField("records", writer.Seq()).Info("Recording stopped")
+			return nil
+		case event := <-ws.Events():
+			entry := logger.WithField("event", event.Type)
+			if event.Err != nil {
+				entry = entry.WithError(event.Err)
+			}
+			switch event.Type {
+			case coinbase.EventFatal:
+				entry.Error("Market data feed failed permanently")
+				return fmt.Errorf("market data feed failed: %w", event.Err)
+			case coinbase.EventDisconnected:
+				entry.Warn("Market data feed disconnected, recording will have a gap")
+			default:
+				entry.Info("Market data feed connection event")
+			}
+		case <-flush.C:
+			if err := writer.Flush(); err != nil {
+				logger.WithError(err).Error("Failed to flush recording")
+			}
+		}
+	}
+}
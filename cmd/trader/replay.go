@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/clock"
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/gregtusar/basis/pkg/recording"
+	"github.com/gregtusar/basis/pkg/trader"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type replayOptions struct {
+	speed        float64
+	interval     time.Duration
+	spotSymbol   string
+	futureSymbol string
+	from         string
+	verbose      bool
+}
+
+func newReplayCmd() *cobra.Command {
+	opts := &replayOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "replay <recording>...",
+		Short: "Play a market data recording back through the trader's market data handling",
+		Long: `Feeds recorded websocket messages through the same ticker and level2
+handlers the trader uses, on a clock set to each message's receive time, and
+prints the top of book for every recorded product at a fixed interval of
+recorded time. With --spot and --future the basis between them is printed as
+the trader would have calculated it. Arguments are recording files or
+directories of them.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runReplay(cmd.Context(), args, opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.Float64Var(&opts.speed, "speed", 1, "multiple of real time to replay at; 0 for as fast as possible")
+	flags.DurationVar(&opts.interval, "interval", time.Second, "recorded time between printed snapshots")
+	flags.StringVar(&opts.spotSymbol, "spot", "", "spot symbol to calculate basis for")
+	flags.StringVar(&opts.futureSymbol, "future", "", "future symbol to calculate basis for")
+	flags.StringVar(&opts.from, "from", "", "fast-forward through messages received before this RFC 3339 time")
+	flags.BoolVar(&opts.verbose, "verbose", false, "log market data handling to stderr")
+
+	return cmd
+}
+
+func runReplay(ctx context.Context, paths []string, opts *replayOptions) error {
+	from, err := parseOptionalTime(opts.from)
+	if err != nil {
+		return fmt.Errorf("invalid --from: %w", err)
+	}
+	if (opts.spotSymbol == "") != (opts.futureSymbol == "") {
+		return fmt.Errorf("--spot and --future must be given together")
+	}
+
+	reader, err := recording.Open(paths...)
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer reader.Close()
+	header := reader.Header()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	if opts.verbose {
+		logger.SetOutput(os.Stderr)
+	}
+
+	// A market-data-only trader: it holds no clients and is never started
+	clk := clock.NewSimulated(header.Created)
+	ws := coinbase.NewWebSocketClient(header.URL, nil, logger)
+	bt := trader.NewBasisTrader(nil, nil, logger)
+	bt.SetClock(clk)
+	bt.SetMarketDataSource(ws)
+	if opts.spotSymbol != "" {
+		strategy := &models.BasisStrategy{
+			ID:           "replay",
+			SpotSymbol:   opts.spotSymbol,
+			FutureSymbol: opts.futureSymbol,
+		}
+		if err := bt.AddStrategy(strategy); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf(replayRowFormat, "Time", "Product", "Bid", "Bid size", "Ask", "Ask size", "Basis %")
+
+	var next time.Time
+	config := recording.ReplayConfig{Speed: opts.speed, From: from, Clock: clk}
+	stats, err := recording.Replay(ctx, reader, config, func(rec recording.Record) error {
+		ws.Dispatch(rec.Time, rec.Message)
+		if rec.Time.Before(from) {
+			return nil
+		}
+
+		if next.IsZero() {
+			next = rec.Time.Truncate(opts.interval).Add(opts.interval)
+		}
+		if rec.Time.Before(next) {
+			return nil
+		}
+		next = rec.Time.Truncate(opts.interval).Add(opts.interval)
+		printReplaySnapshot(os.Stdout, bt, header.Products, rec.Time)
+		return nil
+	})
+
+	fmt.Fprintf(os.Stderr, "Replayed %d records from %d files, %s to %s, %d sequence gaps\n",
+		stats.Records, len(reader.Files()), stats.Start.Format(time.RFC3339), stats.End.Format(time.RFC3339), stats.Gaps)
+	for _, path := range reader.Truncated() {
+		fmt.Fprintf(os.Stderr, "Warning: %s is truncated\n", path)
+	}
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// replayRowFormat lays out replay output in fixed columns, so rows line up
+// while they stream
+const replayRowFormat = "%-12s  %-24s  %14s  %12s  %14s  %12s  %8s\n"
+
+func printReplaySnapshot(w io.Writer, bt *trader.BasisTrader, products []string, now time.Time) {
+	ts := now.UTC().Format("15:04:05.000")
+	for _, product := range products {
+		book, ok := bt.GetOrderBook(product, 1)
+		if !ok || len(book.Bids) == 0 || len(book.Asks) == 0 {
+			fmt.Fprintf(w, replayRowFormat, ts, product, "-", "-", "-", "-", "")
+			continue
+		}
+		bid, ask := book.Bids[0], book.Asks[0]
+		fmt.Fprintf(w, replayRowFormat, ts, product,
+			formatFloat(bid.Price), formatFloat(bid.Size), formatFloat(ask.Price), formatFloat(ask.Size), "")
+	}
+	for _, basis := range bt.GetBasisSnapshots() {
+		fmt.Fprintf(w, replayRowFormat, ts, basis.SpotSymbol+" / "+basis.FutureSymbol,
+			"", "", "", "", fmt.Sprintf("%.4f", basis.BasisPercent))
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -15,6 +15,7 @@ type Config struct {
 	Coinbase CoinbaseConfig `mapstructure:"coinbase"`
 	Trading  TradingConfig  `mapstructure:"trading"`
 	Database DatabaseConfig `mapstructure:"database"`
+	Recorder RecorderConfig `mapstructure:"recorder"`
 	Logging  LoggingConfig  `mapstructure:"logging"`
 	GCP      GCPConfig      `mapstructure:"gcp"`
 }
@@ -28,13 +29,17 @@ type CoinbaseConfig struct {
 	Spot SpotConfig `mapstructure:"spot"`
 	Derivatives DerivativesConfig `mapstructure:"derivatives"`
 	WebSocket WebSocketConfig `mapstructure:"websocket"`
+	RateLimits RateLimitConfig `mapstructure:"rate_limits"`
+	Retry RetryConfig `mapstructure:"retry"`
+	Paper PaperConfig `mapstructure:"paper"`
 }
 
 type SpotConfig struct {
-	APIKey     string `mapstructure:"api_key"`
-	APISecret  string `mapstructure:"api_secret"`
-	Passphrase string `mapstructure:"passphrase"`
-	Sandbox    bool   `mapstructure:"sandbox"`
+	APIKey      string `mapstructure:"api_key"`
+	APISecret   string `mapstructure:"api_secret"`
+	Passphrase  string `mapstructure:"passphrase"`
+	PortfolioID string `mapstructure:"portfolio_id"` // Prime portfolio that orders and balances are scoped to
+	Sandbox     bool   `mapstructure:"sandbox"`
 }
 
 type DerivativesConfig struct {
@@ -55,19 +60,79 @@ type WebSocketConfig struct {
 	URL             string `mapstructure:"url"`
 	ReconnectDelay  int    `mapstructure:"reconnect_delay"`
 	MaxReconnects   int    `mapstructure:"max_reconnects"`
+	TickerChannel   string `mapstructure:"ticker_channel"` // "ticker" or "ticker_batch"
+	UserURL         string `mapstructure:"user_url"` // User channel: JWT auth for Advanced Trade, legacy for Exchange
+	DerivativesURL  string `mapstructure:"derivatives_url"` // Advanced Trade market data for futures when URL is an Exchange feed
+}
+
+// RateLimitConfig sets the client-side token buckets applied to each REST
+// client. Limits are per client, so spot and derivatives each get their own.
+type RateLimitConfig struct {
+	Public     EndpointLimit `mapstructure:"public"`
+	Private    EndpointLimit `mapstructure:"private"`
+	Orders     EndpointLimit `mapstructure:"orders"`
+	MaxBackoff int           `mapstructure:"max_backoff"` // seconds
+}
+
+type EndpointLimit struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+}
+
+// RetryConfig controls retries of transient REST failures. Order placement
+// is only retried under the same client order ID.
+type RetryConfig struct {
+	MaxAttempts int `mapstructure:"max_attempts"`
+	BaseDelayMs int `mapstructure:"base_delay_ms"`
+	MaxDelayMs  int `mapstructure:"max_delay_ms"`
+}
+
+// PaperConfig selects legs whose orders are simulated against live market
+// data instead of being sent to the exchange
+type PaperConfig struct {
+	Spot        PaperLegConfig `mapstructure:"spot"`
+	Derivatives PaperLegConfig `mapstructure:"derivatives"`
+	LatencyMs   int            `mapstructure:"latency_ms"`
+}
+
+type PaperLegConfig struct {
+	Enabled  bool    `mapstructure:"enabled"`
+	MakerFee float64 `mapstructure:"maker_fee"` // fraction of notional
+	TakerFee float64 `mapstructure:"taker_fee"`
 }
 
 type TradingConfig struct {
-	DefaultMinTradeSize     float64 `mapstructure:"default_min_trade_size"`
-	DefaultMaxPosition      float64 `mapstructure:"default_max_position"`
-	DefaultTargetBasis      float64 `mapstructure:"default_target_basis"`
-	RebalanceThreshold      float64 `mapstructure:"rebalance_threshold"`
-	MaxSlippage             float64 `mapstructure:"max_slippage"`
-	OrderTimeout            int     `mapstructure:"order_timeout"`
+	DefaultMinTradeSize          float64     `mapstructure:"default_min_trade_size"`
+	DefaultMaxPosition           float64     `mapstructure:"default_max_position"`
+	DefaultTargetBasis           float64     `mapstructure:"default_target_basis"`
+	DefaultTargetCarry           float64     `mapstructure:"default_target_carry"`
+	DefaultTargetAnnualizedBasis float64     `mapstructure:"default_target_annualized_basis"`
+	RebalanceThreshold           float64     `mapstructure:"rebalance_threshold"`
+	MaxSlippage                  float64     `mapstructure:"max_slippage"`
+	OrderTimeout                 int         `mapstructure:"order_timeout"`
+	OrphanOrderPolicy            string      `mapstructure:"orphan_order_policy"`
+	Carry                        CarryConfig `mapstructure:"carry"`
+}
+
+// CarryConfig parameterizes the expected carry that strategies entering on
+// carry are evaluated by. Fees are fractions of notional per fill.
+type CarryConfig struct {
+	HorizonHours int     `mapstructure:"horizon_hours"`
+	SpotFee      float64 `mapstructure:"spot_fee"`
+	FutureFee    float64 `mapstructure:"future_fee"`
 }
 
 type DatabaseConfig struct {
-	Path string `mapstructure:"path"`
+	Path             string `mapstructure:"path"`
+	SnapshotInterval int    `mapstructure:"snapshot_interval"` // seconds
+}
+
+// RecorderConfig controls the market data recorder run by the record command
+type RecorderConfig struct {
+	Dir            string   `mapstructure:"dir"`
+	Products       []string `mapstructure:"products"`
+	MaxFileMB      int      `mapstructure:"max_file_mb"`      // compressed size at which a file is rotated
+	MaxFileMinutes int      `mapstructure:"max_file_minutes"` // age at which a file is rotated
 }
 
 type LoggingConfig struct {
@@ -140,20 +205,52 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("coinbase.spot.sandbox", false)
 	v.SetDefault("coinbase.derivatives.sandbox", false)
 	v.SetDefault("coinbase.derivatives.auth_type", "legacy") // Default to legacy for backward compatibility
-	v.SetDefault("coinbase.websocket.url", "wss://ws-feed.exchange.coinbase.com")
+	v.SetDefault("coinbase.websocket.url", "wss://advanced-trade-ws.coinbase.com")
 	v.SetDefault("coinbase.websocket.reconnect_delay", 5)
 	v.SetDefault("coinbase.websocket.max_reconnects", 10)
+	v.SetDefault("coinbase.websocket.ticker_channel", "ticker")
+	v.SetDefault("coinbase.websocket.user_url", "wss://advanced-trade-ws-user.coinbase.com")
+	v.SetDefault("coinbase.websocket.derivatives_url", "wss://advanced-trade-ws.coinbase.com")
+	v.SetDefault("coinbase.rate_limits.public.requests_per_second", 10)
+	v.SetDefault("coinbase.rate_limits.public.burst", 10)
+	v.SetDefault("coinbase.rate_limits.private.requests_per_second", 25)
+	v.SetDefault("coinbase.rate_limits.private.burst", 25)
+	v.SetDefault("coinbase.rate_limits.orders.requests_per_second", 10)
+	v.SetDefault("coinbase.rate_limits.orders.burst", 5)
+	v.SetDefault("coinbase.rate_limits.max_backoff", 30)
+	v.SetDefault("coinbase.retry.max_attempts", 4)
+	v.SetDefault("coinbase.retry.base_delay_ms", 250)
+	v.SetDefault("coinbase.retry.max_delay_ms", 5000)
+	v.SetDefault("coinbase.paper.spot.enabled", false)
+	v.SetDefault("coinbase.paper.spot.maker_fee", 0.0005)
+	v.SetDefault("coinbase.paper.spot.taker_fee", 0.001)
+	v.SetDefault("coinbase.paper.derivatives.enabled", false)
+	v.SetDefault("coinbase.paper.derivatives.maker_fee", 0.0002)
+	v.SetDefault("coinbase.paper.derivatives.taker_fee", 0.0005)
+	v.SetDefault("coinbase.paper.latency_ms", 100)
 
 	// Trading defaults
 	v.SetDefault("trading.default_min_trade_size", 0.001)
 	v.SetDefault("trading.default_max_position", 1.0)
 	v.SetDefault("trading.default_target_basis", 5.0)
+	v.SetDefault("trading.default_target_carry", 10.0)
+	v.SetDefault("trading.default_target_annualized_basis", 10.0)
 	v.SetDefault("trading.rebalance_threshold", 0.1)
 	v.SetDefault("trading.max_slippage", 0.01)
 	v.SetDefault("trading.order_timeout", 60)
+	v.SetDefault("trading.orphan_order_policy", "cancel")
+	v.SetDefault("trading.carry.horizon_hours", 168)
+	v.SetDefault("trading.carry.spot_fee", 0.001)
+	v.SetDefault("trading.carry.future_fee", 0.0005)
 
 	// Database defaults
 	v.SetDefault("database.path", "./data/basis_trader.db")
+	v.SetDefault("database.snapshot_interval", 60)
+
+	// Recorder defaults
+	v.SetDefault("recorder.dir", "./data/recordings")
+	v.SetDefault("recorder.max_file_mb", 256)
+	v.SetDefault("recorder.max_file_minutes", 60)
 
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
@@ -187,6 +284,9 @@ func overrideFromEnv(config *Config) {
 	if passphrase := os.Getenv("COINBASE_SPOT_PASSPHRASE"); passphrase != "" {
 		config.Coinbase.Spot.Passphrase = passphrase
 	}
+	if portfolioID := os.Getenv("COINBASE_SPOT_PORTFOLIO_ID"); portfolioID != "" {
+		config.Coinbase.Spot.PortfolioID = portfolioID
+	}
 
 	if apiKey := os.Getenv("COINBASE_DERIVATIVES_API_KEY"); apiKey != "" {
 		config.Coinbase.Derivatives.APIKey = apiKey
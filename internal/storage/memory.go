@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// maxMemorySnapshots bounds the basis history a MemoryStore keeps
+const maxMemorySnapshots = 100000
+
+// MemoryStore is a Repository that keeps everything in memory. It backs runs
+// that need no persistence and builds without a SQLite driver.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	strategies map[string]models.BasisStrategy
+	trades     map[string]models.BasisTrade
+	orders     map[string]OrderRecord
+	fills      map[string][]models.Fill
+	snapshots  []SnapshotRecord
+	funding    []models.FundingPayment
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		strategies: make(map[string]models.BasisStrategy),
+		trades:     make(map[string]models.BasisTrade),
+		orders:     make(map[string]OrderRecord),
+		fills:      make(map[string][]models.Fill),
+	}
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) SaveStrategy(ctx context.Context, strategy *models.BasisStrategy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.strategies[strategy.ID] = *strategy
+	return nil
+}
+
+func (m *MemoryStore) DeleteStrategy(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.strategies[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.strategies, id)
+	return nil
+}
+
+func (m *MemoryStore) ListStrategies(ctx context.Context) ([]models.BasisStrategy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	strategies := make([]models.BasisStrategy, 0, len(m.strategies))
+	for _, s := range m.strategies {
+		strategies = append(strategies, s)
+	}
+	sort.Slice(strategies, func(i, j int) bool {
+		return strategies[i].CreatedAt.Before(strategies[j].CreatedAt)
+	})
+	return strategies, nil
+}
+
+func (m *MemoryStore) SaveTrade(ctx context.Context, trade *models.BasisTrade) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := *trade
+	t.Fills = nil
+	t.Closes = append([]models.TradeLink(nil), trade.Closes...)
+	t.Transitions = append([]models.TradeTransition(nil), trade.Transitions...)
+	m.trades[t.ID] = t
+	return nil
+}
+
+func (m *MemoryStore) GetTrade(ctx context.Context, id string) (*models.BasisTrade, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.trades[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	t.Fills = append([]models.Fill(nil), m.fills[id]...)
+	return &t, nil
+}
+
+func (m *MemoryStore) ListTrades(ctx context.Context, query TradeQuery) ([]models.BasisTrade, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	trades := make([]models.BasisTrade, 0)
+	for _, t := range m.trades {
+		if query.StrategyID != "" && t.StrategyID != query.StrategyID {
+			continue
+		}
+		if query.OpenOnly && t.CompletedAt != nil {
+			continue
+		}
+		t.Fills = append([]models.Fill(nil), m.fills[t.ID]...)
+		trades = append(trades, t)
+	}
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].CreatedAt.After(trades[j].CreatedAt)
+	})
+	if query.Limit > 0 && len(trades) > query.Limit {
+		trades = trades[:query.Limit]
+	}
+	return trades, nil
+}
+
+func (m *MemoryStore) SaveOrder(ctx context.Context, order *OrderRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := *order
+	if existing, ok := m.orders[o.ClientOrderID]; ok {
+		o.CreatedAt = existing.CreatedAt
+	}
+	m.orders[o.ClientOrderID] = o
+	return nil
+}
+
+func (m *MemoryStore) ListOrders(ctx context.Context, tradeID string) ([]OrderRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	orders := make([]OrderRecord, 0)
+	for _, o := range m.orders {
+		if o.TradeID == tradeID {
+			orders = append(orders, o)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	return orders, nil
+}
+
+func (m *MemoryStore) SaveFill(ctx context.Context, tradeID string, fill *models.Fill) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fills[tradeID] = append(m.fills[tradeID], *fill)
+	return nil
+}
+
+func (m *MemoryStore) SaveSnapshot(ctx context.Context, snapshot *SnapshotRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.snapshots = append(m.snapshots, *snapshot)
+	if n := len(m.snapshots); n > maxMemorySnapshots {
+		m.snapshots = append([]SnapshotRecord(nil), m.snapshots[n-maxMemorySnapshots:]...)
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListSnapshots(ctx context.Context, query SnapshotQuery) ([]SnapshotRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshots := make([]SnapshotRecord, 0)
+	for _, s := range m.snapshots {
+		if query.StrategyID != "" && s.StrategyID != query.StrategyID {
+			continue
+		}
+		if s.Timestamp.Before(query.Since) {
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+	if query.Limit > 0 && len(snapshots) > query.Limit {
+		snapshots = snapshots[len(snapshots)-query.Limit:]
+	}
+	return snapshots, nil
+}
+
+func (m *MemoryStore) SaveFundingPayment(ctx context.Context, payment *models.FundingPayment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.funding = append(m.funding, *payment)
+	return nil
+}
+
+func (m *MemoryStore) ListFundingPayments(ctx context.Context, query FundingQuery) ([]models.FundingPayment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	payments := make([]models.FundingPayment, 0)
+	for _, p := range m.funding {
+		if query.StrategyID != "" && p.StrategyID != query.StrategyID {
+			continue
+		}
+		if p.Time.Before(query.Since) {
+			continue
+		}
+		payments = append(payments, p)
+	}
+	sort.SliceStable(payments, func(i, j int) bool {
+		return payments[i].Time.Before(payments[j].Time)
+	})
+	return payments, nil
+}
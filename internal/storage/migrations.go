@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migrations are applied in order, each exactly once. Append new migrations;
+// never edit one that has shipped. Times are stored as Unix nanoseconds.
+var migrations = []string{
+	// 1: initial schema
+	`
+CREATE TABLE strategies (
+	id                  TEXT PRIMARY KEY,
+	spot_symbol         TEXT NOT NULL,
+	future_symbol       TEXT NOT NULL,
+	target_basis        REAL NOT NULL,
+	max_position        REAL NOT NULL,
+	min_trade_size      REAL NOT NULL,
+	rebalance_threshold REAL NOT NULL,
+	is_active           INTEGER NOT NULL,
+	created_at          INTEGER NOT NULL,
+	updated_at          INTEGER NOT NULL
+);
+
+CREATE TABLE basis_trades (
+	id                     TEXT PRIMARY KEY,
+	strategy_id            TEXT NOT NULL,
+	side                   TEXT NOT NULL,
+	status                 TEXT NOT NULL,
+	failure_reason         TEXT NOT NULL,
+	size                   REAL NOT NULL,
+	basis                  REAL NOT NULL,
+	spot_price             REAL NOT NULL,
+	future_price           REAL NOT NULL,
+	spot_order_id          TEXT NOT NULL,
+	future_order_id        TEXT NOT NULL,
+	spot_client_order_id   TEXT NOT NULL,
+	future_client_order_id TEXT NOT NULL,
+	spot_status            TEXT NOT NULL,
+	future_status          TEXT NOT NULL,
+	spot_filled_size       REAL NOT NULL,
+	future_filled_size     REAL NOT NULL,
+	spot_avg_price         REAL NOT NULL,
+	future_avg_price       REAL NOT NULL,
+	closed_size            REAL NOT NULL,
+	realized_pnl           REAL NOT NULL,
+	closes                 TEXT NOT NULL,
+	transitions            TEXT NOT NULL,
+	created_at             INTEGER NOT NULL,
+	completed_at           INTEGER
+);
+CREATE INDEX basis_trades_strategy ON basis_trades (strategy_id, created_at);
+CREATE INDEX basis_trades_open ON basis_trades (completed_at);
+
+CREATE TABLE orders (
+	client_order_id TEXT PRIMARY KEY,
+	order_id        TEXT NOT NULL,
+	trade_id        TEXT NOT NULL,
+	leg             TEXT NOT NULL,
+	symbol          TEXT NOT NULL,
+	side            TEXT NOT NULL,
+	type            TEXT NOT NULL,
+	price           REAL NOT NULL,
+	size            REAL NOT NULL,
+	filled_size     REAL NOT NULL,
+	status          TEXT NOT NULL,
+	reduce_only     INTEGER NOT NULL,
+	created_at      INTEGER NOT NULL,
+	updated_at      INTEGER NOT NULL
+);
+CREATE INDEX orders_trade ON orders (trade_id);
+
+CREATE TABLE fills (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	trade_id        TEXT NOT NULL,
+	exchange_id     TEXT NOT NULL,
+	order_id        TEXT NOT NULL,
+	client_order_id TEXT NOT NULL,
+	symbol          TEXT NOT NULL,
+	side            TEXT NOT NULL,
+	price           REAL NOT NULL,
+	size            REAL NOT NULL,
+	fee             REAL NOT NULL,
+	timestamp       INTEGER NOT NULL
+);
+CREATE INDEX fills_trade ON fills (trade_id);
+
+CREATE TABLE basis_snapshots (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	strategy_id   TEXT NOT NULL,
+	spot_symbol   TEXT NOT NULL,
+	future_symbol TEXT NOT NULL,
+	spot_price    REAL NOT NULL,
+	future_price  REAL NOT NULL,
+	basis         REAL NOT NULL,
+	basis_percent REAL NOT NULL,
+	timestamp     INTEGER NOT NULL
+);
+CREATE INDEX basis_snapshots_strategy ON basis_snapshots (strategy_id, timestamp);
+`,
+
+	// 2: carry-based entry and funding
+	`
+ALTER TABLE strategies ADD COLUMN entry_signal TEXT NOT NULL DEFAULT '';
+ALTER TABLE strategies ADD COLUMN target_carry REAL NOT NULL DEFAULT 0;
+
+ALTER TABLE basis_snapshots ADD COLUMN funding_rate REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN expected_carry REAL;
+
+CREATE TABLE funding_payments (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	strategy_id TEXT NOT NULL,
+	symbol      TEXT NOT NULL,
+	rate        REAL NOT NULL,
+	position    REAL NOT NULL,
+	mark_price  REAL NOT NULL,
+	amount      REAL NOT NULL,
+	timestamp   INTEGER NOT NULL
+);
+CREATE INDEX funding_payments_strategy ON funding_payments (strategy_id, timestamp);
+`,
+
+	// 3: dated futures, annualized basis and rolls
+	`
+ALTER TABLE strategies ADD COLUMN target_annualized_basis REAL NOT NULL DEFAULT 0;
+ALTER TABLE strategies ADD COLUMN roll_days REAL NOT NULL DEFAULT 0;
+
+ALTER TABLE basis_snapshots ADD COLUMN expiry INTEGER;
+ALTER TABLE basis_snapshots ADD COLUMN days_to_expiry REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN annualized_basis REAL NOT NULL DEFAULT 0;
+
+ALTER TABLE basis_trades ADD COLUMN spot_symbol TEXT NOT NULL DEFAULT '';
+ALTER TABLE basis_trades ADD COLUMN future_symbol TEXT NOT NULL DEFAULT '';
+`,
+
+	// 4: side-aware basis, spreads and quote ages
+	`
+ALTER TABLE basis_snapshots ADD COLUMN entry_basis REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN entry_basis_percent REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN exit_basis REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN exit_basis_percent REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN mid_basis REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN mid_basis_percent REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN spot_spread REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN future_spread REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN spot_quote_age INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN future_quote_age INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN exit_carry REAL;
+`,
+
+	// 5: pluggable strategy models
+	`
+ALTER TABLE strategies ADD COLUMN type TEXT NOT NULL DEFAULT '';
+ALTER TABLE strategies ADD COLUMN params TEXT NOT NULL DEFAULT '';
+`,
+
+	// 6: z-score model statistics
+	`
+ALTER TABLE basis_snapshots ADD COLUMN z_score REAL;
+ALTER TABLE basis_snapshots ADD COLUMN basis_mean REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN basis_std_dev REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN entry_band REAL NOT NULL DEFAULT 0;
+ALTER TABLE basis_snapshots ADD COLUMN exit_band REAL NOT NULL DEFAULT 0;
+`,
+
+	// 7: rebalance chunk size
+	`
+ALTER TABLE strategies ADD COLUMN rebalance_chunk REAL NOT NULL DEFAULT 0;
+`,
+
+	// 8: average fill prices
+	`
+ALTER TABLE orders ADD COLUMN avg_fill_price REAL NOT NULL DEFAULT 0;
+`,
+
+	// 9: funding payments booked from predicted rates, as all earlier ones were
+	`
+ALTER TABLE funding_payments ADD COLUMN estimated INTEGER NOT NULL DEFAULT 1;
+`,
+}
+
+// migrate brings the schema up to date, recording each applied migration in
+// schema_migrations
+func migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	applied_at INTEGER NOT NULL
+)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build (%d)", current, len(migrations))
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+			version, time.Now().UnixNano()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,498 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// sqliteDriver is the database/sql driver name registered by modernc.org/sqlite
+const sqliteDriver = "sqlite"
+
+// SQLStore is a Repository backed by an embedded SQLite database
+type SQLStore struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens the SQLite database at path, creating it and its
+// directory if needed, and applies pending migrations
+func OpenSQLite(ctx context.Context, path string) (*SQLStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// SQLite allows one writer; a single connection also keeps the pragmas
+	// below in effect for every statement
+	db.SetMaxOpenConns(1)
+
+	for _, pragma := range []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA busy_timeout = 5000",
+		"PRAGMA synchronous = NORMAL",
+	} {
+		if _, err := db.ExecContext(ctx, pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to set %q: %w", pragma, err)
+		}
+	}
+
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) SaveStrategy(ctx context.Context, st *models.BasisStrategy) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO strategies (id, spot_symbol, future_symbol, target_basis, entry_signal, target_carry,
+	target_annualized_basis, roll_days, type, params, max_position, min_trade_size, rebalance_threshold,
+	rebalance_chunk, is_active, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	spot_symbol = excluded.spot_symbol,
+	future_symbol = excluded.future_symbol,
+	target_basis = excluded.target_basis,
+	entry_signal = excluded.entry_signal,
+	target_carry = excluded.target_carry,
+	target_annualized_basis = excluded.target_annualized_basis,
+	roll_days = excluded.roll_days,
+	type = excluded.type,
+	params = excluded.params,
+	max_position = excluded.max_position,
+	min_trade_size = excluded.min_trade_size,
+	rebalance_threshold = excluded.rebalance_threshold,
+	rebalance_chunk = excluded.rebalance_chunk,
+	is_active = excluded.is_active,
+	updated_at = excluded.updated_at`,
+		st.ID, st.SpotSymbol, st.FutureSymbol, st.TargetBasis, st.EntrySignal, st.TargetCarry,
+		st.TargetAnnualizedBasis, st.RollDays, st.Type, string(st.Params), st.MaxPosition, st.MinTradeSize,
+		st.RebalanceThreshold, st.RebalanceChunk, st.IsActive, toUnix(st.CreatedAt), toUnix(st.UpdatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to save strategy %s: %w", st.ID, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteStrategy(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM strategies WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete strategy %s: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) ListStrategies(ctx context.Context) ([]models.BasisStrategy, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, spot_symbol, future_symbol, target_basis, entry_signal, target_carry,
+	target_annualized_basis, roll_days, type, params, max_position, min_trade_size, rebalance_threshold,
+	rebalance_chunk, is_active, created_at, updated_at
+FROM strategies ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list strategies: %w", err)
+	}
+	defer rows.Close()
+
+	strategies := make([]models.BasisStrategy, 0)
+	for rows.Next() {
+		var st models.BasisStrategy
+		var params string
+		var createdAt, updatedAt int64
+		if err := rows.Scan(&st.ID, &st.SpotSymbol, &st.FutureSymbol, &st.TargetBasis, &st.EntrySignal,
+			&st.TargetCarry, &st.TargetAnnualizedBasis, &st.RollDays, &st.Type, &params, &st.MaxPosition,
+			&st.MinTradeSize, &st.RebalanceThreshold, &st.RebalanceChunk, &st.IsActive, &createdAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan strategy: %w", err)
+		}
+		if params != "" {
+			st.Params = json.RawMessage(params)
+		}
+		st.CreatedAt, st.UpdatedAt = fromUnix(createdAt), fromUnix(updatedAt)
+		strategies = append(strategies, st)
+	}
+	return strategies, rows.Err()
+}
+
+func (s *SQLStore) SaveTrade(ctx context.Context, t *models.BasisTrade) error {
+	closes, err := json.Marshal(nonNil(t.Closes))
+	if err != nil {
+		return fmt.Errorf("failed to encode trade links: %w", err)
+	}
+	transitions, err := json.Marshal(nonNil(t.Transitions))
+	if err != nil {
+		return fmt.Errorf("failed to encode trade transitions: %w", err)
+	}
+
+	var completedAt sql.NullInt64
+	if t.CompletedAt != nil {
+		completedAt = sql.NullInt64{Int64: toUnix(*t.CompletedAt), Valid: true}
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+INSERT OR REPLACE INTO basis_trades (id, strategy_id, spot_symbol, future_symbol, side, status,
+	failure_reason, size, basis, spot_price, future_price, spot_order_id, future_order_id, spot_client_order_id, future_client_order_id,
+	spot_status, future_status, spot_filled_size, future_filled_size, spot_avg_price, future_avg_price,
+	closed_size, realized_pnl, closes, transitions, created_at, completed_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.StrategyID, t.SpotSymbol, t.FutureSymbol, t.Side, t.Status, t.FailureReason, t.Size, t.Basis,
+		t.SpotPrice, t.FuturePrice, t.SpotOrderID, t.FutureOrderID, t.SpotClientOrderID, t.FutureClientOrderID,
+		t.SpotStatus, t.FutureStatus, t.SpotFilledSize, t.FutureFilledSize, t.SpotAvgPrice, t.FutureAvgPrice,
+		t.ClosedSize, t.RealizedPnL, string(closes), string(transitions), toUnix(t.CreatedAt), completedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save trade %s: %w", t.ID, err)
+	}
+	return nil
+}
+
+const tradeColumns = `id, strategy_id, spot_symbol, future_symbol, side, status, failure_reason, size, basis,
+	spot_price, future_price, spot_order_id, future_order_id, spot_client_order_id, future_client_order_id,
+	spot_status, future_status, spot_filled_size, future_filled_size, spot_avg_price, future_avg_price,
+	closed_size, realized_pnl, closes, transitions, created_at, completed_at`
+
+func scanTrade(row interface{ Scan(...any) error }) (*models.BasisTrade, error) {
+	var t models.BasisTrade
+	var closes, transitions string
+	var createdAt int64
+	var completedAt sql.NullInt64
+
+	if err := row.Scan(&t.ID, &t.StrategyID, &t.SpotSymbol, &t.FutureSymbol, &t.Side, &t.Status, &t.FailureReason, &t.Size, &t.Basis,
+		&t.SpotPrice, &t.FuturePrice, &t.SpotOrderID, &t.FutureOrderID, &t.SpotClientOrderID, &t.FutureClientOrderID,
+		&t.SpotStatus, &t.FutureStatus, &t.SpotFilledSize, &t.FutureFilledSize, &t.SpotAvgPrice, &t.FutureAvgPrice,
+		&t.ClosedSize, &t.RealizedPnL, &closes, &transitions, &createdAt, &completedAt); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(closes), &t.Closes); err != nil {
+		return nil, fmt.Errorf("failed to decode trade links: %w", err)
+	}
+	if err := json.Unmarshal([]byte(transitions), &t.Transitions); err != nil {
+		return nil, fmt.Errorf("failed to decode trade transitions: %w", err)
+	}
+	t.CreatedAt = fromUnix(createdAt)
+	if completedAt.Valid {
+		c := fromUnix(completedAt.Int64)
+		t.CompletedAt = &c
+	}
+	return &t, nil
+}
+
+func (s *SQLStore) GetTrade(ctx context.Context, id string) (*models.BasisTrade, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+tradeColumns+` FROM basis_trades WHERE id = ?`, id)
+	t, err := scanTrade(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trade %s: %w", id, err)
+	}
+
+	fills, err := s.listFills(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	t.Fills = fills[id]
+	return t, nil
+}
+
+func (s *SQLStore) ListTrades(ctx context.Context, query TradeQuery) ([]models.BasisTrade, error) {
+	var where []string
+	var args []any
+	if query.StrategyID != "" {
+		where = append(where, "strategy_id = ?")
+		args = append(args, query.StrategyID)
+	}
+	if query.OpenOnly {
+		where = append(where, "completed_at IS NULL")
+	}
+
+	q := `SELECT ` + tradeColumns + ` FROM basis_trades`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	q += ` ORDER BY created_at DESC`
+	if query.Limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trades: %w", err)
+	}
+	defer rows.Close()
+
+	trades := make([]models.BasisTrade, 0)
+	var ids []string
+	for rows.Next() {
+		t, err := scanTrade(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan trade: %w", err)
+		}
+		trades = append(trades, *t)
+		ids = append(ids, t.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list trades: %w", err)
+	}
+	rows.Close()
+
+	fills, err := s.listFills(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range trades {
+		trades[i].Fills = fills[trades[i].ID]
+	}
+	return trades, nil
+}
+
+func (s *SQLStore) SaveOrder(ctx context.Context, o *OrderRecord) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO orders (client_order_id, order_id, trade_id, leg, symbol, side, type, price, size,
+	filled_size, avg_fill_price, status, reduce_only, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (client_order_id) DO UPDATE SET
+	order_id = excluded.order_id,
+	filled_size = excluded.filled_size,
+	avg_fill_price = excluded.avg_fill_price,
+	status = excluded.status,
+	updated_at = excluded.updated_at`,
+		o.ClientOrderID, o.OrderID, o.TradeID, o.Leg, o.Symbol, o.Side, o.Type, o.Price, o.Size,
+		o.FilledSize, o.AvgFillPrice, o.Status, o.ReduceOnly, toUnix(o.CreatedAt), toUnix(o.UpdatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to save order %s: %w", o.ClientOrderID, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) ListOrders(ctx context.Context, tradeID string) ([]OrderRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT client_order_id, order_id, trade_id, leg, symbol, side, type, price, size,
+	filled_size, avg_fill_price, status, reduce_only, created_at, updated_at
+FROM orders WHERE trade_id = ? ORDER BY created_at`, tradeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	defer rows.Close()
+
+	orders := make([]OrderRecord, 0)
+	for rows.Next() {
+		var o OrderRecord
+		var createdAt, updatedAt int64
+		if err := rows.Scan(&o.ClientOrderID, &o.OrderID, &o.TradeID, &o.Leg, &o.Symbol, &o.Side, &o.Type,
+			&o.Price, &o.Size, &o.FilledSize, &o.AvgFillPrice, &o.Status, &o.ReduceOnly, &createdAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		o.CreatedAt, o.UpdatedAt = fromUnix(createdAt), fromUnix(updatedAt)
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+func (s *SQLStore) SaveFill(ctx context.Context, tradeID string, f *models.Fill) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO fills (trade_id, exchange_id, order_id, client_order_id, symbol, side, price, size, fee, timestamp)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		tradeID, f.TradeID, f.OrderID, f.ClientOrderID, f.Symbol, f.Side, f.Price, f.Size, f.Fee, toUnix(f.Timestamp))
+	if err != nil {
+		return fmt.Errorf("failed to save fill for trade %s: %w", tradeID, err)
+	}
+	return nil
+}
+
+// listFills returns the fills of each trade in ids, in execution order
+func (s *SQLStore) listFills(ctx context.Context, ids []string) (map[string][]models.Fill, error) {
+	fills := make(map[string][]models.Fill)
+	if len(ids) == 0 {
+		return fills, nil
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	rows, err := s.db.QueryContext(ctx, `
+SELECT trade_id, exchange_id, order_id, client_order_id, symbol, side, price, size, fee, timestamp
+FROM fills WHERE trade_id IN (`+placeholders+`) ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fills: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tradeID string
+		var f models.Fill
+		var timestamp int64
+		if err := rows.Scan(&tradeID, &f.TradeID, &f.OrderID, &f.ClientOrderID, &f.Symbol, &f.Side,
+			&f.Price, &f.Size, &f.Fee, &timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan fill: %w", err)
+		}
+		f.Timestamp = fromUnix(timestamp)
+		fills[tradeID] = append(fills[tradeID], f)
+	}
+	return fills, rows.Err()
+}
+
+func (s *SQLStore) SaveSnapshot(ctx context.Context, r *SnapshotRecord) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO basis_snapshots (strategy_id, spot_symbol, future_symbol, spot_price, future_price,
+	basis, basis_percent, entry_basis, entry_basis_percent, exit_basis, exit_basis_percent, mid_basis,
+	mid_basis_percent, spot_spread, future_spread, spot_quote_age, future_quote_age, funding_rate,
+	expected_carry, exit_carry, expiry, days_to_expiry, annualized_basis, z_score, basis_mean, basis_std_dev,
+	entry_band, exit_band, timestamp)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.StrategyID, r.SpotSymbol, r.FutureSymbol, r.SpotPrice, r.FuturePrice,
+		r.Basis, r.BasisPercent, r.EntryBasis, r.EntryBasisPercent, r.ExitBasis, r.ExitBasisPercent, r.MidBasis,
+		r.MidBasisPercent, r.SpotSpread, r.FutureSpread, int64(r.SpotQuoteAge), int64(r.FutureQuoteAge), r.FundingRate,
+		sql.NullFloat64{Float64: r.ExpectedCarry, Valid: r.HasCarry}, sql.NullFloat64{Float64: r.ExitCarry, Valid: r.HasCarry},
+		sql.NullInt64{Int64: toUnix(r.Expiry), Valid: !r.Expiry.IsZero()}, r.DaysToExpiry, r.AnnualizedBasis,
+		sql.NullFloat64{Float64: r.ZScore, Valid: r.HasZScore}, r.BasisMean, r.BasisStdDev, r.EntryBand, r.ExitBand,
+		toUnix(r.Timestamp))
+	if err != nil {
+		return fmt.Errorf("failed to save basis snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) ListSnapshots(ctx context.Context, query SnapshotQuery) ([]SnapshotRecord, error) {
+	where := []string{"timestamp >= ?"}
+	args := []any{toUnix(query.Since)}
+	if query.StrategyID != "" {
+		where = append(where, "strategy_id = ?")
+		args = append(args, query.StrategyID)
+	}
+
+	// Take the most recent rows, then return them oldest first
+	q := `SELECT strategy_id, spot_symbol, future_symbol, spot_price, future_price, basis, basis_percent,
+	entry_basis, entry_basis_percent, exit_basis, exit_basis_percent, mid_basis, mid_basis_percent,
+	spot_spread, future_spread, spot_quote_age, future_quote_age, funding_rate, expected_carry, exit_carry,
+	expiry, days_to_expiry, annualized_basis, z_score, basis_mean, basis_std_dev, entry_band, exit_band, timestamp
+FROM basis_snapshots WHERE ` + strings.Join(where, " AND ") + ` ORDER BY timestamp DESC`
+	if query.Limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list basis snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	snapshots := make([]SnapshotRecord, 0)
+	for rows.Next() {
+		var r SnapshotRecord
+		var carry, exitCarry, zScore sql.NullFloat64
+		var expiry sql.NullInt64
+		var spotAge, futureAge, timestamp int64
+		if err := rows.Scan(&r.StrategyID, &r.SpotSymbol, &r.FutureSymbol, &r.SpotPrice, &r.FuturePrice,
+			&r.Basis, &r.BasisPercent, &r.EntryBasis, &r.EntryBasisPercent, &r.ExitBasis, &r.ExitBasisPercent,
+			&r.MidBasis, &r.MidBasisPercent, &r.SpotSpread, &r.FutureSpread, &spotAge, &futureAge,
+			&r.FundingRate, &carry, &exitCarry, &expiry, &r.DaysToExpiry, &r.AnnualizedBasis,
+			&zScore, &r.BasisMean, &r.BasisStdDev, &r.EntryBand, &r.ExitBand, &timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan basis snapshot: %w", err)
+		}
+		r.ExpectedCarry, r.ExitCarry, r.HasCarry = carry.Float64, exitCarry.Float64, carry.Valid
+		r.SpotQuoteAge, r.FutureQuoteAge = time.Duration(spotAge), time.Duration(futureAge)
+		r.ZScore, r.HasZScore = zScore.Float64, zScore.Valid
+		if expiry.Valid {
+			r.Expiry = fromUnix(expiry.Int64)
+		}
+		r.Timestamp = fromUnix(timestamp)
+		snapshots = append(snapshots, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list basis snapshots: %w", err)
+	}
+
+	for i, j := 0, len(snapshots)-1; i < j; i, j = i+1, j-1 {
+		snapshots[i], snapshots[j] = snapshots[j], snapshots[i]
+	}
+	return snapshots, nil
+}
+
+func (s *SQLStore) SaveFundingPayment(ctx context.Context, p *models.FundingPayment) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO funding_payments (strategy_id, symbol, rate, position, mark_price, amount, timestamp, estimated)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.StrategyID, p.Symbol, p.Rate, p.Position, p.MarkPrice, p.Amount, toUnix(p.Time), p.Estimated)
+	if err != nil {
+		return fmt.Errorf("failed to save funding payment: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) ListFundingPayments(ctx context.Context, query FundingQuery) ([]models.FundingPayment, error) {
+	where := []string{"timestamp >= ?"}
+	args := []any{toUnix(query.Since)}
+	if query.StrategyID != "" {
+		where = append(where, "strategy_id = ?")
+		args = append(args, query.StrategyID)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+SELECT strategy_id, symbol, rate, position, mark_price, amount, timestamp, estimated
+FROM funding_payments WHERE `+strings.Join(where, " AND ")+` ORDER BY timestamp`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list funding payments: %w", err)
+	}
+	defer rows.Close()
+
+	payments := make([]models.FundingPayment, 0)
+	for rows.Next() {
+		var p models.FundingPayment
+		var timestamp int64
+		if err := rows.Scan(&p.StrategyID, &p.Symbol, &p.Rate, &p.Position, &p.MarkPrice, &p.Amount, &timestamp, &p.Estimated); err != nil {
+			return nil, fmt.Errorf("failed to scan funding payment: %w", err)
+		}
+		p.Time = fromUnix(timestamp)
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
+
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnix(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// nonNil keeps empty slices encoding as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
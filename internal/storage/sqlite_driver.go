@@ -0,0 +1,4 @@
+package storage
+
+// Link the pure-Go SQLite driver
+import _ "modernc.org/sqlite"
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// openTestStore opens a SQLite database in a fresh temporary directory
+func openTestStore(t *testing.T) (*SQLStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "basis.db")
+	store, err := OpenSQLite(context.Background(), path)
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, path
+}
+
+// schemaVersion is the highest migration recorded as applied
+func schemaVersion(t *testing.T, store *SQLStore) int {
+	t.Helper()
+	var version int
+	if err := store.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatalf("read schema version: %v", err)
+	}
+	return version
+}
+
+func columns(t *testing.T, store *SQLStore, table string) map[string]bool {
+	t.Helper()
+	rows, err := store.db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		t.Fatalf("table info %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("scan column: %v", err)
+		}
+		names[name] = true
+	}
+	return names
+}
+
+func TestOpenSQLiteMigratesFreshDatabase(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	if got := schemaVersion(t, store); got != len(migrations) {
+		t.Errorf("schema version = %d, want %d", got, len(migrations))
+	}
+	var applied int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil {
+		t.Fatalf("count migrations: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", applied, len(migrations))
+	}
+
+	for table, want := range map[string][]string{
+		"strategies":       {"type", "params", "rebalance_chunk"},
+		"basis_snapshots":  {"funding_rate", "mid_basis_percent", "z_score", "exit_band"},
+		"orders":           {"avg_fill_price"},
+		"funding_payments": {"amount", "estimated"},
+	} {
+		have := columns(t, store, table)
+		for _, column := range want {
+			if !have[column] {
+				t.Errorf("%s has no column %s", table, column)
+			}
+		}
+	}
+}
+
+func TestOpenSQLiteUpgradesEarlierSchema(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "basis.db")
+
+	// A database last opened by a build with the first six migrations
+	shipped := migrations
+	migrations = shipped[:6]
+	old, err := OpenSQLite(ctx, path)
+	migrations = shipped
+	if err != nil {
+		t.Fatalf("OpenSQLite with 6 migrations: %v", err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO strategies (id, spot_symbol, future_symbol, target_basis, max_position, min_trade_size,
+			rebalance_threshold, is_active, created_at, updated_at)
+		VALUES ('s-1', 'BTC-USD', 'BTC-PERP', 0.5, 2, 0.1, 0.2, 1, 1, 1)`,
+		`INSERT INTO orders (client_order_id, order_id, trade_id, leg, symbol, side, type, price, size,
+			filled_size, status, reduce_only, created_at, updated_at)
+		VALUES ('c-1', 'o-1', 't-1', 'spot', 'BTC-USD', 'BUY', 'limit', 100, 1, 1, 'filled', 0, 1, 1)`,
+		`INSERT INTO funding_payments (strategy_id, symbol, rate, position, mark_price, amount, timestamp)
+		VALUES ('s-1', 'BTC-PERP', 0.0001, -1, 100, 0.01, 1)`,
+	} {
+		if _, err := old.db.Exec(stmt); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	old.Close()
+
+	for reopen := 0; reopen < 2; reopen++ {
+		store, err := OpenSQLite(ctx, path)
+		if err != nil {
+			t.Fatalf("reopen %d: %v", reopen, err)
+		}
+
+		if got := schemaVersion(t, store); got != len(migrations) {
+			t.Errorf("reopen %d: schema version = %d, want %d", reopen, got, len(migrations))
+		}
+
+		strategies, err := store.ListStrategies(ctx)
+		if err != nil {
+			t.Fatalf("ListStrategies: %v", err)
+		}
+		if len(strategies) != 1 || strategies[0].ID != "s-1" || strategies[0].RebalanceChunk != 0 || strategies[0].MaxPosition != 2 {
+			t.Errorf("strategies = %+v", strategies)
+		}
+
+		orders, err := store.ListOrders(ctx, "t-1")
+		if err != nil {
+			t.Fatalf("ListOrders: %v", err)
+		}
+		if len(orders) != 1 || orders[0].AvgFillPrice != 0 || orders[0].FilledSize != 1 {
+			t.Errorf("orders = %+v", orders)
+		}
+
+		payments, err := store.ListFundingPayments(ctx, FundingQuery{})
+		if err != nil {
+			t.Fatalf("ListFundingPayments: %v", err)
+		}
+		if len(payments) != 1 || !payments[0].Estimated {
+			t.Errorf("payments recorded before the estimated column = %+v, want estimated", payments)
+		}
+		store.Close()
+	}
+}
+
+func TestOpenSQLiteRejectsNewerSchema(t *testing.T) {
+	store, path := openTestStore(t)
+	if _, err := store.db.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, 0)`, len(migrations)+1); err != nil {
+		t.Fatalf("record migration: %v", err)
+	}
+	store.Close()
+
+	if _, err := OpenSQLite(context.Background(), path); err == nil || !strings.Contains(err.Error(), "newer than this build") {
+		t.Errorf("OpenSQLite error = %v, want newer schema", err)
+	}
+}
+
+func TestSQLStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, _ := openTestStore(t)
+	at := time.Unix(0, 1717200000123456789)
+
+	t.Run("strategies", func(t *testing.T) {
+		strategy := models.BasisStrategy{
+			ID:                 "s-1",
+			SpotSymbol:         "BTC-USD",
+			FutureSymbol:       "BTC-PERP",
+			TargetBasis:        0.5,
+			EntrySignal:        models.EntrySignalCarry,
+			TargetCarry:        12,
+			MaxPosition:        2,
+			MinTradeSize:       0.1,
+			RebalanceThreshold: 0.2,
+			RebalanceChunk:     0.5,
+			RollDays:           3,
+			Type:               "zscore",
+			Params:             json.RawMessage(`{"window":120}`),
+			IsActive:           true,
+			CreatedAt:          at,
+			UpdatedAt:          at,
+		}
+		if err := store.SaveStrategy(ctx, &strategy); err != nil {
+			t.Fatalf("SaveStrategy: %v", err)
+		}
+		strategy.RebalanceChunk, strategy.IsActive = 0.25, false
+		if err := store.SaveStrategy(ctx, &strategy); err != nil {
+			t.Fatalf("SaveStrategy update: %v", err)
+		}
+
+		got, err := store.ListStrategies(ctx)
+		if err != nil {
+			t.Fatalf("ListStrategies: %v", err)
+		}
+		if len(got) != 1 || !reflect.DeepEqual(got[0], strategy) {
+			t.Errorf("strategies = %+v, want %+v", got, strategy)
+		}
+
+		if err := store.DeleteStrategy(ctx, "s-1"); err != nil {
+			t.Fatalf("DeleteStrategy: %v", err)
+		}
+		if err := store.DeleteStrategy(ctx, "s-1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteStrategy again = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("trades", func(t *testing.T) {
+		completed := at.Add(time.Minute)
+		trade := models.BasisTrade{
+			ID:                "t-1",
+			StrategyID:        "s-1",
+			SpotSymbol:        "BTC-USD",
+			FutureSymbol:      "BTC-PERP",
+			SpotOrderID:       "o-1",
+			SpotClientOrderID: "c-1",
+			SpotPrice:         100,
+			FuturePrice:       101,
+			Size:              1,
+			Basis:             1,
+			Side:              "exit",
+			Status:            models.TradeStateComplete,
+			SpotStatus:        models.OrderStatusFilled,
+			SpotFilledSize:    1,
+			SpotAvgPrice:      100.5,
+			Closes:            []models.TradeLink{{EntryTradeID: "t-0", Size: 1, RealizedPnL: 0.5}},
+			RealizedPnL:       0.5,
+			Transitions:       []models.TradeTransition{{From: models.TradeStatePending, To: models.TradeStateComplete, Reason: "both legs filled", Time: at}},
+			CreatedAt:         at,
+			CompletedAt:       &completed,
+		}
+		if err := store.SaveTrade(ctx, &trade); err != nil {
+			t.Fatalf("SaveTrade: %v", err)
+		}
+		fill := models.Fill{TradeID: "x-1", OrderID: "o-1", ClientOrderID: "c-1", Symbol: "BTC-USD",
+			Side: models.OrderSideBuy, Price: 100.5, Size: 1, Fee: 0.1, Timestamp: at}
+		if err := store.SaveFill(ctx, trade.ID, &fill); err != nil {
+			t.Fatalf("SaveFill: %v", err)
+		}
+		trade.Fills = []models.Fill{fill}
+
+		got, err := store.GetTrade(ctx, trade.ID)
+		if err != nil {
+			t.Fatalf("GetTrade: %v", err)
+		}
+		if len(got.Transitions) != 1 || !got.Transitions[0].Time.Equal(at) {
+			t.Errorf("transitions = %+v", got.Transitions)
+		}
+		// Transition times come back in the zone they were encoded in
+		got.Transitions[0].Time = at
+		if !reflect.DeepEqual(*got, trade) {
+			t.Errorf("trade = %+v, want %+v", *got, trade)
+		}
+
+		if _, err := store.GetTrade(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetTrade missing = %v, want ErrNotFound", err)
+		}
+		open, err := store.ListTrades(ctx, TradeQuery{OpenOnly: true})
+		if err != nil || len(open) != 0 {
+			t.Errorf("open trades = %+v, %v; want none", open, err)
+		}
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		order := OrderRecord{ClientOrderID: "c-1", OrderID: "o-1", TradeID: "t-1", Leg: "spot", Symbol: "BTC-USD",
+			Side: models.OrderSideBuy, Type: models.OrderTypeLimit, Price: 101, Size: 1, Status: models.OrderStatusNew,
+			CreatedAt: at, UpdatedAt: at}
+		if err := store.SaveOrder(ctx, &order); err != nil {
+			t.Fatalf("SaveOrder: %v", err)
+		}
+		order.FilledSize, order.AvgFillPrice, order.Status = 1, 100.5, models.OrderStatusFilled
+		if err := store.SaveOrder(ctx, &order); err != nil {
+			t.Fatalf("SaveOrder update: %v", err)
+		}
+
+		got, err := store.ListOrders(ctx, "t-1")
+		if err != nil {
+			t.Fatalf("ListOrders: %v", err)
+		}
+		if len(got) != 1 || !reflect.DeepEqual(got[0], order) {
+			t.Errorf("orders = %+v, want %+v", got, order)
+		}
+	})
+
+	t.Run("snapshots", func(t *testing.T) {
+		var want []SnapshotRecord
+		for i := 0; i < 3; i++ {
+			record := SnapshotRecord{StrategyID: "s-1", BasisSnapshot: models.BasisSnapshot{
+				SpotSymbol: "BTC-USD", FutureSymbol: "BTC-0628", SpotPrice: 100, FuturePrice: 101 + float64(i),
+				Basis: 1 + float64(i), BasisPercent: 1, MidBasisPercent: 1.1, SpotQuoteAge: time.Second,
+				FundingRate: 0.0001, ExpectedCarry: 10, ExitCarry: 9, HasCarry: i > 0,
+				Expiry: at.Add(30 * 24 * time.Hour), DaysToExpiry: 30, AnnualizedBasis: 12,
+				ZScore: 2.5, BasisMean: 0.9, BasisStdDev: 0.1, EntryBand: 1.1, ExitBand: 0.9, HasZScore: i == 2,
+				Timestamp: at.Add(time.Duration(i) * time.Minute),
+			}}
+			if !record.HasCarry {
+				record.ExpectedCarry, record.ExitCarry = 0, 0
+			}
+			if !record.HasZScore {
+				record.ZScore = 0
+			}
+			if err := store.SaveSnapshot(ctx, &record); err != nil {
+				t.Fatalf("SaveSnapshot: %v", err)
+			}
+			want = append(want, record)
+		}
+
+		got, err := store.ListSnapshots(ctx, SnapshotQuery{StrategyID: "s-1"})
+		if err != nil {
+			t.Fatalf("ListSnapshots: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("snapshots = %+v, want %+v", got, want)
+		}
+
+		latest, err := store.ListSnapshots(ctx, SnapshotQuery{Limit: 2})
+		if err != nil {
+			t.Fatalf("ListSnapshots with limit: %v", err)
+		}
+		if !reflect.DeepEqual(latest, want[1:]) {
+			t.Errorf("latest snapshots = %+v, want the last two oldest first", latest)
+		}
+	})
+
+	t.Run("funding payments", func(t *testing.T) {
+		payment := models.FundingPayment{StrategyID: "s-1", Symbol: "BTC-PERP", Rate: 0.0001, Position: -1,
+			MarkPrice: 100, Amount: 0.01, Time: at, Estimated: true}
+		if err := store.SaveFundingPayment(ctx, &payment); err != nil {
+			t.Fatalf("SaveFundingPayment: %v", err)
+		}
+
+		got, err := store.ListFundingPayments(ctx, FundingQuery{StrategyID: "s-1"})
+		if err != nil {
+			t.Fatalf("ListFundingPayments: %v", err)
+		}
+		if len(got) != 1 || !reflect.DeepEqual(got[0], payment) {
+			t.Errorf("payments = %+v, want %+v", got, payment)
+		}
+	})
+}
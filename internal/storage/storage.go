@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+var (
+	// ErrNotFound means the requested record does not exist
+	ErrNotFound = errors.New("not found")
+)
+
+// Repository persists strategies, basis trades with their orders and fills,
+// funding payments, and basis history
+type Repository interface {
+	SaveStrategy(ctx context.Context, strategy *models.BasisStrategy) error
+	DeleteStrategy(ctx context.Context, id string) error
+	ListStrategies(ctx context.Context) ([]models.BasisStrategy, error)
+
+	// SaveTrade inserts or replaces a trade. Its fills are stored separately
+	// with SaveFill and attached when the trade is read back.
+	SaveTrade(ctx context.Context, trade *models.BasisTrade) error
+	GetTrade(ctx context.Context, id string) (*models.BasisTrade, error)
+	ListTrades(ctx context.Context, query TradeQuery) ([]models.BasisTrade, error)
+
+	SaveOrder(ctx context.Context, order *OrderRecord) error
+	ListOrders(ctx context.Context, tradeID string) ([]OrderRecord, error)
+	SaveFill(ctx context.Context, tradeID string, fill *models.Fill) error
+
+	SaveSnapshot(ctx context.Context, snapshot *SnapshotRecord) error
+	ListSnapshots(ctx context.Context, query SnapshotQuery) ([]SnapshotRecord, error)
+
+	SaveFundingPayment(ctx context.Context, payment *models.FundingPayment) error
+	ListFundingPayments(ctx context.Context, query FundingQuery) ([]models.FundingPayment, error)
+
+	Close() error
+}
+
+// TradeQuery filters ListTrades. Trades are returned most recent first.
+type TradeQuery struct {
+	StrategyID string
+	OpenOnly   bool // only trades that have not completed or failed
+	Limit      int  // zero for no limit
+}
+
+// SnapshotQuery filters ListSnapshots. Snapshots are returned oldest first.
+type SnapshotQuery struct {
+	StrategyID string
+	Since      time.Time
+	Limit      int // zero for no limit; otherwise the most recent Limit
+}
+
+// FundingQuery filters ListFundingPayments. Payments are returned oldest
+// first.
+type FundingQuery struct {
+	StrategyID string
+	Since      time.Time
+}
+
+// OrderRecord is an order placed for one leg of a basis trade
+type OrderRecord struct {
+	ClientOrderID string
+	OrderID       string
+	TradeID       string
+	Leg           string
+	Symbol        string
+	Side          models.OrderSide
+	Type          models.OrderType
+	Price         float64
+	Size          float64
+	FilledSize    float64
+	AvgFillPrice  float64
+	Status        models.OrderStatus
+	ReduceOnly    bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// SnapshotRecord is a basis observation for a strategy
+type SnapshotRecord struct {
+	StrategyID string `json:"strategy_id"`
+	models.BasisSnapshot
+}
@@ -0,0 +1,285 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gregtusar/basis/internal/storage"
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/gregtusar/basis/pkg/recording"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingBookDepth is the number of levels per side kept from recorded
+// books
+const recordingBookDepth = 10
+
+// Event is one observation of market data at a point in time. An event
+// carries a ticker, a book, a funding payment, or a combination.
+type Event struct {
+	Time    time.Time
+	Symbol  string
+	Ticker  *models.Ticker
+	Book    *models.OrderBook
+	Funding *Funding
+}
+
+// Funding is a perpetual funding payment. Longs pay shorts Rate times the
+// position's notional; a negative rate pays the other way.
+type Funding struct {
+	Rate float64
+}
+
+// csvColumns are the columns LoadCSV understands. Only timestamp and symbol
+// are required.
+var csvColumns = []string{"timestamp", "symbol", "bid", "ask", "last", "bid_size", "ask_size", "funding_rate"}
+
+// LoadCSV reads events from CSV with a header row naming its columns:
+// timestamp, symbol, and any of bid, ask, last, bid_size, ask_size and
+// funding_rate. Timestamps are RFC 3339 or unix seconds, milliseconds or
+// nanoseconds. Rows with prices become tickers, with a one-level book when
+// sizes are given; rows with a funding rate become funding payments. Events
+// are returned in time order.
+func LoadCSV(r io.Reader) ([]Event, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range csvColumns[:2] {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("csv is missing the %s column", required)
+		}
+	}
+
+	var events []Event
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv line %d: %w", line, err)
+		}
+
+		event, ok, err := parseCSVRecord(record, index)
+		if err != nil {
+			return nil, fmt.Errorf("csv line %d: %w", line, err)
+		}
+		if ok {
+			events = append(events, event)
+		}
+	}
+
+	sortEvents(events)
+	return events, nil
+}
+
+func parseCSVRecord(record []string, index map[string]int) (Event, bool, error) {
+	field := func(name string) string {
+		if i, ok := index[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	values := make(map[string]float64)
+	for _, name := range csvColumns[2:] {
+		v := field(name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return Event{}, false, fmt.Errorf("invalid %s %q", name, v)
+		}
+		values[name] = f
+	}
+
+	ts, err := parseTimestamp(field("timestamp"))
+	if err != nil {
+		return Event{}, false, err
+	}
+	event := Event{Time: ts, Symbol: field("symbol")}
+	if event.Symbol == "" {
+		return Event{}, false, fmt.Errorf("missing symbol")
+	}
+
+	if rate, ok := values["funding_rate"]; ok {
+		event.Funding = &Funding{Rate: rate}
+	}
+
+	bid, ask, last := values["bid"], values["ask"], values["last"]
+	if bid > 0 || ask > 0 || last > 0 {
+		if last <= 0 {
+			last = midOrEither(bid, ask)
+		}
+		event.Ticker = &models.Ticker{
+			Symbol:    event.Symbol,
+			BidPrice:  bid,
+			AskPrice:  ask,
+			LastPrice: last,
+			Timestamp: ts,
+		}
+
+		bidSize, askSize := values["bid_size"], values["ask_size"]
+		if bid > 0 && ask > 0 && bidSize > 0 && askSize > 0 {
+			event.Book = &models.OrderBook{
+				Symbol:    event.Symbol,
+				Bids:      []models.OrderBookLevel{{Price: bid, Size: bidSize}},
+				Asks:      []models.OrderBookLevel{{Price: ask, Size: askSize}},
+				Timestamp: ts,
+			}
+		}
+	}
+
+	return event, event.Ticker != nil || event.Funding != nil, nil
+}
+
+// EventsFromSnapshots turns recorded basis snapshots into tickers for both
+// legs. Snapshots carry only prices, so fills run against books synthesized
+// at those prices.
+func EventsFromSnapshots(records []storage.SnapshotRecord) []Event {
+	events := make([]Event, 0, 2*len(records))
+	for _, r := range records {
+		for _, leg := range []struct {
+			symbol string
+			price  float64
+		}{
+			{r.SpotSymbol, r.SpotPrice},
+			{r.FutureSymbol, r.FuturePrice},
+		} {
+			if leg.price <= 0 {
+				continue
+			}
+			events = append(events, Event{
+				Time:   r.Timestamp,
+				Symbol: leg.symbol,
+				Ticker: &models.Ticker{
+					Symbol:    leg.symbol,
+					BidPrice:  leg.price,
+					AskPrice:  leg.price,
+					LastPrice: leg.price,
+					Timestamp: r.Timestamp,
+				},
+			})
+		}
+	}
+
+	sortEvents(events)
+	return events
+}
+
+// LoadRecording decodes a market data recording with the websocket feed's own
+// ticker and level2 handlers. Each ticker becomes an event carrying the book
+// as it stood when the ticker arrived, or no book before the first level2
+// snapshot.
+func LoadRecording(r *recording.Reader) ([]Event, error) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	ws := coinbase.NewWebSocketClient(r.Header().URL, nil, logger)
+	books := coinbase.NewBookBuilder(logger)
+	books.Attach(ws)
+
+	var tickers []models.Ticker
+	ws.OnTicker(func(ticker models.Ticker) {
+		tickers = append(tickers, ticker)
+	})
+
+	var events []Event
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tickers = tickers[:0]
+		ws.Dispatch(rec.Time, rec.Message)
+		for _, ticker := range tickers {
+			ticker := ticker
+			ticker.Timestamp = rec.Time
+			event := Event{Time: rec.Time, Symbol: ticker.Symbol, Ticker: &ticker}
+			if book, ok := books.Book(ticker.Symbol, recordingBookDepth); ok {
+				book.Timestamp = rec.Time
+				event.Book = book
+			}
+			events = append(events, event)
+		}
+	}
+
+	sortEvents(events)
+	return events, nil
+}
+
+// FilterEvents keeps the events in [from, to); zero bounds are open
+func FilterEvents(events []Event, from, to time.Time) []Event {
+	filtered := events[:0:0]
+	for _, e := range events {
+		if !from.IsZero() && e.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !e.Time.Before(to) {
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered
+}
+
+func sortEvents(events []Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+}
+
+func parseTimestamp(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, fmt.Errorf("missing timestamp")
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return ts, nil
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", v)
+	}
+	switch {
+	case n > 1e17:
+		return time.Unix(0, n).UTC(), nil
+	case n > 1e11:
+		return time.UnixMilli(n).UTC(), nil
+	default:
+		return time.Unix(n, 0).UTC(), nil
+	}
+}
+
+func midOrEither(bid, ask float64) float64 {
+	switch {
+	case bid > 0 && ask > 0:
+		return (bid + ask) / 2
+	case bid > 0:
+		return bid
+	default:
+		return ask
+	}
+}
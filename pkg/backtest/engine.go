@@ -0,0 +1,304 @@
+// Package backtest replays historical market data through the trader's
+// strategy and execution code in simulated time, filling orders with the
+// paper-trading client
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/clock"
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/gregtusar/basis/pkg/trader"
+	"github.com/sirupsen/logrus"
+)
+
+// Fees are fractions of notional charged per fill
+type Fees struct {
+	Maker float64
+	Taker float64
+}
+
+// Config describes a backtest run
+type Config struct {
+	Strategy     models.BasisStrategy
+	SpotFees     Fees
+	FutureFees   Fees
+	OrderTimeout time.Duration
+
+	// MaxSlippage bounds each leg's expected average execution price, as a
+	// fraction of the mid. Zero leaves the trader's default.
+	MaxSlippage float64
+
+	// RebalanceThreshold is the trader's default for strategies that do not
+	// set one, as a fraction of MaxPosition
+	RebalanceThreshold float64
+
+	// Carry is the model a strategy entering on carry is evaluated with
+	Carry trader.CarryModel
+
+	// Depth is the size offered at the touch when the data carries no book
+	// sizes. Zero means unlimited, and capacity is not reported.
+	Depth float64
+
+	// Logger receives the trader's logs. Nil discards them.
+	Logger *logrus.Logger
+}
+
+// Run replays events through a BasisTrader running cfg.Strategy. The trader
+// takes one step per distinct event time, after every event at that time has
+// been applied.
+func Run(ctx context.Context, cfg Config, events []Event) (*Report, error) {
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no market data to replay")
+	}
+	strategy := cfg.Strategy
+	if strategy.SpotSymbol == "" || strategy.FutureSymbol == "" {
+		return nil, fmt.Errorf("strategy needs a spot and a future symbol")
+	}
+	if strategy.ID == "" {
+		strategy.ID = "backtest"
+	}
+	strategy.IsActive = true
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(io.Discard)
+	}
+
+	clk := clock.NewSimulated(events[0].Time)
+	mkt := newMarket(cfg.Depth)
+	spot := coinbase.NewPaperClient(mkt, coinbase.PaperConfig{MakerFee: cfg.SpotFees.Maker, TakerFee: cfg.SpotFees.Taker})
+	future := coinbase.NewPaperClient(mkt, coinbase.PaperConfig{MakerFee: cfg.FutureFees.Maker, TakerFee: cfg.FutureFees.Taker})
+	spot.SetClock(clk)
+	future.SetClock(clk)
+
+	bt := trader.NewBasisTrader(spot, future, logger)
+	bt.SetClock(clk)
+	bt.SetCarryModel(cfg.Carry)
+	bt.SetMaxSlippage(cfg.MaxSlippage)
+	bt.SetRebalanceThreshold(cfg.RebalanceThreshold)
+	if cfg.OrderTimeout > 0 {
+		bt.SetOrderTimeout(cfg.OrderTimeout)
+	}
+	bt.AddInstruments(instruments(events)...)
+	if err := bt.AddStrategy(&strategy); err != nil {
+		return nil, err
+	}
+	if result := bt.Reconcile(ctx, false); !result.Consistent {
+		return nil, fmt.Errorf("simulated exchange is inconsistent: %v", result.Problems)
+	}
+
+	run := &run{
+		strategy: &strategy,
+		market:   mkt,
+		spot:     spot,
+		future:   future,
+		trader:   bt,
+		seen:     make(map[string]bool),
+		report:   &Report{Strategy: strategy, Start: events[0].Time},
+	}
+
+	for i := 0; i < len(events); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		now := events[i].Time
+		clk.Set(now)
+		for ; i < len(events) && events[i].Time.Equal(now); i++ {
+			if err := run.apply(ctx, events[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		bt.Step(ctx)
+		if err := run.sample(ctx, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return run.finish(ctx)
+}
+
+// run is the state of a backtest in progress
+type run struct {
+	strategy *models.BasisStrategy
+	market   *market
+	spot     *coinbase.PaperClient
+	future   *coinbase.PaperClient
+	trader   *trader.BasisTrader
+	seen     map[string]bool // trades already accounted for
+	depths   []float64       // touch size available to each entry
+	peak     float64
+	report   *Report
+}
+
+func (r *run) apply(ctx context.Context, e Event) error {
+	r.report.Events++
+	r.market.apply(e)
+	if e.Ticker != nil {
+		// The trader has no order books here, so it sizes trades to the
+		// touch the simulated fills will see, unlimited without --depth when
+		// the data has no book
+		ticker := *e.Ticker
+		if book, err := r.market.GetOrderBook(ctx, e.Symbol, 1); err == nil && len(book.Bids) > 0 && len(book.Asks) > 0 {
+			ticker.BidSize, ticker.AskSize = book.Bids[0].Size, book.Asks[0].Size
+		}
+		r.trader.UpdateTicker(ticker)
+	}
+
+	// Funding accrues on the future position held when it is paid
+	if e.Funding != nil {
+		positions, err := r.future.GetPositions(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range positions {
+			if p.Symbol != e.Symbol {
+				continue
+			}
+			mark := p.MarkPrice
+			if ticker, err := r.market.GetTicker(ctx, e.Symbol); err == nil {
+				mark = ticker.LastPrice
+			}
+			r.report.FundingEarned -= e.Funding.Rate * p.Size * mark
+		}
+	}
+	return nil
+}
+
+// sample records equity after a step, and the liquidity available to any
+// entry the step opened
+func (r *run) sample(ctx context.Context, now time.Time) error {
+	for _, trade := range r.trader.GetTrades() {
+		if r.seen[trade.ID] {
+			continue
+		}
+		r.seen[trade.ID] = true
+		if trade.Side != "enter" {
+			continue
+		}
+
+		_, spotAsk, spotOk := r.market.touch(trade.SpotSymbol)
+		futureBid, _, futureOk := r.market.touch(trade.FutureSymbol)
+		if spotOk && futureOk {
+			r.depths = append(r.depths, math.Min(spotAsk, futureBid))
+		}
+	}
+
+	pnl, err := r.pnl(ctx)
+	if err != nil {
+		return err
+	}
+	equity := pnl.realized + pnl.unrealized + r.report.FundingEarned
+
+	r.peak = math.Max(r.peak, equity)
+	r.report.MaxDrawdown = math.Max(r.report.MaxDrawdown, r.peak-equity)
+	r.report.Equity = append(r.report.Equity, EquityPoint{Time: now, Equity: equity})
+	r.report.End = now
+	return nil
+}
+
+type pnl struct {
+	realized   float64
+	unrealized float64
+	spot       float64
+	future     float64
+}
+
+func (r *run) pnl(ctx context.Context) (pnl, error) {
+	var p pnl
+	for _, client := range []*coinbase.PaperClient{r.spot, r.future} {
+		positions, err := client.GetPositions(ctx)
+		if err != nil {
+			return p, err
+		}
+		for _, pos := range positions {
+			p.realized += pos.RealizedPL
+			p.unrealized += pos.UnrealizedPL
+			// Futures are summed across expiries, as rolls move between them
+			if pos.Symbol == r.strategy.SpotSymbol {
+				p.spot = pos.Size
+			} else {
+				p.future += pos.Size
+			}
+		}
+	}
+	return p, nil
+}
+
+func (r *run) finish(ctx context.Context) (*Report, error) {
+	report := r.report
+	report.Trades = r.trader.GetTrades()
+	sort.Slice(report.Trades, func(i, j int) bool {
+		return report.Trades[i].CreatedAt.Before(report.Trades[j].CreatedAt)
+	})
+
+	for _, trade := range report.Trades {
+		switch {
+		case trade.Status == models.TradeStateFailed:
+			report.Failed++
+		case trade.Side == "enter":
+			report.Entries++
+		case trade.Side == "roll":
+			report.Rolls++
+		default:
+			report.Exits++
+		}
+		report.BasisCaptured += trade.RealizedPnL
+	}
+
+	for _, client := range []*coinbase.PaperClient{r.spot, r.future} {
+		for _, fill := range client.GetFills() {
+			report.Fees += fill.Fee
+			report.Turnover += fill.Price * fill.Size
+		}
+	}
+
+	p, err := r.pnl(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.RealizedPnL = p.realized
+	report.UnrealizedPnL = p.unrealized
+	report.SpotPosition = p.spot
+	report.FuturePosition = p.future
+	report.TotalPnL = p.realized + p.unrealized + report.FundingEarned
+	report.Capacity = median(r.depths)
+	return report, nil
+}
+
+// instruments describes the products in events from their symbols, so dated
+// futures can be rolled into later expiries the data contains
+func instruments(events []Event) []models.Instrument {
+	seen := make(map[string]bool)
+	var listed []models.Instrument
+	for _, e := range events {
+		if !seen[e.Symbol] {
+			seen[e.Symbol] = true
+			listed = append(listed, coinbase.ParseSymbol(e.Symbol))
+		}
+	}
+	return listed
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
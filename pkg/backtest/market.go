@@ -0,0 +1,170 @@
+package backtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// defaultFundingInterval is assumed until two funding payments have replayed
+const defaultFundingInterval = time.Hour
+
+// errMarketDataOnly is returned for order calls, which the paper clients
+// wrapping the market handle themselves
+var errMarketDataOnly = errors.New("backtest market serves market data only")
+
+// market is a coinbase.Client serving the latest replayed ticker and book for
+// each symbol
+type market struct {
+	depth float64 // touch size of synthesized books; unlimited if not set
+
+	mu      sync.RWMutex
+	tickers map[string]models.Ticker
+	books   map[string]*models.OrderBook
+	funding map[string]models.FundingRate
+}
+
+func newMarket(depth float64) *market {
+	if depth <= 0 {
+		depth = math.Inf(1)
+	}
+	return &market{
+		depth:   depth,
+		tickers: make(map[string]models.Ticker),
+		books:   make(map[string]*models.OrderBook),
+		funding: make(map[string]models.FundingRate),
+	}
+}
+
+// apply records an event's market data. A ticker without a book replaces any
+// earlier book, which would otherwise go stale.
+func (m *market) apply(e Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e.Ticker != nil {
+		m.tickers[e.Symbol] = *e.Ticker
+		delete(m.books, e.Symbol)
+	}
+	if e.Book != nil {
+		m.books[e.Symbol] = e.Book
+	}
+	if e.Funding != nil {
+		// The interval is inferred from the spacing of payments
+		interval := defaultFundingInterval
+		previous, ok := m.funding[e.Symbol]
+		if ok && e.Time.After(previous.Timestamp) {
+			interval = e.Time.Sub(previous.Timestamp)
+		}
+		m.funding[e.Symbol] = models.FundingRate{
+			Symbol:        e.Symbol,
+			Rate:          e.Funding.Rate,
+			PredictedRate: e.Funding.Rate,
+			Interval:      interval,
+			Timestamp:     e.Time,
+		}
+	}
+}
+
+// touch returns the size at the best bid and ask for symbol. ok is false when
+// the book is synthesized with unlimited depth.
+func (m *market) touch(symbol string) (bidSize, askSize float64, ok bool) {
+	book, err := m.GetOrderBook(context.Background(), symbol, 1)
+	if err != nil {
+		return 0, 0, false
+	}
+	if len(book.Bids) > 0 {
+		bidSize = book.Bids[0].Size
+	}
+	if len(book.Asks) > 0 {
+		askSize = book.Asks[0].Size
+	}
+	return bidSize, askSize, !math.IsInf(bidSize, 1) && !math.IsInf(askSize, 1)
+}
+
+func (m *market) GetTicker(ctx context.Context, symbol string) (*models.Ticker, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ticker, ok := m.tickers[symbol]
+	if !ok {
+		return nil, fmt.Errorf("no market data for %s", symbol)
+	}
+	return &ticker, nil
+}
+
+// GetOrderBook returns the replayed book, or one level per side at the
+// ticker's bid and ask when the data has no book
+func (m *market) GetOrderBook(ctx context.Context, symbol string, level int) (*models.OrderBook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if book, ok := m.books[symbol]; ok {
+		cp := *book
+		cp.Bids = append([]models.OrderBookLevel(nil), book.Bids...)
+		cp.Asks = append([]models.OrderBookLevel(nil), book.Asks...)
+		return &cp, nil
+	}
+
+	ticker, ok := m.tickers[symbol]
+	if !ok {
+		return nil, fmt.Errorf("no market data for %s", symbol)
+	}
+
+	bid, ask := ticker.BidPrice, ticker.AskPrice
+	if bid <= 0 {
+		bid = ticker.LastPrice
+	}
+	if ask <= 0 {
+		ask = ticker.LastPrice
+	}
+	return &models.OrderBook{
+		Symbol:    symbol,
+		Bids:      []models.OrderBookLevel{{Price: bid, Size: m.depth}},
+		Asks:      []models.OrderBookLevel{{Price: ask, Size: m.depth}},
+		Timestamp: ticker.Timestamp,
+	}, nil
+}
+
+// GetFundingRate returns the last funding paid on symbol, predicting the next
+// payment will be the same. No funding time is given, so the trader never
+// settles funding itself; the engine accounts for payments as they replay.
+func (m *market) GetFundingRate(ctx context.Context, symbol string) (*models.FundingRate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rate, ok := m.funding[symbol]
+	if !ok {
+		return nil, fmt.Errorf("no funding data for %s", symbol)
+	}
+	return &rate, nil
+}
+
+func (m *market) GetPositions(ctx context.Context) ([]models.Position, error) {
+	return nil, nil
+}
+
+func (m *market) PlaceOrder(ctx context.Context, order *models.OrderRequest) (*models.Order, error) {
+	return nil, errMarketDataOnly
+}
+
+func (m *market) CancelOrder(ctx context.Context, orderID string) error {
+	return errMarketDataOnly
+}
+
+func (m *market) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	return nil, errMarketDataOnly
+}
+
+func (m *market) GetOpenOrders(ctx context.Context, symbols []string) ([]models.Order, error) {
+	return nil, nil
+}
+
+func (m *market) Subscribe(channels []string, symbols []string) error {
+	return nil
+}
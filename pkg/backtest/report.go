@@ -0,0 +1,104 @@
+package backtest
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// Report summarizes a backtest. P&L figures are in quote currency and net of
+// fees unless noted.
+type Report struct {
+	Strategy models.BasisStrategy `json:"strategy"`
+	Start    time.Time            `json:"start"`
+	End      time.Time            `json:"end"`
+	Events   int                  `json:"events"`
+
+	Trades  []models.BasisTrade `json:"trades"`
+	Entries int                 `json:"entries"`
+	Exits   int                 `json:"exits"`
+	Rolls   int                 `json:"rolls"`
+	Failed  int                 `json:"failed"`
+
+	RealizedPnL   float64 `json:"realized_pnl"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"` // open legs marked at the last book mid
+	FundingEarned float64 `json:"funding_earned"`
+	TotalPnL      float64 `json:"total_pnl"`
+	BasisCaptured float64 `json:"basis_captured"` // entry minus exit basis on closed size, plus roll spreads, before fees
+	Fees          float64 `json:"fees"`
+	MaxDrawdown   float64 `json:"max_drawdown"` // largest fall in total P&L from a prior peak
+
+	// Turnover is the notional traded across both legs
+	Turnover float64 `json:"turnover"`
+
+	// Capacity is the median size available at the touch on both legs when
+	// entries were taken, in base units. Zero if the data has no depth.
+	Capacity float64 `json:"capacity"`
+
+	SpotPosition   float64 `json:"spot_position"`
+	FuturePosition float64 `json:"future_position"`
+
+	Equity []EquityPoint `json:"equity"`
+}
+
+// EquityPoint is total P&L after a step
+type EquityPoint struct {
+	Time   time.Time `json:"time"`
+	Equity float64   `json:"equity"`
+}
+
+// WriteText writes a human-readable summary and trade list
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	s := r.Strategy
+	fmt.Fprintf(tw, "Strategy\t%s / %s\n", s.SpotSymbol, s.FutureSymbol)
+	target := fmt.Sprintf("target basis %.4f%%", s.TargetBasis)
+	switch s.EntrySignal {
+	case models.EntrySignalCarry:
+		target = fmt.Sprintf("target carry %.2f%%/yr", s.TargetCarry)
+	case models.EntrySignalAnnualizedBasis:
+		target = fmt.Sprintf("target annualized basis %.2f%%/yr", s.TargetAnnualizedBasis)
+	}
+	if s.Type != "" {
+		target = fmt.Sprintf("%s model", s.Type)
+		if len(s.Params) > 0 {
+			target += " " + string(s.Params)
+		}
+	}
+	fmt.Fprintf(tw, "Parameters\t%s, min trade %g, max position %g\n",
+		target, s.MinTradeSize, s.MaxPosition)
+	fmt.Fprintf(tw, "Period\t%s to %s (%d events)\n",
+		r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Events)
+	fmt.Fprintf(tw, "Trades\t%d entries, %d exits, %d rolls, %d failed\n", r.Entries, r.Exits, r.Rolls, r.Failed)
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "Realized P&L\t%.2f\n", r.RealizedPnL)
+	fmt.Fprintf(tw, "Unrealized P&L\t%.2f\n", r.UnrealizedPnL)
+	fmt.Fprintf(tw, "Funding earned\t%.2f\n", r.FundingEarned)
+	fmt.Fprintf(tw, "Total P&L\t%.2f\n", r.TotalPnL)
+	fmt.Fprintf(tw, "Basis captured\t%.2f\n", r.BasisCaptured)
+	fmt.Fprintf(tw, "Fees\t%.2f\n", r.Fees)
+	fmt.Fprintf(tw, "Max drawdown\t%.2f\n", r.MaxDrawdown)
+	fmt.Fprintf(tw, "Turnover\t%.2f\n", r.Turnover)
+	if r.Capacity > 0 {
+		fmt.Fprintf(tw, "Capacity\t%g per trade\n", r.Capacity)
+	} else {
+		fmt.Fprintf(tw, "Capacity\tunknown, data has no depth\n")
+	}
+	fmt.Fprintf(tw, "Open position\tspot %g, future %g\n", r.SpotPosition, r.FuturePosition)
+
+	if len(r.Trades) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "Time\tSide\tStatus\tSize\tLegs\tSpot\tFuture\tBasis\tRealized")
+		for _, t := range r.Trades {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%g\t%s / %s\t%.2f\t%.2f\t%.2f\t%.2f\n",
+				t.CreatedAt.Format(time.RFC3339), t.Side, t.Status, t.Size, t.SpotSymbol, t.FutureSymbol,
+				t.SpotAvgPrice, t.FutureAvgPrice, t.Basis, t.RealizedPnL)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,140 @@
+// Package clock abstracts time so trading loops run unchanged in real time and
+// in simulated time during backtests
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and schedules tickers
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on C at a fixed interval until stopped
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real returns the wall clock
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Simulated is a clock that only moves when told to. Tickers fire as the
+// clock passes their deadlines; like time.Ticker, ticks are dropped while a
+// receiver is behind.
+type Simulated struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*simTicker
+}
+
+// NewSimulated returns a simulated clock set to start
+func NewSimulated(start time.Time) *Simulated {
+	return &Simulated{now: start}
+}
+
+func (s *Simulated) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.now
+}
+
+func (s *Simulated) Since(t time.Time) time.Duration {
+	return s.Now().Sub(t)
+}
+
+func (s *Simulated) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := &simTicker{clock: s, interval: d, next: s.now.Add(d), c: make(chan time.Time, 1)}
+	s.tickers = append(s.tickers, t)
+	return t
+}
+
+// Set moves the clock to t, firing tickers in deadline order. The clock never
+// moves backwards.
+func (s *Simulated) Set(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.Before(s.now) {
+		return
+	}
+
+	for {
+		due := s.tickers[:0:0]
+		for _, tk := range s.tickers {
+			if !tk.next.After(t) {
+				due = append(due, tk)
+			}
+		}
+		if len(due) == 0 {
+			break
+		}
+		sort.Slice(due, func(i, j int) bool { return due[i].next.Before(due[j].next) })
+
+		tk := due[0]
+		s.now = tk.next
+		select {
+		case tk.c <- tk.next:
+		default:
+		}
+		tk.next = tk.next.Add(tk.interval)
+	}
+	s.now = t
+}
+
+// Advance moves the clock forward by d
+func (s *Simulated) Advance(d time.Duration) {
+	s.Set(s.Now().Add(d))
+}
+
+type simTicker struct {
+	clock    *Simulated
+	interval time.Duration
+	next     time.Time
+	c        chan time.Time
+}
+
+func (t *simTicker) C() <-chan time.Time { return t.c }
+
+func (t *simTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, tk := range t.clock.tickers {
+		if tk == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,640 @@
+package coinbase
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+const (
+	advancedTradePrefix = "/api/v3/brokerage"
+
+	// defaultBookDepth is the number of levels requested for level 2 books
+	defaultBookDepth = 50
+
+	// defaultFundingInterval applies when a perpetual does not report its
+	// funding interval
+	defaultFundingInterval = time.Hour
+
+	// atProductPageSize is how many products are listed per request
+	atProductPageSize = 250
+)
+
+// Advanced Trade API request/response types
+
+type atPriceLevel struct {
+	Price string `json:"price"`
+	Size  string `json:"size"`
+}
+
+type atPriceBook struct {
+	ProductID string         `json:"product_id"`
+	Bids      []atPriceLevel `json:"bids"`
+	Asks      []atPriceLevel `json:"asks"`
+	Time      time.Time      `json:"time"`
+}
+
+type atProductBookResponse struct {
+	PriceBook atPriceBook `json:"pricebook"`
+}
+
+type atMarketTrade struct {
+	TradeID   string    `json:"trade_id"`
+	ProductID string    `json:"product_id"`
+	Price     string    `json:"price"`
+	Size      string    `json:"size"`
+	Time      time.Time `json:"time"`
+	Side      string    `json:"side"`
+}
+
+type atTickerResponse struct {
+	Trades  []atMarketTrade `json:"trades"`
+	BestBid string          `json:"best_bid"`
+	BestAsk string          `json:"best_ask"`
+}
+
+type atPosition struct {
+	ProductID         string `json:"product_id"`
+	ExpirationTime    string `json:"expiration_time"`
+	Side              string `json:"side"`
+	NumberOfContracts string `json:"number_of_contracts"`
+	CurrentPrice      string `json:"current_price"`
+	AvgEntryPrice     string `json:"avg_entry_price"`
+	UnrealizedPNL     string `json:"unrealized_pnl"`
+	DailyRealizedPNL  string `json:"daily_realized_pnl"`
+}
+
+type atProduct struct {
+	ProductID            string                  `json:"product_id"`
+	ProductType          string                  `json:"product_type"`
+	BaseCurrencyID       string                  `json:"base_currency_id"`
+	QuoteCurrencyID      string                  `json:"quote_currency_id"`
+	BaseIncrement        string                  `json:"base_increment"`
+	QuoteIncrement       string                  `json:"quote_increment"`
+	PriceIncrement       string                  `json:"price_increment"`
+	FutureProductDetails *atFutureProductDetails `json:"future_product_details"`
+}
+
+type atFutureProductDetails struct {
+	ContractExpiry     string              `json:"contract_expiry"`
+	ContractExpiryType string              `json:"contract_expiry_type"`
+	ContractSize       string              `json:"contract_size"`
+	ContractRootUnit   string              `json:"contract_root_unit"`
+	FundingInterval    string              `json:"funding_interval"`
+	PerpetualDetails   *atPerpetualDetails `json:"perpetual_details"`
+}
+
+type atListProductsResponse struct {
+	Products []atProduct `json:"products"`
+}
+
+type atPerpetualDetails struct {
+	FundingRate  string `json:"funding_rate"`
+	FundingTime  string `json:"funding_time"`
+	OpenInterest string `json:"open_interest"`
+}
+
+type atPositionsResponse struct {
+	Positions []atPosition `json:"positions"`
+}
+
+type atLimitConfig struct {
+	BaseSize   string `json:"base_size"`
+	LimitPrice string `json:"limit_price"`
+	PostOnly   bool   `json:"post_only,omitempty"`
+}
+
+type atMarketConfig struct {
+	BaseSize string `json:"base_size,omitempty"`
+}
+
+type atOrderConfiguration struct {
+	MarketIOC *atMarketConfig `json:"market_market_ioc,omitempty"`
+	LimitGTC  *atLimitConfig  `json:"limit_limit_gtc,omitempty"`
+	LimitFOK  *atLimitConfig  `json:"limit_limit_fok,omitempty"`
+	LimitIOC  *atLimitConfig  `json:"sor_limit_ioc,omitempty"`
+}
+
+type atCreateOrderRequest struct {
+	ClientOrderID      string               `json:"client_order_id"`
+	ProductID          string               `json:"product_id"`
+	Side               string               `json:"side"`
+	OrderConfiguration atOrderConfiguration `json:"order_configuration"`
+	ReduceOnly         bool                 `json:"reduce_only,omitempty"`
+}
+
+type atCreateOrderResponse struct {
+	Success         bool   `json:"success"`
+	FailureReason   string `json:"failure_reason"`
+	OrderID         string `json:"order_id"`
+	SuccessResponse struct {
+		OrderID       string `json:"order_id"`
+		ProductID     string `json:"product_id"`
+		Side          string `json:"side"`
+		ClientOrderID string `json:"client_order_id"`
+	} `json:"success_response"`
+	ErrorResponse struct {
+		Error                 string `json:"error"`
+		Message               string `json:"message"`
+		ErrorDetails          string `json:"error_details"`
+		PreviewFailureReason  string `json:"preview_failure_reason"`
+		NewOrderFailureReason string `json:"new_order_failure_reason"`
+	} `json:"error_response"`
+}
+
+type atCancelOrdersRequest struct {
+	OrderIDs []string `json:"order_ids"`
+}
+
+type atCancelOrdersResponse struct {
+	Results []struct {
+		Success       bool   `json:"success"`
+		FailureReason string `json:"failure_reason"`
+		OrderID       string `json:"order_id"`
+	} `json:"results"`
+}
+
+type atOrder struct {
+	OrderID            string               `json:"order_id"`
+	ProductID          string               `json:"product_id"`
+	ClientOrderID      string               `json:"client_order_id"`
+	Side               string               `json:"side"`
+	Status             string               `json:"status"`
+	TimeInForce        string               `json:"time_in_force"`
+	OrderType          string               `json:"order_type"`
+	OrderConfiguration atOrderConfiguration `json:"order_configuration"`
+	FilledSize         string               `json:"filled_size"`
+	AverageFilledPrice string               `json:"average_filled_price"`
+	CreatedTime        time.Time            `json:"created_time"`
+	LastFillTime       *time.Time           `json:"last_fill_time"`
+}
+
+type atGetOrderResponse struct {
+	Order atOrder `json:"order"`
+}
+
+type atListOrdersResponse struct {
+	Orders  []atOrder `json:"orders"`
+	HasNext bool      `json:"has_next"`
+	Cursor  string    `json:"cursor"`
+}
+
+func (c *AdvancedTradeClient) GetTicker(ctx context.Context, symbol string) (*models.Ticker, error) {
+	path := fmt.Sprintf("%s/products/%s/ticker?limit=1", advancedTradePrefix, url.PathEscape(symbol))
+
+	var resp atTickerResponse
+	if err := c.doRequest(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get ticker for %s: %w", symbol, err)
+	}
+
+	ticker := &models.Ticker{
+		Symbol:    symbol,
+		BidPrice:  parseFloat(resp.BestBid),
+		AskPrice:  parseFloat(resp.BestAsk),
+		Timestamp: time.Now(),
+	}
+	if len(resp.Trades) > 0 {
+		last := resp.Trades[0]
+		ticker.LastPrice = parseFloat(last.Price)
+		ticker.LastSize = parseFloat(last.Size)
+		if !last.Time.IsZero() {
+			ticker.Timestamp = last.Time
+		}
+	}
+
+	return ticker, nil
+}
+
+func (c *AdvancedTradeClient) GetOrderBook(ctx context.Context, symbol string, level int) (*models.OrderBook, error) {
+	limit := defaultBookDepth
+	if level <= 1 {
+		limit = 1
+	}
+	path := fmt.Sprintf("%s/product_book?product_id=%s&limit=%d", advancedTradePrefix, url.QueryEscape(symbol), limit)
+
+	var resp atProductBookResponse
+	if err := c.doRequest(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get order book for %s: %w", symbol, err)
+	}
+
+	book := &models.OrderBook{
+		Symbol:    symbol,
+		Bids:      convertATLevels(resp.PriceBook.Bids),
+		Asks:      convertATLevels(resp.PriceBook.Asks),
+		Timestamp: resp.PriceBook.Time,
+	}
+	if book.Timestamp.IsZero() {
+		book.Timestamp = time.Now()
+	}
+
+	return book, nil
+}
+
+// GetFundingRate returns a perpetual's funding terms. Advanced Trade publishes
+// a single rate, the one accruing over the current interval, which is also
+// the best prediction of the next settlement.
+func (c *AdvancedTradeClient) GetFundingRate(ctx context.Context, symbol string) (*models.FundingRate, error) {
+	path := fmt.Sprintf("%s/products/%s", advancedTradePrefix, url.PathEscape(symbol))
+
+	var resp atProduct
+	if err := c.doRequest(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get product %s: %w", symbol, err)
+	}
+	details := resp.FutureProductDetails
+	if details == nil || details.PerpetualDetails == nil {
+		return nil, fmt.Errorf("%s is not a perpetual future", symbol)
+	}
+
+	interval, err := time.ParseDuration(details.FundingInterval)
+	if err != nil || interval <= 0 {
+		interval = defaultFundingInterval
+	}
+
+	rate := parseFloat(details.PerpetualDetails.FundingRate)
+	funding := &models.FundingRate{
+		Symbol:        symbol,
+		Rate:          rate,
+		PredictedRate: rate,
+		Interval:      interval,
+		Timestamp:     time.Now(),
+	}
+	if t, err := time.Parse(time.RFC3339, details.PerpetualDetails.FundingTime); err == nil {
+		funding.NextFundingTime = t
+	}
+	return funding, nil
+}
+
+// GetInstruments lists the futures the derivatives venues offer, dated and
+// perpetual. Spot products are described by the spot client.
+func (c *AdvancedTradeClient) GetInstruments(ctx context.Context) ([]models.Instrument, error) {
+	var instruments []models.Instrument
+	for offset := 0; ; offset += atProductPageSize {
+		path := fmt.Sprintf("%s/products?product_type=FUTURE&limit=%d&offset=%d", advancedTradePrefix, atProductPageSize, offset)
+
+		var resp atListProductsResponse
+		if err := c.doRequest(ctx, http.MethodGet, path, nil, &resp); err != nil {
+			return nil, fmt.Errorf("failed to list products: %w", err)
+		}
+		for i := range resp.Products {
+			instruments = append(instruments, convertATProduct(&resp.Products[i]))
+		}
+
+		if len(resp.Products) < atProductPageSize {
+			return instruments, nil
+		}
+	}
+}
+
+func (c *AdvancedTradeClient) GetPositions(ctx context.Context) ([]models.Position, error) {
+	var resp atPositionsResponse
+	if err := c.doRequest(ctx, http.MethodGet, advancedTradePrefix+"/cfm/positions", nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	now := time.Now()
+	positions := make([]models.Position, 0, len(resp.Positions))
+	for _, p := range resp.Positions {
+		size := parseFloat(p.NumberOfContracts)
+		side := strings.ToLower(p.Side)
+		if side == "short" {
+			size = -size
+		}
+
+		positions = append(positions, models.Position{
+			Symbol:       p.ProductID,
+			Side:         side,
+			Size:         size,
+			EntryPrice:   parseFloat(p.AvgEntryPrice),
+			MarkPrice:    parseFloat(p.CurrentPrice),
+			UnrealizedPL: parseFloat(p.UnrealizedPNL),
+			RealizedPL:   parseFloat(p.DailyRealizedPNL),
+			UpdatedAt:    now,
+		})
+	}
+
+	return positions, nil
+}
+
+// PlaceOrder submits an order, retrying transient failures under the same
+// client order ID and checking whether an ambiguous attempt landed first
+func (c *AdvancedTradeClient) PlaceOrder(ctx context.Context, order *models.OrderRequest) (*models.Order, error) {
+	config, err := buildATOrderConfiguration(order)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOrderID := order.ClientOrderID
+	if clientOrderID == "" {
+		if clientOrderID, err = generateNonce(); err != nil {
+			return nil, fmt.Errorf("failed to generate client order id: %w", err)
+		}
+	}
+
+	return c.retry.placeOrder(ctx,
+		func() (*models.Order, error) {
+			return c.placeOrderOnce(ctx, order, config, clientOrderID)
+		},
+		func(ctx context.Context, since time.Time) (*models.Order, error) {
+			return c.GetOrderByClientID(ctx, order.Symbol, clientOrderID, since)
+		},
+	)
+}
+
+func (c *AdvancedTradeClient) placeOrderOnce(ctx context.Context, order *models.OrderRequest,
+	config atOrderConfiguration, clientOrderID string) (*models.Order, error) {
+	req := atCreateOrderRequest{
+		ClientOrderID:      clientOrderID,
+		ProductID:          order.Symbol,
+		Side:               strings.ToUpper(string(order.Side)),
+		OrderConfiguration: config,
+		// The exchange enforces reduce-only against the position it holds
+		// when the order matches, so a concurrent fill cannot race it
+		ReduceOnly: order.ReduceOnly,
+	}
+
+	var resp atCreateOrderResponse
+	if err := c.doRequest(ctx, http.MethodPost, advancedTradePrefix+"/orders", req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to place order: %w", err)
+	}
+
+	if !resp.Success {
+		// Rejections come back as 200 with success=false
+		code := resp.ErrorResponse.NewOrderFailureReason
+		if code == "" {
+			code = resp.ErrorResponse.Error
+		}
+		if code == "" {
+			code = resp.FailureReason
+		}
+		return nil, fmt.Errorf("order rejected: %w", &APIError{
+			StatusCode:           http.StatusOK,
+			Code:                 code,
+			Message:              resp.ErrorResponse.Message,
+			Details:              resp.ErrorResponse.ErrorDetails,
+			PreviewFailureReason: resp.ErrorResponse.PreviewFailureReason,
+			Method:               http.MethodPost,
+			Path:                 advancedTradePrefix + "/orders",
+		})
+	}
+
+	orderID := resp.SuccessResponse.OrderID
+	if orderID == "" {
+		orderID = resp.OrderID
+	}
+
+	now := time.Now()
+	return &models.Order{
+		OrderID:       orderID,
+		ClientOrderID: clientOrderID,
+		Symbol:        order.Symbol,
+		Side:          order.Side,
+		Type:          order.Type,
+		Price:         order.Price,
+		Size:          order.Size,
+		Status:        models.OrderStatusNew,
+		TimeInForce:   order.TimeInForce,
+		PostOnly:      order.PostOnly,
+		ReduceOnly:    order.ReduceOnly,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}, nil
+}
+
+func (c *AdvancedTradeClient) CancelOrder(ctx context.Context, orderID string) error {
+	req := atCancelOrdersRequest{OrderIDs: []string{orderID}}
+
+	var resp atCancelOrdersResponse
+	if err := c.doRequest(ctx, http.MethodPost, advancedTradePrefix+"/orders/batch_cancel", req, &resp); err != nil {
+		return fmt.Errorf("failed to cancel order %s: %w", orderID, err)
+	}
+
+	for _, result := range resp.Results {
+		if result.OrderID == orderID && !result.Success {
+			return fmt.Errorf("failed to cancel order %s: %w", orderID, &APIError{
+				StatusCode: http.StatusOK,
+				Code:       result.FailureReason,
+				Method:     http.MethodPost,
+				Path:       advancedTradePrefix + "/orders/batch_cancel",
+			})
+		}
+	}
+
+	return nil
+}
+
+func (c *AdvancedTradeClient) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	path := fmt.Sprintf("%s/orders/historical/%s", advancedTradePrefix, url.PathEscape(orderID))
+
+	var resp atGetOrderResponse
+	if err := c.doRequest(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get order %s: %w", orderID, err)
+	}
+
+	return convertATOrder(&resp.Order), nil
+}
+
+// GetOrderByClientID finds an order placed since the given time by its client
+// order ID, returning ErrOrderNotFound if there is none
+func (c *AdvancedTradeClient) GetOrderByClientID(ctx context.Context, symbol, clientOrderID string, since time.Time) (*models.Order, error) {
+	query := url.Values{}
+	query.Set("product_ids", symbol)
+	query.Set("start_date", since.UTC().Format(time.RFC3339))
+
+	var found *models.Order
+	err := c.listOrders(ctx, query, func(o *atOrder) bool {
+		if o.ClientOrderID == clientOrderID {
+			found = convertATOrder(o)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("client order %s: %w", clientOrderID, ErrOrderNotFound)
+	}
+	return found, nil
+}
+
+// GetOpenOrders returns every working order on the given products
+func (c *AdvancedTradeClient) GetOpenOrders(ctx context.Context, symbols []string) ([]models.Order, error) {
+	query := url.Values{}
+	query.Set("order_status", "OPEN")
+	for _, symbol := range symbols {
+		query.Add("product_ids", symbol)
+	}
+
+	orders := make([]models.Order, 0)
+	err := c.listOrders(ctx, query, func(o *atOrder) bool {
+		orders = append(orders, *convertATOrder(o))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// listOrders pages through historical orders matching query, passing each to
+// visit until it returns false
+func (c *AdvancedTradeClient) listOrders(ctx context.Context, query url.Values, visit func(o *atOrder) bool) error {
+	path := advancedTradePrefix + "/orders/historical/batch"
+	for {
+		var resp atListOrdersResponse
+		if err := c.doRequest(ctx, http.MethodGet, path+"?"+query.Encode(), nil, &resp); err != nil {
+			return fmt.Errorf("failed to list orders: %w", err)
+		}
+
+		for i := range resp.Orders {
+			if !visit(&resp.Orders[i]) {
+				return nil
+			}
+		}
+
+		if !resp.HasNext || resp.Cursor == "" {
+			return nil
+		}
+		query.Set("cursor", resp.Cursor)
+	}
+}
+
+func buildATOrderConfiguration(order *models.OrderRequest) (atOrderConfiguration, error) {
+	var config atOrderConfiguration
+	size := formatFloat(order.Size)
+
+	switch order.Type {
+	case models.OrderTypeMarket:
+		config.MarketIOC = &atMarketConfig{BaseSize: size}
+	case models.OrderTypeLimit:
+		limit := &atLimitConfig{
+			BaseSize:   size,
+			LimitPrice: formatFloat(order.Price),
+		}
+		switch strings.ToUpper(order.TimeInForce) {
+		case "", "GTC":
+			limit.PostOnly = order.PostOnly
+			config.LimitGTC = limit
+		case "IOC":
+			config.LimitIOC = limit
+		case "FOK":
+			config.LimitFOK = limit
+		default:
+			return config, fmt.Errorf("unsupported time in force %q", order.TimeInForce)
+		}
+	default:
+		return config, fmt.Errorf("unsupported order type %q", order.Type)
+	}
+
+	return config, nil
+}
+
+func convertATOrder(o *atOrder) *models.Order {
+	order := &models.Order{
+		OrderID:       o.OrderID,
+		ClientOrderID: o.ClientOrderID,
+		Symbol:        o.ProductID,
+		Side:          models.OrderSide(strings.ToLower(o.Side)),
+		FilledSize:    parseFloat(o.FilledSize),
+		AvgFillPrice:  parseFloat(o.AverageFilledPrice),
+		TimeInForce:   o.TimeInForce,
+		CreatedAt:     o.CreatedTime,
+		UpdatedAt:     o.CreatedTime,
+	}
+	if o.LastFillTime != nil {
+		order.UpdatedAt = *o.LastFillTime
+	}
+
+	cfg := o.OrderConfiguration
+	switch {
+	case cfg.MarketIOC != nil:
+		order.Type = models.OrderTypeMarket
+		order.Size = parseFloat(cfg.MarketIOC.BaseSize)
+		order.Price = parseFloat(o.AverageFilledPrice)
+	case cfg.LimitGTC != nil:
+		order.Type = models.OrderTypeLimit
+		order.Size = parseFloat(cfg.LimitGTC.BaseSize)
+		order.Price = parseFloat(cfg.LimitGTC.LimitPrice)
+		order.PostOnly = cfg.LimitGTC.PostOnly
+	case cfg.LimitIOC != nil:
+		order.Type = models.OrderTypeLimit
+		order.Size = parseFloat(cfg.LimitIOC.BaseSize)
+		order.Price = parseFloat(cfg.LimitIOC.LimitPrice)
+	case cfg.LimitFOK != nil:
+		order.Type = models.OrderTypeLimit
+		order.Size = parseFloat(cfg.LimitFOK.BaseSize)
+		order.Price = parseFloat(cfg.LimitFOK.LimitPrice)
+	default:
+		order.Type = models.OrderType(strings.ToLower(o.OrderType))
+	}
+
+	order.Status = convertATOrderStatus(o.Status, order.FilledSize)
+	return order
+}
+
+func convertATProduct(p *atProduct) models.Instrument {
+	instrument := models.Instrument{
+		Symbol:        p.ProductID,
+		Type:          models.MarketTypeSpot,
+		Underlying:    p.BaseCurrencyID,
+		Quote:         p.QuoteCurrencyID,
+		ContractSize:  1,
+		TickSize:      parseFloat(p.PriceIncrement),
+		SizeIncrement: parseFloat(p.BaseIncrement),
+	}
+	if instrument.TickSize == 0 {
+		instrument.TickSize = parseFloat(p.QuoteIncrement)
+	}
+
+	details := p.FutureProductDetails
+	if details == nil {
+		return instrument
+	}
+	if details.ContractRootUnit != "" {
+		instrument.Underlying = details.ContractRootUnit
+	}
+	if size := parseFloat(details.ContractSize); size > 0 {
+		instrument.ContractSize = size
+	}
+
+	instrument.Type = models.MarketTypePerpetual
+	if details.ContractExpiryType != "PERPETUAL" {
+		instrument.Type = models.MarketTypeFuture
+		if t, err := time.Parse(time.RFC3339, details.ContractExpiry); err == nil {
+			instrument.Expiry = t
+		}
+	}
+	return instrument
+}
+
+func convertATOrderStatus(status string, filled float64) models.OrderStatus {
+	switch strings.ToUpper(status) {
+	case "FILLED":
+		return models.OrderStatusFilled
+	case "CANCELLED", "EXPIRED":
+		return models.OrderStatusCancelled
+	case "FAILED":
+		return models.OrderStatusRejected
+	default:
+		if filled > 0 {
+			return models.OrderStatusPartiallyFilled
+		}
+		return models.OrderStatusNew
+	}
+}
+
+func convertATLevels(levels []atPriceLevel) []models.OrderBookLevel {
+	result := make([]models.OrderBookLevel, 0, len(levels))
+	for _, l := range levels {
+		result = append(result, models.OrderBookLevel{
+			Price: parseFloat(l.Price),
+			Size:  parseFloat(l.Size),
+		})
+	}
+	return result
+}
@@ -0,0 +1,361 @@
+package coinbase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// recordedRequest is a request a fixture server received
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// fixtureServer replays recorded JSON responses from testdata, keyed by
+// "METHOD /path", and records the requests it is sent
+type fixtureServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	routes   map[string]string
+	requests []recordedRequest
+}
+
+func newFixtureServer(t *testing.T, dir string, routes map[string]string) *fixtureServer {
+	t.Helper()
+
+	s := &fixtureServer{routes: make(map[string]string)}
+	for route, fixture := range routes {
+		s.routes[route] = filepath.Join("testdata", dir, fixture)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fixtureServer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, recordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	fixture, ok := s.routes[r.Method+" "+r.URL.Path]
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, `{"error":"NOT_FOUND","message":"no fixture"}`, http.StatusNotFound)
+		return
+	}
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// route replaces the fixture served for a route
+func (s *fixtureServer) route(route, dir, fixture string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes[route] = filepath.Join("testdata", dir, fixture)
+}
+
+// received returns the requests made to path
+func (s *fixtureServer) received(path string) []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []recordedRequest
+	for _, r := range s.requests {
+		if r.Path == path {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// testBaseClient is a client for server that makes each request once
+func testBaseClient(server *fixtureServer, auth Authenticator) BaseClient {
+	return BaseClient{
+		auth:       auth,
+		baseURL:    server.URL,
+		httpClient: server.Client(),
+		limiter:    NewRateLimiter(DefaultRateLimits()),
+		retry:      RetryPolicy{MaxAttempts: 1},
+	}
+}
+
+func newTestAdvancedTradeClient(t *testing.T, routes map[string]string) (*AdvancedTradeClient, *fixtureServer) {
+	server := newFixtureServer(t, "advanced_trade", routes)
+	auth := NewLegacyAuthenticator("test-key", "c2VjcmV0", "test-passphrase")
+	return &AdvancedTradeClient{BaseClient: testBaseClient(server, auth)}, server
+}
+
+func TestAdvancedTradeGetInstruments(t *testing.T) {
+	client, server := newTestAdvancedTradeClient(t, map[string]string{
+		"GET /api/v3/brokerage/products": "products.json",
+	})
+
+	instruments, err := client.GetInstruments(context.Background())
+	if err != nil {
+		t.Fatalf("GetInstruments: %v", err)
+	}
+	if got := server.received("/api/v3/brokerage/products")[0].Query.Get("product_type"); got != "FUTURE" {
+		t.Errorf("product_type = %q, want FUTURE", got)
+	}
+	if len(instruments) != 2 {
+		t.Fatalf("got %d instruments, want 2", len(instruments))
+	}
+
+	dated := instruments[0]
+	wantExpiry := time.Date(2024, 12, 27, 16, 0, 0, 0, time.UTC)
+	if dated.Symbol != "BIT-27DEC24-CDE" || dated.Type != models.MarketTypeFuture || dated.Underlying != "BTC" ||
+		dated.Quote != "USD" || dated.ContractSize != 0.01 || dated.TickSize != 5 || dated.SizeIncrement != 1 ||
+		!dated.Expiry.Equal(wantExpiry) {
+		t.Errorf("dated future = %+v", dated)
+	}
+
+	perp := instruments[1]
+	if perp.Symbol != "BTC-PERP-INTX" || perp.Type != models.MarketTypePerpetual || perp.Underlying != "BTC" ||
+		perp.Quote != "USDC" || perp.ContractSize != 1 || perp.TickSize != 0.1 || !perp.Expiry.IsZero() {
+		t.Errorf("perpetual = %+v", perp)
+	}
+}
+
+func TestAdvancedTradeGetFundingRate(t *testing.T) {
+	client, _ := newTestAdvancedTradeClient(t, map[string]string{
+		"GET /api/v3/brokerage/products/BTC-PERP-INTX": "product_perp.json",
+	})
+
+	funding, err := client.GetFundingRate(context.Background(), "BTC-PERP-INTX")
+	if err != nil {
+		t.Fatalf("GetFundingRate: %v", err)
+	}
+	if funding.Rate != -0.000025 || funding.PredictedRate != funding.Rate || funding.Interval != time.Hour ||
+		!funding.NextFundingTime.Equal(time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("funding = %+v", funding)
+	}
+}
+
+func TestAdvancedTradeGetTicker(t *testing.T) {
+	client, server := newTestAdvancedTradeClient(t, map[string]string{
+		"GET /api/v3/brokerage/products/BTC-PERP-INTX/ticker": "ticker.json",
+	})
+
+	ticker, err := client.GetTicker(context.Background(), "BTC-PERP-INTX")
+	if err != nil {
+		t.Fatalf("GetTicker: %v", err)
+	}
+	if got := server.received("/api/v3/brokerage/products/BTC-PERP-INTX/ticker")[0].Query.Get("limit"); got != "1" {
+		t.Errorf("limit = %q, want 1", got)
+	}
+
+	wantTime := time.Date(2024, 6, 1, 12, 34, 56, 789000000, time.UTC)
+	if ticker.Symbol != "BTC-PERP-INTX" || ticker.BidPrice != 67450.0 || ticker.AskPrice != 67450.2 ||
+		ticker.LastPrice != 67450.1 || ticker.LastSize != 0.0215 || !ticker.Timestamp.Equal(wantTime) {
+		t.Errorf("ticker = %+v", ticker)
+	}
+}
+
+func TestAdvancedTradeGetOrderBook(t *testing.T) {
+	client, server := newTestAdvancedTradeClient(t, map[string]string{
+		"GET /api/v3/brokerage/product_book": "product_book.json",
+	})
+
+	book, err := client.GetOrderBook(context.Background(), "BTC-PERP-INTX", 2)
+	if err != nil {
+		t.Fatalf("GetOrderBook: %v", err)
+	}
+	query := server.received("/api/v3/brokerage/product_book")[0].Query
+	if query.Get("product_id") != "BTC-PERP-INTX" || query.Get("limit") != "50" {
+		t.Errorf("query = %v", query)
+	}
+
+	wantBids := []models.OrderBookLevel{{Price: 67450.0, Size: 1.25}, {Price: 67449.5, Size: 0.4}}
+	wantAsks := []models.OrderBookLevel{{Price: 67450.2, Size: 0.8}, {Price: 67451.0, Size: 2.1}}
+	if !equalLevels(book.Bids, wantBids) || !equalLevels(book.Asks, wantAsks) {
+		t.Errorf("book = %+v", book)
+	}
+	if !book.Timestamp.Equal(time.Date(2024, 6, 1, 12, 34, 57, 1000000, time.UTC)) {
+		t.Errorf("timestamp = %v", book.Timestamp)
+	}
+}
+
+func TestAdvancedTradePlaceOrder(t *testing.T) {
+	client, server := newTestAdvancedTradeClient(t, map[string]string{
+		"POST /api/v3/brokerage/orders": "create_order.json",
+	})
+
+	order, err := client.PlaceOrder(context.Background(), &models.OrderRequest{
+		ClientOrderID: "basis-test-1",
+		Symbol:        "BTC-PERP-INTX",
+		Side:          models.OrderSideBuy,
+		Type:          models.OrderTypeLimit,
+		Price:         67000,
+		Size:          0.5,
+		PostOnly:      true,
+	})
+	if err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	if order.OrderID != "11111111-2222-3333-4444-555555555555" || order.ClientOrderID != "basis-test-1" ||
+		order.Status != models.OrderStatusNew {
+		t.Errorf("order = %+v", order)
+	}
+
+	body := decodeBody(t, server.received("/api/v3/brokerage/orders")[0])
+	if body["client_order_id"] != "basis-test-1" || body["product_id"] != "BTC-PERP-INTX" || body["side"] != "BUY" {
+		t.Errorf("body = %v", body)
+	}
+	if _, ok := body["reduce_only"]; ok {
+		t.Errorf("reduce_only sent on an ordinary order: %v", body)
+	}
+	limit, _ := body["order_configuration"].(map[string]any)["limit_limit_gtc"].(map[string]any)
+	if limit["base_size"] != "0.5" || limit["limit_price"] != "67000" || limit["post_only"] != true {
+		t.Errorf("order_configuration = %v", body["order_configuration"])
+	}
+}
+
+func TestAdvancedTradePlaceReduceOnlyOrder(t *testing.T) {
+	client, server := newTestAdvancedTradeClient(t, map[string]string{
+		"POST /api/v3/brokerage/orders": "create_order.json",
+	})
+	request := &models.OrderRequest{
+		ClientOrderID: "basis-test-2",
+		Symbol:        "BIT-27DEC24-CDE",
+		Side:          models.OrderSideBuy,
+		Type:          models.OrderTypeMarket,
+		Size:          4,
+		ReduceOnly:    true,
+	}
+
+	if _, err := client.PlaceOrder(context.Background(), request); err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	body := decodeBody(t, server.received("/api/v3/brokerage/orders")[0])
+	if body["reduce_only"] != true {
+		t.Errorf("reduce_only = %v, want true", body["reduce_only"])
+	}
+	if market, _ := body["order_configuration"].(map[string]any)["market_market_ioc"].(map[string]any); market["base_size"] != "4" {
+		t.Errorf("order_configuration = %v", body["order_configuration"])
+	}
+	if got := server.received("/api/v3/brokerage/cfm/positions"); len(got) != 0 {
+		t.Errorf("reduce-only order checked positions %d times", len(got))
+	}
+
+	// The exchange rejects reduce-only orders that would grow the position
+	server.route("POST /api/v3/brokerage/orders", "advanced_trade", "create_order_reduce_only.json")
+	_, err := client.PlaceOrder(context.Background(), request)
+	if !errors.Is(err, ErrReduceOnly) {
+		t.Fatalf("PlaceOrder error = %v, want ErrReduceOnly", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.PreviewFailureReason != "PREVIEW_INVALID_REDUCE_ONLY" {
+		t.Errorf("error = %#v", err)
+	}
+}
+
+func TestAdvancedTradeCancelOrder(t *testing.T) {
+	client, server := newTestAdvancedTradeClient(t, map[string]string{
+		"POST /api/v3/brokerage/orders/batch_cancel": "batch_cancel.json",
+	})
+
+	if err := client.CancelOrder(context.Background(), "11111111-2222-3333-4444-555555555555"); err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	body := decodeBody(t, server.received("/api/v3/brokerage/orders/batch_cancel")[0])
+	if ids, _ := body["order_ids"].([]any); len(ids) != 1 || ids[0] != "11111111-2222-3333-4444-555555555555" {
+		t.Errorf("order_ids = %v", body["order_ids"])
+	}
+
+	server.route("POST /api/v3/brokerage/orders/batch_cancel", "advanced_trade", "batch_cancel_unknown.json")
+	if err := client.CancelOrder(context.Background(), "99999999-0000-0000-0000-000000000000"); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("CancelOrder error = %v, want ErrOrderNotFound", err)
+	}
+}
+
+func TestAdvancedTradeGetOrder(t *testing.T) {
+	client, _ := newTestAdvancedTradeClient(t, map[string]string{
+		"GET /api/v3/brokerage/orders/historical/11111111-2222-3333-4444-555555555555": "order.json",
+	})
+
+	order, err := client.GetOrder(context.Background(), "11111111-2222-3333-4444-555555555555")
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if order.ClientOrderID != "basis-test-1" || order.Symbol != "BTC-PERP-INTX" || order.Side != models.OrderSideBuy ||
+		order.Type != models.OrderTypeLimit || order.Size != 0.5 || order.Price != 67000 || !order.PostOnly ||
+		order.FilledSize != 0.2 || order.AvgFillPrice != 67000 || order.Status != models.OrderStatusPartiallyFilled {
+		t.Errorf("order = %+v", order)
+	}
+	if !order.UpdatedAt.Equal(time.Date(2024, 6, 1, 12, 31, 15, 500000000, time.UTC)) {
+		t.Errorf("updated at = %v, want the last fill time", order.UpdatedAt)
+	}
+}
+
+func TestAdvancedTradeGetPositions(t *testing.T) {
+	client, _ := newTestAdvancedTradeClient(t, map[string]string{
+		"GET /api/v3/brokerage/cfm/positions": "cfm_positions.json",
+	})
+
+	positions, err := client.GetPositions(context.Background())
+	if err != nil {
+		t.Fatalf("GetPositions: %v", err)
+	}
+	if len(positions) != 2 {
+		t.Fatalf("got %d positions, want 2", len(positions))
+	}
+
+	short := positions[0]
+	if short.Symbol != "BIT-27DEC24-CDE" || short.Side != "short" || short.Size != -12 || short.EntryPrice != 68850 ||
+		short.MarkPrice != 69120 || short.UnrealizedPL != -32.4 || short.RealizedPL != 4.25 {
+		t.Errorf("short = %+v", short)
+	}
+	if long := positions[1]; long.Symbol != "ETP-27DEC24-CDE" || long.Side != "long" || long.Size != 3 {
+		t.Errorf("long = %+v", long)
+	}
+}
+
+func decodeBody(t *testing.T, r recordedRequest) map[string]any {
+	t.Helper()
+
+	var body map[string]any
+	if err := json.Unmarshal(r.Body, &body); err != nil {
+		t.Fatalf("failed to decode %s %s body: %v", r.Method, r.Path, err)
+	}
+	return body
+}
+
+func equalLevels(got, want []models.OrderBookLevel) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
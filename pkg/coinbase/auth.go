@@ -7,14 +7,20 @@ import (
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
-	"math/big"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// subscriptionSigner is implemented by authenticators that can sign
+// websocket subscribe messages
+type subscriptionSigner interface {
+	signSubscription(sub *SubscribeMessage) error
+}
+
 // AuthType represents the authentication method
 type AuthType string
 
@@ -60,12 +66,65 @@ func (l *LegacyAuthenticator) sign(method, path, body, timestamp string) string
 	return computeHMAC(message, l.apiSecret)
 }
 
+// signSubscription adds key, passphrase and the /users/self/verify signature
+// expected by the Exchange websocket feed
+func (l *LegacyAuthenticator) signSubscription(sub *SubscribeMessage) error {
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+
+	sub.Key = l.apiKey
+	sub.Passphrase = l.passphrase
+	sub.Timestamp = timestamp
+	sub.Signature = l.sign("GET", "/users/self/verify", "", timestamp)
+	return nil
+}
+
+// PrimeAuthenticator signs requests for the Prime REST API, which uses the
+// same HMAC scheme as legacy keys but with X-CB-ACCESS-* headers
+type PrimeAuthenticator struct {
+	LegacyAuthenticator
+}
+
+func NewPrimeAuthenticator(apiKey, apiSecret, passphrase string) *PrimeAuthenticator {
+	return &PrimeAuthenticator{
+		LegacyAuthenticator: LegacyAuthenticator{
+			apiKey:     apiKey,
+			apiSecret:  apiSecret,
+			passphrase: passphrase,
+		},
+	}
+}
+
+func (p *PrimeAuthenticator) AddAuthHeaders(req *http.Request, method, path, body string) error {
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	signature := p.sign(method, path, body, timestamp)
+
+	req.Header.Set("X-CB-ACCESS-KEY", p.apiKey)
+	req.Header.Set("X-CB-ACCESS-SIGNATURE", signature)
+	req.Header.Set("X-CB-ACCESS-TIMESTAMP", timestamp)
+	req.Header.Set("X-CB-ACCESS-PASSPHRASE", p.passphrase)
+
+	return nil
+}
+
 // JWTAuthenticator uses the new JWT-based authentication
 type JWTAuthenticator struct {
 	apiKeyName string
 	privateKey *ecdsa.PrivateKey
+
+	// Cached websocket token, reused until it is close to expiry
+	wsMu     sync.Mutex
+	wsToken  string
+	wsExpiry time.Time
 }
 
+const (
+	// jwtLifetime is the validity Coinbase allows for API JWTs
+	jwtLifetime = 2 * time.Minute
+
+	// jwtRefreshMargin is how long before expiry a cached token is replaced
+	jwtRefreshMargin = 30 * time.Second
+)
+
 func NewJWTAuthenticator(apiKeyName, privateKeyPEM string) (*JWTAuthenticator, error) {
 	// Parse the private key
 	block, _ := pem.Decode([]byte(privateKeyPEM))
@@ -94,6 +153,11 @@ func NewJWTAuthenticator(apiKeyName, privateKeyPEM string) (*JWTAuthenticator, e
 }
 
 func (j *JWTAuthenticator) AddAuthHeaders(req *http.Request, method, path, body string) error {
+	// The JWT uri claim covers the path only, never the query string
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+
 	token, err := j.generateJWT(method, req.Host, path)
 	if err != nil {
 		return fmt.Errorf("failed to generate JWT: %w", err)
@@ -104,21 +168,61 @@ func (j *JWTAuthenticator) AddAuthHeaders(req *http.Request, method, path, body
 }
 
 func (j *JWTAuthenticator) generateJWT(method, host, path string) (string, error) {
+	token, _, err := j.signJWT(method + " " + host + path)
+	return token, err
+}
+
+// signSubscription attaches a websocket JWT to an Advanced Trade subscription
+func (j *JWTAuthenticator) signSubscription(sub *SubscribeMessage) error {
+	token, err := j.websocketJWT()
+	if err != nil {
+		return fmt.Errorf("failed to generate websocket JWT: %w", err)
+	}
+	sub.JWT = token
+	return nil
+}
+
+// websocketJWT returns a token for websocket messages, which carry no uri
+// claim. Tokens are cached and replaced once within jwtRefreshMargin of expiry.
+func (j *JWTAuthenticator) websocketJWT() (string, error) {
+	j.wsMu.Lock()
+	defer j.wsMu.Unlock()
+
+	if j.wsToken != "" && time.Until(j.wsExpiry) > jwtRefreshMargin {
+		return j.wsToken, nil
+	}
+
+	token, expiry, err := j.signJWT("")
+	if err != nil {
+		return "", err
+	}
+	j.wsToken = token
+	j.wsExpiry = expiry
+	return token, nil
+}
+
+// signJWT signs a token for the given uri claim, omitted when empty
+func (j *JWTAuthenticator) signJWT(uri string) (string, time.Time, error) {
 	// Generate nonce
 	nonce, err := generateNonce()
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
+	now := time.Now()
+	expiry := now.Add(jwtLifetime)
+
 	// JWT claims
 	claims := jwt.MapClaims{
-		"sub": j.apiKeyName,
-		"iss": "coinbase-cloud",
-		"nbf": time.Now().Unix(),
-		"exp": time.Now().Add(2 * time.Minute).Unix(),
-		"uri": method + " " + host + path,
+		"sub":   j.apiKeyName,
+		"iss":   "coinbase-cloud",
+		"nbf":   now.Unix(),
+		"exp":   expiry.Unix(),
 		"nonce": nonce,
 	}
+	if uri != "" {
+		claims["uri"] = uri
+	}
 
 	// Create token
 	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
@@ -128,10 +232,10 @@ func (j *JWTAuthenticator) generateJWT(method, host, path string) (string, error
 	// Sign token
 	tokenString, err := token.SignedString(j.privateKey)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
 	}
 
-	return tokenString, nil
+	return tokenString, expiry, nil
 }
 
 func generateNonce() (string, error) {
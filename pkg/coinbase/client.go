@@ -1,12 +1,16 @@
 package coinbase
 
 import (
+	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gregtusar/basis/pkg/models"
@@ -19,13 +23,29 @@ type Client interface {
 	PlaceOrder(ctx context.Context, order *models.OrderRequest) (*models.Order, error)
 	CancelOrder(ctx context.Context, orderID string) error
 	GetOrder(ctx context.Context, orderID string) (*models.Order, error)
+	GetOpenOrders(ctx context.Context, symbols []string) ([]models.Order, error)
 	Subscribe(channels []string, symbols []string) error
 }
 
+// FundingRateSource is implemented by clients for venues that list perpetual
+// futures
+type FundingRateSource interface {
+	GetFundingRate(ctx context.Context, symbol string) (*models.FundingRate, error)
+}
+
+// InstrumentSource is implemented by clients that can describe the products
+// their venue lists
+type InstrumentSource interface {
+	GetInstruments(ctx context.Context) ([]models.Instrument, error)
+}
+
 type BaseClient struct {
 	auth       Authenticator
 	baseURL    string
 	httpClient *http.Client
+	limiter    *RateLimiter
+	retry      RetryPolicy
+	ws         *WebSocketClient
 }
 
 type AdvancedTradeClient struct {
@@ -34,6 +54,8 @@ type AdvancedTradeClient struct {
 
 type PrimeClient struct {
 	BaseClient
+	portfolioID   string
+	marketDataURL string
 }
 
 // NewAdvancedTradeClient creates a client with legacy authentication (for backward compatibility)
@@ -48,6 +70,8 @@ func NewAdvancedTradeClient(apiKey, apiSecret, passphrase string, sandbox bool)
 			auth:       NewLegacyAuthenticator(apiKey, apiSecret, passphrase),
 			baseURL:    baseURL,
 			httpClient: &http.Client{Timeout: 30 * time.Second},
+			limiter:    NewRateLimiter(DefaultRateLimits()),
+			retry:      DefaultRetryPolicy(),
 		},
 	}
 }
@@ -69,24 +93,67 @@ func NewAdvancedTradeClientJWT(apiKeyName, privateKeyPEM string, sandbox bool) (
 			auth:       auth,
 			baseURL:    baseURL,
 			httpClient: &http.Client{Timeout: 30 * time.Second},
+			limiter:    NewRateLimiter(DefaultRateLimits()),
+			retry:      DefaultRetryPolicy(),
 		},
 	}, nil
 }
 
-// NewPrimeClient creates a client with legacy authentication (Prime still uses this)
-func NewPrimeClient(apiKey, apiSecret, passphrase string, sandbox bool) *PrimeClient {
+// NewPrimeClient creates a client with Prime key/secret/passphrase authentication.
+// Orders and balances are scoped to portfolioID; public market data comes from
+// the Exchange product endpoints since Prime REST does not serve books.
+func NewPrimeClient(apiKey, apiSecret, passphrase, portfolioID string, sandbox bool) *PrimeClient {
 	baseURL := "https://api.prime.coinbase.com"
+	marketDataURL := "https://api.exchange.coinbase.com"
 	if sandbox {
 		baseURL = "https://api-public.sandbox.prime.coinbase.com"
+		marketDataURL = "https://api-public.sandbox.exchange.coinbase.com"
 	}
 
 	return &PrimeClient{
 		BaseClient: BaseClient{
-			auth:       NewLegacyAuthenticator(apiKey, apiSecret, passphrase),
+			auth:       NewPrimeAuthenticator(apiKey, apiSecret, passphrase),
 			baseURL:    baseURL,
 			httpClient: &http.Client{Timeout: 30 * time.Second},
+			limiter:    NewRateLimiter(DefaultRateLimits()),
+			retry:      DefaultRetryPolicy(),
 		},
+		portfolioID:   portfolioID,
+		marketDataURL: marketDataURL,
+	}
+}
+
+// SetRateLimiter replaces the client's default rate limiter
+func (c *BaseClient) SetRateLimiter(limiter *RateLimiter) {
+	c.limiter = limiter
+}
+
+// SetRetryPolicy replaces the client's default retry policy
+func (c *BaseClient) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
+// RateLimitStats reports the client's usage of each endpoint class
+func (c *BaseClient) RateLimitStats() []RateLimitStats {
+	return c.limiter.Stats()
+}
+
+// Authenticator returns the credentials the client signs requests with, so a
+// websocket client for the same account can reuse them
+func (c *BaseClient) Authenticator() Authenticator {
+	return c.auth
+}
+
+// SetWebSocket attaches a websocket client used to serve Subscribe
+func (c *BaseClient) SetWebSocket(ws *WebSocketClient) {
+	c.ws = ws
+}
+
+func (c *BaseClient) Subscribe(channels []string, symbols []string) error {
+	if c.ws == nil {
+		return fmt.Errorf("client has no websocket attached")
 	}
+	return c.ws.Subscribe(channels, symbols)
 }
 
 // computeHMAC calculates HMAC for legacy authentication
@@ -96,8 +163,52 @@ func computeHMAC(message, secret string) string {
 	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
 
-func (c *BaseClient) doRequest(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
-	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+// doRequest is the request pipeline shared by all REST clients: in (if non-nil)
+// is marshalled as the JSON body, the request is signed and sent, non-2xx
+// responses are returned as *APIError and successful bodies are decoded into
+// out (if non-nil). GETs are retried on transient failures; other methods are
+// sent exactly once.
+func (c *BaseClient) doRequest(ctx context.Context, method, path string, in, out interface{}) error {
+	if method != http.MethodGet {
+		return c.doRequestOnce(ctx, method, path, in, out)
+	}
+	return c.retry.retry(ctx, func() error {
+		return c.doRequestOnce(ctx, method, path, in, out)
+	})
+}
+
+func (c *BaseClient) doRequestOnce(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	class := classifyEndpoint(method, path)
+	if err := c.limiter.Wait(ctx, class); err != nil {
+		return err
+	}
+
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	c.limiter.Observe(class, resp)
+
+	return decodeResponse(resp, method, path, out)
+}
+
+// send signs and executes a single request with the given raw body
+func (c *BaseClient) send(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
 	if err != nil {
 		return nil, err
 	}
@@ -106,8 +217,49 @@ func (c *BaseClient) doRequest(ctx context.Context, method, path string, body []
 	if err := c.auth.AddAuthHeaders(req, method, path, string(body)); err != nil {
 		return nil, fmt.Errorf("failed to add auth headers: %w", err)
 	}
-	
+
 	req.Header.Set("Content-Type", "application/json")
 
 	return c.httpClient.Do(req)
-}
\ No newline at end of file
+}
+
+// decodeResponse consumes resp, converting non-2xx statuses into *APIError
+// and decoding successful bodies into out (if non-nil)
+func decodeResponse(resp *http.Response, method, path string, out interface{}) error {
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(resp.StatusCode, method, path, data)
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// parseFloat converts the decimal strings used by Coinbase APIs, treating
+// empty or malformed values as zero
+func parseFloat(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// formatFloat renders a float as the decimal string Coinbase expects
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
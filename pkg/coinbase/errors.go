@@ -0,0 +1,111 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors callers can branch on with errors.Is. An *APIError unwraps
+// to at most one of these depending on its status and error codes.
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrUnauthorized      = errors.New("unauthorized")
+
+	// ErrReduceOnly means a reduce-only order would open or grow a position
+	ErrReduceOnly = errors.New("reduce-only order would increase position")
+
+	// ErrOrderStatusUnknown means an order placement failed in a way that
+	// leaves open whether the exchange accepted it, and it could not be found
+	// by its client order ID afterwards. It may yet appear.
+	ErrOrderStatusUnknown = errors.New("order status unknown")
+)
+
+// APIError is a non-2xx response or a rejected request returned by a
+// Coinbase REST API
+type APIError struct {
+	StatusCode           int
+	Code                 string
+	Message              string
+	Details              string
+	PreviewFailureReason string
+	Method               string
+	Path                 string
+}
+
+// errorResponse is the error body returned by Coinbase REST APIs
+type errorResponse struct {
+	Error                string `json:"error"`
+	Code                 string `json:"code"`
+	Message              string `json:"message"`
+	ErrorDetails         string `json:"error_details"`
+	PreviewFailureReason string `json:"preview_failure_reason"`
+}
+
+func newAPIError(statusCode int, method, path string, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		Method:     method,
+		Path:       path,
+	}
+
+	var resp errorResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		apiErr.Message = strings.TrimSpace(string(body))
+		return apiErr
+	}
+
+	apiErr.Code = resp.Error
+	if apiErr.Code == "" {
+		apiErr.Code = resp.Code
+	}
+	apiErr.Message = resp.Message
+	apiErr.Details = resp.ErrorDetails
+	apiErr.PreviewFailureReason = resp.PreviewFailureReason
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s: status %d", e.Method, e.Path, e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.PreviewFailureReason != "" {
+		msg += " (" + e.PreviewFailureReason + ")"
+	}
+	return msg
+}
+
+// Unwrap maps the error onto the matching sentinel, if any
+func (e *APIError) Unwrap() error {
+	codes := strings.ToUpper(e.Code + " " + e.PreviewFailureReason)
+	message := strings.ToLower(e.Message)
+
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests || strings.Contains(codes, "RATE_LIMIT"):
+		return ErrRateLimited
+	case strings.Contains(codes, "REDUCE_ONLY") || strings.Contains(message, "reduce only") || strings.Contains(message, "reduce-only"):
+		return ErrReduceOnly
+	case strings.Contains(codes, "INSUFFICIENT_FUND") || strings.Contains(message, "insufficient"):
+		return ErrInsufficientFunds
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case strings.Contains(codes, "UNKNOWN_CANCEL_ORDER") || strings.Contains(message, "order not found"):
+		return ErrOrderNotFound
+	case e.StatusCode == http.StatusNotFound && strings.Contains(e.Path, "/order"):
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+// Temporary reports whether the request may succeed if repeated later
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
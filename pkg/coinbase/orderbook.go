@@ -0,0 +1,432 @@
+package coinbase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// resyncTimeout bounds a REST snapshot fetched to repair a book
+	resyncTimeout = 10 * time.Second
+
+	// resyncDepth is the book level requested when resyncing
+	resyncDepth = 2
+)
+
+// BookBuilder maintains local L2 order books from level2 websocket messages.
+// A book becomes invalid on a sequence gap, an out-of-order message or a
+// disconnect. A book streamed from a websocket is repaired by resubscribing
+// its level2 channel there, since only the feed's own snapshot lines up with
+// the updates that follow it; a REST snapshot is used only for books no feed
+// has streamed. Exchange books are only trusted while their messages carry
+// sequence numbers; Advanced Trade ones rely on the connection's sequence_num.
+type BookBuilder struct {
+	mu      sync.RWMutex
+	books   map[string]*localBook
+	clients map[string]Client
+	logger  *logrus.Logger
+}
+
+type localBook struct {
+	bids      map[float64]float64
+	asks      map[float64]float64
+	sequence  int64
+	valid     bool
+	resyncing bool
+	updatedAt time.Time
+
+	// source is the websocket the book was last streamed from
+	source *WebSocketClient
+}
+
+// Exchange feed level2 messages
+
+type exchangeSnapshotMessage struct {
+	ProductID string      `json:"product_id"`
+	Sequence  int64       `json:"sequence"`
+	Bids      [][2]string `json:"bids"`
+	Asks      [][2]string `json:"asks"`
+}
+
+type exchangeL2UpdateMessage struct {
+	ProductID string      `json:"product_id"`
+	Sequence  int64       `json:"sequence"`
+	Time      time.Time   `json:"time"`
+	Changes   [][3]string `json:"changes"`
+}
+
+// Advanced Trade level2 messages
+
+type atL2Message struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Events    []atL2Event `json:"events"`
+}
+
+type atL2Event struct {
+	Type      string       `json:"type"`
+	ProductID string       `json:"product_id"`
+	Updates   []atL2Update `json:"updates"`
+}
+
+type atL2Update struct {
+	Side        string `json:"side"`
+	PriceLevel  string `json:"price_level"`
+	NewQuantity string `json:"new_quantity"`
+}
+
+func NewBookBuilder(logger *logrus.Logger) *BookBuilder {
+	return &BookBuilder{
+		books:   make(map[string]*localBook),
+		clients: make(map[string]Client),
+		logger:  logger,
+	}
+}
+
+// Track starts maintaining a book for productID, resynced through client
+func (b *BookBuilder) Track(productID string, client Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.clients[productID] = client
+	if _, ok := b.books[productID]; !ok {
+		b.books[productID] = newLocalBook()
+	}
+}
+
+// Attach registers the builder's handlers on a websocket client
+func (b *BookBuilder) Attach(ws *WebSocketClient) {
+	ws.RegisterHandler("snapshot", func(message json.RawMessage) error {
+		return b.handleExchangeSnapshot(ws, message)
+	})
+	ws.RegisterHandler("l2update", func(message json.RawMessage) error {
+		return b.handleExchangeUpdate(ws, message)
+	})
+	ws.RegisterHandler("l2_data", func(message json.RawMessage) error {
+		return b.handleATLevel2(ws, message)
+	})
+	ws.OnSequenceGap(func(expected, got int64) {
+		b.InvalidateAll(fmt.Sprintf("feed sequence gap: expected %d, got %d", expected, got))
+	})
+}
+
+// Book returns a sorted copy of up to depth levels per side (all levels if
+// depth <= 0). ok is false if the book is unknown or currently invalid.
+func (b *BookBuilder) Book(productID string, depth int) (book *models.OrderBook, ok bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	lb, exists := b.books[productID]
+	if !exists || !lb.valid {
+		return nil, false
+	}
+
+	return &models.OrderBook{
+		Symbol:    productID,
+		Bids:      sortedLevels(lb.bids, depth, true),
+		Asks:      sortedLevels(lb.asks, depth, false),
+		Sequence:  lb.sequence,
+		Timestamp: lb.updatedAt,
+	}, true
+}
+
+// Invalidate marks a book unusable and schedules a resync
+func (b *BookBuilder) Invalidate(productID, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.invalidateLocked(productID, reason)
+}
+
+// InvalidateAll marks every tracked book unusable, e.g. after a disconnect
+func (b *BookBuilder) InvalidateAll(reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for productID := range b.books {
+		b.invalidateLocked(productID, reason)
+	}
+}
+
+func (b *BookBuilder) invalidateLocked(productID, reason string) {
+	lb, ok := b.books[productID]
+	if !ok {
+		return
+	}
+
+	if lb.valid {
+		b.logger.WithFields(logrus.Fields{
+			"product_id": productID,
+			"reason":     reason,
+		}).Warn("Order book invalidated")
+	}
+	lb.valid = false
+
+	if lb.resyncing {
+		return
+	}
+	switch {
+	case lb.source != nil:
+		lb.resyncing = true
+		go b.resubscribe(productID, lb.source)
+	case b.clients[productID] != nil:
+		lb.resyncing = true
+		go b.resync(productID, b.clients[productID])
+	}
+}
+
+// resubscribe asks the websocket a book was streamed from for a fresh
+// snapshot. Updates are ignored until it arrives, so none applied to the new
+// book predate it.
+func (b *BookBuilder) resubscribe(productID string, ws *WebSocketClient) {
+	err := ws.Resubscribe([]string{ws.Level2Channel()}, []string{productID})
+	if err == nil {
+		// resyncing is cleared by the snapshot
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.books[productID].resyncing = false
+	if ws.Connected() {
+		b.logger.WithError(err).WithField("product_id", productID).Error("Failed to resubscribe order book")
+	}
+	// Otherwise the reconnect replays the subscription, which sends a snapshot
+}
+
+// resync replaces an invalid book that no feed has streamed with a REST
+// snapshot
+func (b *BookBuilder) resync(productID string, client Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), resyncTimeout)
+	defer cancel()
+
+	snapshot, err := client.GetOrderBook(ctx, productID, resyncDepth)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lb := b.books[productID]
+	lb.resyncing = false
+	if lb.valid {
+		// A websocket snapshot arrived while the REST call was in flight
+		return
+	}
+	if lb.source != nil {
+		// A feed started streaming the book, and only its own snapshot
+		// lines up with its updates
+		b.invalidateLocked(productID, "order book is streamed")
+		return
+	}
+	if err != nil {
+		b.logger.WithError(err).WithField("product_id", productID).Error("Failed to resync order book")
+		return
+	}
+
+	lb.reset()
+	for _, level := range snapshot.Bids {
+		lb.bids[level.Price] = level.Size
+	}
+	for _, level := range snapshot.Asks {
+		lb.asks[level.Price] = level.Size
+	}
+	lb.sequence = snapshot.Sequence
+	lb.valid = true
+	lb.updatedAt = snapshot.Timestamp
+	if lb.updatedAt.IsZero() {
+		lb.updatedAt = time.Now()
+	}
+
+	b.logger.WithField("product_id", productID).Info("Order book resynced from REST snapshot")
+}
+
+func (b *BookBuilder) handleExchangeSnapshot(ws *WebSocketClient, message json.RawMessage) error {
+	var msg exchangeSnapshotMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lb := b.bookLocked(msg.ProductID)
+	lb.reset()
+	lb.source = ws
+	lb.resyncing = false
+	for _, level := range msg.Bids {
+		lb.bids[parseFloat(level[0])] = parseFloat(level[1])
+	}
+	for _, level := range msg.Asks {
+		lb.asks[parseFloat(level[0])] = parseFloat(level[1])
+	}
+	lb.sequence = msg.Sequence
+	// Updates following an unsequenced snapshot cannot be checked for gaps
+	lb.valid = msg.Sequence != 0
+	lb.updatedAt = time.Now()
+	return nil
+}
+
+func (b *BookBuilder) handleExchangeUpdate(ws *WebSocketClient, message json.RawMessage) error {
+	var msg exchangeL2UpdateMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("failed to decode l2update: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lb := b.bookLocked(msg.ProductID)
+	if !b.checkSourceLocked(msg.ProductID, lb, ws) || !lb.valid {
+		return nil
+	}
+	if !b.checkSequenceLocked(msg.ProductID, lb, msg.Sequence) {
+		return nil
+	}
+
+	for _, change := range msg.Changes {
+		side := lb.bids
+		if change[0] == "sell" {
+			side = lb.asks
+		}
+		applyLevel(side, parseFloat(change[1]), parseFloat(change[2]))
+	}
+	lb.updatedAt = msg.Time
+	if lb.updatedAt.IsZero() {
+		lb.updatedAt = time.Now()
+	}
+	return nil
+}
+
+func (b *BookBuilder) handleATLevel2(ws *WebSocketClient, message json.RawMessage) error {
+	var msg atL2Message
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("failed to decode l2_data: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range msg.Events {
+		lb := b.bookLocked(event.ProductID)
+		switch event.Type {
+		case "snapshot":
+			lb.reset()
+			lb.valid = true
+			lb.resyncing = false
+			lb.source = ws
+		case "update":
+			if !b.checkSourceLocked(event.ProductID, lb, ws) || !lb.valid {
+				continue
+			}
+		default:
+			continue
+		}
+
+		for _, update := range event.Updates {
+			side := lb.bids
+			if update.Side == "offer" || update.Side == "ask" {
+				side = lb.asks
+			}
+			applyLevel(side, parseFloat(update.PriceLevel), parseFloat(update.NewQuantity))
+		}
+		lb.updatedAt = msg.Timestamp
+		if lb.updatedAt.IsZero() {
+			lb.updatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// checkSequenceLocked validates a per-product sequence number, invalidating
+// the book on gaps and reordering. Messages already covered by a snapshot are
+// dropped. Returns whether the message should be applied.
+func (b *BookBuilder) checkSequenceLocked(productID string, lb *localBook, sequence int64) bool {
+	if sequence == 0 {
+		// A dropped update cannot be detected, so the book stays unusable
+		// until a sequenced snapshot replaces it. A REST resync would be
+		// just as unverifiable once the next update arrives.
+		if lb.valid {
+			b.logger.WithField("product_id", productID).Warn("Order book update carries no sequence number, book is unverifiable")
+		}
+		lb.valid = false
+		return false
+	}
+	switch {
+	case sequence <= lb.sequence:
+		return false
+	case sequence > lb.sequence+1:
+		b.invalidateLocked(productID, fmt.Sprintf("sequence gap: expected %d, got %d", lb.sequence+1, sequence))
+		return false
+	}
+
+	lb.sequence = sequence
+	return true
+}
+
+// checkSourceLocked invalidates a book that was not built from ws's own
+// snapshot, such as one resynced over REST, so that ws sends one. Returns
+// whether updates from ws may be applied.
+func (b *BookBuilder) checkSourceLocked(productID string, lb *localBook, ws *WebSocketClient) bool {
+	if lb.source == ws {
+		return true
+	}
+	lb.source = ws
+	b.invalidateLocked(productID, "order book was not built from the feed's snapshot")
+	return false
+}
+
+func (b *BookBuilder) bookLocked(productID string) *localBook {
+	lb, ok := b.books[productID]
+	if !ok {
+		lb = newLocalBook()
+		b.books[productID] = lb
+	}
+	return lb
+}
+
+func newLocalBook() *localBook {
+	return &localBook{
+		bids: make(map[float64]float64),
+		asks: make(map[float64]float64),
+	}
+}
+
+func (lb *localBook) reset() {
+	lb.bids = make(map[float64]float64)
+	lb.asks = make(map[float64]float64)
+	lb.sequence = 0
+}
+
+func applyLevel(side map[float64]float64, price, size float64) {
+	if size == 0 {
+		delete(side, price)
+		return
+	}
+	side[price] = size
+}
+
+func sortedLevels(side map[float64]float64, depth int, descending bool) []models.OrderBookLevel {
+	prices := make([]float64, 0, len(side))
+	for price := range side {
+		prices = append(prices, price)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+	if depth > 0 && len(prices) > depth {
+		prices = prices[:depth]
+	}
+
+	levels := make([]models.OrderBookLevel, 0, len(prices))
+	for _, price := range prices {
+		levels = append(levels, models.OrderBookLevel{Price: price, Size: side[price]})
+	}
+	return levels
+}
@@ -0,0 +1,122 @@
+package coinbase
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExchangeBookRequiresSequence(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		valid    bool
+	}{
+		{
+			name: "sequenced",
+			messages: []string{
+				`{"type":"snapshot","product_id":"BTC-USD","sequence":10,"bids":[["100","1"]],"asks":[["101","1"]]}`,
+				`{"type":"l2update","product_id":"BTC-USD","sequence":11,"changes":[["buy","100.5","2"]]}`,
+			},
+			valid: true,
+		},
+		{
+			name: "unsequenced snapshot",
+			messages: []string{
+				`{"type":"snapshot","product_id":"BTC-USD","bids":[["100","1"]],"asks":[["101","1"]]}`,
+			},
+		},
+		{
+			name: "unsequenced update",
+			messages: []string{
+				`{"type":"snapshot","product_id":"BTC-USD","sequence":10,"bids":[["100","1"]],"asks":[["101","1"]]}`,
+				`{"type":"l2update","product_id":"BTC-USD","changes":[["buy","100.5","2"]]}`,
+			},
+		},
+		{
+			name: "gap",
+			messages: []string{
+				`{"type":"snapshot","product_id":"BTC-USD","sequence":10,"bids":[["100","1"]],"asks":[["101","1"]]}`,
+				`{"type":"l2update","product_id":"BTC-USD","sequence":12,"changes":[["buy","100.5","2"]]}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := NewWebSocketClient("wss://ws-feed.exchange.coinbase.com", nil, testLogger())
+			books := NewBookBuilder(testLogger())
+			books.Attach(ws)
+			for _, msg := range tt.messages {
+				ws.Dispatch(time.Now(), []byte(msg))
+			}
+
+			book, ok := books.Book("BTC-USD", 0)
+			if ok != tt.valid {
+				t.Fatalf("book valid = %v, want %v", ok, tt.valid)
+			}
+			if ok && (len(book.Bids) != 2 || book.Bids[0].Price != 100.5 || book.Sequence != 11) {
+				t.Errorf("book = %+v", book)
+			}
+		})
+	}
+}
+
+func TestStreamedBookResyncsFromFeedSnapshot(t *testing.T) {
+	url, received := newSubscriptionServer(t)
+	ws := NewWebSocketClient(url, nil, testLogger())
+	ws.feed = FeedAdvancedTrade
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ws.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer ws.Close()
+
+	books := NewBookBuilder(testLogger())
+	books.Attach(ws)
+	level2 := func(sequence int64, eventType, price string) []byte {
+		return []byte(fmt.Sprintf(`{"channel":"l2_data","sequence_num":%d,"events":[{"type":%q,"product_id":"BTC-USD",`+
+			`"updates":[{"side":"bid","price_level":%q,"new_quantity":"1"},{"side":"offer","price_level":"101","new_quantity":"1"}]}]}`,
+			sequence, eventType, price))
+	}
+	bestBid := func() float64 {
+		t.Helper()
+		book, ok := books.Book("BTC-USD", 1)
+		if !ok {
+			t.Fatal("book invalid")
+		}
+		return book.Bids[0].Price
+	}
+
+	ws.Dispatch(time.Now(), level2(0, "snapshot", "100"))
+	if got := bestBid(); got != 100 {
+		t.Fatalf("best bid = %g after snapshot, want 100", got)
+	}
+
+	// A gap in the connection's sequence invalidates the book and asks the
+	// feed for a fresh snapshot
+	ws.Dispatch(time.Now(), level2(2, "update", "100.5"))
+	if _, ok := books.Book("BTC-USD", 1); ok {
+		t.Fatal("book still valid after a sequence gap")
+	}
+	for _, want := range []string{"unsubscribe", "subscribe"} {
+		msg := nextSubscription(t, received, time.Second)
+		if msg.Type != want || msg.Channel != "level2" || len(msg.ProductIDs) != 1 || msg.ProductIDs[0] != "BTC-USD" {
+			t.Fatalf("subscription = %+v, want %s level2 BTC-USD", msg, want)
+		}
+	}
+
+	// Updates still in flight before the snapshot are not applied
+	ws.Dispatch(time.Now(), level2(3, "update", "100.6"))
+	if _, ok := books.Book("BTC-USD", 1); ok {
+		t.Fatal("book valid before the feed's snapshot")
+	}
+	ws.Dispatch(time.Now(), level2(4, "snapshot", "99"))
+	ws.Dispatch(time.Now(), level2(5, "update", "99.5"))
+	if got := bestBid(); got != 99.5 {
+		t.Errorf("best bid = %g after resync, want 99.5", got)
+	}
+}
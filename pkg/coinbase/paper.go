@@ -0,0 +1,722 @@
+package coinbase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/clock"
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+const (
+	// paperBookMaxAge is how long a fetched book is reused for matching
+	paperBookMaxAge = 500 * time.Millisecond
+
+	// paperSizeEpsilon absorbs float error when comparing sizes
+	paperSizeEpsilon = 1e-9
+)
+
+// ErrPostOnly means a post-only order would have crossed the book
+var ErrPostOnly = errors.New("post-only order would cross the book")
+
+// PaperConfig controls how a PaperClient simulates execution
+type PaperConfig struct {
+	MakerFee float64       // fraction of notional charged on resting fills
+	TakerFee float64       // fraction of notional charged on crossing fills
+	Latency  time.Duration // delay before an order or cancel reaches the book
+}
+
+// PaperClient simulates an exchange against live market data. Tickers, books
+// and subscriptions come from the wrapped client; orders, fills and positions
+// exist only in memory and are lost on restart.
+//
+// Orders are matched against the wrapped client's order book whenever they
+// are placed or read. Marketable size walks the book at taker fees. Resting
+// limit orders join the back of the queue at their price and fill at maker
+// fees once the size ahead of them has traded, or when the opposite side
+// crosses their price.
+type PaperClient struct {
+	market Client
+	config PaperConfig
+	clock  clock.Clock
+
+	mu        sync.Mutex
+	books     map[string]*paperBook
+	orders    map[string]*paperOrder
+	clientIDs map[string]string // client order ID -> order ID
+	positions map[string]*paperPosition
+	fills     []models.Fill
+
+	onFill  func(models.Fill)
+	pending []models.Fill // fills not yet passed to onFill
+}
+
+type paperBook struct {
+	book      *models.OrderBook
+	fetchedAt time.Time
+	seq       int64
+}
+
+type paperOrder struct {
+	order      models.Order
+	fills      int
+	notional   float64 // filled size times price, for the average fill price
+	queueAhead float64 // resting size ahead of the order at its price
+	levelSize  float64 // resting size at the order's price when last matched
+	bookSeq    int64   // last book matched against
+}
+
+type paperPosition struct {
+	size       float64
+	avgPrice   float64
+	realizedPL float64
+	fees       float64
+	markPrice  float64
+}
+
+// NewPaperClient returns a client that trades on paper against market's data
+func NewPaperClient(market Client, config PaperConfig) *PaperClient {
+	return &PaperClient{
+		market:    market,
+		config:    config,
+		clock:     clock.Real(),
+		books:     make(map[string]*paperBook),
+		orders:    make(map[string]*paperOrder),
+		clientIDs: make(map[string]string),
+		positions: make(map[string]*paperPosition),
+	}
+}
+
+// SetClock replaces the wall clock used to timestamp orders and fills and to
+// age cached books. Latency is always waited out in real time, so simulated
+// clocks should be paired with zero latency.
+func (c *PaperClient) SetClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock = clk
+}
+
+// OnFill registers a handler for simulated fills, standing in for the
+// exchange's user channel. The handler is called without internal locks held.
+func (c *PaperClient) OnFill(handler func(models.Fill)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onFill = handler
+}
+
+// notify passes pending fills to the fill handler. It must be called without
+// c.mu held.
+func (c *PaperClient) notify() {
+	c.mu.Lock()
+	handler, fills := c.onFill, c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	if handler == nil {
+		return
+	}
+	for _, fill := range fills {
+		handler(fill)
+	}
+}
+
+func (c *PaperClient) GetTicker(ctx context.Context, symbol string) (*models.Ticker, error) {
+	return c.market.GetTicker(ctx, symbol)
+}
+
+func (c *PaperClient) GetOrderBook(ctx context.Context, symbol string, level int) (*models.OrderBook, error) {
+	return c.market.GetOrderBook(ctx, symbol, level)
+}
+
+func (c *PaperClient) Subscribe(channels []string, symbols []string) error {
+	return c.market.Subscribe(channels, symbols)
+}
+
+// RateLimitStats reports the wrapped client's limiter usage, which market
+// data fetched for matching counts against
+func (c *PaperClient) RateLimitStats() []RateLimitStats {
+	if limited, ok := c.market.(interface{ RateLimitStats() []RateLimitStats }); ok {
+		return limited.RateLimitStats()
+	}
+	return nil
+}
+
+// GetFundingRate returns the wrapped client's funding terms. Funding is not
+// settled on simulated positions.
+func (c *PaperClient) GetFundingRate(ctx context.Context, symbol string) (*models.FundingRate, error) {
+	source, ok := c.market.(FundingRateSource)
+	if !ok {
+		return nil, fmt.Errorf("no funding rates for %s", symbol)
+	}
+	return source.GetFundingRate(ctx, symbol)
+}
+
+// GetInstruments describes the wrapped client's products
+func (c *PaperClient) GetInstruments(ctx context.Context) ([]models.Instrument, error) {
+	source, ok := c.market.(InstrumentSource)
+	if !ok {
+		return nil, fmt.Errorf("no instruments listed")
+	}
+	return source.GetInstruments(ctx)
+}
+
+// GetPositions returns the simulated positions, marked at the last book mid
+func (c *PaperClient) GetPositions(ctx context.Context) ([]models.Position, error) {
+	c.mu.Lock()
+	symbols := c.openSymbolsLocked()
+	c.mu.Unlock()
+
+	for _, symbol := range symbols {
+		if err := c.match(ctx, symbol); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	positions := make([]models.Position, 0, len(c.positions))
+	for symbol, p := range c.positions {
+		side := "long"
+		if p.size < 0 {
+			side = "short"
+		}
+		position := models.Position{
+			Symbol:     symbol,
+			Side:       side,
+			Size:       p.size,
+			EntryPrice: p.avgPrice,
+			MarkPrice:  p.markPrice,
+			RealizedPL: p.realizedPL - p.fees,
+			UpdatedAt:  now,
+		}
+		if p.markPrice > 0 {
+			position.UnrealizedPL = (p.markPrice - p.avgPrice) * p.size
+		}
+		positions = append(positions, position)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].Symbol < positions[j].Symbol
+	})
+	return positions, nil
+}
+
+// GetFills returns every simulated execution, oldest first
+func (c *PaperClient) GetFills() []models.Fill {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]models.Fill(nil), c.fills...)
+}
+
+// PlaceOrder accepts an order after the configured latency and matches it
+// against the book. Placing an existing client order ID returns that order.
+func (c *PaperClient) PlaceOrder(ctx context.Context, req *models.OrderRequest) (*models.Order, error) {
+	switch req.Type {
+	case models.OrderTypeMarket, models.OrderTypeLimit:
+	default:
+		return nil, fmt.Errorf("unsupported order type %q", req.Type)
+	}
+	tif := strings.ToUpper(req.TimeInForce)
+	switch tif {
+	case "", "GTC", "IOC", "FOK":
+	default:
+		return nil, fmt.Errorf("unsupported time in force %q", req.TimeInForce)
+	}
+	if req.Size <= 0 {
+		return nil, fmt.Errorf("invalid order size %s", formatFloat(req.Size))
+	}
+
+	c.mu.Lock()
+	if id, ok := c.clientIDs[req.ClientOrderID]; ok && req.ClientOrderID != "" {
+		order := c.orders[id].order
+		c.mu.Unlock()
+		return &order, nil
+	}
+	if req.ReduceOnly {
+		if err := c.checkReduceOnlyLocked(req); err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+	}
+	c.mu.Unlock()
+
+	if err := c.delay(ctx); err != nil {
+		return nil, err
+	}
+
+	book, seq, err := c.book(ctx, req.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOrderID := req.ClientOrderID
+	if clientOrderID == "" {
+		if clientOrderID, err = generateNonce(); err != nil {
+			return nil, fmt.Errorf("failed to generate client order id: %w", err)
+		}
+	}
+	// Random like exchange order IDs, so paper legs never collide
+	orderID, err := generateNonce()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate order id: %w", err)
+	}
+
+	defer c.notify()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.PostOnly && req.Type == models.OrderTypeLimit && crosses(book, req.Side, req.Price) {
+		return nil, ErrPostOnly
+	}
+
+	now := c.clock.Now()
+	po := &paperOrder{
+		order: models.Order{
+			OrderID:       orderID,
+			ClientOrderID: clientOrderID,
+			Symbol:        req.Symbol,
+			Side:          req.Side,
+			Type:          req.Type,
+			Price:         req.Price,
+			Size:          req.Size,
+			Status:        models.OrderStatusNew,
+			TimeInForce:   req.TimeInForce,
+			PostOnly:      req.PostOnly,
+			ReduceOnly:    req.ReduceOnly,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		},
+		bookSeq: seq,
+	}
+	c.orders[po.order.OrderID] = po
+	c.clientIDs[clientOrderID] = po.order.OrderID
+
+	limit := math.Inf(1)
+	if req.Side == models.OrderSideSell {
+		limit = 0
+	}
+	if req.Type == models.OrderTypeLimit {
+		limit = req.Price
+	}
+
+	if tif == "FOK" && available(book, req.Side, limit) < req.Size-paperSizeEpsilon {
+		po.order.Status = models.OrderStatusCancelled
+		order := po.order
+		return &order, nil
+	}
+
+	c.takeLocked(po, book, limit)
+
+	switch {
+	case po.order.Status == models.OrderStatusFilled:
+	case req.Type == models.OrderTypeMarket || tif == "IOC" || tif == "FOK":
+		// The unfilled remainder never rests
+		po.order.Status = models.OrderStatusCancelled
+	default:
+		po.levelSize = levelSize(book, req.Side, req.Price)
+		po.queueAhead = po.levelSize
+	}
+
+	order := po.order
+	return &order, nil
+}
+
+// CancelOrder cancels a resting order after the configured latency. Fills
+// that land before the cancel arrives are kept.
+func (c *PaperClient) CancelOrder(ctx context.Context, orderID string) error {
+	c.mu.Lock()
+	po, ok := c.orders[orderID]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("failed to cancel order %s: %w", orderID, ErrOrderNotFound)
+	}
+
+	if err := c.delay(ctx); err != nil {
+		return err
+	}
+	if err := c.match(ctx, po.order.Symbol); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !paperOrderOpen(po) {
+		return nil
+	}
+	po.order.Status = models.OrderStatusCancelled
+	po.order.UpdatedAt = c.clock.Now()
+	return nil
+}
+
+func (c *PaperClient) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	c.mu.Lock()
+	po, ok := c.orders[orderID]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("failed to get order %s: %w", orderID, ErrOrderNotFound)
+	}
+
+	if err := c.match(ctx, po.order.Symbol); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	order := po.order
+	return &order, nil
+}
+
+// GetOrderByClientID finds an order by the client order ID it was placed with
+func (c *PaperClient) GetOrderByClientID(ctx context.Context, symbol, clientOrderID string, since time.Time) (*models.Order, error) {
+	c.mu.Lock()
+	id, ok := c.clientIDs[clientOrderID]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("failed to find order %s: %w", clientOrderID, ErrOrderNotFound)
+	}
+	return c.GetOrder(ctx, id)
+}
+
+func (c *PaperClient) GetOpenOrders(ctx context.Context, symbols []string) ([]models.Order, error) {
+	for _, symbol := range symbols {
+		if err := c.match(ctx, symbol); err != nil {
+			return nil, err
+		}
+	}
+
+	wanted := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		wanted[symbol] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var orders []models.Order
+	for _, po := range c.orders {
+		if paperOrderOpen(po) && wanted[po.order.Symbol] {
+			orders = append(orders, po.order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	return orders, nil
+}
+
+// match advances the resting orders on a symbol against a fresh book
+func (c *PaperClient) match(ctx context.Context, symbol string) error {
+	c.mu.Lock()
+	resting := false
+	for _, po := range c.orders {
+		if po.order.Symbol == symbol && paperOrderOpen(po) {
+			resting = true
+			break
+		}
+	}
+	c.mu.Unlock()
+	if !resting {
+		return nil
+	}
+
+	book, seq, err := c.book(ctx, symbol)
+	if err != nil {
+		return err
+	}
+
+	defer c.notify()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, po := range c.orders {
+		if po.order.Symbol != symbol || !paperOrderOpen(po) || po.bookSeq >= seq {
+			continue
+		}
+		po.bookSeq = seq
+		c.restLocked(po, book)
+	}
+	return nil
+}
+
+// restLocked fills a resting limit order from a new book. Must be called
+// with c.mu held.
+func (c *PaperClient) restLocked(po *paperOrder, book *models.OrderBook) {
+	side, price := po.order.Side, po.order.Price
+
+	// The opposite side crossing the order means it traded through
+	if crosses(book, side, price) {
+		c.fillLocked(po, price, math.Min(remaining(po), available(book, side, price)), c.config.MakerFee)
+		if !paperOrderOpen(po) {
+			return
+		}
+	}
+
+	// Size leaving the level trades ahead of the order first. Only the touch
+	// trades; shrinking deeper levels are cancels. Behind an unbounded level
+	// the order only fills when crossed.
+	size := levelSize(book, side, price)
+	if shrunk := po.levelSize - size; shrunk > 0 && !math.IsInf(shrunk, 1) {
+		po.queueAhead -= shrunk
+		if po.queueAhead < 0 && atTouch(book, side, price) {
+			c.fillLocked(po, price, math.Min(remaining(po), -po.queueAhead), c.config.MakerFee)
+		}
+		po.queueAhead = math.Max(po.queueAhead, 0)
+	}
+	po.levelSize = size
+}
+
+// takeLocked fills an order against the opposite side of the book up to
+// limit, walking price levels. Must be called with c.mu held.
+func (c *PaperClient) takeLocked(po *paperOrder, book *models.OrderBook, limit float64) {
+	levels := book.Asks
+	if po.order.Side == models.OrderSideSell {
+		levels = book.Bids
+	}
+
+	for _, level := range levels {
+		if !marketable(po.order.Side, level.Price, limit) || remaining(po) < paperSizeEpsilon {
+			break
+		}
+		c.fillLocked(po, level.Price, math.Min(remaining(po), level.Size), c.config.TakerFee)
+	}
+}
+
+// fillLocked records an execution and applies it to the position. Must be
+// called with c.mu held.
+func (c *PaperClient) fillLocked(po *paperOrder, price, size, feeRate float64) {
+	if size < paperSizeEpsilon {
+		return
+	}
+
+	now := c.clock.Now()
+	fee := price * size * feeRate
+	fill := models.Fill{
+		TradeID:       fmt.Sprintf("%s-%d", po.order.OrderID, po.fills+1),
+		OrderID:       po.order.OrderID,
+		ClientOrderID: po.order.ClientOrderID,
+		Symbol:        po.order.Symbol,
+		Side:          po.order.Side,
+		Price:         price,
+		Size:          size,
+		Fee:           fee,
+		Timestamp:     now,
+
+		OrderFilledSize: po.order.FilledSize + size,
+	}
+	c.fills = append(c.fills, fill)
+	c.pending = append(c.pending, fill)
+
+	po.fills++
+	po.notional += price * size
+	po.order.FilledSize += size
+	po.order.AvgFillPrice = po.notional / po.order.FilledSize
+	po.order.UpdatedAt = now
+	if remaining(po) < paperSizeEpsilon {
+		po.order.Status = models.OrderStatusFilled
+	} else {
+		po.order.Status = models.OrderStatusPartiallyFilled
+	}
+	// Market orders report their average fill price, as on the exchange
+	if po.order.Type == models.OrderTypeMarket {
+		po.order.Price = po.order.AvgFillPrice
+	}
+
+	qty := size
+	if po.order.Side == models.OrderSideSell {
+		qty = -size
+	}
+	position, ok := c.positions[po.order.Symbol]
+	if !ok {
+		position = &paperPosition{}
+		c.positions[po.order.Symbol] = position
+	}
+	position.apply(qty, price)
+	position.fees += fee
+}
+
+// apply adds a signed quantity at price, realizing P&L on the part that
+// reduces the position
+func (p *paperPosition) apply(qty, price float64) {
+	if p.size != 0 && (p.size > 0) != (qty > 0) {
+		closed := math.Min(math.Abs(qty), math.Abs(p.size))
+		if p.size > 0 {
+			p.realizedPL += (price - p.avgPrice) * closed
+		} else {
+			p.realizedPL += (p.avgPrice - price) * closed
+		}
+	}
+
+	switch {
+	case p.size == 0 || (p.size > 0) == (qty > 0):
+		p.avgPrice = (p.avgPrice*math.Abs(p.size) + price*math.Abs(qty)) / math.Abs(p.size+qty)
+	case math.Abs(qty) > math.Abs(p.size):
+		p.avgPrice = price
+	}
+
+	p.size += qty
+	if math.Abs(p.size) < paperSizeEpsilon {
+		p.size, p.avgPrice = 0, 0
+	}
+}
+
+// checkReduceOnlyLocked enforces ReduceOnly against the simulated position.
+// Must be called with c.mu held.
+func (c *PaperClient) checkReduceOnlyLocked(req *models.OrderRequest) error {
+	var size float64
+	if p, ok := c.positions[req.Symbol]; ok {
+		size = p.size
+	}
+
+	reducible := -size
+	if req.Side == models.OrderSideSell {
+		reducible = size
+	}
+	if req.Size > reducible+paperSizeEpsilon {
+		return fmt.Errorf("%s %s %s against position %s: %w", req.Side, formatFloat(req.Size),
+			req.Symbol, formatFloat(size), ErrReduceOnly)
+	}
+	return nil
+}
+
+// book returns a recent order book for symbol and its fetch sequence,
+// refreshing it from the wrapped client once it is stale
+func (c *PaperClient) book(ctx context.Context, symbol string) (*models.OrderBook, int64, error) {
+	c.mu.Lock()
+	cached, ok := c.books[symbol]
+	c.mu.Unlock()
+	if ok && c.clock.Since(cached.fetchedAt) < paperBookMaxAge {
+		return cached.book, cached.seq, nil
+	}
+
+	book, err := c.market.GetOrderBook(ctx, symbol, 2)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get paper trading book for %s: %w", symbol, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seq := int64(1)
+	if cached, ok := c.books[symbol]; ok {
+		seq = cached.seq + 1
+	}
+	c.books[symbol] = &paperBook{book: book, fetchedAt: c.clock.Now(), seq: seq}
+
+	if p, ok := c.positions[symbol]; ok && len(book.Bids) > 0 && len(book.Asks) > 0 {
+		p.markPrice = (book.Bids[0].Price + book.Asks[0].Price) / 2
+	}
+	return book, seq, nil
+}
+
+func (c *PaperClient) openSymbolsLocked() []string {
+	seen := make(map[string]bool)
+	var symbols []string
+	for symbol := range c.positions {
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+	}
+	for _, po := range c.orders {
+		if paperOrderOpen(po) && !seen[po.order.Symbol] {
+			seen[po.order.Symbol] = true
+			symbols = append(symbols, po.order.Symbol)
+		}
+	}
+	return symbols
+}
+
+// delay waits out the configured latency
+func (c *PaperClient) delay(ctx context.Context) error {
+	if c.config.Latency <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(c.config.Latency)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func paperOrderOpen(po *paperOrder) bool {
+	switch po.order.Status {
+	case models.OrderStatusNew, models.OrderStatusPartiallyFilled:
+		return true
+	}
+	return false
+}
+
+func remaining(po *paperOrder) float64 {
+	return po.order.Size - po.order.FilledSize
+}
+
+// marketable reports whether a resting price is within an order's limit
+func marketable(side models.OrderSide, price, limit float64) bool {
+	if side == models.OrderSideBuy {
+		return price <= limit
+	}
+	return price >= limit
+}
+
+// crosses reports whether the opposite side of the book reaches price
+func crosses(book *models.OrderBook, side models.OrderSide, price float64) bool {
+	if side == models.OrderSideBuy {
+		return len(book.Asks) > 0 && book.Asks[0].Price <= price
+	}
+	return len(book.Bids) > 0 && book.Bids[0].Price >= price
+}
+
+// available is the opposite-side size within limit
+func available(book *models.OrderBook, side models.OrderSide, limit float64) float64 {
+	levels := book.Asks
+	if side == models.OrderSideSell {
+		levels = book.Bids
+	}
+
+	var size float64
+	for _, level := range levels {
+		if !marketable(side, level.Price, limit) {
+			break
+		}
+		size += level.Size
+	}
+	return size
+}
+
+// levelSize is the same-side size resting at exactly price
+func levelSize(book *models.OrderBook, side models.OrderSide, price float64) float64 {
+	levels := book.Bids
+	if side == models.OrderSideSell {
+		levels = book.Asks
+	}
+
+	for _, level := range levels {
+		if level.Price == price {
+			return level.Size
+		}
+	}
+	return 0
+}
+
+// atTouch reports whether price is at or better than the same-side best
+func atTouch(book *models.OrderBook, side models.OrderSide, price float64) bool {
+	if side == models.OrderSideBuy {
+		return len(book.Bids) == 0 || price >= book.Bids[0].Price
+	}
+	return len(book.Asks) == 0 || price <= book.Asks[0].Price
+}
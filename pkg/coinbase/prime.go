@@ -0,0 +1,454 @@
+package coinbase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+const primePrefix = "/v1"
+
+// Prime API request/response types
+
+type primeCreateOrderRequest struct {
+	PortfolioID   string `json:"portfolio_id"`
+	ProductID     string `json:"product_id"`
+	Side          string `json:"side"`
+	ClientOrderID string `json:"client_order_id"`
+	Type          string `json:"type"`
+	BaseQuantity  string `json:"base_quantity"`
+	LimitPrice    string `json:"limit_price,omitempty"`
+	TimeInForce   string `json:"time_in_force,omitempty"`
+	PostOnly      bool   `json:"post_only,omitempty"`
+}
+
+type primeCreateOrderResponse struct {
+	OrderID string `json:"order_id"`
+}
+
+type primeOrder struct {
+	ID                 string    `json:"id"`
+	PortfolioID        string    `json:"portfolio_id"`
+	ProductID          string    `json:"product_id"`
+	Side               string    `json:"side"`
+	ClientOrderID      string    `json:"client_order_id"`
+	Type               string    `json:"type"`
+	BaseQuantity       string    `json:"base_quantity"`
+	LimitPrice         string    `json:"limit_price"`
+	TimeInForce        string    `json:"time_in_force"`
+	Status             string    `json:"status"`
+	CreatedAt          time.Time `json:"created_at"`
+	FilledQuantity     string    `json:"filled_quantity"`
+	AverageFilledPrice string    `json:"average_filled_price"`
+	PostOnly           bool      `json:"post_only"`
+}
+
+type primeGetOrderResponse struct {
+	Order primeOrder `json:"order"`
+}
+
+type primePagination struct {
+	NextCursor string `json:"next_cursor"`
+	HasNext    bool   `json:"has_next"`
+}
+
+type primeListOrdersResponse struct {
+	Orders     []primeOrder    `json:"orders"`
+	Pagination primePagination `json:"pagination"`
+}
+
+type primeBalance struct {
+	Symbol string `json:"symbol"`
+	Amount string `json:"amount"`
+	Holds  string `json:"holds"`
+}
+
+type primeBalancesResponse struct {
+	Balances []primeBalance `json:"balances"`
+}
+
+type exchangeTickerResponse struct {
+	Bid    string    `json:"bid"`
+	Ask    string    `json:"ask"`
+	Price  string    `json:"price"`
+	Size   string    `json:"size"`
+	Volume string    `json:"volume"`
+	Time   time.Time `json:"time"`
+}
+
+type exchangeProduct struct {
+	ID             string `json:"id"`
+	BaseCurrency   string `json:"base_currency"`
+	QuoteCurrency  string `json:"quote_currency"`
+	BaseIncrement  string `json:"base_increment"`
+	QuoteIncrement string `json:"quote_increment"`
+}
+
+type exchangeBookResponse struct {
+	Bids     [][]json.RawMessage `json:"bids"`
+	Asks     [][]json.RawMessage `json:"asks"`
+	Sequence int64               `json:"sequence"`
+	Time     time.Time           `json:"time"`
+}
+
+func (c *PrimeClient) GetTicker(ctx context.Context, symbol string) (*models.Ticker, error) {
+	path := fmt.Sprintf("/products/%s/ticker", url.PathEscape(symbol))
+
+	var resp exchangeTickerResponse
+	if err := c.publicJSON(ctx, path, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get ticker for %s: %w", symbol, err)
+	}
+
+	ticker := &models.Ticker{
+		Symbol:    symbol,
+		BidPrice:  parseFloat(resp.Bid),
+		AskPrice:  parseFloat(resp.Ask),
+		LastPrice: parseFloat(resp.Price),
+		LastSize:  parseFloat(resp.Size),
+		Volume24h: parseFloat(resp.Volume),
+		Timestamp: resp.Time,
+	}
+	if ticker.Timestamp.IsZero() {
+		ticker.Timestamp = time.Now()
+	}
+
+	return ticker, nil
+}
+
+func (c *PrimeClient) GetOrderBook(ctx context.Context, symbol string, level int) (*models.OrderBook, error) {
+	// Level 3 is not aggregated and not useful for pricing
+	if level < 1 || level > 2 {
+		level = 2
+	}
+	path := fmt.Sprintf("/products/%s/book?level=%d", url.PathEscape(symbol), level)
+
+	var resp exchangeBookResponse
+	if err := c.publicJSON(ctx, path, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get order book for %s: %w", symbol, err)
+	}
+
+	bids, err := convertExchangeLevels(resp.Bids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bids for %s: %w", symbol, err)
+	}
+	asks, err := convertExchangeLevels(resp.Asks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse asks for %s: %w", symbol, err)
+	}
+
+	book := &models.OrderBook{
+		Symbol:    symbol,
+		Bids:      bids,
+		Asks:      asks,
+		Sequence:  resp.Sequence,
+		Timestamp: resp.Time,
+	}
+	if book.Timestamp.IsZero() {
+		book.Timestamp = time.Now()
+	}
+
+	return book, nil
+}
+
+// GetInstruments lists the spot products on the exchange Prime routes to
+func (c *PrimeClient) GetInstruments(ctx context.Context) ([]models.Instrument, error) {
+	var resp []exchangeProduct
+	if err := c.publicJSON(ctx, "/products", &resp); err != nil {
+		return nil, fmt.Errorf("failed to list products: %w", err)
+	}
+
+	instruments := make([]models.Instrument, 0, len(resp))
+	for _, p := range resp {
+		instruments = append(instruments, models.Instrument{
+			Symbol:        p.ID,
+			Type:          models.MarketTypeSpot,
+			Underlying:    p.BaseCurrency,
+			Quote:         p.QuoteCurrency,
+			ContractSize:  1,
+			TickSize:      parseFloat(p.QuoteIncrement),
+			SizeIncrement: parseFloat(p.BaseIncrement),
+		})
+	}
+	return instruments, nil
+}
+
+// GetPositions reports non-zero portfolio balances as long positions keyed by
+// asset, e.g. BTC, since a balance is not held against any one quote currency
+func (c *PrimeClient) GetPositions(ctx context.Context) ([]models.Position, error) {
+	path := fmt.Sprintf("%s/portfolios/%s/balances", primePrefix, url.PathEscape(c.portfolioID))
+
+	var resp primeBalancesResponse
+	if err := c.doRequest(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get balances: %w", err)
+	}
+
+	now := time.Now()
+	positions := make([]models.Position, 0, len(resp.Balances))
+	for _, b := range resp.Balances {
+		asset := strings.ToUpper(b.Symbol)
+		amount := parseFloat(b.Amount)
+		if amount == 0 {
+			continue
+		}
+
+		positions = append(positions, models.Position{
+			Symbol:    asset,
+			Side:      "long",
+			Size:      amount,
+			UpdatedAt: now,
+		})
+	}
+
+	return positions, nil
+}
+
+// PlaceOrder submits an order, retrying transient failures under the same
+// client order ID and checking whether an ambiguous attempt landed first
+func (c *PrimeClient) PlaceOrder(ctx context.Context, order *models.OrderRequest) (*models.Order, error) {
+	clientOrderID := order.ClientOrderID
+	if clientOrderID == "" {
+		var err error
+		if clientOrderID, err = generateNonce(); err != nil {
+			return nil, fmt.Errorf("failed to generate client order id: %w", err)
+		}
+	}
+
+	req := primeCreateOrderRequest{
+		PortfolioID:   c.portfolioID,
+		ProductID:     order.Symbol,
+		Side:          strings.ToUpper(string(order.Side)),
+		ClientOrderID: clientOrderID,
+		BaseQuantity:  formatFloat(order.Size),
+	}
+
+	switch order.Type {
+	case models.OrderTypeMarket:
+		req.Type = "MARKET"
+	case models.OrderTypeLimit:
+		req.Type = "LIMIT"
+		req.LimitPrice = formatFloat(order.Price)
+		req.PostOnly = order.PostOnly
+		switch strings.ToUpper(order.TimeInForce) {
+		case "", "GTC":
+			req.TimeInForce = "GOOD_UNTIL_CANCELLED"
+		case "IOC":
+			req.TimeInForce = "IMMEDIATE_OR_CANCEL"
+		case "FOK":
+			req.TimeInForce = "FILL_OR_KILL"
+		default:
+			return nil, fmt.Errorf("unsupported time in force %q", order.TimeInForce)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported order type %q", order.Type)
+	}
+
+	return c.retry.placeOrder(ctx,
+		func() (*models.Order, error) {
+			return c.placeOrderOnce(ctx, order, &req)
+		},
+		func(ctx context.Context, since time.Time) (*models.Order, error) {
+			return c.GetOrderByClientID(ctx, order.Symbol, clientOrderID, since)
+		},
+	)
+}
+
+func (c *PrimeClient) placeOrderOnce(ctx context.Context, order *models.OrderRequest, req *primeCreateOrderRequest) (*models.Order, error) {
+	path := fmt.Sprintf("%s/portfolios/%s/order", primePrefix, url.PathEscape(c.portfolioID))
+
+	var resp primeCreateOrderResponse
+	if err := c.doRequest(ctx, http.MethodPost, path, req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to place order: %w", err)
+	}
+
+	now := time.Now()
+	return &models.Order{
+		OrderID:       resp.OrderID,
+		ClientOrderID: req.ClientOrderID,
+		Symbol:        order.Symbol,
+		Side:          order.Side,
+		Type:          order.Type,
+		Price:         order.Price,
+		Size:          order.Size,
+		Status:        models.OrderStatusNew,
+		TimeInForce:   order.TimeInForce,
+		PostOnly:      order.PostOnly,
+		ReduceOnly:    order.ReduceOnly,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}, nil
+}
+
+func (c *PrimeClient) CancelOrder(ctx context.Context, orderID string) error {
+	path := fmt.Sprintf("%s/portfolios/%s/orders/%s/cancel", primePrefix,
+		url.PathEscape(c.portfolioID), url.PathEscape(orderID))
+
+	if err := c.doRequest(ctx, http.MethodPost, path, nil, nil); err != nil {
+		return fmt.Errorf("failed to cancel order %s: %w", orderID, err)
+	}
+	return nil
+}
+
+func (c *PrimeClient) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	path := fmt.Sprintf("%s/portfolios/%s/orders/%s", primePrefix,
+		url.PathEscape(c.portfolioID), url.PathEscape(orderID))
+
+	var resp primeGetOrderResponse
+	if err := c.doRequest(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get order %s: %w", orderID, err)
+	}
+
+	return convertPrimeOrder(&resp.Order), nil
+}
+
+// GetOrderByClientID finds an order placed since the given time by its client
+// order ID, returning ErrOrderNotFound if there is none
+func (c *PrimeClient) GetOrderByClientID(ctx context.Context, symbol, clientOrderID string, since time.Time) (*models.Order, error) {
+	query := url.Values{}
+	query.Set("product_ids", symbol)
+	query.Set("start_date", since.UTC().Format(time.RFC3339))
+
+	var found *models.Order
+	err := c.listOrders(ctx, "orders", query, func(o *primeOrder) bool {
+		if o.ClientOrderID == clientOrderID {
+			found = convertPrimeOrder(o)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("client order %s: %w", clientOrderID, ErrOrderNotFound)
+	}
+	return found, nil
+}
+
+// GetOpenOrders returns every working order on the given products
+func (c *PrimeClient) GetOpenOrders(ctx context.Context, symbols []string) ([]models.Order, error) {
+	query := url.Values{}
+	for _, symbol := range symbols {
+		query.Add("product_ids", symbol)
+	}
+
+	orders := make([]models.Order, 0)
+	err := c.listOrders(ctx, "open_orders", query, func(o *primeOrder) bool {
+		orders = append(orders, *convertPrimeOrder(o))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// listOrders pages through a portfolio order listing ("orders" or
+// "open_orders") matching query, passing each order to visit until it
+// returns false
+func (c *PrimeClient) listOrders(ctx context.Context, listing string, query url.Values, visit func(o *primeOrder) bool) error {
+	path := fmt.Sprintf("%s/portfolios/%s/%s", primePrefix, url.PathEscape(c.portfolioID), listing)
+	for {
+		var resp primeListOrdersResponse
+		if err := c.doRequest(ctx, http.MethodGet, path+"?"+query.Encode(), nil, &resp); err != nil {
+			return fmt.Errorf("failed to list orders: %w", err)
+		}
+
+		for i := range resp.Orders {
+			if !visit(&resp.Orders[i]) {
+				return nil
+			}
+		}
+
+		if !resp.Pagination.HasNext || resp.Pagination.NextCursor == "" {
+			return nil
+		}
+		query.Set("cursor", resp.Pagination.NextCursor)
+	}
+}
+
+// publicJSON fetches an unauthenticated market data endpoint
+func (c *PrimeClient) publicJSON(ctx context.Context, path string, out interface{}) error {
+	return c.retry.retry(ctx, func() error {
+		return c.publicJSONOnce(ctx, path, out)
+	})
+}
+
+func (c *PrimeClient) publicJSONOnce(ctx context.Context, path string, out interface{}) error {
+	if err := c.limiter.Wait(ctx, EndpointPublic); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.marketDataURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	c.limiter.Observe(EndpointPublic, resp)
+
+	return decodeResponse(resp, http.MethodGet, path, out)
+}
+
+func convertPrimeOrder(o *primeOrder) *models.Order {
+	filled := parseFloat(o.FilledQuantity)
+
+	order := &models.Order{
+		OrderID:       o.ID,
+		ClientOrderID: o.ClientOrderID,
+		Symbol:        o.ProductID,
+		Side:          models.OrderSide(strings.ToLower(o.Side)),
+		Type:          models.OrderType(strings.ToLower(o.Type)),
+		Price:         parseFloat(o.LimitPrice),
+		Size:          parseFloat(o.BaseQuantity),
+		FilledSize:    filled,
+		AvgFillPrice:  parseFloat(o.AverageFilledPrice),
+		TimeInForce:   o.TimeInForce,
+		PostOnly:      o.PostOnly,
+		CreatedAt:     o.CreatedAt,
+		UpdatedAt:     o.CreatedAt,
+	}
+	if order.Type == models.OrderTypeMarket {
+		order.Price = parseFloat(o.AverageFilledPrice)
+	}
+
+	// Prime and Advanced Trade share order status names
+	order.Status = convertATOrderStatus(o.Status, filled)
+	return order
+}
+
+// convertExchangeLevels parses [price, size, num_orders] book entries
+func convertExchangeLevels(levels [][]json.RawMessage) ([]models.OrderBookLevel, error) {
+	result := make([]models.OrderBookLevel, 0, len(levels))
+	for _, l := range levels {
+		if len(l) < 2 {
+			return nil, fmt.Errorf("malformed book level")
+		}
+
+		var price, size string
+		if err := json.Unmarshal(l[0], &price); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(l[1], &size); err != nil {
+			return nil, err
+		}
+
+		level := models.OrderBookLevel{
+			Price: parseFloat(price),
+			Size:  parseFloat(size),
+		}
+		if len(l) > 2 {
+			_ = json.Unmarshal(l[2], &level.NumOrder)
+		}
+		result = append(result, level)
+	}
+	return result, nil
+}
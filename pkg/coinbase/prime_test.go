@@ -0,0 +1,168 @@
+package coinbase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+const (
+	testPrimeKey        = "prime-key"
+	testPrimeSecret     = "prime-secret"
+	testPrimePassphrase = "prime-passphrase"
+	testPrimePortfolio  = "pf-7b1f"
+)
+
+func newTestPrimeClient(t *testing.T, routes map[string]string) (*PrimeClient, *fixtureServer) {
+	server := newFixtureServer(t, "prime", routes)
+	auth := NewPrimeAuthenticator(testPrimeKey, testPrimeSecret, testPrimePassphrase)
+	return &PrimeClient{
+		BaseClient:    testBaseClient(server, auth),
+		portfolioID:   testPrimePortfolio,
+		marketDataURL: server.URL,
+	}, server
+}
+
+// checkPrimeSignature verifies a request was signed over its timestamp,
+// method, path and body with the Prime headers
+func checkPrimeSignature(t *testing.T, r recordedRequest) {
+	t.Helper()
+
+	header := r.Header
+	if header.Get("X-CB-ACCESS-KEY") != testPrimeKey || header.Get("X-CB-ACCESS-PASSPHRASE") != testPrimePassphrase {
+		t.Errorf("%s %s: key headers = %v", r.Method, r.Path, header)
+	}
+	if header.Get("CB-ACCESS-KEY") != "" || header.Get("CB-ACCESS-SIGN") != "" {
+		t.Errorf("%s %s: sent legacy headers", r.Method, r.Path)
+	}
+
+	timestamp := header.Get("X-CB-ACCESS-TIMESTAMP")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)).Abs() > time.Minute {
+		t.Errorf("%s %s: timestamp = %q", r.Method, r.Path, timestamp)
+	}
+
+	path := r.Path
+	if len(r.Query) > 0 {
+		path += "?" + r.Query.Encode()
+	}
+	mac := hmac.New(sha256.New, []byte(testPrimeSecret))
+	mac.Write([]byte(timestamp + r.Method + path + string(r.Body)))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); header.Get("X-CB-ACCESS-SIGNATURE") != want {
+		t.Errorf("%s %s: signature = %q, want %q", r.Method, r.Path, header.Get("X-CB-ACCESS-SIGNATURE"), want)
+	}
+}
+
+func TestPrimeAuthenticatorHeaders(t *testing.T) {
+	auth := NewPrimeAuthenticator(testPrimeKey, testPrimeSecret, testPrimePassphrase)
+	body := `{"portfolio_id":"pf-7b1f"}`
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.prime.coinbase.com/v1/portfolios/pf-7b1f/order", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.AddAuthHeaders(req, http.MethodPost, "/v1/portfolios/pf-7b1f/order", body); err != nil {
+		t.Fatalf("AddAuthHeaders: %v", err)
+	}
+
+	checkPrimeSignature(t, recordedRequest{
+		Method: http.MethodPost,
+		Path:   "/v1/portfolios/pf-7b1f/order",
+		Header: req.Header,
+		Body:   []byte(body),
+	})
+}
+
+func TestPrimePlaceOrder(t *testing.T) {
+	client, server := newTestPrimeClient(t, map[string]string{
+		"POST /v1/portfolios/pf-7b1f/order": "create_order.json",
+	})
+
+	order, err := client.PlaceOrder(context.Background(), &models.OrderRequest{
+		ClientOrderID: "basis-prime-1",
+		Symbol:        "BTC-USD",
+		Side:          models.OrderSideBuy,
+		Type:          models.OrderTypeLimit,
+		Price:         67500.5,
+		Size:          0.25,
+		TimeInForce:   "IOC",
+	})
+	if err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	if order.OrderID != "8e0b6a3c-6a2f-4f41-9d3e-0b7c6c1f2d4a" || order.ClientOrderID != "basis-prime-1" {
+		t.Errorf("order = %+v", order)
+	}
+
+	requests := server.received("/v1/portfolios/pf-7b1f/order")
+	if len(requests) != 1 {
+		t.Fatalf("got %d order requests, want 1", len(requests))
+	}
+	checkPrimeSignature(t, requests[0])
+
+	body := decodeBody(t, requests[0])
+	want := map[string]any{
+		"portfolio_id":    testPrimePortfolio,
+		"product_id":      "BTC-USD",
+		"side":            "BUY",
+		"client_order_id": "basis-prime-1",
+		"type":            "LIMIT",
+		"base_quantity":   "0.25",
+		"limit_price":     "67500.5",
+		"time_in_force":   "IMMEDIATE_OR_CANCEL",
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s = %v, want %v", key, body[key], value)
+		}
+	}
+}
+
+func TestPrimeCancelOrder(t *testing.T) {
+	client, server := newTestPrimeClient(t, map[string]string{
+		"POST /v1/portfolios/pf-7b1f/orders/8e0b6a3c-6a2f-4f41-9d3e-0b7c6c1f2d4a/cancel": "cancel_order.json",
+	})
+
+	if err := client.CancelOrder(context.Background(), "8e0b6a3c-6a2f-4f41-9d3e-0b7c6c1f2d4a"); err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	requests := server.received("/v1/portfolios/pf-7b1f/orders/8e0b6a3c-6a2f-4f41-9d3e-0b7c6c1f2d4a/cancel")
+	if len(requests) != 1 {
+		t.Fatalf("got %d cancel requests, want 1", len(requests))
+	}
+	checkPrimeSignature(t, requests[0])
+}
+
+func TestPrimeGetPositions(t *testing.T) {
+	client, server := newTestPrimeClient(t, map[string]string{
+		"GET /v1/portfolios/pf-7b1f/balances": "balances.json",
+	})
+
+	positions, err := client.GetPositions(context.Background())
+	if err != nil {
+		t.Fatalf("GetPositions: %v", err)
+	}
+	checkPrimeSignature(t, server.received("/v1/portfolios/pf-7b1f/balances")[0])
+
+	// Balances are keyed by asset; empty ones are not positions
+	want := []models.Position{
+		{Symbol: "BTC", Side: "long", Size: 1.75},
+		{Symbol: "USD", Side: "long", Size: 250000},
+		{Symbol: "ETH", Side: "long", Size: 12.5},
+	}
+	if len(positions) != len(want) {
+		t.Fatalf("positions = %+v", positions)
+	}
+	for i, p := range positions {
+		if p.Symbol != want[i].Symbol || p.Side != want[i].Side || p.Size != want[i].Size {
+			t.Errorf("position %d = %+v, want %+v", i, p, want[i])
+		}
+	}
+}
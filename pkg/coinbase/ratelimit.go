@@ -0,0 +1,286 @@
+package coinbase
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// EndpointClass groups REST endpoints that share a rate limit
+type EndpointClass string
+
+const (
+	EndpointPublic  EndpointClass = "public"
+	EndpointPrivate EndpointClass = "private"
+	EndpointOrder   EndpointClass = "order"
+)
+
+// EndpointRate is a token bucket refilling at RequestsPerSecond up to Burst
+type EndpointRate struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// RateLimits configures the per-class token buckets of a RateLimiter
+type RateLimits struct {
+	Public     EndpointRate
+	Private    EndpointRate
+	Orders     EndpointRate
+	MaxBackoff time.Duration
+}
+
+// DefaultRateLimits stays under the documented per-key limits of the
+// Exchange public, Advanced Trade and Prime REST APIs
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Public:     EndpointRate{RequestsPerSecond: 10, Burst: 10},
+		Private:    EndpointRate{RequestsPerSecond: 25, Burst: 25},
+		Orders:     EndpointRate{RequestsPerSecond: 10, Burst: 5},
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+// RateLimitStats reports how close a client is running to its limits
+type RateLimitStats struct {
+	Class           EndpointClass `json:"class"`
+	ConfiguredRate  float64       `json:"configured_rate"`
+	CurrentRate     float64       `json:"current_rate"`
+	Burst           int           `json:"burst"`
+	Tokens          float64       `json:"tokens"`
+	Requests        uint64        `json:"requests"`
+	Throttled       uint64        `json:"throttled"`
+	TotalWait       time.Duration `json:"total_wait"`
+	ServerLimit     int           `json:"server_limit"`
+	ServerRemaining int           `json:"server_remaining"`
+	BackoffUntil    time.Time     `json:"backoff_until"`
+}
+
+// RateLimiter throttles requests per endpoint class and adapts to the rate
+// limit headers and 429 responses returned by the server
+type RateLimiter struct {
+	classes    map[EndpointClass]*classLimiter
+	maxBackoff time.Duration
+}
+
+type classLimiter struct {
+	mu              sync.Mutex
+	limiter         *rate.Limiter
+	configured      rate.Limit
+	requests        uint64
+	throttled       uint64
+	consecutive429  int
+	totalWait       time.Duration
+	serverLimit     int
+	serverRemaining int
+	backoffUntil    time.Time
+}
+
+const (
+	// minRateFraction bounds how far adaptive throttling may cut the rate
+	minRateFraction = 0.1
+
+	// lowRemainingFraction of the server limit triggers pre-emptive slowdown
+	lowRemainingFraction = 0.1
+
+	baseBackoff = 500 * time.Millisecond
+)
+
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	maxBackoff := limits.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRateLimits().MaxBackoff
+	}
+
+	return &RateLimiter{
+		classes: map[EndpointClass]*classLimiter{
+			EndpointPublic:  newClassLimiter(limits.Public),
+			EndpointPrivate: newClassLimiter(limits.Private),
+			EndpointOrder:   newClassLimiter(limits.Orders),
+		},
+		maxBackoff: maxBackoff,
+	}
+}
+
+func newClassLimiter(r EndpointRate) *classLimiter {
+	limit := rate.Limit(r.RequestsPerSecond)
+	if r.RequestsPerSecond <= 0 {
+		limit = rate.Inf
+	}
+	burst := r.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return &classLimiter{
+		limiter:         rate.NewLimiter(limit, burst),
+		configured:      limit,
+		serverLimit:     -1,
+		serverRemaining: -1,
+	}
+}
+
+// Wait blocks until a request of the given class may be sent
+func (r *RateLimiter) Wait(ctx context.Context, class EndpointClass) error {
+	cl := r.class(class)
+	start := time.Now()
+
+	cl.mu.Lock()
+	backoff := time.Until(cl.backoffUntil)
+	cl.mu.Unlock()
+
+	if backoff > 0 {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if err := cl.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	cl.mu.Lock()
+	cl.requests++
+	cl.totalWait += time.Since(start)
+	cl.mu.Unlock()
+	return nil
+}
+
+// Observe updates the limiter from a response's status and rate limit headers
+func (r *RateLimiter) Observe(class EndpointClass, resp *http.Response) {
+	cl := r.class(class)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if limit, ok := headerInt(resp.Header, "X-Ratelimit-Limit"); ok {
+		cl.serverLimit = limit
+	}
+	if remaining, ok := headerInt(resp.Header, "X-Ratelimit-Remaining"); ok {
+		cl.serverRemaining = remaining
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		cl.throttled++
+		cl.consecutive429++
+
+		backoff := time.Duration(float64(baseBackoff) * math.Pow(2, float64(cl.consecutive429-1)))
+		if retryAfter, ok := headerInt(resp.Header, "Retry-After"); ok {
+			if d := time.Duration(retryAfter) * time.Second; d > backoff {
+				backoff = d
+			}
+		}
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+		cl.backoffUntil = time.Now().Add(backoff)
+		cl.setRate(cl.limiter.Limit() / 2)
+		return
+	}
+
+	cl.consecutive429 = 0
+
+	if cl.serverLimit > 0 && cl.serverRemaining >= 0 &&
+		float64(cl.serverRemaining) < float64(cl.serverLimit)*lowRemainingFraction {
+		cl.setRate(cl.limiter.Limit() * 0.75)
+		return
+	}
+
+	// Recover towards the configured rate after successful requests
+	if cl.limiter.Limit() < cl.configured {
+		cl.setRate(cl.limiter.Limit() + cl.configured*0.05)
+	}
+}
+
+// Stats returns a snapshot of every endpoint class
+func (r *RateLimiter) Stats() []RateLimitStats {
+	stats := make([]RateLimitStats, 0, len(r.classes))
+	for _, class := range []EndpointClass{EndpointPublic, EndpointPrivate, EndpointOrder} {
+		cl := r.classes[class]
+		cl.mu.Lock()
+		stats = append(stats, RateLimitStats{
+			Class:           class,
+			ConfiguredRate:  float64(cl.configured),
+			CurrentRate:     float64(cl.limiter.Limit()),
+			Burst:           cl.limiter.Burst(),
+			Tokens:          cl.limiter.Tokens(),
+			Requests:        cl.requests,
+			Throttled:       cl.throttled,
+			TotalWait:       cl.totalWait,
+			ServerLimit:     cl.serverLimit,
+			ServerRemaining: cl.serverRemaining,
+			BackoffUntil:    cl.backoffUntil,
+		})
+		cl.mu.Unlock()
+	}
+	return stats
+}
+
+func (r *RateLimiter) class(class EndpointClass) *classLimiter {
+	if cl, ok := r.classes[class]; ok {
+		return cl
+	}
+	return r.classes[EndpointPrivate]
+}
+
+// setRate applies an adaptive rate, clamped between the floor and the
+// configured rate. Must be called with cl.mu held.
+func (cl *classLimiter) setRate(limit rate.Limit) {
+	if cl.configured == rate.Inf {
+		return
+	}
+	if floor := cl.configured * minRateFraction; limit < floor {
+		limit = floor
+	}
+	if limit > cl.configured {
+		limit = cl.configured
+	}
+	cl.limiter.SetLimit(limit)
+}
+
+// classifyEndpoint picks the rate limit class for a REST request. Market data
+// reads are public so that book resyncs and polling fallbacks cannot use up
+// the budget order placement and account reads draw on.
+func classifyEndpoint(method, path string) EndpointClass {
+	if method != http.MethodGet {
+		if strings.Contains(path, "/order") {
+			return EndpointOrder
+		}
+		return EndpointPrivate
+	}
+
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	path = strings.TrimPrefix(path, advancedTradePrefix)
+	for _, prefix := range marketDataPaths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return EndpointPublic
+		}
+	}
+	return EndpointPrivate
+}
+
+// marketDataPaths are the market data endpoints, less the Advanced Trade
+// prefix on that API's
+var marketDataPaths = []string{"/market", "/products", "/product_book", "/best_bid_ask"}
+
+func headerInt(h http.Header, key string) (int, bool) {
+	v := h.Get(key)
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,34 @@
+package coinbase
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClassifyEndpoint(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   EndpointClass
+	}{
+		{http.MethodGet, "/api/v3/brokerage/products?product_type=FUTURE&limit=250&offset=0", EndpointPublic},
+		{http.MethodGet, "/api/v3/brokerage/products/BTC-PERP-INTX", EndpointPublic},
+		{http.MethodGet, "/api/v3/brokerage/products/BTC-PERP-INTX/ticker?limit=1", EndpointPublic},
+		{http.MethodGet, "/api/v3/brokerage/product_book?product_id=BTC-USD&limit=50", EndpointPublic},
+		{http.MethodGet, "/api/v3/brokerage/market/products/BTC-USD/ticker", EndpointPublic},
+		{http.MethodGet, "/products/BTC-USD/book?level=2", EndpointPublic},
+		{http.MethodGet, "/api/v3/brokerage/cfm/positions", EndpointPrivate},
+		{http.MethodGet, "/api/v3/brokerage/orders/historical/batch?order_status=OPEN", EndpointPrivate},
+		{http.MethodGet, "/v1/portfolios/pf-1/balances", EndpointPrivate},
+		{http.MethodGet, "/api/v3/brokerage/productsx", EndpointPrivate},
+		{http.MethodPost, "/api/v3/brokerage/orders", EndpointOrder},
+		{http.MethodPost, "/api/v3/brokerage/orders/batch_cancel", EndpointOrder},
+		{http.MethodPost, "/v1/portfolios/pf-1/order", EndpointOrder},
+	}
+
+	for _, tt := range tests {
+		if got := classifyEndpoint(tt.method, tt.path); got != tt.want {
+			t.Errorf("classifyEndpoint(%s, %s) = %s, want %s", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package coinbase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// RetryPolicy controls retries of transient REST failures. Only idempotent
+// requests are retried: reads, and order placement keyed by a client order ID.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   250 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+	}
+}
+
+// backoff returns a fully jittered exponential delay before the given retry
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << uint(attempt-1)
+	if ceiling <= 0 || ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// sleep waits for the backoff before the given retry or until ctx is done
+func (p RetryPolicy) sleep(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(p.backoff(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retry calls fn until it succeeds, fails permanently or attempts run out
+func (p RetryPolicy) retry(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(ctx, err) || attempt >= p.MaxAttempts {
+			return err
+		}
+		if err := p.sleep(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// placeOrder submits an order, retrying transient failures with the same
+// client order ID. When a failure leaves the outcome unknown, lookup is used
+// to find out whether the order landed before anything is resent, and once
+// more before giving up. That last lookup runs on a fresh context, since the
+// caller's expiring mid-request is itself an unknown outcome. An order that
+// cannot be found then is reported with ErrOrderStatusUnknown.
+func (p RetryPolicy) placeOrder(ctx context.Context, place func() (*models.Order, error),
+	lookup func(ctx context.Context, since time.Time) (*models.Order, error)) (*models.Order, error) {
+	// Orders created before the first attempt cannot be this one, allowing
+	// for the exchange's clock running behind
+	since := time.Now().Add(-orderLookupSkew)
+
+	for attempt := 1; ; attempt++ {
+		order, err := place()
+		if err == nil {
+			return order, nil
+		}
+		if !outcomeUnknown(err) {
+			return nil, err
+		}
+
+		if ctx.Err() != nil || attempt >= p.MaxAttempts {
+			return resolveOrder(ctx, since, lookup, err)
+		}
+		if p.sleep(ctx, attempt) != nil {
+			return resolveOrder(ctx, since, lookup, err)
+		}
+
+		// A lookup error is not proof the order is absent, but resending is
+		// still safe because the exchange deduplicates on client order ID
+		if existing, lerr := lookup(ctx, since); lerr == nil && existing != nil {
+			return existing, nil
+		}
+	}
+}
+
+const (
+	// orderLookupSkew widens client order ID lookups for clock differences
+	orderLookupSkew = 30 * time.Second
+
+	// orderLookupTimeout bounds the final lookup of an order whose placement
+	// outcome is unknown
+	orderLookupTimeout = 10 * time.Second
+)
+
+// resolveOrder makes a last lookup for an order whose placement failed with
+// err and whose outcome is unknown, returning it if it landed
+func resolveOrder(ctx context.Context, since time.Time,
+	lookup func(ctx context.Context, since time.Time) (*models.Order, error), err error) (*models.Order, error) {
+	lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), orderLookupTimeout)
+	defer cancel()
+
+	existing, lerr := lookup(lookupCtx, since)
+	if lerr == nil && existing != nil {
+		return existing, nil
+	}
+	if errors.Is(lerr, ErrOrderNotFound) {
+		// Not yet visible is not proof it was never accepted
+		return nil, fmt.Errorf("%w: %w", ErrOrderStatusUnknown, err)
+	}
+	return nil, fmt.Errorf("%w: %w (lookup: %v)", ErrOrderStatusUnknown, err, lerr)
+}
+
+// isTransient reports whether err may clear up on retry: timeouts, dropped
+// connections, 5xx responses and rate limiting
+func isTransient(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && transientError(err)
+}
+
+// outcomeUnknown reports whether a request that failed with err may still
+// have reached the exchange: transient failures and the caller's context
+// ending mid-request
+func outcomeUnknown(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || transientError(err)
+}
+
+func transientError(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
@@ -0,0 +1,85 @@
+package coinbase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+func TestPlaceOrderLooksUpAfterCallerDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	placed := &models.Order{OrderID: "landed", ClientOrderID: "basis-1"}
+	var lookups int
+	order, err := DefaultRetryPolicy().placeOrder(ctx,
+		func() (*models.Order, error) {
+			<-ctx.Done()
+			return nil, fmt.Errorf("failed to place order: %w", ctx.Err())
+		},
+		func(lookupCtx context.Context, since time.Time) (*models.Order, error) {
+			lookups++
+			if lookupCtx.Err() != nil {
+				t.Errorf("lookup context already done: %v", lookupCtx.Err())
+			}
+			if _, ok := lookupCtx.Deadline(); !ok {
+				t.Error("lookup context has no deadline")
+			}
+			if since.After(start) || start.Sub(since) > time.Minute {
+				t.Errorf("lookup since %v, order created at %v", since, start)
+			}
+			return placed, nil
+		},
+	)
+	if err != nil || order != placed {
+		t.Fatalf("placeOrder = %v, %v; want the order found by lookup", order, err)
+	}
+	if lookups != 1 {
+		t.Errorf("looked up %d times, want 1", lookups)
+	}
+}
+
+func TestPlaceOrderUnknownOutcome(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	unavailable := &APIError{StatusCode: http.StatusBadGateway, Method: http.MethodPost, Path: "/orders"}
+
+	var places int
+	_, err := policy.placeOrder(context.Background(),
+		func() (*models.Order, error) {
+			places++
+			return nil, unavailable
+		},
+		func(context.Context, time.Time) (*models.Order, error) {
+			return nil, ErrOrderNotFound
+		},
+	)
+	if places != 2 {
+		t.Errorf("placed %d times, want 2", places)
+	}
+	if !errors.Is(err, ErrOrderStatusUnknown) || !errors.Is(err, unavailable) {
+		t.Errorf("error = %v, want ErrOrderStatusUnknown wrapping the last failure", err)
+	}
+}
+
+func TestPlaceOrderRejectionIsNotLookedUp(t *testing.T) {
+	rejected := &APIError{StatusCode: http.StatusBadRequest, Code: "INSUFFICIENT_FUND"}
+
+	_, err := DefaultRetryPolicy().placeOrder(context.Background(),
+		func() (*models.Order, error) {
+			return nil, rejected
+		},
+		func(context.Context, time.Time) (*models.Order, error) {
+			t.Error("looked up a rejected order")
+			return nil, ErrOrderNotFound
+		},
+	)
+	if !errors.Is(err, ErrInsufficientFunds) || errors.Is(err, ErrOrderStatusUnknown) {
+		t.Errorf("error = %v, want the rejection", err)
+	}
+}
@@ -0,0 +1,60 @@
+package coinbase
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// expiryLayout is the expiry date in dated future symbols, such as the 27DEC24
+// of BIT-27DEC24-CDE
+const expiryLayout = "02Jan06"
+
+// ParseSymbol infers what it can about a product from its symbol, for products
+// no exchange has described:
+//
+//   - BTC-PERP, BTC-PERP-INTX and BTC-USD-PERP are perpetuals on BTC
+//   - BIT-27DEC24-CDE is a future expiring on 27 December 2024 at 00:00 UTC,
+//     on the underlying its contract code names
+//   - anything else, such as BTC-USD, is spot
+//
+// Contract sizes and increments are unknown and left at 1 and 0.
+func ParseSymbol(symbol string) models.Instrument {
+	parts := strings.Split(strings.ToUpper(symbol), "-")
+	instrument := models.Instrument{
+		Symbol:       symbol,
+		Type:         models.MarketTypeSpot,
+		Underlying:   parts[0],
+		ContractSize: 1,
+	}
+
+	for _, part := range parts[1:] {
+		if part == "PERP" {
+			instrument.Type = models.MarketTypePerpetual
+			instrument.Quote = "USD"
+			return instrument
+		}
+	}
+	if len(parts) == 3 {
+		if expiry, err := time.Parse(expiryLayout, titleMonth(parts[1])); err == nil {
+			instrument.Type = models.MarketTypeFuture
+			instrument.Expiry = expiry
+			instrument.Quote = "USD"
+			return instrument
+		}
+	}
+	if len(parts) >= 2 {
+		instrument.Quote = parts[1]
+	}
+	return instrument
+}
+
+// titleMonth turns the month of a DDMONYY date into the Jan form time.Parse
+// expects
+func titleMonth(date string) string {
+	if len(date) != 7 {
+		return date
+	}
+	return date[:3] + strings.ToLower(date[3:5]) + date[5:]
+}
@@ -0,0 +1,114 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// TickerHandler receives every ticker update decoded from a websocket feed
+type TickerHandler func(ticker models.Ticker)
+
+// Exchange feed ticker message
+type exchangeTickerMessage struct {
+	ProductID   string    `json:"product_id"`
+	Price       string    `json:"price"`
+	BestBid     string    `json:"best_bid"`
+	BestBidSize string    `json:"best_bid_size"`
+	BestAsk     string    `json:"best_ask"`
+	BestAskSize string    `json:"best_ask_size"`
+	Volume24h   string    `json:"volume_24h"`
+	LastSize    string    `json:"last_size"`
+	Time        time.Time `json:"time"`
+}
+
+// Advanced Trade ticker and ticker_batch messages
+type atTickerMessage struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Events    []atTickerEvent `json:"events"`
+}
+
+type atTickerEvent struct {
+	Type    string         `json:"type"`
+	Tickers []atTickerData `json:"tickers"`
+}
+
+type atTickerData struct {
+	ProductID       string `json:"product_id"`
+	Price           string `json:"price"`
+	Volume24h       string `json:"volume_24_h"`
+	BestBid         string `json:"best_bid"`
+	BestBidQuantity string `json:"best_bid_quantity"`
+	BestAsk         string `json:"best_ask"`
+	BestAskQuantity string `json:"best_ask_quantity"`
+}
+
+// OnTicker decodes ticker and ticker_batch messages in the client's feed
+// format and passes each ticker to handler
+func (ws *WebSocketClient) OnTicker(handler TickerHandler) {
+	decode := ws.handleExchangeTicker
+	if ws.feed == FeedAdvancedTrade {
+		decode = ws.handleATTicker
+	}
+
+	messageHandler := func(message json.RawMessage) error {
+		return decode(message, handler)
+	}
+	ws.RegisterHandler("ticker", messageHandler)
+	ws.RegisterHandler("ticker_batch", messageHandler)
+}
+
+func (ws *WebSocketClient) handleExchangeTicker(message json.RawMessage, handler TickerHandler) error {
+	var msg exchangeTickerMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("failed to decode ticker: %w", err)
+	}
+
+	ticker := models.Ticker{
+		Symbol:    msg.ProductID,
+		BidPrice:  parseFloat(msg.BestBid),
+		BidSize:   parseFloat(msg.BestBidSize),
+		AskPrice:  parseFloat(msg.BestAsk),
+		AskSize:   parseFloat(msg.BestAskSize),
+		LastPrice: parseFloat(msg.Price),
+		LastSize:  parseFloat(msg.LastSize),
+		Volume24h: parseFloat(msg.Volume24h),
+		Timestamp: msg.Time,
+	}
+	if ticker.Timestamp.IsZero() {
+		ticker.Timestamp = time.Now()
+	}
+
+	handler(ticker)
+	return nil
+}
+
+func (ws *WebSocketClient) handleATTicker(message json.RawMessage, handler TickerHandler) error {
+	var msg atTickerMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("failed to decode ticker: %w", err)
+	}
+
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	for _, event := range msg.Events {
+		for _, t := range event.Tickers {
+			handler(models.Ticker{
+				Symbol:    t.ProductID,
+				BidPrice:  parseFloat(t.BestBid),
+				BidSize:   parseFloat(t.BestBidQuantity),
+				AskPrice:  parseFloat(t.BestAsk),
+				AskSize:   parseFloat(t.BestAskQuantity),
+				LastPrice: parseFloat(t.Price),
+				Volume24h: parseFloat(t.Volume24h),
+				Timestamp: timestamp,
+			})
+		}
+	}
+	return nil
+}
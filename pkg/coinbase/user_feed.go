@@ -0,0 +1,313 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// OrderUpdateHandler receives the latest state of an order on the user channel
+type OrderUpdateHandler func(order models.Order)
+
+// FillHandler receives each execution against an order on the user channel
+type FillHandler func(fill models.Fill)
+
+// userFeed decodes the authenticated user channel. It tracks each open order
+// so that fills can be derived from Advanced Trade's cumulative quantities,
+// and so Exchange feed messages, which only carry deltas, can be turned into
+// full order states.
+type userFeed struct {
+	mu      sync.Mutex
+	orders  map[string]*userOrderState
+	onOrder OrderUpdateHandler
+	onFill  FillHandler
+}
+
+type userOrderState struct {
+	order    models.Order
+	avgPrice float64
+	fees     float64
+}
+
+// Exchange feed user channel messages
+
+type exchangeUserMessage struct {
+	Type          string    `json:"type"`
+	OrderID       string    `json:"order_id"`
+	ClientOID     string    `json:"client_oid"`
+	ProductID     string    `json:"product_id"`
+	Side          string    `json:"side"`
+	OrderType     string    `json:"order_type"`
+	Size          string    `json:"size"`
+	Price         string    `json:"price"`
+	RemainingSize string    `json:"remaining_size"`
+	NewSize       string    `json:"new_size"`
+	Reason        string    `json:"reason"`
+	Time          time.Time `json:"time"`
+
+	// match messages
+	TradeID      int64  `json:"trade_id"`
+	MakerOrderID string `json:"maker_order_id"`
+	TakerOrderID string `json:"taker_order_id"`
+	MakerFeeRate string `json:"maker_fee_rate"`
+	TakerFeeRate string `json:"taker_fee_rate"`
+}
+
+// Advanced Trade user channel messages
+
+type atUserMessage struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Events    []atUserEvent `json:"events"`
+}
+
+type atUserEvent struct {
+	Type   string        `json:"type"`
+	Orders []atUserOrder `json:"orders"`
+}
+
+type atUserOrder struct {
+	OrderID            string    `json:"order_id"`
+	ClientOrderID      string    `json:"client_order_id"`
+	ProductID          string    `json:"product_id"`
+	OrderSide          string    `json:"order_side"`
+	OrderType          string    `json:"order_type"`
+	Status             string    `json:"status"`
+	TimeInForce        string    `json:"time_in_force"`
+	LimitPrice         string    `json:"limit_price"`
+	AvgPrice           string    `json:"avg_price"`
+	CumulativeQuantity string    `json:"cumulative_quantity"`
+	LeavesQuantity     string    `json:"leaves_quantity"`
+	TotalFees          string    `json:"total_fees"`
+	PostOnly           bool      `json:"post_only"`
+	CreationTime       time.Time `json:"creation_time"`
+}
+
+// OnOrderUpdate decodes the user channel in the client's feed format, passing
+// order state changes to onOrder and executions to onFill
+func (ws *WebSocketClient) OnOrderUpdate(onOrder OrderUpdateHandler, onFill FillHandler) {
+	feed := &userFeed{
+		orders:  make(map[string]*userOrderState),
+		onOrder: onOrder,
+		onFill:  onFill,
+	}
+
+	if ws.feed == FeedAdvancedTrade {
+		ws.RegisterHandler("user", feed.handleATUser)
+		// Each connection's snapshot is a new baseline; executions missed
+		// while disconnected are picked up by polling the orders
+		ws.OnConnect(feed.reset)
+		return
+	}
+	for _, msgType := range []string{"received", "open", "match", "change", "done"} {
+		ws.RegisterHandler(msgType, feed.handleExchangeUser)
+	}
+}
+
+func (f *userFeed) handleATUser(message json.RawMessage) error {
+	var msg atUserMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("failed to decode user message: %w", err)
+	}
+
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	for _, event := range msg.Events {
+		for _, o := range event.Orders {
+			f.applyATOrder(&o, timestamp, event.Type == "snapshot")
+		}
+	}
+	return nil
+}
+
+// reset forgets every order's state, e.g. on reconnecting
+func (f *userFeed) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.orders = make(map[string]*userOrderState)
+}
+
+// applyATOrder publishes an order's state and derives a fill from any
+// increase in its cumulative quantity since the last message. Orders in a
+// snapshot produce no fill: their executions predate the subscription, and
+// the snapshot is the baseline later messages are compared against.
+func (f *userFeed) applyATOrder(o *atUserOrder, timestamp time.Time, snapshot bool) {
+	filled := parseFloat(o.CumulativeQuantity)
+	avgPrice := parseFloat(o.AvgPrice)
+	fees := parseFloat(o.TotalFees)
+
+	order := models.Order{
+		OrderID:       o.OrderID,
+		ClientOrderID: o.ClientOrderID,
+		Symbol:        o.ProductID,
+		Side:          models.OrderSide(strings.ToLower(o.OrderSide)),
+		Type:          models.OrderType(strings.ToLower(o.OrderType)),
+		Price:         parseFloat(o.LimitPrice),
+		Size:          filled + parseFloat(o.LeavesQuantity),
+		FilledSize:    filled,
+		AvgFillPrice:  avgPrice,
+		Status:        convertATOrderStatus(o.Status, filled),
+		TimeInForce:   o.TimeInForce,
+		PostOnly:      o.PostOnly,
+		CreatedAt:     o.CreationTime,
+		UpdatedAt:     timestamp,
+	}
+	if order.Price == 0 {
+		order.Price = avgPrice
+	}
+
+	f.mu.Lock()
+	prev, seen := f.orders[o.OrderID]
+	if !seen && !snapshot {
+		// Placed after the subscription, so every execution is new
+		prev, seen = &userOrderState{}, true
+	}
+	var fill *models.Fill
+	if seen && !snapshot && filled > prev.order.FilledSize {
+		size := filled - prev.order.FilledSize
+		fill = &models.Fill{
+			OrderID:       order.OrderID,
+			ClientOrderID: order.ClientOrderID,
+			Symbol:        order.Symbol,
+			Side:          order.Side,
+			Price:         (avgPrice*filled - prev.avgPrice*prev.order.FilledSize) / size,
+			Size:          size,
+			Fee:           fees - prev.fees,
+			Timestamp:     timestamp,
+
+			OrderFilledSize: filled,
+		}
+	}
+	if isTerminal(order.Status) {
+		delete(f.orders, o.OrderID)
+	} else {
+		f.orders[o.OrderID] = &userOrderState{order: order, avgPrice: avgPrice, fees: fees}
+	}
+	f.mu.Unlock()
+
+	if fill != nil {
+		f.onFill(*fill)
+	}
+	f.onOrder(order)
+}
+
+func (f *userFeed) handleExchangeUser(message json.RawMessage) error {
+	var msg exchangeUserMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("failed to decode user message: %w", err)
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+
+	if msg.Type == "match" {
+		f.applyExchangeMatch(&msg)
+		return nil
+	}
+
+	f.mu.Lock()
+	state, seen := f.orders[msg.OrderID]
+	if !seen {
+		state = &userOrderState{order: models.Order{
+			OrderID:   msg.OrderID,
+			Symbol:    msg.ProductID,
+			Side:      models.OrderSide(msg.Side),
+			Status:    models.OrderStatusNew,
+			CreatedAt: msg.Time,
+		}}
+		f.orders[msg.OrderID] = state
+	}
+
+	order := &state.order
+	order.UpdatedAt = msg.Time
+	switch msg.Type {
+	case "received":
+		order.ClientOrderID = msg.ClientOID
+		order.Type = models.OrderType(msg.OrderType)
+		order.Price = parseFloat(msg.Price)
+		order.Size = parseFloat(msg.Size)
+	case "open":
+		if order.Size > 0 {
+			order.FilledSize = order.Size - parseFloat(msg.RemainingSize)
+		}
+	case "change":
+		order.Size = order.FilledSize + parseFloat(msg.NewSize)
+	case "done":
+		if order.Size > 0 {
+			order.FilledSize = order.Size - parseFloat(msg.RemainingSize)
+		}
+		order.Status = models.OrderStatusCancelled
+		if msg.Reason == "filled" {
+			order.Status = models.OrderStatusFilled
+		}
+		delete(f.orders, msg.OrderID)
+	}
+	if order.Status == models.OrderStatusNew && order.FilledSize > 0 {
+		order.Status = models.OrderStatusPartiallyFilled
+	}
+	snapshot := *order
+	f.mu.Unlock()
+
+	f.onOrder(snapshot)
+	return nil
+}
+
+// applyExchangeMatch publishes a fill for whichever side of the match is one
+// of our orders. The match side is the maker's, so a taker fill is reversed.
+func (f *userFeed) applyExchangeMatch(msg *exchangeUserMessage) {
+	f.mu.Lock()
+	orderID, feeRate := msg.MakerOrderID, msg.MakerFeeRate
+	side := models.OrderSide(msg.Side)
+	state, ok := f.orders[orderID]
+	if !ok {
+		orderID, feeRate = msg.TakerOrderID, msg.TakerFeeRate
+		side = models.OrderSideBuy
+		if msg.Side == string(models.OrderSideBuy) {
+			side = models.OrderSideSell
+		}
+		state, ok = f.orders[orderID]
+	}
+	if !ok {
+		f.mu.Unlock()
+		return
+	}
+
+	size := parseFloat(msg.Size)
+	price := parseFloat(msg.Price)
+	order := &state.order
+	order.AvgFillPrice = (order.AvgFillPrice*order.FilledSize + price*size) / (order.FilledSize + size)
+	order.FilledSize += size
+	order.UpdatedAt = msg.Time
+	if order.Status == models.OrderStatusNew {
+		order.Status = models.OrderStatusPartiallyFilled
+	}
+	snapshot := *order
+	f.mu.Unlock()
+
+	f.onFill(models.Fill{
+		TradeID:       fmt.Sprintf("%d", msg.TradeID),
+		OrderID:       orderID,
+		ClientOrderID: snapshot.ClientOrderID,
+		Symbol:        msg.ProductID,
+		Side:          side,
+		Price:         price,
+		Size:          size,
+		Fee:           price * size * parseFloat(feeRate),
+		Timestamp:     msg.Time,
+
+		OrderFilledSize: snapshot.FilledSize,
+	})
+	f.onOrder(snapshot)
+}
+
+func isTerminal(status models.OrderStatus) bool {
+	return status == models.OrderStatusFilled ||
+		status == models.OrderStatusCancelled ||
+		status == models.OrderStatusRejected
+}
@@ -0,0 +1,82 @@
+package coinbase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+func TestATUserFeedRebaselinesOnReconnect(t *testing.T) {
+	url, _ := newSubscriptionServer(t)
+	ws := NewWebSocketClient(url, nil, testLogger())
+	ws.feed = FeedAdvancedTrade
+	ws.SetReconnectPolicy(time.Millisecond, 3)
+
+	var mu sync.Mutex
+	var fills []models.Fill
+	ws.OnOrderUpdate(func(models.Order) {}, func(fill models.Fill) {
+		mu.Lock()
+		defer mu.Unlock()
+		fills = append(fills, fill)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ws.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer ws.Close()
+	if event := <-ws.Events(); event.Type != EventConnected {
+		t.Fatalf("event = %s, want connected", event.Type)
+	}
+
+	sequence := 0
+	send := func(eventType, filled, avgPrice string) {
+		msg := fmt.Sprintf(`{"channel":"user","sequence_num":%d,"events":[{"type":%q,"orders":[`+
+			`{"order_id":"o-1","client_order_id":"c-1","product_id":"BTC-USD","order_side":"BUY","status":"OPEN",`+
+			`"cumulative_quantity":%q,"leaves_quantity":"1","avg_price":%q}]}]}`, sequence, eventType, filled, avgPrice)
+		sequence++
+		ws.Dispatch(time.Now(), []byte(msg))
+	}
+	check := func(want ...float64) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if len(fills) != len(want) {
+			t.Fatalf("fills = %+v, want sizes %v", fills, want)
+		}
+		for i, size := range want {
+			if fills[i].Size != size {
+				t.Errorf("fill %d size = %g, want %g", i, fills[i].Size, size)
+			}
+		}
+	}
+
+	send("update", "0.5", "100")
+	check(0.5)
+	if fills[0].OrderFilledSize != 0.5 || fills[0].Price != 100 {
+		t.Errorf("fill = %+v", fills[0])
+	}
+
+	// Executions while disconnected are only in the next snapshot's totals
+	ws.handleDisconnect(errors.New("dropped"))
+	for event := range ws.Events() {
+		if event.Type == EventReconnected {
+			break
+		}
+	}
+	sequence = 0
+	send("snapshot", "1", "101")
+	check(0.5)
+
+	send("update", "1.5", "102")
+	check(0.5, 0.5)
+	if fill := fills[1]; fill.OrderFilledSize != 1.5 || fill.Price != 104 {
+		t.Errorf("fill after snapshot = %+v", fill)
+	}
+}
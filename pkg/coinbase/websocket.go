@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,51 +12,215 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// WebSocketFeed identifies the subscribe protocol spoken by a websocket URL
+type WebSocketFeed string
+
+const (
+	// FeedExchange takes one subscribe message listing all channels, signed
+	// with legacy HMAC credentials
+	FeedExchange WebSocketFeed = "exchange"
+
+	// FeedAdvancedTrade takes one subscribe message per channel, carrying a JWT
+	FeedAdvancedTrade WebSocketFeed = "advanced_trade"
+)
+
+// authChannels require credentials and must be re-signed before the
+// subscription's JWT expires
+var authChannels = map[string]bool{
+	"user":                    true,
+	"futures_balance_summary": true,
+}
+
+const (
+	defaultReconnectDelay = 5 * time.Second
+	defaultMaxReconnects  = 10
+
+	// maxReconnectBackoff caps the exponential delay between reconnects
+	maxReconnectBackoff = 2 * time.Minute
+)
+
 type WebSocketClient struct {
-	url          string
-	apiKey       string
-	apiSecret    string
-	passphrase   string
-	conn         *websocket.Conn
-	mu           sync.Mutex
-	connected    bool
-	subscriptions map[string]bool
-	handlers     map[string]MessageHandler
-	logger       *logrus.Logger
+	url            string
+	feed           WebSocketFeed
+	auth           Authenticator
+	conn           *websocket.Conn
+	connCancel     context.CancelFunc
+	mu             sync.Mutex
+	connected      bool
+	supervising    bool
+	closed         bool
+	reconnectDelay time.Duration
+	maxReconnects  int
+	authRefresh    time.Duration
+	subscriptions  map[string]map[string]bool // channel -> product IDs
+	handlers       map[string]MessageHandler
+	gapHandlers    []SequenceGapHandler
+	connectHooks   []func()
+	taps           []MessageTap
+	lastSequence   int64
+	events         chan ConnectionEvent
+	disconnects    chan error
+	logger         *logrus.Logger
+}
+
+// ConnectionEventType describes a change in the websocket connection state
+type ConnectionEventType string
+
+const (
+	EventConnected    ConnectionEventType = "connected"
+	EventDisconnected ConnectionEventType = "disconnected"
+	EventReconnected  ConnectionEventType = "reconnected"
+
+	// EventFatal means reconnection was abandoned after MaxReconnects attempts
+	EventFatal ConnectionEventType = "fatal"
+)
+
+type ConnectionEvent struct {
+	Type    ConnectionEventType
+	URL     string
+	Attempt int
+	Err     error
+	Time    time.Time
 }
 
 type MessageHandler func(message json.RawMessage) error
 
+// MessageTap observes a raw message and the time it was received
+type MessageTap func(received time.Time, msg WSMessage)
+
+// SequenceGapHandler is called when messages on an Advanced Trade connection
+// were lost or reordered
+type SequenceGapHandler func(expected, got int64)
+
 type WSMessage struct {
-	Type      string          `json:"type"`
-	ProductID string          `json:"product_id"`
-	Time      time.Time       `json:"time"`
-	Sequence  int64           `json:"sequence"`
-	Message   json.RawMessage `json:"-"`
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"`
+	ProductID string    `json:"product_id"`
+	Time      time.Time `json:"time"`
+	Sequence  int64     `json:"sequence"`
+
+	// SequenceNum is the per-connection counter of Advanced Trade messages
+	SequenceNum int64           `json:"sequence_num"`
+	Message     json.RawMessage `json:"-"`
+}
+
+// Key is the name handlers are registered under: the channel for Advanced
+// Trade messages, the type for Exchange ones
+func (m *WSMessage) Key() string {
+	if m.Channel != "" {
+		return m.Channel
+	}
+	return m.Type
 }
 
 type SubscribeMessage struct {
 	Type       string   `json:"type"`
 	ProductIDs []string `json:"product_ids"`
-	Channels   []string `json:"channels"`
-	Signature  string   `json:"signature"`
-	Key        string   `json:"key"`
-	Passphrase string   `json:"passphrase"`
-	Timestamp  string   `json:"timestamp"`
+	Channels   []string `json:"channels,omitempty"`
+	Channel    string   `json:"channel,omitempty"`
+	Signature  string   `json:"signature,omitempty"`
+	Key        string   `json:"key,omitempty"`
+	Passphrase string   `json:"passphrase,omitempty"`
+	Timestamp  string   `json:"timestamp,omitempty"`
+	JWT        string   `json:"jwt,omitempty"`
 }
 
-func NewWebSocketClient(url, apiKey, apiSecret, passphrase string, logger *logrus.Logger) *WebSocketClient {
+// NewWebSocketClient creates a client for the feed at url. auth may be nil for
+// public channels; otherwise LegacyAuthenticator signs Exchange feed
+// subscriptions and JWTAuthenticator signs Advanced Trade ones.
+func NewWebSocketClient(url string, auth Authenticator, logger *logrus.Logger) *WebSocketClient {
 	return &WebSocketClient{
-		url:           url,
-		apiKey:        apiKey,
-		apiSecret:     apiSecret,
-		passphrase:    passphrase,
-		subscriptions: make(map[string]bool),
-		handlers:      make(map[string]MessageHandler),
-		logger:        logger,
+		url:            url,
+		feed:           feedForURL(url),
+		auth:           auth,
+		reconnectDelay: defaultReconnectDelay,
+		maxReconnects:  defaultMaxReconnects,
+		authRefresh:    jwtLifetime - jwtRefreshMargin,
+		subscriptions:  make(map[string]map[string]bool),
+		handlers:       make(map[string]MessageHandler),
+		events:         make(chan ConnectionEvent, 16),
+		disconnects:    make(chan error, 1),
+		logger:         logger,
 	}
 }
 
+// SetReconnectPolicy configures the base delay between reconnect attempts,
+// doubled on each consecutive failure, and how many attempts are made before
+// the client gives up and emits EventFatal
+func (ws *WebSocketClient) SetReconnectPolicy(delay time.Duration, maxReconnects int) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.reconnectDelay = delay
+	ws.maxReconnects = maxReconnects
+}
+
+// Events delivers connection state changes. Events are dropped if the
+// channel is not drained.
+func (ws *WebSocketClient) Events() <-chan ConnectionEvent {
+	return ws.events
+}
+
+// Connected reports whether the socket is currently up
+func (ws *WebSocketClient) Connected() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.connected
+}
+
+// Feed returns the subscribe protocol spoken by the client's URL
+func (ws *WebSocketClient) Feed() WebSocketFeed {
+	return ws.feed
+}
+
+// Level2Channel returns the name of the public L2 order book channel. Only
+// Advanced Trade books can be verified: their messages are counted by the
+// connection's sequence_num, while Exchange level2_batch updates carry no
+// sequence and leave the book unusable.
+func (ws *WebSocketClient) Level2Channel() string {
+	if ws.feed == FeedAdvancedTrade {
+		return "level2"
+	}
+	return "level2_batch"
+}
+
+// MatchesChannel returns the name of the public trade channel
+func (ws *WebSocketClient) MatchesChannel() string {
+	if ws.feed == FeedAdvancedTrade {
+		return "market_trades"
+	}
+	return "matches"
+}
+
+// CheckAuth reports whether the client's credentials can sign subscriptions
+// on its feed: legacy keys on the Exchange feed, JWTs on Advanced Trade ones
+func (ws *WebSocketClient) CheckAuth() error {
+	switch ws.auth.(type) {
+	case nil:
+		return nil
+	case *JWTAuthenticator:
+		if ws.feed != FeedAdvancedTrade {
+			return fmt.Errorf("JWT credentials cannot sign %s feed subscriptions at %s", ws.feed, ws.url)
+		}
+	case *LegacyAuthenticator:
+		if ws.feed != FeedExchange {
+			return fmt.Errorf("legacy credentials cannot sign %s feed subscriptions at %s, which take a JWT", ws.feed, ws.url)
+		}
+	default:
+		return fmt.Errorf("%T cannot sign websocket subscriptions", ws.auth)
+	}
+	return nil
+}
+
+// feedForURL infers the subscribe protocol from the websocket host
+func feedForURL(url string) WebSocketFeed {
+	if strings.Contains(url, "advanced-trade") {
+		return FeedAdvancedTrade
+	}
+	return FeedExchange
+}
+
+// Connect dials the feed and starts a supervisor that reconnects and replays
+// subscriptions whenever the connection drops, until ctx is cancelled
 func (ws *WebSocketClient) Connect(ctx context.Context) error {
 	ws.mu.Lock()
 	defer ws.mu.Unlock()
@@ -64,48 +229,285 @@ func (ws *WebSocketClient) Connect(ctx context.Context) error {
 		return nil
 	}
 
+	conn, err := ws.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	ws.closed = false
+	ws.startConnection(ctx, conn)
+	ws.emit(ConnectionEvent{Type: EventConnected})
+
+	if !ws.supervising {
+		ws.supervising = true
+		go ws.supervise(ctx)
+	}
+
+	return nil
+}
+
+// Close shuts the connection down without triggering a reconnect
+func (ws *WebSocketClient) Close() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.closed = true
+	ws.stopConnection()
+}
+
+// Subscribe records the subscription so it is replayed after reconnects, and
+// sends it immediately if the socket is up
+func (ws *WebSocketClient) Subscribe(channels []string, productIDs []string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if !ws.connected && !ws.supervising {
+		return fmt.Errorf("websocket not connected")
+	}
+
+	for _, channel := range channels {
+		if ws.subscriptions[channel] == nil {
+			ws.subscriptions[channel] = make(map[string]bool)
+		}
+		for _, productID := range productIDs {
+			ws.subscriptions[channel][productID] = true
+		}
+	}
+
+	if !ws.connected {
+		// Sent by replaySubscriptions once the supervisor reconnects
+		return nil
+	}
+
+	return ws.sendSubscriptions("subscribe", channels, productIDs)
+}
+
+// Resubscribe unsubscribes and resubscribes productIDs on channels so the
+// feed sends fresh snapshots. It returns an error if the websocket is not
+// connected; a reconnect replays subscriptions, which has the same effect.
+func (ws *WebSocketClient) Resubscribe(channels []string, productIDs []string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if !ws.connected {
+		return fmt.Errorf("websocket not connected")
+	}
+	if err := ws.sendSubscriptions("unsubscribe", channels, productIDs); err != nil {
+		return err
+	}
+	return ws.sendSubscriptions("subscribe", channels, productIDs)
+}
+
+func (ws *WebSocketClient) dial(ctx context.Context) (*websocket.Conn, error) {
 	dialer := websocket.Dialer{
 		HandshakeTimeout: 10 * time.Second,
 	}
 
 	conn, _, err := dialer.DialContext(ctx, ws.url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to connect to websocket: %w", err)
+		return nil, fmt.Errorf("failed to connect to websocket: %w", err)
 	}
+	return conn, nil
+}
+
+// startConnection adopts conn and starts its reader and keepalive goroutines,
+// which stop when the connection is torn down. Must be called with ws.mu held.
+func (ws *WebSocketClient) startConnection(ctx context.Context, conn *websocket.Conn) {
+	connCtx, cancel := context.WithCancel(ctx)
 
 	ws.conn = conn
+	ws.connCancel = cancel
 	ws.connected = true
+	ws.lastSequence = -1
+	for _, hook := range ws.connectHooks {
+		hook()
+	}
 
-	go ws.readLoop(ctx)
-	go ws.keepAlive(ctx)
+	go ws.readLoop(connCtx, conn)
+	go ws.keepAlive(connCtx)
+	if _, ok := ws.auth.(*JWTAuthenticator); ok {
+		go ws.refreshAuth(connCtx)
+	}
+}
 
-	return nil
+// stopConnection closes the current socket. Must be called with ws.mu held.
+func (ws *WebSocketClient) stopConnection() {
+	ws.connected = false
+	if ws.connCancel != nil {
+		ws.connCancel()
+		ws.connCancel = nil
+	}
+	if ws.conn != nil {
+		ws.conn.Close()
+	}
 }
 
-func (ws *WebSocketClient) Subscribe(channels []string, productIDs []string) error {
+// supervise waits for disconnects and reconnects until ctx is done, the
+// client is closed or MaxReconnects consecutive attempts fail
+func (ws *WebSocketClient) supervise(ctx context.Context) {
+	defer func() {
+		ws.mu.Lock()
+		ws.supervising = false
+		ws.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+			return
+		case err := <-ws.disconnects:
+			ws.mu.Lock()
+			closed := ws.closed
+			ws.mu.Unlock()
+			if closed {
+				return
+			}
+
+			ws.emit(ConnectionEvent{Type: EventDisconnected, Err: err})
+			if err := ws.reconnect(ctx); err != nil {
+				if ctx.Err() == nil {
+					ws.logger.WithError(err).WithField("url", ws.url).Error("Giving up on websocket reconnection")
+					ws.emit(ConnectionEvent{Type: EventFatal, Err: err})
+				}
+				return
+			}
+		}
+	}
+}
+
+// reconnect dials with exponential backoff and replays every recorded
+// subscription on the new connection
+func (ws *WebSocketClient) reconnect(ctx context.Context) error {
 	ws.mu.Lock()
-	defer ws.mu.Unlock()
+	delay, maxReconnects := ws.reconnectDelay, ws.maxReconnects
+	ws.mu.Unlock()
 
-	if !ws.connected {
-		return fmt.Errorf("websocket not connected")
+	var lastErr error
+	for attempt := 1; attempt <= maxReconnects; attempt++ {
+		backoff := delay << uint(attempt-1)
+		if backoff <= 0 || backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		ws.logger.WithFields(logrus.Fields{
+			"url":     ws.url,
+			"attempt": attempt,
+		}).Info("Reconnecting websocket")
+
+		conn, err := ws.dial(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		ws.mu.Lock()
+		if ws.closed {
+			ws.mu.Unlock()
+			conn.Close()
+			return fmt.Errorf("websocket closed")
+		}
+		ws.startConnection(ctx, conn)
+		err = ws.replaySubscriptions()
+		if err != nil {
+			ws.stopConnection()
+		}
+		ws.mu.Unlock()
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		// Drop any disconnect raised by a connection that has been replaced
+		select {
+		case <-ws.disconnects:
+		default:
+		}
+
+		ws.emit(ConnectionEvent{Type: EventReconnected, Attempt: attempt})
+		return nil
 	}
 
-	timestamp := fmt.Sprintf("%d", time.Now().Unix())
-	
-	sub := SubscribeMessage{
-		Type:       "subscribe",
-		ProductIDs: productIDs,
-		Channels:   channels,
-		Key:        ws.apiKey,
-		Passphrase: ws.passphrase,
-		Timestamp:  timestamp,
+	if lastErr == nil {
+		lastErr = fmt.Errorf("reconnection disabled")
 	}
+	return fmt.Errorf("failed to reconnect after %d attempts: %w", maxReconnects, lastErr)
+}
 
-	// Generate signature
-	message := timestamp + "GET" + "/users/self/verify"
-	sub.Signature = ws.sign(message)
+// replaySubscriptions re-sends every recorded subscription. Must be called
+// with ws.mu held.
+func (ws *WebSocketClient) replaySubscriptions() error {
+	for channel, products := range ws.subscriptions {
+		if err := ws.sendSubscriptions("subscribe", []string{channel}, productList(products)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ws *WebSocketClient) emit(event ConnectionEvent) {
+	event.URL = ws.url
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
 
-	return ws.conn.WriteJSON(sub)
+	select {
+	case ws.events <- event:
+	default:
+		ws.logger.WithField("event", event.Type).Warn("Dropped websocket connection event")
+	}
+}
+
+// sendSubscriptions writes signed subscribe/unsubscribe messages in the
+// format of the client's feed. Must be called with ws.mu held.
+func (ws *WebSocketClient) sendSubscriptions(msgType string, channels []string, productIDs []string) error {
+	var messages []SubscribeMessage
+	if ws.feed == FeedAdvancedTrade {
+		for _, channel := range channels {
+			messages = append(messages, SubscribeMessage{
+				Type:       msgType,
+				ProductIDs: productIDs,
+				Channel:    channel,
+			})
+		}
+	} else {
+		messages = append(messages, SubscribeMessage{
+			Type:       msgType,
+			ProductIDs: productIDs,
+			Channels:   channels,
+		})
+	}
+
+	signer, canSign := ws.auth.(subscriptionSigner)
+	for i := range messages {
+		if canSign {
+			if err := signer.signSubscription(&messages[i]); err != nil {
+				return fmt.Errorf("failed to sign subscription: %w", err)
+			}
+		}
+		if err := ws.conn.WriteJSON(messages[i]); err != nil {
+			return fmt.Errorf("failed to send subscription: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// OnMessage registers a tap that sees every decoded message before it is
+// routed to handlers, including ones no handler is registered for
+func (ws *WebSocketClient) OnMessage(tap MessageTap) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.taps = append(ws.taps, tap)
 }
 
 func (ws *WebSocketClient) RegisterHandler(messageType string, handler MessageHandler) {
@@ -114,25 +516,108 @@ func (ws *WebSocketClient) RegisterHandler(messageType string, handler MessageHa
 	ws.handlers[messageType] = handler
 }
 
-func (ws *WebSocketClient) readLoop(ctx context.Context) {
+// OnConnect registers a callback run as each connection is established,
+// before subscriptions are sent on it. It is called with the client's lock
+// held, so it must not call back into the client.
+func (ws *WebSocketClient) OnConnect(hook func()) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.connectHooks = append(ws.connectHooks, hook)
+}
+
+// OnSequenceGap registers a callback for lost or reordered messages
+func (ws *WebSocketClient) OnSequenceGap(handler SequenceGapHandler) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.gapHandlers = append(ws.gapHandlers, handler)
+}
+
+// checkSequence tracks the Advanced Trade sequence_num, which counts every
+// message on a connection, and notifies gap handlers when it skips or goes
+// backwards
+func (ws *WebSocketClient) checkSequence(msg *WSMessage) {
+	if ws.feed != FeedAdvancedTrade || msg.Channel == "" {
+		return
+	}
+
+	ws.mu.Lock()
+	expected := ws.lastSequence + 1
+	gap := ws.lastSequence >= 0 && msg.SequenceNum != expected
+	ws.lastSequence = msg.SequenceNum
+	handlers := ws.gapHandlers
+	ws.mu.Unlock()
+
+	if !gap {
+		return
+	}
+
+	ws.logger.WithFields(logrus.Fields{
+		"expected": expected,
+		"got":      msg.SequenceNum,
+	}).Warn("Websocket sequence gap")
+	for _, handler := range handlers {
+		handler(expected, msg.SequenceNum)
+	}
+}
+
+func (ws *WebSocketClient) readLoop(ctx context.Context, conn *websocket.Conn) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			var msg WSMessage
-			err := ws.conn.ReadJSON(&msg)
+			_, data, err := conn.ReadMessage()
 			if err != nil {
+				if ctx.Err() != nil {
+					// Connection was torn down deliberately
+					return
+				}
 				ws.logger.WithError(err).Error("Failed to read websocket message")
-				ws.handleDisconnect()
+				ws.handleDisconnect(err)
 				return
 			}
 
-			if handler, ok := ws.handlers[msg.Type]; ok {
-				if err := handler(msg.Message); err != nil {
-					ws.logger.WithError(err).Error("Handler error")
-				}
-			}
+			ws.dispatch(time.Now(), data)
+		}
+	}
+}
+
+// Dispatch handles a message as if it had just been read from the
+// connection, e.g. when replaying a recording. received is passed to message
+// taps.
+func (ws *WebSocketClient) Dispatch(received time.Time, data []byte) {
+	ws.dispatch(received, data)
+}
+
+func (ws *WebSocketClient) dispatch(received time.Time, data []byte) {
+	var msg WSMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		ws.logger.WithError(err).Warn("Failed to decode websocket message")
+		return
+	}
+	msg.Message = data
+
+	ws.mu.Lock()
+	taps := ws.taps
+	ws.mu.Unlock()
+	for _, tap := range taps {
+		tap(received, msg)
+	}
+
+	ws.checkSequence(&msg)
+
+	if msg.Type == "error" {
+		ws.logger.WithField("message", string(data)).Error("Websocket error message")
+		return
+	}
+
+	ws.mu.Lock()
+	handler, ok := ws.handlers[msg.Key()]
+	ws.mu.Unlock()
+
+	if ok {
+		if err := handler(msg.Message); err != nil {
+			ws.logger.WithError(err).Error("Handler error")
 		}
 	}
 }
@@ -147,28 +632,81 @@ func (ws *WebSocketClient) keepAlive(ctx context.Context) {
 			return
 		case <-ticker.C:
 			ws.mu.Lock()
-			if ws.connected {
-				if err := ws.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-					ws.logger.WithError(err).Error("Failed to send ping")
-					ws.handleDisconnect()
+			if !ws.connected {
+				ws.mu.Unlock()
+				return
+			}
+			err := ws.conn.WriteMessage(websocket.PingMessage, nil)
+			ws.mu.Unlock()
+
+			if err != nil {
+				ws.logger.WithError(err).Error("Failed to send ping")
+				ws.handleDisconnect(err)
+				return
+			}
+		}
+	}
+}
+
+// refreshAuth re-signs authenticated subscriptions with a fresh JWT before
+// the previous one expires, keeping long-lived connections authorized
+func (ws *WebSocketClient) refreshAuth(ctx context.Context) {
+	ws.mu.Lock()
+	interval := ws.authRefresh
+	ws.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ws.mu.Lock()
+			if !ws.connected {
+				ws.mu.Unlock()
+				return
+			}
+			var err error
+			for channel, products := range ws.subscriptions {
+				if !authChannels[channel] {
+					continue
+				}
+				if err = ws.sendSubscriptions("subscribe", []string{channel}, productList(products)); err != nil {
+					break
 				}
 			}
 			ws.mu.Unlock()
+
+			if err != nil {
+				ws.logger.WithError(err).Error("Failed to refresh websocket authentication")
+			}
 		}
 	}
 }
 
-func (ws *WebSocketClient) handleDisconnect() {
+// handleDisconnect tears down a failed connection and hands it to the
+// supervisor for reconnection
+func (ws *WebSocketClient) handleDisconnect(err error) {
 	ws.mu.Lock()
-	defer ws.mu.Unlock()
-	
-	ws.connected = false
-	if ws.conn != nil {
-		ws.conn.Close()
+	if !ws.connected {
+		ws.mu.Unlock()
+		return
+	}
+	ws.stopConnection()
+	ws.mu.Unlock()
+
+	select {
+	case ws.disconnects <- err:
+	default:
 	}
 }
 
-func (ws *WebSocketClient) sign(message string) string {
-	// Implementation would be similar to BaseClient.sign
-	return ""
-}
\ No newline at end of file
+func productList(products map[string]bool) []string {
+	list := make([]string, 0, len(products))
+	for productID := range products {
+		list = append(list, productID)
+	}
+	return list
+}
@@ -0,0 +1,213 @@
+package coinbase
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// newSubscriptionServer starts a websocket server that forwards every
+// subscription message it receives, and returns its ws:// URL
+func newSubscriptionServer(t *testing.T) (string, <-chan SubscribeMessage) {
+	t.Helper()
+
+	received := make(chan SubscribeMessage, 16)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		for {
+			var msg SubscribeMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			received <- msg
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http"), received
+}
+
+func nextSubscription(t *testing.T, received <-chan SubscribeMessage, timeout time.Duration) SubscribeMessage {
+	t.Helper()
+
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(timeout):
+		t.Fatal("no subscription received")
+		return SubscribeMessage{}
+	}
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestLegacySubscriptionSignature(t *testing.T) {
+	url, received := newSubscriptionServer(t)
+	auth := NewLegacyAuthenticator("ws-key", "ws-secret", "ws-passphrase")
+	ws := NewWebSocketClient(url, auth, testLogger())
+	if err := ws.CheckAuth(); err != nil {
+		t.Fatalf("CheckAuth: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ws.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.Subscribe([]string{"user"}, []string{"BTC-USD"}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	msg := nextSubscription(t, received, time.Second)
+	if msg.Type != "subscribe" || len(msg.Channels) != 1 || msg.Channels[0] != "user" || msg.Channel != "" {
+		t.Errorf("subscription = %+v, want one Exchange message for the user channel", msg)
+	}
+	if len(msg.ProductIDs) != 1 || msg.ProductIDs[0] != "BTC-USD" {
+		t.Errorf("product_ids = %v", msg.ProductIDs)
+	}
+	if msg.Key != "ws-key" || msg.Passphrase != "ws-passphrase" || msg.JWT != "" {
+		t.Errorf("credentials = %+v", msg)
+	}
+
+	seconds, err := strconv.ParseInt(msg.Timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)).Abs() > time.Minute {
+		t.Errorf("timestamp = %q", msg.Timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte("ws-secret"))
+	mac.Write([]byte(msg.Timestamp + "GET/users/self/verify"))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); msg.Signature != want {
+		t.Errorf("signature = %q, want %q", msg.Signature, want)
+	}
+}
+
+func TestJWTSubscriptionRefresh(t *testing.T) {
+	const keyName = "organizations/org-1/apiKeys/key-1"
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewJWTAuthenticator(keyName, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator: %v", err)
+	}
+
+	url, received := newSubscriptionServer(t)
+	ws := NewWebSocketClient(url, auth, testLogger())
+	ws.feed = FeedAdvancedTrade
+	ws.authRefresh = 50 * time.Millisecond
+	if err := ws.CheckAuth(); err != nil {
+		t.Fatalf("CheckAuth: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ws.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.Subscribe([]string{"user", "ticker"}, []string{"BTC-PERP-INTX"}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// parse checks the token's signature and claims and returns its expiry
+	parse := func(msg SubscribeMessage) time.Time {
+		t.Helper()
+
+		token, err := jwt.Parse(msg.JWT, func(*jwt.Token) (any, error) {
+			return &key.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"}))
+		if err != nil {
+			t.Fatalf("%s subscription JWT: %v", msg.Channel, err)
+		}
+		if token.Header["kid"] != keyName {
+			t.Errorf("kid = %v", token.Header["kid"])
+		}
+		claims := token.Claims.(jwt.MapClaims)
+		if claims["sub"] != keyName || claims["iss"] != "coinbase-cloud" {
+			t.Errorf("claims = %v", claims)
+		}
+		if _, ok := claims["uri"]; ok {
+			t.Errorf("websocket JWT carries a uri claim: %v", claims["uri"])
+		}
+		expiry, err := claims.GetExpirationTime()
+		if err != nil || expiry == nil {
+			t.Fatalf("exp = %v, %v", expiry, err)
+		}
+		return expiry.Time
+	}
+
+	first := map[string]SubscribeMessage{}
+	for i := 0; i < 2; i++ {
+		msg := nextSubscription(t, received, time.Second)
+		if msg.Type != "subscribe" || len(msg.Channels) != 0 || msg.Key != "" {
+			t.Errorf("subscription = %+v, want one Advanced Trade message per channel", msg)
+		}
+		first[msg.Channel] = msg
+	}
+	if _, ok := first["user"]; !ok {
+		t.Fatalf("subscriptions = %v, want user and ticker", first)
+	}
+	expiry := parse(first["user"])
+	if d := time.Until(expiry); d <= jwtRefreshMargin || d > jwtLifetime {
+		t.Errorf("JWT expires in %v, want about %v", d, jwtLifetime)
+	}
+
+	// Bring the cached token within the refresh margin of expiry; the next
+	// refresh must re-send the user subscription with a new token
+	auth.wsMu.Lock()
+	auth.wsExpiry = time.Now().Add(jwtRefreshMargin / 2)
+	auth.wsMu.Unlock()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		var msg SubscribeMessage
+		select {
+		case msg = <-received:
+		case <-deadline:
+			t.Fatal("user subscription was not re-sent with a fresh JWT")
+		}
+		if msg.Channel != "user" {
+			t.Fatalf("refreshed the unauthenticated %q channel", msg.Channel)
+		}
+		if msg.JWT == first["user"].JWT {
+			continue
+		}
+		if refreshed := parse(msg); time.Until(refreshed) <= jwtRefreshMargin {
+			t.Errorf("refreshed JWT expires in %v", time.Until(refreshed))
+		}
+		return
+	}
+}
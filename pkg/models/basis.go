@@ -1,43 +1,265 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// BasisSnapshot is a strategy's basis at a point in time. Basis and
+// BasisPercent are between the legs' last trade prices.
 type BasisSnapshot struct {
-	SpotSymbol   string
-	FutureSymbol string
-	SpotPrice    float64
-	FuturePrice  float64
-	Basis        float64
-	BasisPercent float64
-	Timestamp    time.Time
+	SpotSymbol   string  `json:"spot_symbol"`
+	FutureSymbol string  `json:"future_symbol"`
+	SpotPrice    float64 `json:"spot_price"`
+	FuturePrice  float64 `json:"future_price"`
+	Basis        float64 `json:"basis"`
+	BasisPercent float64 `json:"basis_percent"`
+
+	// EntryBasis is the basis entering captures, selling the future at its
+	// bid and buying spot at its ask. ExitBasis is the basis exiting gives
+	// up, buying the future at its ask and selling spot at its bid. MidBasis
+	// is between the legs' mid prices.
+	EntryBasis        float64 `json:"entry_basis"`
+	EntryBasisPercent float64 `json:"entry_basis_percent"`
+	ExitBasis         float64 `json:"exit_basis"`
+	ExitBasisPercent  float64 `json:"exit_basis_percent"`
+	MidBasis          float64 `json:"mid_basis"`
+	MidBasisPercent   float64 `json:"mid_basis_percent"`
+
+	// Spreads are each leg's ask minus its bid, and ages how long ago each
+	// leg's quote was received
+	SpotSpread     float64       `json:"spot_spread"`
+	FutureSpread   float64       `json:"future_spread"`
+	SpotQuoteAge   time.Duration `json:"spot_quote_age"`
+	FutureQuoteAge time.Duration `json:"future_quote_age"`
+
+	// FundingRate is the future's predicted funding rate per interval, zero
+	// for futures without funding. ExpectedCarry is the annualized percent
+	// return the carry model expects from entering now, at the entry basis;
+	// ExitCarry is the same valued at the exit basis, what exiting now gives
+	// up. HasCarry is false when they could not be estimated.
+	FundingRate   float64 `json:"funding_rate"`
+	ExpectedCarry float64 `json:"expected_carry"`
+	ExitCarry     float64 `json:"exit_carry"`
+	HasCarry      bool    `json:"has_carry"`
+
+	// Expiry is when a dated future settles, zero for perpetuals.
+	// AnnualizedBasis is EntryBasisPercent scaled to a year over the time
+	// left to it.
+	Expiry          time.Time `json:"expiry"`
+	DaysToExpiry    float64   `json:"days_to_expiry"`
+	AnnualizedBasis float64   `json:"annualized_basis"`
+
+	// ZScore is MidBasisPercent's distance from its mean in the strategy's
+	// z-score model, in standard deviations. EntryBand is the entry basis
+	// percent the model enters at and ExitBand the exit basis percent it
+	// exits at. HasZScore is false for other models, and while the model is
+	// warming up.
+	ZScore      float64 `json:"z_score"`
+	BasisMean   float64 `json:"basis_mean"`
+	BasisStdDev float64 `json:"basis_std_dev"`
+	EntryBand   float64 `json:"entry_band"`
+	ExitBand    float64 `json:"exit_band"`
+	HasZScore   bool    `json:"has_z_score"`
+
+	Timestamp time.Time `json:"timestamp"`
 }
 
+// EntrySignal selects the measure a strategy enters and exits on
+type EntrySignal string
+
+const (
+	// EntrySignalBasis trades on the raw basis percent against TargetBasis
+	EntrySignalBasis EntrySignal = "basis"
+
+	// EntrySignalCarry trades on expected annualized carry against
+	// TargetCarry
+	EntrySignalCarry EntrySignal = "carry"
+
+	// EntrySignalAnnualizedBasis trades a dated future's basis, annualized
+	// over its time to expiry, against TargetAnnualizedBasis
+	EntrySignalAnnualizedBasis EntrySignal = "annualized_basis"
+)
+
 type BasisStrategy struct {
-	ID               string
-	SpotSymbol       string
-	FutureSymbol     string
-	TargetBasis      float64
-	MaxPosition      float64
-	MinTradeSize     float64
+	ID                    string
+	SpotSymbol            string
+	FutureSymbol          string
+	TargetBasis           float64
+	EntrySignal           EntrySignal // empty trades on basis
+	TargetCarry           float64     // annualized percent, with EntrySignalCarry
+	TargetAnnualizedBasis float64     // percent, with EntrySignalAnnualizedBasis
+	MaxPosition           float64
+	MinTradeSize          float64 // size signals are priced at, and rolled per step
+
+	// RebalanceThreshold is how far, as a fraction of MaxPosition, the
+	// position may drift from its model's target before it is traded back;
+	// zero uses the trader's default
 	RebalanceThreshold float64
-	IsActive         bool
-	CreatedAt        time.Time
-	UpdatedAt        time.Time
+
+	// RebalanceChunk is the most traded per evaluation while rebalancing
+	// toward the model's target, sized down to what the books absorb within
+	// the slippage limit; zero uses MinTradeSize
+	RebalanceChunk float64
+
+	// RollDays is how many days before a dated future expires the position
+	// is rolled into the next expiry; zero rolls at the trader's default
+	RollDays float64
+
+	// Type names the signal model that decides the position to hold, from
+	// those registered with the trader; empty is the threshold model on
+	// EntrySignal. Params are the model's parameters, as JSON.
+	Type   string
+	Params json.RawMessage
+
+	IsActive  bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
-type BasisTrade struct {
-	ID           string
-	StrategyID   string
-	SpotOrderID  string
-	FutureOrderID string
-	SpotPrice    float64
-	FuturePrice  float64
+// StrategyPosition is the inventory a strategy's own fills have put on
+type StrategyPosition struct {
+	StrategyID     string
+	SpotSymbol     string
+	FutureSymbol   string
+	SpotSize       float64 // signed, positive when long
+	FutureSize     float64 // signed, negative when short
+	SpotAvgPrice   float64
+	FutureAvgPrice float64
+
+	// ExpiringSymbol is a future the strategy is rolling out of, with the
+	// size still held in it
+	ExpiringSymbol string
+	ExpiringSize   float64
+
+	// EstimatedFundingPnL is funding booked on the future leg at the
+	// predicted rates, negative when paid. It estimates, and may differ
+	// from, what the exchange settled.
+	EstimatedFundingPnL float64
+
+	// AvgEntryBasis is the future's average entry price less the spot's
+	AvgEntryBasis float64
+
+	// NetDelta is the unhedged size, zero when the legs offset exactly
+	NetDelta  float64
+	UpdatedAt time.Time
+}
+
+// PositionDiscrepancy flags a symbol whose exchange position does not match
+// the sum of strategy allocations
+type PositionDiscrepancy struct {
+	Symbol     string
+	Ledger     float64 // sum of strategy allocations
+	External   float64 // held outside the strategies when first reconciled
+	Exchange   float64
+	Difference float64 // Exchange - External - Ledger
+	DetectedAt time.Time
+}
+
+// ExternalPosition is inventory on the exchange that no strategy owns, such
+// as balances held before the trader started or a strategy was added
+type ExternalPosition struct {
+	Symbol string
+	Size   float64
+}
+
+// PositionReport is the strategy ledger alongside the exchange positions it
+// was last reconciled against
+type PositionReport struct {
+	Strategies    []StrategyPosition
+	Exchange      []Position
+	External      []ExternalPosition
+	Discrepancies []PositionDiscrepancy
+	ReconciledAt  *time.Time
+}
+
+// ReconcileResult reports how trader state was rebuilt from the store and
+// matched against the exchange. Trading stays halted until a pass is
+// consistent.
+type ReconcileResult struct {
+	StartedAt       time.Time
+	CompletedAt     time.Time
+	Consistent      bool
+	Strategies      int      // strategies loaded from the store
+	TradesRestored  []string // unsettled trades resumed under supervision
+	OrdersRefreshed int      // persisted working orders refreshed from the exchange
+	OrdersAdopted   []string // exchange orders nobody owned, now tracked
+	OrdersCancelled []string // exchange orders nobody owned, now cancelled
+	Positions       []PositionDiscrepancy
+	Problems        []string
+}
+
+// BasisTradeState is a step in the lifecycle of a basis trade
+type BasisTradeState string
+
+const (
+	// TradeStatePending means both legs are working and neither has filled
+	TradeStatePending BasisTradeState = "pending"
+
+	// TradeStateLeg1Filled means one leg has filled and the other is working
+	TradeStateLeg1Filled BasisTradeState = "leg1_filled"
+
+	// TradeStateHedging means the lagging leg is being completed at market
+	TradeStateHedging BasisTradeState = "hedging"
+
+	TradeStateComplete BasisTradeState = "complete"
+
+	// TradeStateUnwinding means hedging failed and the filled leg is being
+	// closed out
+	TradeStateUnwinding BasisTradeState = "unwinding"
+
+	TradeStateFailed BasisTradeState = "failed"
+)
+
+// TradeTransition records a change in a basis trade's state
+type TradeTransition struct {
+	From   BasisTradeState
+	To     BasisTradeState
+	Reason string
+	Time   time.Time
+}
+
+// TradeLink ties part of an exit trade to the entry trade it closes
+type TradeLink struct {
+	EntryTradeID string
 	Size         float64
-	Basis        float64
-	Side         string // "enter" or "exit"
-	Status       string
-	CreatedAt    time.Time
-	CompletedAt  *time.Time
-}
\ No newline at end of file
+
+	// RealizedPnL is the basis captured on Size: entry basis minus exit
+	// basis, in quote currency
+	RealizedPnL float64
+}
+
+// BasisTrade is a pair of orders traded together. Entries buy spot and sell
+// the future, exits reverse them. Rolls move a short future position into a
+// later expiry: their spot fields describe the expiring future bought back,
+// and Basis is the spread between the two futures.
+type BasisTrade struct {
+	ID                  string
+	StrategyID          string
+	SpotSymbol          string
+	FutureSymbol        string
+	SpotOrderID         string
+	FutureOrderID       string
+	SpotClientOrderID   string
+	FutureClientOrderID string
+	SpotPrice           float64
+	FuturePrice         float64
+	Size                float64
+	Basis               float64
+	Side                string // "enter", "exit" or "roll"
+	Status              BasisTradeState
+	FailureReason       string
+	SpotStatus          OrderStatus
+	FutureStatus        OrderStatus
+	SpotFilledSize      float64
+	FutureFilledSize    float64
+	SpotAvgPrice        float64
+	FutureAvgPrice      float64
+	ClosedSize          float64     // entry trades: size closed by exits so far
+	Closes              []TradeLink // exit trades: the entries closed
+	RealizedPnL         float64     // exits: basis captured across Closes; rolls: the spread
+	Fills               []Fill
+	Transitions         []TradeTransition
+	CreatedAt           time.Time
+	CompletedAt         *time.Time
+}
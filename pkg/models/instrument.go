@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+)
+
+// Instrument describes a tradable product
+type Instrument struct {
+	Symbol string
+	Type   MarketType
+
+	// Underlying is the asset the product tracks, such as BTC, and Quote the
+	// currency it is priced in
+	Underlying string
+	Quote      string
+
+	// Expiry is when a dated future settles, zero for other types
+	Expiry time.Time
+
+	// ContractSize is the units of Underlying one contract of size 1 is for;
+	// 1 for spot and for futures sized in the underlying
+	ContractSize float64
+
+	TickSize      float64 // price increment
+	SizeIncrement float64 // in the exchange's units: contracts for futures
+}
+
+// Expires reports whether the instrument is a dated future
+func (i Instrument) Expires() bool {
+	return i.Type == MarketTypeFuture && !i.Expiry.IsZero()
+}
+
+// TermStructure is the basis of every listed future on an underlying against
+// its spot price
+type TermStructure struct {
+	Underlying string
+	SpotSymbol string
+	SpotPrice  float64
+	Points     []TermStructurePoint // perpetuals first, then by expiry
+	Timestamp  time.Time
+}
+
+// TermStructurePoint is one future's basis. AnnualizedBasis is zero for
+// perpetuals, which never converge; their FundingRate is the predicted rate
+// per funding interval, if known.
+type TermStructurePoint struct {
+	Symbol          string
+	Type            MarketType
+	Expiry          time.Time
+	DaysToExpiry    float64
+	Price           float64
+	Basis           float64
+	BasisPercent    float64
+	AnnualizedBasis float64
+	FundingRate     float64
+}
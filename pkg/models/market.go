@@ -14,14 +14,16 @@ type Market struct {
 type MarketType string
 
 const (
-	MarketTypeSpot   MarketType = "spot"
-	MarketTypeFuture MarketType = "future"
+	MarketTypeSpot      MarketType = "spot"
+	MarketTypeFuture    MarketType = "future" // dated, with an expiry
+	MarketTypePerpetual MarketType = "perpetual"
 )
 
 type OrderBook struct {
 	Symbol    string
 	Bids      []OrderBookLevel
 	Asks      []OrderBookLevel
+	Sequence  int64
 	Timestamp time.Time
 }
 
@@ -61,4 +63,35 @@ type Position struct {
 	UnrealizedPL float64
 	RealizedPL   float64
 	UpdatedAt    time.Time
+}
+
+// FundingRate is a perpetual's funding terms. At each funding time longs pay
+// shorts the rate times their position's notional; a negative rate pays the
+// other way.
+type FundingRate struct {
+	Symbol string
+
+	// Rate accrues over the current interval; PredictedRate is the best
+	// estimate of the rate that will settle at NextFundingTime
+	Rate          float64
+	PredictedRate float64
+
+	Interval        time.Duration
+	NextFundingTime time.Time
+	Timestamp       time.Time
+}
+
+// FundingPayment is funding settled on a strategy's perpetual position
+type FundingPayment struct {
+	StrategyID string
+	Symbol     string
+	Rate       float64
+	Position   float64 // signed size funded, negative when short
+	MarkPrice  float64
+	Amount     float64 // received, negative when paid
+	Time       time.Time
+
+	// Estimated is set when Amount was worked out from the rate last
+	// predicted for the interval, not reported by the exchange
+	Estimated bool
 }
\ No newline at end of file
@@ -5,19 +5,21 @@ import (
 )
 
 type Order struct {
-	OrderID      string
-	Symbol       string
-	Side         OrderSide
-	Type         OrderType
-	Price        float64
-	Size         float64
-	FilledSize   float64
-	Status       OrderStatus
-	TimeInForce  string
-	PostOnly     bool
-	ReduceOnly   bool
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	OrderID       string
+	ClientOrderID string
+	Symbol        string
+	Side          OrderSide
+	Type          OrderType
+	Price         float64
+	Size          float64
+	FilledSize    float64
+	AvgFillPrice  float64 // of the filled size, zero if nothing has filled or it is not reported
+	Status        OrderStatus
+	TimeInForce   string
+	PostOnly      bool
+	ReduceOnly    bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 type OrderSide string
@@ -43,15 +45,39 @@ const (
 	OrderStatusFilled          OrderStatus = "filled"
 	OrderStatusCancelled       OrderStatus = "cancelled"
 	OrderStatusRejected        OrderStatus = "rejected"
+
+	// OrderStatusUnknown is an order whose placement failed in a way that
+	// leaves open whether the exchange accepted it
+	OrderStatusUnknown OrderStatus = "unknown"
 )
 
+// Fill is a single execution against an order
+type Fill struct {
+	TradeID       string
+	OrderID       string
+	ClientOrderID string
+	Symbol        string
+	Side          OrderSide
+	Price         float64
+	Size          float64
+	Fee           float64
+	Timestamp     time.Time
+
+	// OrderFilledSize is the order's filled size including this fill, zero
+	// if the source does not report it
+	OrderFilledSize float64
+}
+
 type OrderRequest struct {
-	Symbol      string
-	Side        OrderSide
-	Type        OrderType
-	Price       float64
-	Size        float64
-	TimeInForce string
-	PostOnly    bool
-	ReduceOnly  bool
-}
\ No newline at end of file
+	// ClientOrderID makes placement idempotent: retries reuse it so the
+	// exchange never accepts the same order twice
+	ClientOrderID string
+	Symbol        string
+	Side          OrderSide
+	Type          OrderType
+	Price         float64
+	Size          float64
+	TimeInForce   string
+	PostOnly      bool
+	ReduceOnly    bool
+}
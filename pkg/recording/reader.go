@@ -0,0 +1,202 @@
+package recording
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Reader reads the records of a recording in order, across its files
+type Reader struct {
+	files     []recordingFile
+	current   int
+	file      *os.File
+	gz        *gzip.Reader
+	buf       *bufio.Reader
+	truncated []string
+}
+
+type recordingFile struct {
+	path   string
+	header Header
+}
+
+// Open reads the recording made up of paths, each a recording file or a
+// directory of them. Files are read in the order they were written,
+// regardless of how they are named, and must come from the same websocket.
+func Open(paths ...string) (*Reader, error) {
+	var names []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			names = append(names, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), FileExt) {
+				names = append(names, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no recording files in %s", strings.Join(paths, ", "))
+	}
+
+	files := make([]recordingFile, 0, len(names))
+	var truncated []string
+	for _, name := range names {
+		header, err := readHeader(name)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// Killed before the header was flushed, so it holds no records
+			truncated = append(truncated, name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if len(files) > 0 && header.URL != files[0].header.URL {
+			return nil, fmt.Errorf("%s was recorded from %s, not %s", name, header.URL, files[0].header.URL)
+		}
+		files = append(files, recordingFile{path: name, header: header})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no complete recording files in %s", strings.Join(paths, ", "))
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i].header, files[j].header
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+		return a.FirstSeq < b.FirstSeq
+	})
+
+	return &Reader{files: files, current: -1, truncated: truncated}, nil
+}
+
+// Header returns the header of the recording's first file
+func (r *Reader) Header() Header {
+	return r.files[0].header
+}
+
+// Files returns the recording's files in read order
+func (r *Reader) Files() []string {
+	paths := make([]string, len(r.files))
+	for i, f := range r.files {
+		paths[i] = f.path
+	}
+	return paths
+}
+
+// Truncated returns the files found so far that end mid-stream, as a file
+// does when the recorder is killed. Their records up to the last flush are
+// read; files cut off before their header are skipped.
+func (r *Reader) Truncated() []string {
+	return r.truncated
+}
+
+// Next returns the next record, or io.EOF after the last one
+func (r *Reader) Next() (Record, error) {
+	for {
+		if r.buf == nil {
+			if r.current+1 >= len(r.files) {
+				return Record{}, io.EOF
+			}
+			r.current++
+			if err := r.openCurrent(); err != nil {
+				return Record{}, err
+			}
+		}
+
+		line, err := r.buf.ReadBytes('\n')
+		if err == nil {
+			var rec Record
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return Record{}, fmt.Errorf("%s: failed to decode record: %w", r.files[r.current].path, err)
+			}
+			return rec, nil
+		}
+
+		// A partial line at the end is a write cut short
+		if len(line) > 0 || errors.Is(err, io.ErrUnexpectedEOF) {
+			r.truncated = append(r.truncated, r.files[r.current].path)
+		} else if !errors.Is(err, io.EOF) {
+			return Record{}, fmt.Errorf("%s: failed to read record: %w", r.files[r.current].path, err)
+		}
+		r.closeCurrent()
+	}
+}
+
+// Close releases the file being read
+func (r *Reader) Close() error {
+	r.closeCurrent()
+	return nil
+}
+
+func (r *Reader) openCurrent() error {
+	path := r.files[r.current].path
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	r.file, r.gz, r.buf = file, gz, bufio.NewReader(gz)
+	if _, err := r.buf.ReadBytes('\n'); err != nil {
+		r.closeCurrent()
+		return fmt.Errorf("%s: failed to read header: %w", path, err)
+	}
+	return nil
+}
+
+func (r *Reader) closeCurrent() {
+	if r.file == nil {
+		return
+	}
+	r.gz.Close()
+	r.file.Close()
+	r.file, r.gz, r.buf = nil, nil, nil
+}
+
+func readHeader(path string) (Header, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return Header{}, err
+	}
+	defer gz.Close()
+
+	line, err := bufio.NewReader(gz).ReadBytes('\n')
+	if err != nil {
+		return Header{}, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	var header Header
+	if err := json.Unmarshal(line, &header); err != nil {
+		return Header{}, fmt.Errorf("failed to decode header: %w", err)
+	}
+	return header, header.validate()
+}
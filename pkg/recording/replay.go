@@ -0,0 +1,91 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/clock"
+)
+
+// ReplayConfig controls the pace of a replay
+type ReplayConfig struct {
+	// Speed is a multiple of recorded time: 1 replays in real time, 10 ten
+	// times faster. Zero or less replays as fast as records can be handled.
+	Speed float64
+
+	// Records received before From are handled immediately, to build state
+	// up to the point of interest; pacing starts at the first record after it
+	From time.Time
+
+	// Clock, if set, is moved to each record's receive time before the record
+	// is handled, so consumers see the time the message originally arrived
+	Clock *clock.Simulated
+}
+
+// ReplayStats summarizes a replay
+type ReplayStats struct {
+	Records int
+	Start   time.Time
+	End     time.Time
+
+	// Gaps counts breaks in the record sequence, from missing files or
+	// records the recorder failed to write
+	Gaps int
+}
+
+// Replay passes every record in r to handle, paced by their receive times.
+// It stops at the end of the recording, when ctx is cancelled, or when
+// handle returns an error.
+func Replay(ctx context.Context, r *Reader, config ReplayConfig, handle func(Record) error) (ReplayStats, error) {
+	var stats ReplayStats
+	var paceFrom, began time.Time
+	var lastSeq int64
+
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		if stats.Records == 0 {
+			stats.Start = rec.Time
+		} else if rec.Seq != lastSeq+1 && rec.Seq != 1 {
+			// Numbering restarts at 1 with each run of the recorder
+			stats.Gaps++
+		}
+		lastSeq = rec.Seq
+
+		if began.IsZero() && !rec.Time.Before(config.From) {
+			paceFrom, began = rec.Time, time.Now()
+		}
+		if config.Speed > 0 && !began.IsZero() {
+			due := time.Duration(float64(rec.Time.Sub(paceFrom)) / config.Speed)
+			if wait := due - time.Since(began); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return stats, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+
+		if config.Clock != nil {
+			config.Clock.Set(rec.Time)
+		}
+		if err := handle(rec); err != nil {
+			return stats, err
+		}
+		stats.Records++
+		stats.End = rec.Time
+	}
+}
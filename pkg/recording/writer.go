@@ -0,0 +1,213 @@
+package recording
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPrefix   = "marketdata"
+	defaultMaxBytes = 256 << 20
+	defaultMaxAge   = time.Hour
+)
+
+// WriterConfig describes where a recording is written and when its files
+// rotate
+type WriterConfig struct {
+	Dir    string
+	Prefix string // file name prefix; "marketdata" if empty
+
+	// A file is closed and a new one started once it holds MaxBytes of
+	// compressed data or spans MaxAge of receive time
+	MaxBytes int64
+	MaxAge   time.Duration
+
+	// Written to each file's header
+	URL      string
+	Channels []string
+	Products []string
+}
+
+// Writer appends records to a recording, rotating files as they fill. It is
+// safe for concurrent use.
+type Writer struct {
+	config WriterConfig
+
+	mu      sync.Mutex
+	seq     int64
+	file    *os.File
+	counter *countingWriter
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	started time.Time
+	closed  bool
+}
+
+func NewWriter(config WriterConfig) (*Writer, error) {
+	if config.Prefix == "" {
+		config.Prefix = defaultPrefix
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaultMaxBytes
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = defaultMaxAge
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	return &Writer{config: config}, nil
+}
+
+// Write numbers rec and appends it, starting a new file first if the current
+// one is full
+func (w *Writer) Write(rec Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("recording writer is closed")
+	}
+	if w.file != nil && (w.counter.n >= w.config.MaxBytes || rec.Time.Sub(w.started) >= w.config.MaxAge) {
+		if err := w.closeFileLocked(); err != nil {
+			return err
+		}
+	}
+
+	w.seq++
+	rec.Seq = w.seq
+	if w.file == nil {
+		if err := w.openFileLocked(rec.Time); err != nil {
+			w.seq--
+			return err
+		}
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	if _, err := w.buf.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	return nil
+}
+
+// Flush pushes buffered records through to the file, so a crash loses at
+// most what was written since
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush recording: %w", err)
+	}
+	if err := w.gz.Flush(); err != nil {
+		return fmt.Errorf("failed to flush recording: %w", err)
+	}
+	return nil
+}
+
+// Close finishes the current file. Later writes fail.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	return w.closeFileLocked()
+}
+
+// Seq returns the sequence number of the last record written
+func (w *Writer) Seq() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+func (w *Writer) openFileLocked(start time.Time) error {
+	start = start.UTC()
+	base := fmt.Sprintf("%s-%s", w.config.Prefix, start.Format("20060102T150405Z"))
+
+	var file *os.File
+	for n := 0; file == nil; n++ {
+		name := base + FileExt
+		if n > 0 {
+			name = fmt.Sprintf("%s-%d%s", base, n, FileExt)
+		}
+		f, err := os.OpenFile(filepath.Join(w.config.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		switch {
+		case errors.Is(err, fs.ErrExist):
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to create recording file: %w", err)
+		}
+		file = f
+	}
+
+	w.file = file
+	w.counter = &countingWriter{w: file}
+	w.gz = gzip.NewWriter(w.counter)
+	w.buf = bufio.NewWriter(w.gz)
+	w.started = start
+
+	header := Header{
+		Format:   Format,
+		Version:  Version,
+		Created:  start,
+		URL:      w.config.URL,
+		Channels: w.config.Channels,
+		Products: w.config.Products,
+		FirstSeq: w.seq,
+	}
+	line, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode recording header: %w", err)
+	}
+	if _, err := w.buf.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write recording header: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) closeFileLocked() error {
+	defer func() { w.file = nil }()
+
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to flush recording: %w", err)
+	}
+	if err := w.gz.Close(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to finish recording file: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close recording file: %w", err)
+	}
+	return nil
+}
+
+// countingWriter tracks the compressed size of a file
+type countingWriter struct {
+	w *os.File
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -2,11 +2,16 @@ package trader
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gregtusar/basis/internal/storage"
+	"github.com/gregtusar/basis/pkg/clock"
 	"github.com/gregtusar/basis/pkg/coinbase"
 	"github.com/gregtusar/basis/pkg/models"
 	"github.com/sirupsen/logrus"
@@ -16,38 +21,108 @@ type BasisTrader struct {
 	spotClient   coinbase.Client
 	futureClient coinbase.Client
 	strategies   map[string]*models.BasisStrategy
-	positions    map[string]*models.Position
+	runners      map[string]*strategyRunner // strategy ID -> its model
+	ledger       *positionLedger
 	marketData   *MarketDataManager
+	instruments  *instrumentRegistry
+	rolls        map[string]string // strategy ID -> future being rolled into, empty if none is listed
+	feeds        map[string]*feed
+	userFeed     *coinbase.WebSocketClient
+	trades       map[string]*tradeState
+	orders       map[string]*trackedOrder // keyed by client order ID
+	orderIDs     map[string]string        // exchange order ID -> client order ID
+	orderTimeout time.Duration
+	maxSlippage  float64
+	store        storage.Repository
+	persistQueue chan persistOp
+	persistDone  chan struct{}
 	logger       *logrus.Logger
 	mu           sync.RWMutex
 	stopCh       chan struct{}
-}
-
-type MarketDataManager struct {
-	tickers    map[string]*models.Ticker
-	orderBooks map[string]*models.OrderBook
-	mu         sync.RWMutex
+	priceUpdates chan struct{}
+	running      bool
+
+	snapshotInterval time.Duration
+	lastSnapshot     time.Time
+	clock            clock.Clock
+	carry            CarryModel
+
+	// rebalanceThreshold is the default strategy RebalanceThreshold
+	rebalanceThreshold float64
+
+	// Reconciliation of persisted state against the exchanges; trading is
+	// enabled once it is consistent
+	reconcileMu     sync.Mutex
+	orphanPolicy    OrphanPolicy
+	restored        bool
+	reconciled      bool
+	reconcileResult *models.ReconcileResult
 }
 
 func NewBasisTrader(spotClient, futureClient coinbase.Client, logger *logrus.Logger) *BasisTrader {
-	return &BasisTrader{
-		spotClient:   spotClient,
-		futureClient: futureClient,
-		strategies:   make(map[string]*models.BasisStrategy),
-		positions:    make(map[string]*models.Position),
-		marketData: &MarketDataManager{
-			tickers:    make(map[string]*models.Ticker),
-			orderBooks: make(map[string]*models.OrderBook),
-		},
-		logger: logger,
-		stopCh: make(chan struct{}),
+	clk := clock.Real()
+	bt := &BasisTrader{
+		spotClient:       spotClient,
+		futureClient:     futureClient,
+		strategies:       make(map[string]*models.BasisStrategy),
+		runners:          make(map[string]*strategyRunner),
+		ledger:           newPositionLedger(clk),
+		feeds:            make(map[string]*feed),
+		trades:           make(map[string]*tradeState),
+		orders:           make(map[string]*trackedOrder),
+		orderIDs:         make(map[string]string),
+		orderTimeout:     defaultOrderTimeout,
+		maxSlippage:      defaultMaxSlippage,
+		snapshotInterval: defaultSnapshotInterval,
+		orphanPolicy:     OrphanPolicyCancel,
+		marketData:       newMarketDataManager(logger, clk),
+		instruments:      newInstrumentRegistry(),
+		rolls:            make(map[string]string),
+		clock:            clk,
+		logger:           logger,
+		stopCh:           make(chan struct{}),
+		priceUpdates:     make(chan struct{}, 1),
+	}
+
+	for _, client := range []coinbase.Client{spotClient, futureClient} {
+		if notifier, ok := client.(fillNotifier); ok {
+			notifier.OnFill(bt.handleFill)
+		}
 	}
+	return bt
 }
 
 func (bt *BasisTrader) Start(ctx context.Context) error {
 	bt.logger.Info("Starting basis trader")
 
-	// Start market data collection
+	if err := bt.startFeeds(ctx); err != nil {
+		return err
+	}
+
+	// Describe the products before positions are read, as futures positions
+	// are reported in contracts
+	bt.loadInstruments(ctx)
+
+	// Restore persisted state and match it against the exchanges before
+	// trading; an inconsistent result is retried until it clears
+	if !bt.Reconcile(ctx, false).Consistent {
+		go bt.retryReconcile(ctx)
+	}
+
+	bt.mu.Lock()
+	bt.running = true
+	strategies := make([]*models.BasisStrategy, 0, len(bt.strategies))
+	for _, s := range bt.strategies {
+		strategies = append(strategies, s)
+	}
+	bt.mu.Unlock()
+
+	for _, strategy := range strategies {
+		bt.subscribeMarketData(strategy)
+		bt.subscribeOrderUpdates(strategy)
+	}
+
+	// Start REST market data polling, used while the stream is down
 	go bt.collectMarketData(ctx)
 
 	// Start strategy execution loop
@@ -56,24 +131,98 @@ func (bt *BasisTrader) Start(ctx context.Context) error {
 	// Start position monitoring
 	go bt.monitorPositions(ctx)
 
+	// Start polling orders the user stream is not reporting on
+	go bt.monitorOrders(ctx)
+
+	// Start driving open trades through hedging and unwinding
+	go bt.superviseTrades(ctx)
+
+	// Start polling funding rates and settling funding on perpetual positions
+	go bt.monitorFunding(ctx)
+
+	// Start refreshing listed products, for new expiries to roll into
+	go bt.monitorInstruments(ctx)
+
+	// Start recording basis history, which models' statistics are built from
+	go bt.recordSnapshots(ctx)
+
 	return nil
 }
 
+// Stop stops trading and waits for queued writes to reach the store
 func (bt *BasisTrader) Stop() {
 	bt.logger.Info("Stopping basis trader")
 	close(bt.stopCh)
+
+	bt.mu.RLock()
+	done := bt.persistDone
+	bt.mu.RUnlock()
+	if done != nil {
+		<-done
+	}
 }
 
-func (bt *BasisTrader) AddStrategy(strategy *models.BasisStrategy) error {
+// SetClock replaces the wall clock, so backtests can run the trader in
+// simulated time. Must be called before Start or Step.
+func (bt *BasisTrader) SetClock(c clock.Clock) {
 	bt.mu.Lock()
-	defer bt.mu.Unlock()
+	bt.clock = c
+	bt.ledger.clock = c
+	bt.mu.Unlock()
+
+	bt.marketData.mu.Lock()
+	bt.marketData.clock = c
+	bt.marketData.mu.Unlock()
+}
+
+// Step runs one pass of each trading loop at the clock's current time: funding
+// and order polling, trade supervision, recording the basis once the
+// snapshot interval has passed, strategy evaluation and position
+// reconciliation. Backtests drive the trader with Step instead of Start so
+// every pass completes before the clock moves.
+func (bt *BasisTrader) Step(ctx context.Context) {
+	bt.updateFunding(ctx)
+	bt.pollOrders(ctx)
+	bt.manageTrades(ctx)
+	bt.recordSnapshotIfDue()
+	bt.checkAndExecuteTrades(ctx)
+	bt.updatePositions(ctx)
+}
+
+func (bt *BasisTrader) AddStrategy(strategy *models.BasisStrategy) error {
+	model, err := newStrategy(strategy)
+	if err != nil {
+		return err
+	}
 
+	bt.mu.Lock()
 	if _, exists := bt.strategies[strategy.ID]; exists {
+		bt.mu.Unlock()
 		return fmt.Errorf("strategy %s already exists", strategy.ID)
 	}
 
+	if bt.store != nil {
+		if err := bt.store.SaveStrategy(context.Background(), strategy); err != nil {
+			bt.mu.Unlock()
+			return err
+		}
+	}
+
 	bt.strategies[strategy.ID] = strategy
-	bt.logger.WithField("strategy_id", strategy.ID).Info("Added new strategy")
+	bt.runners[strategy.ID] = &strategyRunner{model: model}
+	running := bt.running
+	bt.mu.Unlock()
+
+	bt.logger.WithFields(logrus.Fields{
+		"strategy_id": strategy.ID,
+		"type":        strategy.Type,
+	}).Info("Added new strategy")
+
+	// Strategies added before Start are subscribed once the feeds connect
+	if running {
+		bt.subscribeMarketData(strategy)
+		bt.subscribeOrderUpdates(strategy)
+	}
 	return nil
 }
 
@@ -85,13 +234,21 @@ func (bt *BasisTrader) RemoveStrategy(strategyID string) error {
 		return fmt.Errorf("strategy %s not found", strategyID)
 	}
 
+	if bt.store != nil {
+		if err := bt.store.DeleteStrategy(context.Background(), strategyID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+
 	delete(bt.strategies, strategyID)
+	delete(bt.runners, strategyID)
+	delete(bt.rolls, strategyID)
 	bt.logger.WithField("strategy_id", strategyID).Info("Removed strategy")
 	return nil
 }
 
 func (bt *BasisTrader) collectMarketData(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := bt.clock.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
 	for {
@@ -100,18 +257,22 @@ func (bt *BasisTrader) collectMarketData(ctx context.Context) {
 			return
 		case <-bt.stopCh:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			bt.updateMarketData(ctx)
 		}
 	}
 }
 
+// updateMarketData polls tickers over REST for symbols the websocket is not
+// keeping fresh: those with no feed or whose feed is down, and those that
+// have gone quiet
 func (bt *BasisTrader) updateMarketData(ctx context.Context) {
 	bt.mu.RLock()
 	strategies := make([]*models.BasisStrategy, 0, len(bt.strategies))
 	for _, s := range bt.strategies {
 		strategies = append(strategies, s)
 	}
+	rolling := bt.rollSymbolsLocked()
 	bt.mu.RUnlock()
 
 	// Collect unique symbols
@@ -120,33 +281,40 @@ func (bt *BasisTrader) updateMarketData(ctx context.Context) {
 		symbols[strategy.SpotSymbol] = true
 		symbols[strategy.FutureSymbol] = true
 	}
+	for _, symbol := range rolling {
+		symbols[symbol] = true
+	}
 
-	// Fetch tickers for all symbols
 	for symbol := range symbols {
-		go func(s string) {
-			// Determine which client to use based on symbol type
-			var client coinbase.Client
-			if isSpotSymbol(s) {
-				client = bt.spotClient
-			} else {
-				client = bt.futureClient
+		name, subscribed := bt.marketData.streamingFeed(symbol)
+		_, age, ok := bt.marketData.ticker(symbol)
+		switch {
+		case !subscribed:
+			bt.notePolling(symbol, true, "not subscribed on any feed")
+		case !bt.feedUp(name):
+			bt.notePolling(symbol, true, fmt.Sprintf("%s feed is down", name))
+		default:
+			bt.notePolling(symbol, false, "")
+			if ok && age < tickerPollAfter {
+				continue
 			}
+			// A quiet symbol is topped up over REST while its stream is up
+		}
 
-			ticker, err := client.GetTicker(ctx, s)
+		go func(s string) {
+			ticker, err := bt.clientFor(s).GetTicker(ctx, s)
 			if err != nil {
 				bt.logger.WithError(err).WithField("symbol", s).Error("Failed to get ticker")
 				return
 			}
 
-			bt.marketData.mu.Lock()
-			bt.marketData.tickers[s] = ticker
-			bt.marketData.mu.Unlock()
+			bt.marketData.updateTicker(*ticker)
 		}(symbol)
 	}
 }
 
 func (bt *BasisTrader) executeStrategies(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := bt.clock.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for {
@@ -155,7 +323,9 @@ func (bt *BasisTrader) executeStrategies(ctx context.Context) {
 			return
 		case <-bt.stopCh:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
+			bt.checkAndExecuteTrades(ctx)
+		case <-bt.priceUpdates:
 			bt.checkAndExecuteTrades(ctx)
 		}
 	}
@@ -163,6 +333,10 @@ func (bt *BasisTrader) executeStrategies(ctx context.Context) {
 
 func (bt *BasisTrader) checkAndExecuteTrades(ctx context.Context) {
 	bt.mu.RLock()
+	if !bt.reconciled {
+		bt.mu.RUnlock()
+		return
+	}
 	strategies := make([]*models.BasisStrategy, 0, len(bt.strategies))
 	for _, s := range bt.strategies {
 		if s.IsActive {
@@ -172,143 +346,378 @@ func (bt *BasisTrader) checkAndExecuteTrades(ctx context.Context) {
 	bt.mu.RUnlock()
 
 	for _, strategy := range strategies {
-		basis := bt.calculateBasis(strategy)
-		if basis == nil {
+		runner := bt.runnerFor(strategy.ID)
+		if runner == nil {
 			continue
 		}
+		runner.onTimer(bt.clock.Now())
 
-		// Check if we should enter or exit a position
-		if bt.shouldEnterPosition(strategy, basis) {
-			bt.enterBasisTrade(ctx, strategy, basis)
-		} else if bt.shouldExitPosition(strategy, basis) {
-			bt.exitBasisTrade(ctx, strategy, basis)
+		// Positions near expiry are rolled before anything else is traded
+		if bt.manageRoll(ctx, strategy) {
+			continue
 		}
+
+		bt.tradeTowardTarget(ctx, strategy, runner)
 	}
 }
 
-func (bt *BasisTrader) calculateBasis(strategy *models.BasisStrategy) *models.BasisSnapshot {
-	bt.marketData.mu.RLock()
-	spotTicker, spotOk := bt.marketData.tickers[strategy.SpotSymbol]
-	futureTicker, futureOk := bt.marketData.tickers[strategy.FutureSymbol]
-	bt.marketData.mu.RUnlock()
+// tradeTowardTarget passes a strategy's model the basis the books can
+// execute at, not last prices, and rebalances toward the target it returns:
+// once the position has drifted more than the rebalance band from it, or at
+// once for an exit, one chunk of up to RebalanceChunk per evaluation until
+// it is reached
+func (bt *BasisTrader) tradeTowardTarget(ctx context.Context, strategy *models.BasisStrategy, runner *strategyRunner) {
+	basis := bt.calculateBasis(strategy)
+	if basis == nil {
+		return
+	}
+
+	bt.mu.RLock()
+	open := bt.openSizeLocked(strategy.ID)
+	bt.mu.RUnlock()
+
+	entry := bt.planEntry(strategy, strategy.MinTradeSize)
+	exit := bt.planExit(strategy, math.Min(strategy.MinTradeSize, open))
+
+	// Models see every update, even while nothing can be traded
+	update := MarketUpdate{Strategy: strategy, Basis: basis, Position: open, Time: bt.clock.Now()}
+	if entry != nil {
+		update.Entry = entry.basis
+	}
+	if exit != nil {
+		update.Exit = exit.basis
+	}
+	target := runner.onMarketData(update)
+	if target == nil || (entry == nil && exit == nil) {
+		return
+	}
 
-	if !spotOk || !futureOk {
+	step := bt.rebalanceStep(strategy, runner, open, target)
+	switch {
+	case step > 0:
+		// Reprice the signal's entry for the step, which stops at the target
+		if entry != nil && math.Abs(entry.size-step) > sizeEpsilon {
+			entry = bt.planEntry(strategy, step)
+		}
+		if entry == nil || !bt.shouldEnterPosition(strategy, entry, target) {
+			return
+		}
+		bt.enterBasisTrade(ctx, strategy, entry, target)
+	case step < 0:
+		// Reprice the signal's exit for the step, which stops at the target
+		if exit != nil && math.Abs(exit.size+step) > sizeEpsilon {
+			exit = bt.planExit(strategy, -step)
+		}
+		if exit == nil || !bt.shouldExitPosition(strategy, exit) {
+			return
+		}
+		bt.exitBasisTrade(ctx, strategy, exit, target)
+	}
+}
+
+// legPrices is a leg's quote and last trade price
+type legPrices struct {
+	bid, ask, last float64
+	age            time.Duration
+}
+
+func (p legPrices) mid() float64 {
+	return (p.bid + p.ask) / 2
+}
+
+// quotes returns the latest quote of each of a strategy's legs. ok is false
+// if either is missing or stale.
+func (bt *BasisTrader) quotes(strategy *models.BasisStrategy) (spot, future legPrices, ok bool) {
+	spotTicker, spotAge, spotOk := bt.marketData.ticker(strategy.SpotSymbol)
+	futureTicker, futureAge, futureOk := bt.marketData.ticker(strategy.FutureSymbol)
+
+	// Never evaluate against prices that neither the stream nor polling refreshed
+	if !spotOk || !futureOk || spotAge > maxTickerAge || futureAge > maxTickerAge {
+		return legPrices{}, legPrices{}, false
+	}
+	return tickerPrices(spotTicker, spotAge), tickerPrices(futureTicker, futureAge), true
+}
+
+// tickerPrices reads a ticker's quote, taking the last price for a missing
+// side
+func tickerPrices(ticker models.Ticker, age time.Duration) legPrices {
+	p := legPrices{bid: ticker.BidPrice, ask: ticker.AskPrice, last: ticker.LastPrice, age: age}
+	if p.bid <= 0 {
+		p.bid = p.last
+	}
+	if p.ask <= 0 {
+		p.ask = p.last
+	}
+	return p
+}
+
+// calculateBasis returns a strategy's basis at its legs' latest quotes, with
+// any measures its model adds, or nil if either is missing or stale
+func (bt *BasisTrader) calculateBasis(strategy *models.BasisStrategy) *models.BasisSnapshot {
+	spot, future, ok := bt.quotes(strategy)
+	if !ok {
 		return nil
 	}
 
-	basis := futureTicker.LastPrice - spotTicker.LastPrice
-	basisPercent := (basis / spotTicker.LastPrice) * 100
+	snapshot := bt.basisAt(strategy, spot, future)
+	if runner := bt.runnerFor(strategy.ID); runner != nil {
+		runner.annotate(snapshot)
+	}
+	return snapshot
+}
+
+// basisAt returns a strategy's basis, and the measures derived from it, at
+// the given leg prices
+func (bt *BasisTrader) basisAt(strategy *models.BasisStrategy, spot, future legPrices) *models.BasisSnapshot {
+	snapshot := &models.BasisSnapshot{
+		SpotSymbol:     strategy.SpotSymbol,
+		FutureSymbol:   strategy.FutureSymbol,
+		SpotPrice:      spot.last,
+		FuturePrice:    future.last,
+		Basis:          future.last - spot.last,
+		EntryBasis:     future.bid - spot.ask,
+		ExitBasis:      future.ask - spot.bid,
+		MidBasis:       future.mid() - spot.mid(),
+		SpotSpread:     spot.ask - spot.bid,
+		FutureSpread:   future.ask - future.bid,
+		SpotQuoteAge:   spot.age,
+		FutureQuoteAge: future.age,
+		Timestamp:      bt.clock.Now(),
+	}
+	snapshot.BasisPercent = snapshot.Basis / spot.last * 100
+	snapshot.EntryBasisPercent = snapshot.EntryBasis / spot.ask * 100
+	snapshot.ExitBasisPercent = snapshot.ExitBasis / spot.bid * 100
+	snapshot.MidBasisPercent = snapshot.MidBasis / spot.mid() * 100
+
+	var toExpiry time.Duration
+	if instrument := bt.instruments.lookup(strategy.FutureSymbol); instrument.Expires() {
+		toExpiry = instrument.Expiry.Sub(snapshot.Timestamp)
+		snapshot.Expiry = instrument.Expiry
+		snapshot.DaysToExpiry = toExpiry.Hours() / 24
+		snapshot.AnnualizedBasis = annualize(snapshot.EntryBasisPercent, snapshot.DaysToExpiry)
+	}
+
+	if funding, ok := bt.fundingFor(strategy.FutureSymbol); ok {
+		bt.mu.RLock()
+		model := bt.carry
+		bt.mu.RUnlock()
 
-	return &models.BasisSnapshot{
-		SpotSymbol:   strategy.SpotSymbol,
-		FutureSymbol: strategy.FutureSymbol,
-		SpotPrice:    spotTicker.LastPrice,
-		FuturePrice:  futureTicker.LastPrice,
-		Basis:        basis,
-		BasisPercent: basisPercent,
-		Timestamp:    time.Now(),
+		if funding != nil {
+			snapshot.FundingRate = funding.PredictedRate
+		}
+		snapshot.ExpectedCarry = model.estimate(snapshot.EntryBasisPercent, funding, toExpiry).total
+		snapshot.ExitCarry = model.estimate(snapshot.ExitBasisPercent, funding, toExpiry).total
+		snapshot.HasCarry = true
 	}
+	return snapshot
 }
 
-func (bt *BasisTrader) shouldEnterPosition(strategy *models.BasisStrategy, basis *models.BasisSnapshot) bool {
-	// Check if basis is attractive enough
-	if basis.BasisPercent < strategy.TargetBasis {
-		return false
+// planEntry prices buying spot and selling the future for size, or as much
+// of it as the books can absorb. Its basis is entered at the expected
+// execution prices. It returns nil if the trade cannot be priced.
+func (bt *BasisTrader) planEntry(strategy *models.BasisStrategy, size float64) *executionPlan {
+	if size < sizeEpsilon {
+		return nil
+	}
+	spot, future, ok := bt.quotes(strategy)
+	if !ok {
+		return nil
 	}
 
-	// Check if we have room for more position
-	bt.mu.RLock()
-	position, exists := bt.positions[strategy.ID]
-	bt.mu.RUnlock()
+	plan, err := bt.planTrade(
+		&tradeLeg{client: bt.spotClient, symbol: strategy.SpotSymbol, side: models.OrderSideBuy},
+		&tradeLeg{client: bt.futureClient, symbol: strategy.FutureSymbol, side: models.OrderSideSell},
+		size,
+	)
+	if err != nil {
+		bt.logger.WithError(err).WithField("strategy_id", strategy.ID).Debug("Entry not executable")
+		return nil
+	}
+	spot.ask, future.bid = plan.spotQuote.price, plan.futureQuote.price
+	plan.basis = bt.basisAt(strategy, spot, future)
+	return plan
+}
 
-	if !exists || math.Abs(position.Size) < strategy.MaxPosition {
-		return true
+// planExit prices selling spot and buying back the future for size, or as
+// much of it as the books can absorb. The buy is reduce-only so it can never
+// leave the future leg net long. Its basis is exited at the expected
+// execution prices. It returns nil if size is zero or the trade cannot be
+// priced.
+func (bt *BasisTrader) planExit(strategy *models.BasisStrategy, size float64) *executionPlan {
+	if size < sizeEpsilon {
+		return nil
+	}
+	spot, future, ok := bt.quotes(strategy)
+	if !ok {
+		return nil
 	}
 
-	return false
+	plan, err := bt.planTrade(
+		&tradeLeg{client: bt.spotClient, symbol: strategy.SpotSymbol, side: models.OrderSideSell},
+		&tradeLeg{client: bt.futureClient, symbol: strategy.FutureSymbol, side: models.OrderSideBuy, reduceOnly: true},
+		size,
+	)
+	if err != nil {
+		bt.logger.WithError(err).WithField("strategy_id", strategy.ID).Debug("Exit not executable")
+		return nil
+	}
+	spot.bid, future.ask = plan.spotQuote.price, plan.futureQuote.price
+	plan.basis = bt.basisAt(strategy, spot, future)
+	return plan
 }
 
-func (bt *BasisTrader) shouldExitPosition(strategy *models.BasisStrategy, basis *models.BasisSnapshot) bool {
-	// Check if basis has compressed too much
-	if basis.BasisPercent > strategy.TargetBasis*0.5 {
+// shouldEnterPosition reports whether an entry of plan's size fits within
+// both the target and MaxPosition
+func (bt *BasisTrader) shouldEnterPosition(strategy *models.BasisStrategy, plan *executionPlan, target *Target) bool {
+	// Check if we have room for more position
+	bt.mu.RLock()
+	exposure := bt.ledger.exposure(strategy.ID)
+	blocked := bt.entryBlockedLocked(strategy.ID)
+	bt.mu.RUnlock()
+
+	// Wait for the previous trade to settle, or back off after a failure
+	if blocked {
 		return false
 	}
 
-	// Check if we have a position to exit
+	return exposure+plan.size <= math.Min(target.Size, strategy.MaxPosition)+sizeEpsilon
+}
+
+// shouldExitPosition reports whether the strategy has a settled position to
+// exit that still covers the plan's size, which may have been planned against
+// a position fills have since reduced
+func (bt *BasisTrader) shouldExitPosition(strategy *models.BasisStrategy, plan *executionPlan) bool {
 	bt.mu.RLock()
-	position, exists := bt.positions[strategy.ID]
+	open := bt.openSizeLocked(strategy.ID)
+	blocked := bt.entryBlockedLocked(strategy.ID)
 	bt.mu.RUnlock()
 
-	return exists && position.Size > 0
+	return !blocked && open > sizeEpsilon && plan.size <= open+sizeEpsilon
 }
 
-func (bt *BasisTrader) enterBasisTrade(ctx context.Context, strategy *models.BasisStrategy, basis *models.BasisSnapshot) {
+func (bt *BasisTrader) enterBasisTrade(ctx context.Context, strategy *models.BasisStrategy, plan *executionPlan, target *Target) {
 	bt.logger.WithFields(logrus.Fields{
 		"strategy_id": strategy.ID,
-		"basis":       basis.BasisPercent,
+		"basis":       plan.basis.EntryBasisPercent,
+		"carry":       plan.basis.ExpectedCarry,
+		"size":        plan.size,
+		"target":      target.Size,
 	}).Info("Entering basis trade")
 
-	// Place spot buy order
-	spotOrder := &models.OrderRequest{
-		Symbol: strategy.SpotSymbol,
-		Side:   models.OrderSideBuy,
-		Type:   models.OrderTypeLimit,
-		Price:  basis.SpotPrice * 1.001, // Slightly above market
-		Size:   strategy.MinTradeSize,
+	// Buy spot and sell the future
+	ts := newTradeState(newTrade(strategy, plan, tradeSideEnter, bt.clock.Now()), plan.spot, plan.future)
+	bt.openTrade(ctx, strategy, plan, ts, target.Reason)
+}
+
+// exitBasisTrade closes part of the strategy's open position by selling spot
+// and buying back the future. Larger positions are exited in RebalanceChunk
+// steps, one per evaluation, until the strategy reaches its target.
+func (bt *BasisTrader) exitBasisTrade(ctx context.Context, strategy *models.BasisStrategy, plan *executionPlan, target *Target) {
+	bt.logger.WithFields(logrus.Fields{
+		"strategy_id": strategy.ID,
+		"basis":       plan.basis.ExitBasisPercent,
+		"carry":       plan.basis.ExitCarry,
+		"size":        plan.size,
+		"target":      target.Size,
+	}).Info("Exiting basis trade")
+
+	ts := newTradeState(newTrade(strategy, plan, tradeSideExit, bt.clock.Now()), plan.spot, plan.future)
+	bt.openTrade(ctx, strategy, plan, ts, target.Reason)
+}
+
+// tradeSequence tells apart trades created at the same instant, which is
+// routine under a simulated clock
+var tradeSequence atomic.Uint64
+
+// newTrade records a planned trade at its expected execution prices
+func newTrade(strategy *models.BasisStrategy, plan *executionPlan, side string, now time.Time) *models.BasisTrade {
+	return &models.BasisTrade{
+		ID:          fmt.Sprintf("%s-%d-%d", strategy.ID, now.UnixNano(), tradeSequence.Add(1)),
+		StrategyID:  strategy.ID,
+		SpotPrice:   plan.spotQuote.price,
+		FuturePrice: plan.futureQuote.price,
+		Size:        plan.size,
+		Basis:       plan.futureQuote.price - plan.spotQuote.price,
+		Side:        side,
+		CreatedAt:   now,
 	}
+}
+
+// openTrade registers a trade and places limit orders for both legs at the
+// limit prices of the plan. Supervision takes over from there.
+func (bt *BasisTrader) openTrade(ctx context.Context, strategy *models.BasisStrategy, plan *executionPlan,
+	ts *tradeState, reason string) {
+	trade := ts.trade
+	spotLeg, futureLeg := ts.spotLeg(), ts.legs[legFuture]
+	ts.busy = true
+
+	bt.mu.Lock()
+	bt.trades[trade.ID] = ts
+	bt.transitionLocked(ts, models.TradeStatePending, reason)
+	bt.mu.Unlock()
+
+	defer func() {
+		bt.mu.Lock()
+		ts.busy = false
+		bt.mu.Unlock()
+	}()
 
-	spotResult, err := bt.spotClient.PlaceOrder(ctx, spotOrder)
+	// Place the spot order at the price that takes the book levels planned on
+	spotOrder, err := bt.placeLegOrder(ctx, ts, spotLeg.name, spotLeg.side, models.OrderTypeLimit,
+		plan.spotQuote.limit, trade.Size)
 	if err != nil {
-		bt.logger.WithError(err).Error("Failed to place spot order")
+		bt.logOrderError(err, strategy, fmt.Sprintf("Failed to place %s order", spotLeg.name))
+		bt.mu.Lock()
+		bt.transitionLocked(ts, models.TradeStateFailed, fmt.Sprintf("%s order failed: %v", spotLeg.name, err))
+		bt.mu.Unlock()
 		return
 	}
 
-	// Place futures sell order
-	futureOrder := &models.OrderRequest{
-		Symbol: strategy.FutureSymbol,
-		Side:   models.OrderSideSell,
-		Type:   models.OrderTypeLimit,
-		Price:  basis.FuturePrice * 0.999, // Slightly below market
-		Size:   strategy.MinTradeSize,
-	}
-
-	futureResult, err := bt.futureClient.PlaceOrder(ctx, futureOrder)
+	// Place the future order the same way. If it fails the first leg is
+	// cancelled, and hedged or unwound if it filled, by supervision.
+	futureOrder, err := bt.placeLegOrder(ctx, ts, legFuture, futureLeg.side, models.OrderTypeLimit,
+		plan.futureQuote.limit, trade.Size)
 	if err != nil {
-		bt.logger.WithError(err).Error("Failed to place future order")
-		// Cancel spot order
-		bt.spotClient.CancelOrder(ctx, spotResult.OrderID)
-		return
+		bt.logOrderError(err, strategy, "Failed to place future order")
 	}
 
-	// Record the trade
-	trade := &models.BasisTrade{
-		ID:            fmt.Sprintf("%s-%d", strategy.ID, time.Now().Unix()),
-		StrategyID:    strategy.ID,
-		SpotOrderID:   spotResult.OrderID,
-		FutureOrderID: futureResult.OrderID,
-		SpotPrice:     basis.SpotPrice,
-		FuturePrice:   basis.FuturePrice,
-		Size:          strategy.MinTradeSize,
-		Basis:         basis.Basis,
-		Side:          "enter",
-		Status:        "pending",
-		CreatedAt:     time.Now(),
+	bt.mu.Lock()
+	trade.SpotOrderID = spotOrder.orderID
+	trade.SpotClientOrderID = spotOrder.clientOrderID
+	if futureOrder != nil {
+		trade.FutureOrderID = futureOrder.orderID
+		trade.FutureClientOrderID = futureOrder.clientOrderID
 	}
+	bt.saveTradeLocked(trade)
+	bt.mu.Unlock()
 
-	// Store trade record (would typically go to database)
 	bt.logger.WithField("trade_id", trade.ID).Info("Basis trade initiated")
 }
 
-func (bt *BasisTrader) exitBasisTrade(ctx context.Context, strategy *models.BasisStrategy, basis *models.BasisSnapshot) {
-	// Similar implementation for exiting positions
-	bt.logger.WithFields(logrus.Fields{
-		"strategy_id": strategy.ID,
-		"basis":       basis.BasisPercent,
-	}).Info("Exiting basis trade")
+// throughMarket prices a limit order 0.1% through price so it fills on arrival
+func throughMarket(price float64, side models.OrderSide) float64 {
+	if side == models.OrderSideBuy {
+		return price * 1.001
+	}
+	return price * 0.999
+}
+
+// logOrderError logs an order placement failure at a level matching its cause
+func (bt *BasisTrader) logOrderError(err error, strategy *models.BasisStrategy, msg string) {
+	entry := bt.logger.WithError(err).WithField("strategy_id", strategy.ID)
+
+	switch {
+	case errors.Is(err, coinbase.ErrRateLimited):
+		entry.Warn(msg + ": rate limited")
+	case errors.Is(err, coinbase.ErrInsufficientFunds):
+		entry.Warn(msg + ": insufficient funds")
+	default:
+		entry.Error(msg)
+	}
 }
 
 func (bt *BasisTrader) monitorPositions(ctx context.Context) {
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := bt.clock.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
 	for {
@@ -317,7 +726,7 @@ func (bt *BasisTrader) monitorPositions(ctx context.Context) {
 			return
 		case <-bt.stopCh:
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			bt.updatePositions(ctx)
 		}
 	}
@@ -335,12 +744,10 @@ func (bt *BasisTrader) updatePositions(ctx context.Context) {
 		bt.logger.WithError(err).Error("Failed to get future positions")
 		return
 	}
+	bt.positionUnits(futurePositions)
 
-	// Merge and update positions
 	bt.mu.Lock()
-	for _, pos := range append(positions, futurePositions...) {
-		bt.positions[pos.Symbol] = &pos
-	}
+	bt.reconcilePositionsLocked(append(bt.spotPositionsLocked(positions), futurePositions...))
 	bt.mu.Unlock()
 }
 
@@ -362,11 +769,31 @@ func (bt *BasisTrader) GetBasisSnapshots() []models.BasisSnapshot {
 	return snapshots
 }
 
-func isSpotSymbol(symbol string) bool {
-	// Simple heuristic - futures symbols typically have "-PERP" suffix
-	return !contains(symbol, "-PERP")
+// rateLimited is implemented by clients that apply client-side rate limits
+type rateLimited interface {
+	RateLimitStats() []coinbase.RateLimitStats
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && s[len(s)-len(substr):] == substr
-}
\ No newline at end of file
+// GetRateLimitStats reports limiter usage for each leg's client
+func (bt *BasisTrader) GetRateLimitStats() map[string][]coinbase.RateLimitStats {
+	stats := make(map[string][]coinbase.RateLimitStats)
+	if c, ok := bt.spotClient.(rateLimited); ok {
+		stats["spot"] = c.RateLimitStats()
+	}
+	if c, ok := bt.futureClient.(rateLimited); ok {
+		stats["derivatives"] = c.RateLimitStats()
+	}
+	return stats
+}
+
+// newClientOrderID returns a random UUID identifying an order to the
+// exchange, so a retried placement can never create a second order
+func newClientOrderID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate client order id: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
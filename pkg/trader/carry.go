@@ -0,0 +1,135 @@
+package trader
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+const (
+	// year annualizes carry
+	year = 365 * 24 * time.Hour
+
+	// defaultCarryHorizon is how long a position is expected to be held
+	defaultCarryHorizon = 7 * 24 * time.Hour
+)
+
+// CarryModel estimates the annualized return of holding a basis position:
+// funding received on the short perpetual, plus the basis converging to zero
+// over the holding horizon, less the fees of entering and exiting both legs
+type CarryModel struct {
+	// Horizon is how long a position is expected to be held. The basis is
+	// assumed to converge over it and round-trip fees are amortized over it.
+	Horizon time.Duration
+
+	// Fees per fill, as fractions of notional
+	SpotFee   float64
+	FutureFee float64
+}
+
+// carryEstimate is expected carry and its parts, in annualized percent of
+// notional
+type carryEstimate struct {
+	funding float64
+	drift   float64
+	fees    float64
+	total   float64
+}
+
+// estimate values entering at basisPercent. funding is nil for futures that
+// do not pay funding. A dated future converges by its expiry, so toExpiry,
+// when positive, caps the horizon.
+func (m CarryModel) estimate(basisPercent float64, funding *models.FundingRate, toExpiry time.Duration) carryEstimate {
+	horizon := m.Horizon
+	if horizon <= 0 {
+		horizon = defaultCarryHorizon
+	}
+	if toExpiry > 0 && toExpiry < horizon {
+		horizon = toExpiry
+	}
+	perHorizon := float64(year) / float64(horizon)
+
+	var e carryEstimate
+	if funding != nil && funding.Interval > 0 {
+		// Longs pay shorts, and the future leg is short
+		e.funding = funding.PredictedRate * float64(year) / float64(funding.Interval) * 100
+	}
+	e.drift = basisPercent * perHorizon
+	e.fees = 2 * (m.SpotFee + m.FutureFee) * 100 * perHorizon
+	e.total = e.funding + e.drift - e.fees
+	return e
+}
+
+// SetCarryModel sets the model strategies trading on carry enter and exit by
+func (bt *BasisTrader) SetCarryModel(model CarryModel) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	bt.carry = model
+}
+
+// annualize scales a basis percent that converges over days to a year
+func annualize(basisPercent, days float64) float64 {
+	if days <= 0 {
+		return 0
+	}
+	return basisPercent * 365 / days
+}
+
+// checkEntrySignal fails for entry signals the trader does not know
+func checkEntrySignal(strategy *models.BasisStrategy) error {
+	switch strategy.EntrySignal {
+	case "", models.EntrySignalBasis, models.EntrySignalCarry, models.EntrySignalAnnualizedBasis:
+		return nil
+	}
+	return fmt.Errorf("unknown entry signal %q", strategy.EntrySignal)
+}
+
+// signalTarget is the target a strategy sets for its entry signal
+func signalTarget(strategy *models.BasisStrategy) float64 {
+	switch strategy.EntrySignal {
+	case models.EntrySignalCarry:
+		return strategy.TargetCarry
+	case models.EntrySignalAnnualizedBasis:
+		return strategy.TargetAnnualizedBasis
+	}
+	return strategy.TargetBasis
+}
+
+// entrySignal returns the measure a strategy trades on and its target, at
+// the basis a trade on side would execute at: the entry basis for entries
+// and the exit basis for exits. ok is false when the measure is unavailable
+// for this snapshot.
+func entrySignal(strategy *models.BasisStrategy, basis *models.BasisSnapshot, side string) (value, target float64, ok bool) {
+	exit := side == tradeSideExit
+	target = signalTarget(strategy)
+	switch strategy.EntrySignal {
+	case models.EntrySignalCarry:
+		if exit {
+			return basis.ExitCarry, target, basis.HasCarry
+		}
+		return basis.ExpectedCarry, target, basis.HasCarry
+	case models.EntrySignalAnnualizedBasis:
+		value = basis.AnnualizedBasis
+		if exit {
+			value = annualize(basis.ExitBasisPercent, basis.DaysToExpiry)
+		}
+		return value, target, !basis.Expiry.IsZero() && basis.DaysToExpiry > 0
+	}
+	if exit {
+		return basis.ExitBasisPercent, target, true
+	}
+	return basis.EntryBasisPercent, target, true
+}
+
+// describeSignal names the measure a strategy traded on, for trade reasons
+func describeSignal(strategy *models.BasisStrategy, basis *models.BasisSnapshot, side string) string {
+	value, _, _ := entrySignal(strategy, basis, side)
+	switch strategy.EntrySignal {
+	case models.EntrySignalCarry:
+		return fmt.Sprintf("%s carry %.2f%%/yr", side, value)
+	case models.EntrySignalAnnualizedBasis:
+		return fmt.Sprintf("%s annualized basis %.2f%%/yr", side, value)
+	}
+	return fmt.Sprintf("%s basis %.4f%%", side, value)
+}
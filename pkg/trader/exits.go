@@ -0,0 +1,90 @@
+package trader
+
+import (
+	"math"
+	"sort"
+
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Basis trade sides
+const (
+	tradeSideEnter = "enter"
+	tradeSideExit  = "exit"
+	tradeSideRoll  = "roll"
+)
+
+// matchedSize is the size a settled trade holds on both legs
+func matchedSize(trade *models.BasisTrade) float64 {
+	return math.Max(0, math.Min(trade.SpotFilledSize, trade.FutureFilledSize))
+}
+
+// openSizeLocked is the strategy's hedged size that settled entries put on
+// and exits have not yet closed. Must be called with bt.mu held.
+func (bt *BasisTrader) openSizeLocked(strategyID string) float64 {
+	var open float64
+	for _, ts := range bt.trades {
+		trade := ts.trade
+		if trade.StrategyID != strategyID || trade.Side != tradeSideEnter || !ts.terminal() {
+			continue
+		}
+		open += matchedSize(trade) - trade.ClosedSize
+	}
+	return math.Max(0, open)
+}
+
+// closeEntriesLocked links a settled exit to the entries it closes, oldest
+// first, and books the basis captured on each. Must be called with bt.mu
+// held.
+func (bt *BasisTrader) closeEntriesLocked(exit *tradeState) {
+	remaining := matchedSize(exit.trade)
+	if remaining < sizeEpsilon {
+		return
+	}
+
+	var entries []*models.BasisTrade
+	for _, ts := range bt.trades {
+		trade := ts.trade
+		if trade.StrategyID == exit.trade.StrategyID && trade.Side == tradeSideEnter && ts.terminal() &&
+			matchedSize(trade)-trade.ClosedSize > sizeEpsilon {
+			entries = append(entries, trade)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	exitBasis := exit.trade.FutureAvgPrice - exit.trade.SpotAvgPrice
+	for _, entry := range entries {
+		if remaining < sizeEpsilon {
+			break
+		}
+
+		size := math.Min(remaining, matchedSize(entry)-entry.ClosedSize)
+		entryBasis := entry.FutureAvgPrice - entry.SpotAvgPrice
+		link := models.TradeLink{
+			EntryTradeID: entry.ID,
+			Size:         size,
+			RealizedPnL:  size * (entryBasis - exitBasis),
+		}
+
+		entry.ClosedSize += size
+		bt.saveTradeLocked(entry)
+		exit.trade.Closes = append(exit.trade.Closes, link)
+		exit.trade.RealizedPnL += link.RealizedPnL
+		remaining -= size
+	}
+
+	logger := bt.logger.WithFields(logrus.Fields{
+		"trade_id":     exit.trade.ID,
+		"strategy_id":  exit.trade.StrategyID,
+		"entries":      len(exit.trade.Closes),
+		"realized_pnl": exit.trade.RealizedPnL,
+	})
+	if remaining > sizeEpsilon {
+		logger.WithField("unmatched", remaining).Warn("Exit closed more than the open entries")
+		return
+	}
+	logger.Info("Exit closed entries")
+}
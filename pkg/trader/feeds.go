@@ -0,0 +1,122 @@
+package trader
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/sirupsen/logrus"
+)
+
+// FeedStatus is the connection state of an attached websocket feed
+type FeedStatus string
+
+const (
+	FeedStatusUp   FeedStatus = "up"
+	FeedStatusDown FeedStatus = "down"
+
+	// FeedStatusFailed means the feed gave up reconnecting
+	FeedStatusFailed FeedStatus = "failed"
+)
+
+type feed struct {
+	name   string
+	client *coinbase.WebSocketClient
+	status FeedStatus
+}
+
+// AttachFeed registers a websocket feed that the trader connects on Start and
+// watches for connection events
+func (bt *BasisTrader) AttachFeed(name string, client *coinbase.WebSocketClient) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	bt.feeds[name] = &feed{name: name, client: client, status: FeedStatusDown}
+}
+
+// startFeeds connects every attached feed and starts monitoring it
+func (bt *BasisTrader) startFeeds(ctx context.Context) error {
+	bt.mu.RLock()
+	feeds := make([]*feed, 0, len(bt.feeds))
+	for _, f := range bt.feeds {
+		feeds = append(feeds, f)
+	}
+	bt.mu.RUnlock()
+
+	for _, f := range feeds {
+		go bt.monitorFeed(ctx, f)
+		if err := f.client.Connect(ctx); err != nil {
+			return fmt.Errorf("failed to connect %s feed: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+func (bt *BasisTrader) monitorFeed(ctx context.Context, f *feed) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-bt.stopCh:
+			f.client.Close()
+			return
+		case event := <-f.client.Events():
+			bt.handleFeedEvent(f, event)
+		}
+	}
+}
+
+func (bt *BasisTrader) handleFeedEvent(f *feed, event coinbase.ConnectionEvent) {
+	entry := bt.logger.WithFields(logrus.Fields{
+		"feed":  f.name,
+		"event": event.Type,
+	})
+	if event.Err != nil {
+		entry = entry.WithError(event.Err)
+	}
+
+	bt.mu.Lock()
+	switch event.Type {
+	case coinbase.EventConnected, coinbase.EventReconnected:
+		f.status = FeedStatusUp
+	case coinbase.EventDisconnected:
+		f.status = FeedStatusDown
+	case coinbase.EventFatal:
+		f.status = FeedStatusFailed
+	}
+	bt.mu.Unlock()
+
+	switch event.Type {
+	case coinbase.EventConnected:
+		entry.Info("Websocket feed connected")
+	case coinbase.EventReconnected:
+		entry.WithField("attempt", event.Attempt).Info("Websocket feed reconnected, resuming streaming")
+	case coinbase.EventDisconnected:
+		entry.Warn("Websocket feed disconnected, falling back to REST polling")
+		if f.name == marketDataFeedName || f.name == derivativesDataFeedName {
+			bt.marketData.books.InvalidateAll("market data feed disconnected")
+		}
+	case coinbase.EventFatal:
+		entry.Error("Websocket feed failed permanently, falling back to REST polling")
+	}
+}
+
+// feedUp reports whether the named feed is attached and connected
+func (bt *BasisTrader) feedUp(name string) bool {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	f, ok := bt.feeds[name]
+	return ok && f.status == FeedStatusUp
+}
+
+// GetFeedStatus reports the connection state of each attached feed
+func (bt *BasisTrader) GetFeedStatus() map[string]FeedStatus {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	status := make(map[string]FeedStatus, len(bt.feeds))
+	for name, f := range bt.feeds {
+		status[name] = f.status
+	}
+	return status
+}
@@ -0,0 +1,196 @@
+package trader
+
+import (
+	"context"
+	"time"
+
+	"github.com/gregtusar/basis/internal/storage"
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fundingPollInterval is how often each perpetual's funding rate is
+	// refreshed. A settlement is booked on the first poll after it.
+	fundingPollInterval = time.Minute
+
+	// maxFundingAge is the oldest funding rate carry is estimated from
+	maxFundingAge = 5 * time.Minute
+)
+
+// setFunding stores a funding rate and returns the one it replaced
+func (m *MarketDataManager) setFunding(rate models.FundingRate) (previous models.FundingRate, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, ok = m.funding[rate.Symbol]
+	m.funding[rate.Symbol] = rate
+	m.fundingAt[rate.Symbol] = m.clock.Now()
+	return previous, ok
+}
+
+// fundingRate returns the latest funding rate for symbol and its age
+func (m *MarketDataManager) fundingRate(symbol string) (models.FundingRate, time.Duration, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rate, ok := m.funding[symbol]
+	if !ok {
+		return models.FundingRate{}, 0, false
+	}
+	return rate, m.clock.Since(m.fundingAt[symbol]), true
+}
+
+// GetFundingRates returns the latest funding rate of each strategy future
+// that pays funding
+func (bt *BasisTrader) GetFundingRates() []models.FundingRate {
+	bt.marketData.mu.RLock()
+	defer bt.marketData.mu.RUnlock()
+
+	rates := make([]models.FundingRate, 0, len(bt.marketData.funding))
+	for _, rate := range bt.marketData.funding {
+		rates = append(rates, rate)
+	}
+	return rates
+}
+
+// fundingFor returns the funding terms carry is estimated with. ok is false
+// when the future pays funding but no recent rate is known.
+func (bt *BasisTrader) fundingFor(symbol string) (rate *models.FundingRate, ok bool) {
+	if _, pays := bt.futureClient.(coinbase.FundingRateSource); !pays {
+		return nil, true
+	}
+	if bt.instruments.lookup(symbol).Type != models.MarketTypePerpetual {
+		// Dated futures converge at expiry instead
+		return nil, true
+	}
+
+	funding, age, known := bt.marketData.fundingRate(symbol)
+	if !known || age > maxFundingAge {
+		return nil, false
+	}
+	return &funding, true
+}
+
+func (bt *BasisTrader) monitorFunding(ctx context.Context) {
+	ticker := bt.clock.NewTicker(fundingPollInterval)
+	defer ticker.Stop()
+
+	bt.updateFunding(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-bt.stopCh:
+			return
+		case <-ticker.C():
+			bt.updateFunding(ctx)
+		}
+	}
+}
+
+// updateFunding refreshes the funding rate of every strategy future not
+// polled in the last fundingPollInterval, and settles funding on positions
+// in any future whose funding time has rolled over since the last poll
+func (bt *BasisTrader) updateFunding(ctx context.Context) {
+	source, ok := bt.futureClient.(coinbase.FundingRateSource)
+	if !ok {
+		return
+	}
+
+	bt.mu.RLock()
+	symbols := make(map[string]bool)
+	for _, s := range bt.strategies {
+		if bt.instruments.lookup(s.FutureSymbol).Type == models.MarketTypePerpetual {
+			symbols[s.FutureSymbol] = true
+		}
+	}
+	bt.mu.RUnlock()
+
+	for symbol := range symbols {
+		if _, age, known := bt.marketData.fundingRate(symbol); known && age < fundingPollInterval {
+			continue
+		}
+
+		rate, err := source.GetFundingRate(ctx, symbol)
+		if err != nil {
+			bt.logger.WithError(err).WithField("symbol", symbol).Debug("Failed to get funding rate")
+			continue
+		}
+
+		previous, known := bt.marketData.setFunding(*rate)
+		if known && !previous.NextFundingTime.IsZero() && rate.NextFundingTime.After(previous.NextFundingTime) {
+			// The rate last predicted for the interval is the one that settled
+			bt.settleFunding(symbol, previous.PredictedRate, previous.NextFundingTime)
+		}
+	}
+}
+
+// settleFunding books funding at rate on each strategy's position in symbol.
+// The rate is the one last predicted for the interval, so the payments are
+// estimates of what the exchange settled.
+func (bt *BasisTrader) settleFunding(symbol string, rate float64, at time.Time) {
+	mark := 0.0
+	if ticker, _, ok := bt.marketData.ticker(symbol); ok {
+		mark = ticker.LastPrice
+	}
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	for id, pos := range bt.ledger.strategies {
+		if pos.future.symbol != symbol || pos.future.size == 0 {
+			continue
+		}
+
+		price := mark
+		if price <= 0 {
+			price = pos.future.avgPrice
+		}
+		payment := models.FundingPayment{
+			StrategyID: id,
+			Symbol:     symbol,
+			Rate:       rate,
+			Position:   pos.future.size,
+			MarkPrice:  price,
+			Amount:     -rate * pos.future.size * price,
+			Time:       at,
+			Estimated:  true,
+		}
+		pos.funding += payment.Amount
+
+		bt.logger.WithFields(logrus.Fields{
+			"strategy_id": id,
+			"symbol":      symbol,
+			"rate":        rate,
+			"position":    payment.Position,
+			"amount":      payment.Amount,
+		}).Info("Booked estimated funding")
+
+		bt.persistLocked(func(ctx context.Context, store storage.Repository) error {
+			return store.SaveFundingPayment(ctx, &payment)
+		})
+	}
+}
+
+// restoreFunding sets each strategy's estimated funding from the payments in
+// the store
+func (bt *BasisTrader) restoreFunding(ctx context.Context, store storage.Repository) error {
+	payments, err := store.ListFundingPayments(ctx, storage.FundingQuery{})
+	if err != nil {
+		return err
+	}
+
+	totals := make(map[string]float64)
+	for _, p := range payments {
+		totals[p.StrategyID] += p.Amount
+	}
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	for id, total := range totals {
+		bt.ledger.position(id).funding = total
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package trader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+// instrumentRefreshInterval is how often listed products are reloaded, picking
+// up newly listed expiries
+const instrumentRefreshInterval = time.Hour
+
+// instrumentRegistry describes the products strategies trade. Products the
+// clients list are described by the exchange; anything else is inferred from
+// its symbol.
+type instrumentRegistry struct {
+	mu     sync.RWMutex
+	listed map[string]models.Instrument
+}
+
+func newInstrumentRegistry() *instrumentRegistry {
+	return &instrumentRegistry{listed: make(map[string]models.Instrument)}
+}
+
+// add records listed instruments, replacing earlier descriptions
+func (r *instrumentRegistry) add(instruments ...models.Instrument) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, instrument := range instruments {
+		r.listed[instrument.Symbol] = instrument
+	}
+}
+
+// lookup returns what is known about symbol
+func (r *instrumentRegistry) lookup(symbol string) models.Instrument {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if instrument, ok := r.listed[symbol]; ok {
+		return instrument
+	}
+	return coinbase.ParseSymbol(symbol)
+}
+
+// all returns every listed instrument, by symbol
+func (r *instrumentRegistry) all() []models.Instrument {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	instruments := make([]models.Instrument, 0, len(r.listed))
+	for _, instrument := range r.listed {
+		instruments = append(instruments, instrument)
+	}
+	sort.Slice(instruments, func(i, j int) bool {
+		return instruments[i].Symbol < instruments[j].Symbol
+	})
+	return instruments
+}
+
+// futures returns the listed futures on underlying that have not expired by
+// now: perpetuals first, then dated futures by expiry
+func (r *instrumentRegistry) futures(underlying string, now time.Time) []models.Instrument {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var futures []models.Instrument
+	for _, instrument := range r.listed {
+		if instrument.Underlying != underlying || instrument.Type == models.MarketTypeSpot {
+			continue
+		}
+		if instrument.Expires() && !instrument.Expiry.After(now) {
+			continue
+		}
+		futures = append(futures, instrument)
+	}
+	sort.Slice(futures, func(i, j int) bool {
+		a, b := futures[i], futures[j]
+		if a.Expires() != b.Expires() {
+			return !a.Expires()
+		}
+		if !a.Expiry.Equal(b.Expiry) {
+			return a.Expiry.Before(b.Expiry)
+		}
+		return a.Symbol < b.Symbol
+	})
+	return futures
+}
+
+// rollTarget returns the listed dated future a position in current rolls
+// into: the first on the same underlying and quote expiring after notBefore
+func (r *instrumentRegistry) rollTarget(current models.Instrument, notBefore time.Time) (models.Instrument, bool) {
+	for _, candidate := range r.futures(current.Underlying, notBefore) {
+		if candidate.Expires() && candidate.Symbol != current.Symbol && candidate.Quote == current.Quote {
+			return candidate, true
+		}
+	}
+	return models.Instrument{}, false
+}
+
+// toContracts converts a size in the underlying to the symbol's order units,
+// rounded down to its size increment
+func (r *instrumentRegistry) toContracts(symbol string, size float64) float64 {
+	instrument := r.lookup(symbol)
+	if instrument.ContractSize <= 0 || instrument.ContractSize == 1 {
+		return size
+	}
+
+	contracts := size / instrument.ContractSize
+	if inc := instrument.SizeIncrement; inc > 0 {
+		// The epsilon keeps float error from costing a whole increment
+		contracts = math.Floor(contracts/inc+1e-9) * inc
+	}
+	return contracts
+}
+
+// fromContracts converts a size in the symbol's order units to the underlying
+func (r *instrumentRegistry) fromContracts(symbol string, contracts float64) float64 {
+	instrument := r.lookup(symbol)
+	if instrument.ContractSize <= 0 {
+		return contracts
+	}
+	return contracts * instrument.ContractSize
+}
+
+// AddInstruments describes products no client lists, such as those in
+// backtest data
+func (bt *BasisTrader) AddInstruments(instruments ...models.Instrument) {
+	bt.instruments.add(instruments...)
+}
+
+// GetInstrument returns what the trader knows about symbol
+func (bt *BasisTrader) GetInstrument(symbol string) models.Instrument {
+	return bt.instruments.lookup(symbol)
+}
+
+// GetInstruments returns every product the clients list
+func (bt *BasisTrader) GetInstruments() []models.Instrument {
+	return bt.instruments.all()
+}
+
+// loadInstruments lists the products of each client that can describe them
+func (bt *BasisTrader) loadInstruments(ctx context.Context) {
+	for name, client := range map[string]coinbase.Client{legSpot: bt.spotClient, legFuture: bt.futureClient} {
+		source, ok := client.(coinbase.InstrumentSource)
+		if !ok {
+			continue
+		}
+
+		instruments, err := source.GetInstruments(ctx)
+		if err != nil {
+			bt.logger.WithError(err).WithField("client", name).Warn("Failed to load instruments")
+			continue
+		}
+		bt.instruments.add(instruments...)
+		bt.logger.WithFields(logrus.Fields{
+			"client":      name,
+			"instruments": len(instruments),
+		}).Debug("Loaded instruments")
+	}
+}
+
+func (bt *BasisTrader) monitorInstruments(ctx context.Context) {
+	ticker := bt.clock.NewTicker(instrumentRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-bt.stopCh:
+			return
+		case <-ticker.C():
+			bt.loadInstruments(ctx)
+		}
+	}
+}
+
+// clientFor returns the client that trades symbol
+func (bt *BasisTrader) clientFor(symbol string) coinbase.Client {
+	if bt.instruments.lookup(symbol).Type == models.MarketTypeSpot {
+		return bt.spotClient
+	}
+	return bt.futureClient
+}
+
+// positionUnits converts exchange position sizes to the underlying
+func (bt *BasisTrader) positionUnits(positions []models.Position) []models.Position {
+	for i := range positions {
+		positions[i].Size = bt.instruments.fromContracts(positions[i].Symbol, positions[i].Size)
+	}
+	return positions
+}
+
+// spotPositionsLocked attributes balances reported by asset, as Prime reports
+// them, to the spot products the strategies trade on that asset. Where several
+// products share an asset, each is credited up to its allocation and the
+// remainder goes to the first. Balances of assets no strategy trades are
+// dropped; positions reported by product are kept. Must be called with bt.mu
+// held.
+func (bt *BasisTrader) spotPositionsLocked(positions []models.Position) []models.Position {
+	products := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, s := range bt.strategies {
+		if !seen[s.SpotSymbol] {
+			seen[s.SpotSymbol] = true
+			asset := bt.instruments.lookup(s.SpotSymbol).Underlying
+			products[asset] = append(products[asset], s.SpotSymbol)
+		}
+	}
+	for _, symbols := range products {
+		sort.Strings(symbols)
+	}
+	allocated := bt.ledger.allocated()
+
+	result := make([]models.Position, 0, len(positions))
+	for _, p := range positions {
+		if instrument := bt.instruments.lookup(p.Symbol); instrument.Quote != "" {
+			result = append(result, p)
+			continue
+		}
+
+		symbols := products[strings.ToUpper(p.Symbol)]
+		sizes := make([]float64, len(symbols))
+		remaining := p.Size
+		for i := len(symbols) - 1; i > 0; i-- {
+			sizes[i] = math.Min(math.Max(allocated[symbols[i]], 0), math.Max(remaining, 0))
+			remaining -= sizes[i]
+		}
+		if len(symbols) > 0 {
+			sizes[0] = remaining
+		}
+		for i, symbol := range symbols {
+			position := p
+			position.Symbol = symbol
+			position.Size = sizes[i]
+			result = append(result, position)
+		}
+	}
+	return result
+}
+
+// GetTermStructure returns the basis of every listed, unexpired future on
+// underlying against spotSymbol. Prices the market data feed is not keeping
+// fresh are fetched over REST; futures without a price are left out.
+func (bt *BasisTrader) GetTermStructure(ctx context.Context, underlying, spotSymbol string) (*models.TermStructure, error) {
+	spotPrice, err := bt.lastPrice(ctx, spotSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spot price: %w", err)
+	}
+
+	now := bt.clock.Now()
+	ts := &models.TermStructure{
+		Underlying: underlying,
+		SpotSymbol: spotSymbol,
+		SpotPrice:  spotPrice,
+		Timestamp:  now,
+	}
+	for _, future := range bt.instruments.futures(underlying, now) {
+		price, err := bt.lastPrice(ctx, future.Symbol)
+		if err != nil {
+			bt.logger.WithError(err).WithField("symbol", future.Symbol).Debug("Failed to price future")
+			continue
+		}
+
+		point := models.TermStructurePoint{
+			Symbol:       future.Symbol,
+			Type:         future.Type,
+			Expiry:       future.Expiry,
+			Price:        price,
+			Basis:        price - spotPrice,
+			BasisPercent: (price - spotPrice) / spotPrice * 100,
+		}
+		if future.Expires() {
+			toExpiry := future.Expiry.Sub(now)
+			point.DaysToExpiry = toExpiry.Hours() / 24
+			point.AnnualizedBasis = annualize(point.BasisPercent, point.DaysToExpiry)
+		} else if rate, _, ok := bt.marketData.fundingRate(future.Symbol); ok {
+			point.FundingRate = rate.PredictedRate
+		}
+		ts.Points = append(ts.Points, point)
+	}
+	return ts, nil
+}
+
+// lastPrice returns symbol's last trade price from market data if fresh,
+// otherwise from its client
+func (bt *BasisTrader) lastPrice(ctx context.Context, symbol string) (float64, error) {
+	if ticker, age, ok := bt.marketData.ticker(symbol); ok && age <= maxTickerAge && ticker.LastPrice > 0 {
+		return ticker.LastPrice, nil
+	}
+
+	ticker, err := bt.clientFor(symbol).GetTicker(ctx, symbol)
+	if err != nil {
+		return 0, err
+	}
+	if ticker.LastPrice <= 0 {
+		return 0, fmt.Errorf("no price for %s", symbol)
+	}
+	return ticker.LastPrice, nil
+}
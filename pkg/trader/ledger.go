@@ -0,0 +1,343 @@
+package trader
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/clock"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+// positionTolerance absorbs exchange rounding when reconciling positions
+const positionTolerance = 1e-6
+
+// positionLedger attributes fills to the strategies that placed them, so
+// strategies sharing a symbol never see each other's inventory. The sum of
+// allocations per symbol is reconciled against the exchange.
+type positionLedger struct {
+	strategies map[string]*strategyPosition
+
+	// external is what each symbol held outside the strategies when first
+	// reconciled, such as inventory from before the trader started or the
+	// strategy was added
+	external map[string]float64
+
+	exchange      []models.Position
+	discrepancies map[string]models.PositionDiscrepancy
+	suspect       map[string]bool // mismatched on the last pass only
+	reconciledAt  *time.Time
+	clock         clock.Clock
+}
+
+type strategyPosition struct {
+	spot      legPosition
+	future    legPosition
+	expiring  legPosition // a future being rolled out of
+	funding   float64     // estimated, received on the future leg
+	updatedAt time.Time
+}
+
+type legPosition struct {
+	symbol   string
+	size     float64
+	avgPrice float64
+}
+
+func newPositionLedger(clk clock.Clock) *positionLedger {
+	return &positionLedger{
+		clock:         clk,
+		strategies:    make(map[string]*strategyPosition),
+		external:      make(map[string]float64),
+		discrepancies: make(map[string]models.PositionDiscrepancy),
+		suspect:       make(map[string]bool),
+	}
+}
+
+// apply adds a signed quantity to the leg. The average price moves only when
+// the position grows, and resets when it flips.
+func (p *legPosition) apply(qty, price float64) {
+	switch {
+	case p.size == 0 || (p.size > 0) == (qty > 0):
+		p.avgPrice = (p.avgPrice*math.Abs(p.size) + price*math.Abs(qty)) / math.Abs(p.size+qty)
+	case math.Abs(qty) > math.Abs(p.size):
+		p.avgPrice = price
+	}
+
+	p.size += qty
+	if math.Abs(p.size) < sizeEpsilon {
+		p.size, p.avgPrice = 0, 0
+	}
+}
+
+// position returns a strategy's position, creating it if needed
+func (l *positionLedger) position(strategyID string) *strategyPosition {
+	pos, ok := l.strategies[strategyID]
+	if !ok {
+		pos = &strategyPosition{}
+		l.strategies[strategyID] = pos
+	}
+	return pos
+}
+
+// futureLeg returns the future leg holding symbol. A strategy holds one
+// future, except while rolling: the first execution in a new future moves
+// the one held aside as expiring.
+func (p *strategyPosition) futureLeg(symbol string) *legPosition {
+	switch {
+	case p.expiring.symbol == symbol && p.expiring.size != 0:
+		return &p.expiring
+	case p.future.symbol == symbol || p.future.size == 0:
+		return &p.future
+	case p.expiring.size == 0:
+		p.expiring, p.future = p.future, legPosition{}
+		return &p.future
+	default:
+		// A third future; keep it rather than lose it
+		return &p.future
+	}
+}
+
+// book attributes an execution on a trade leg to the trade's strategy
+func (l *positionLedger) book(strategyID, legName, symbol string, side models.OrderSide, size, price float64) {
+	pos := l.position(strategyID)
+
+	leg := &pos.spot
+	if legName != legSpot {
+		leg = pos.futureLeg(symbol)
+	}
+	leg.symbol = symbol
+
+	qty := size
+	if side == models.OrderSideSell {
+		qty = -size
+	}
+	leg.apply(qty, price)
+	if pos.expiring.size == 0 {
+		pos.expiring = legPosition{}
+	}
+	pos.updatedAt = l.clock.Now()
+}
+
+// held is a strategy's signed size in a future
+func (l *positionLedger) held(strategyID, symbol string) float64 {
+	pos, ok := l.strategies[strategyID]
+	if !ok {
+		return 0
+	}
+	var size float64
+	for _, leg := range []legPosition{pos.future, pos.expiring} {
+		if leg.symbol == symbol {
+			size += leg.size
+		}
+	}
+	return size
+}
+
+// exposure is the larger of a strategy's two legs
+func (l *positionLedger) exposure(strategyID string) float64 {
+	pos, ok := l.strategies[strategyID]
+	if !ok {
+		return 0
+	}
+	return math.Max(math.Abs(pos.spot.size), math.Abs(pos.future.size+pos.expiring.size))
+}
+
+// allocated sums the strategy allocations per symbol
+func (l *positionLedger) allocated() map[string]float64 {
+	totals := make(map[string]float64)
+	for _, pos := range l.strategies {
+		for _, leg := range []legPosition{pos.spot, pos.future, pos.expiring} {
+			if leg.symbol != "" {
+				totals[leg.symbol] += leg.size
+			}
+		}
+	}
+	return totals
+}
+
+// reconcile compares allocations against exchange positions for the given
+// symbols. The first pass over a symbol records any difference as external
+// inventory. Later mismatches are flagged once they persist across two
+// passes, so fills landing between the ledger and the exchange snapshot are
+// not flagged. It returns the newly flagged discrepancies and the external
+// inventory recorded on this pass.
+func (l *positionLedger) reconcile(exchange []models.Position, symbols []string) ([]models.PositionDiscrepancy, map[string]float64) {
+	now := l.clock.Now()
+
+	held := make(map[string]float64)
+	for _, p := range exchange {
+		held[p.Symbol] += p.Size
+	}
+	ledger := l.allocated()
+
+	var flagged []models.PositionDiscrepancy
+	recorded := make(map[string]float64)
+	for _, symbol := range symbols {
+		if _, ok := l.external[symbol]; !ok {
+			l.external[symbol] = held[symbol] - ledger[symbol]
+			recorded[symbol] = l.external[symbol]
+			continue
+		}
+
+		diff := held[symbol] - l.external[symbol] - ledger[symbol]
+		if math.Abs(diff) <= positionTolerance {
+			delete(l.discrepancies, symbol)
+			delete(l.suspect, symbol)
+			continue
+		}
+		if !l.suspect[symbol] {
+			l.suspect[symbol] = true
+			continue
+		}
+
+		d := models.PositionDiscrepancy{
+			Symbol:     symbol,
+			Ledger:     ledger[symbol],
+			External:   l.external[symbol],
+			Exchange:   held[symbol],
+			Difference: diff,
+			DetectedAt: now,
+		}
+		if existing, ok := l.discrepancies[symbol]; ok {
+			d.DetectedAt = existing.DetectedAt
+		} else {
+			flagged = append(flagged, d)
+		}
+		l.discrepancies[symbol] = d
+	}
+
+	l.exchange = exchange
+	l.reconciledAt = &now
+	return flagged, recorded
+}
+
+// bookExecutionLocked books the part of an order's filled size that its
+// streamed fills have not already booked, at the price its average fill
+// price leaves for it, or at fallback if the exchange reports no average.
+// Must be called with bt.mu held.
+func (bt *BasisTrader) bookExecutionLocked(tracked *trackedOrder, fallback float64) {
+	unbooked := tracked.filledSize - tracked.bookedSize
+	if unbooked < sizeEpsilon {
+		return
+	}
+
+	price := fallback
+	if avg := tracked.avgFillPrice; avg > 0 {
+		price = avg
+		if rest := (avg*tracked.filledSize - tracked.bookedValue) / unbooked; rest > 0 {
+			price = rest
+		}
+	}
+	bt.bookFillLocked(tracked, unbooked, price)
+}
+
+// reconcilePositionsLocked reconciles the ledger against freshly fetched
+// exchange positions. Must be called with bt.mu held.
+func (bt *BasisTrader) reconcilePositionsLocked(exchange []models.Position) {
+	seen := make(map[string]bool)
+	var symbols []string
+	for _, s := range bt.strategies {
+		for _, symbol := range []string{s.SpotSymbol, s.FutureSymbol} {
+			if !seen[symbol] {
+				seen[symbol] = true
+				symbols = append(symbols, symbol)
+			}
+		}
+	}
+	for symbol := range bt.ledger.allocated() {
+		if !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	flagged, external := bt.ledger.reconcile(exchange, symbols)
+	for symbol, size := range external {
+		bt.logExternalLocked(symbol, size)
+	}
+	for _, d := range flagged {
+		bt.logger.WithFields(logrus.Fields{
+			"symbol":     d.Symbol,
+			"ledger":     d.Ledger,
+			"external":   d.External,
+			"exchange":   d.Exchange,
+			"difference": d.Difference,
+		}).Warn("Exchange position does not match strategy allocations")
+	}
+}
+
+// logExternalLocked warns about inventory recorded as held outside the
+// strategies, which is never traded or checked against their allocations.
+// Must be called with bt.mu held.
+func (bt *BasisTrader) logExternalLocked(symbol string, size float64) {
+	if math.Abs(size) <= positionTolerance {
+		return
+	}
+	bt.logger.WithFields(logrus.Fields{
+		"symbol":   symbol,
+		"external": size,
+		"ledger":   bt.ledger.allocated()[symbol],
+	}).Warn("Exchange holds inventory outside strategy allocations, recorded as external")
+}
+
+// GetPositionReport returns each strategy's position, the exchange positions,
+// inventory held outside the strategies and any discrepancies between them
+func (bt *BasisTrader) GetPositionReport() models.PositionReport {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	report := models.PositionReport{
+		Strategies:    make([]models.StrategyPosition, 0, len(bt.ledger.strategies)),
+		Exchange:      append([]models.Position{}, bt.ledger.exchange...),
+		External:      make([]models.ExternalPosition, 0, len(bt.ledger.external)),
+		Discrepancies: make([]models.PositionDiscrepancy, 0, len(bt.ledger.discrepancies)),
+	}
+	if bt.ledger.reconciledAt != nil {
+		t := *bt.ledger.reconciledAt
+		report.ReconciledAt = &t
+	}
+
+	for id, pos := range bt.ledger.strategies {
+		sp := models.StrategyPosition{
+			StrategyID:          id,
+			SpotSymbol:          pos.spot.symbol,
+			FutureSymbol:        pos.future.symbol,
+			SpotSize:            pos.spot.size,
+			FutureSize:          pos.future.size,
+			SpotAvgPrice:        pos.spot.avgPrice,
+			FutureAvgPrice:      pos.future.avgPrice,
+			ExpiringSymbol:      pos.expiring.symbol,
+			ExpiringSize:        pos.expiring.size,
+			EstimatedFundingPnL: pos.funding,
+			NetDelta:            pos.spot.size + pos.future.size + pos.expiring.size,
+			UpdatedAt:           pos.updatedAt,
+		}
+		if pos.spot.size != 0 && pos.future.size != 0 {
+			sp.AvgEntryBasis = pos.future.avgPrice - pos.spot.avgPrice
+		}
+		report.Strategies = append(report.Strategies, sp)
+	}
+	sort.Slice(report.Strategies, func(i, j int) bool {
+		return report.Strategies[i].StrategyID < report.Strategies[j].StrategyID
+	})
+
+	for symbol, size := range bt.ledger.external {
+		if math.Abs(size) > positionTolerance {
+			report.External = append(report.External, models.ExternalPosition{Symbol: symbol, Size: size})
+		}
+	}
+	sort.Slice(report.External, func(i, j int) bool {
+		return report.External[i].Symbol < report.External[j].Symbol
+	})
+
+	for _, d := range bt.ledger.discrepancies {
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	sort.Slice(report.Discrepancies, func(i, j int) bool {
+		return report.Discrepancies[i].Symbol < report.Discrepancies[j].Symbol
+	})
+
+	return report
+}
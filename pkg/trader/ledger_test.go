@@ -0,0 +1,234 @@
+package trader
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/clock"
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+func TestLegPositionApply(t *testing.T) {
+	type execution struct{ qty, price float64 }
+	tests := []struct {
+		name       string
+		executions []execution
+		size       float64
+		avgPrice   float64
+	}{
+		{name: "open", executions: []execution{{1, 100}}, size: 1, avgPrice: 100},
+		{name: "grow long", executions: []execution{{1, 100}, {3, 104}}, size: 4, avgPrice: 103},
+		{name: "grow short", executions: []execution{{-2, 100}, {-2, 110}}, size: -4, avgPrice: 105},
+		{name: "reduce keeps average", executions: []execution{{2, 100}, {-1, 120}}, size: 1, avgPrice: 100},
+		{name: "flip resets average", executions: []execution{{1, 100}, {-3, 90}}, size: -2, avgPrice: 90},
+		{name: "close clears", executions: []execution{{1.5, 100}, {-1.5, 90}}},
+		{name: "dust clears", executions: []execution{{1, 100}, {-1 + sizeEpsilon/2, 90}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var leg legPosition
+			for _, e := range tt.executions {
+				leg.apply(e.qty, e.price)
+			}
+			if math.Abs(leg.size-tt.size) > sizeEpsilon || math.Abs(leg.avgPrice-tt.avgPrice) > 1e-9 {
+				t.Errorf("leg = %g at %g, want %g at %g", leg.size, leg.avgPrice, tt.size, tt.avgPrice)
+			}
+		})
+	}
+}
+
+func TestLedgerRollsFutureLegs(t *testing.T) {
+	type booking struct {
+		symbol string
+		side   models.OrderSide
+		size   float64
+	}
+	tests := []struct {
+		name     string
+		bookings []booking
+		future   legPosition
+		expiring legPosition
+	}{
+		{
+			name:     "same future",
+			bookings: []booking{{"BTC-0628", models.OrderSideSell, 1}, {"BTC-0628", models.OrderSideSell, 1}},
+			future:   legPosition{symbol: "BTC-0628", size: -2, avgPrice: 100},
+		},
+		{
+			name:     "new future sets the held one aside",
+			bookings: []booking{{"BTC-0628", models.OrderSideSell, 1}, {"BTC-0927", models.OrderSideSell, 1}},
+			future:   legPosition{symbol: "BTC-0927", size: -1, avgPrice: 100},
+			expiring: legPosition{symbol: "BTC-0628", size: -1, avgPrice: 100},
+		},
+		{
+			name: "closing the expiring future clears it",
+			bookings: []booking{
+				{"BTC-0628", models.OrderSideSell, 1},
+				{"BTC-0927", models.OrderSideSell, 1},
+				{"BTC-0628", models.OrderSideBuy, 1},
+			},
+			future: legPosition{symbol: "BTC-0927", size: -1, avgPrice: 100},
+		},
+		{
+			name: "a third future stays on the future leg",
+			bookings: []booking{
+				{"BTC-0628", models.OrderSideSell, 1},
+				{"BTC-0927", models.OrderSideSell, 1},
+				{"BTC-1227", models.OrderSideSell, 1},
+			},
+			future:   legPosition{symbol: "BTC-1227", size: -2, avgPrice: 100},
+			expiring: legPosition{symbol: "BTC-0628", size: -1, avgPrice: 100},
+		},
+		{
+			name:     "a flat future leg is reused",
+			bookings: []booking{{"BTC-0628", models.OrderSideSell, 1}, {"BTC-0628", models.OrderSideBuy, 1}, {"BTC-0927", models.OrderSideSell, 1}},
+			future:   legPosition{symbol: "BTC-0927", size: -1, avgPrice: 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := newPositionLedger(clock.NewSimulated(time.Now()))
+			for _, b := range tt.bookings {
+				ledger.book("s-1", legFuture, b.symbol, b.side, b.size, 100)
+			}
+
+			pos := ledger.position("s-1")
+			if pos.future != tt.future || pos.expiring != tt.expiring {
+				t.Errorf("future %+v, expiring %+v; want %+v, %+v", pos.future, pos.expiring, tt.future, tt.expiring)
+			}
+		})
+	}
+}
+
+func TestLedgerReconcile(t *testing.T) {
+	position := func(symbol string, size float64) []models.Position {
+		return []models.Position{{Symbol: symbol, Size: size}}
+	}
+	type pass struct {
+		exchange []models.Position
+		flagged  int
+		external map[string]float64
+	}
+	tests := []struct {
+		name     string
+		booked   float64
+		passes   []pass
+		external float64
+		flagged  bool
+	}{
+		{
+			name:     "first pass records external inventory",
+			booked:   1,
+			passes:   []pass{{exchange: position("BTC-USD", 1.5), external: map[string]float64{"BTC-USD": 0.5}}},
+			external: 0.5,
+		},
+		{
+			name:   "matching allocations",
+			booked: 1,
+			passes: []pass{
+				{exchange: position("BTC-USD", 1.5), external: map[string]float64{"BTC-USD": 0.5}},
+				{exchange: position("BTC-USD", 1.5)},
+			},
+			external: 0.5,
+		},
+		{
+			name:   "one mismatched pass is only suspect",
+			booked: 1,
+			passes: []pass{
+				{exchange: position("BTC-USD", 1), external: map[string]float64{"BTC-USD": 0}},
+				{exchange: position("BTC-USD", 2)},
+				{exchange: position("BTC-USD", 1)},
+			},
+		},
+		{
+			name:   "two mismatched passes are flagged once",
+			booked: 1,
+			passes: []pass{
+				{exchange: position("BTC-USD", 1), external: map[string]float64{"BTC-USD": 0}},
+				{exchange: position("BTC-USD", 2)},
+				{exchange: position("BTC-USD", 2), flagged: 1},
+				{exchange: position("BTC-USD", 2)},
+			},
+			flagged: true,
+		},
+		{
+			name:   "flag clears once matched",
+			booked: 1,
+			passes: []pass{
+				{exchange: position("BTC-USD", 1), external: map[string]float64{"BTC-USD": 0}},
+				{exchange: position("BTC-USD", 2)},
+				{exchange: position("BTC-USD", 2), flagged: 1},
+				{exchange: position("BTC-USD", 1)},
+			},
+		},
+		{
+			name:   "missing exchange position",
+			booked: 1,
+			passes: []pass{
+				{exchange: position("BTC-USD", 1), external: map[string]float64{"BTC-USD": 0}},
+				{},
+				{flagged: 1},
+			},
+			flagged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := newPositionLedger(clock.NewSimulated(time.Now()))
+			ledger.book("s-1", legSpot, "BTC-USD", models.OrderSideBuy, tt.booked, 100)
+
+			for i, p := range tt.passes {
+				flagged, external := ledger.reconcile(p.exchange, []string{"BTC-USD"})
+				if len(flagged) != p.flagged {
+					t.Errorf("pass %d flagged %+v, want %d", i, flagged, p.flagged)
+				}
+				if len(external) != len(p.external) {
+					t.Errorf("pass %d recorded external %v, want %v", i, external, p.external)
+				}
+				for symbol, size := range p.external {
+					if got, ok := external[symbol]; !ok || math.Abs(got-size) > positionTolerance {
+						t.Errorf("pass %d recorded external %s %g, want %g", i, symbol, got, size)
+					}
+				}
+			}
+
+			if got := ledger.external["BTC-USD"]; math.Abs(got-tt.external) > positionTolerance {
+				t.Errorf("external = %g, want %g", got, tt.external)
+			}
+			if _, ok := ledger.discrepancies["BTC-USD"]; ok != tt.flagged {
+				t.Errorf("discrepancy flagged = %v, want %v", ok, tt.flagged)
+			}
+		})
+	}
+}
+
+func TestSpotPositionsByAsset(t *testing.T) {
+	bt := NewBasisTrader(nil, nil, testLogger())
+	bt.strategies["s-1"] = &models.BasisStrategy{ID: "s-1", SpotSymbol: "BTC-USD", FutureSymbol: "BTC-PERP"}
+	bt.strategies["s-2"] = &models.BasisStrategy{ID: "s-2", SpotSymbol: "BTC-USDC", FutureSymbol: "BTC-PERP"}
+	bt.strategies["s-3"] = &models.BasisStrategy{ID: "s-3", SpotSymbol: "ETH-USD", FutureSymbol: "ETH-PERP"}
+	bt.ledger.book("s-2", legSpot, "BTC-USDC", models.OrderSideBuy, 0.5, 100)
+
+	got := bt.spotPositionsLocked([]models.Position{
+		{Symbol: "BTC", Size: 2},
+		{Symbol: "USD", Size: 1000},
+		{Symbol: "SOL", Size: 3},
+		{Symbol: "ETH-USD", Size: 4},
+	})
+
+	// BTC-USDC is credited its allocation and BTC-USD, the first BTC
+	// product, the rest
+	want := map[string]float64{"BTC-USD": 1.5, "BTC-USDC": 0.5, "ETH-USD": 4}
+	if len(got) != len(want) {
+		t.Fatalf("positions = %+v, want %v", got, want)
+	}
+	for _, p := range got {
+		if size, ok := want[p.Symbol]; !ok || math.Abs(p.Size-size) > sizeEpsilon {
+			t.Errorf("%s position %g, want %g", p.Symbol, p.Size, size)
+		}
+	}
+}
@@ -0,0 +1,600 @@
+package trader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultOrderTimeout bounds how long a leg may work before it is hedged
+	defaultOrderTimeout = 60 * time.Second
+
+	// tradeSupervisionInterval is how often working trades are driven forward
+	tradeSupervisionInterval = time.Second
+
+	// maxUnwindAttempts bounds how many unwind orders are tried before the
+	// trade is abandoned as unhedged
+	maxUnwindAttempts = 3
+
+	// sizeEpsilon absorbs float rounding when comparing filled sizes
+	sizeEpsilon = 1e-9
+)
+
+// Trade leg names. A roll's expiring leg takes the place of the spot leg.
+const (
+	legSpot     = "spot"
+	legFuture   = "future"
+	legExpiring = "expiring"
+)
+
+// tradeState is the trader's working state for a basis trade
+type tradeState struct {
+	trade          *models.BasisTrade
+	legs           map[string]*tradeLeg
+	leg1FilledAt   time.Time
+	hedgeStartedAt time.Time
+	lastChase      time.Time
+	unwindAttempts int
+
+	// busy is set while orders for the trade are being placed or cancelled,
+	// so supervision never acts on a half-updated trade
+	busy bool
+}
+
+// tradeLeg is one side of a basis trade and every order placed for it
+type tradeLeg struct {
+	name   string
+	client coinbase.Client
+	symbol string
+	side   models.OrderSide
+	orders []*trackedOrder
+
+	// reduceOnly marks orders in the leg's direction reduce-only
+	reduceOnly bool
+}
+
+func newTradeState(trade *models.BasisTrade, spot, future *tradeLeg) *tradeState {
+	first := legSpot
+	if trade.Side == tradeSideRoll {
+		first = legExpiring
+	}
+	spot.name, future.name = first, legFuture
+	trade.SpotSymbol, trade.FutureSymbol = spot.symbol, future.symbol
+
+	return &tradeState{
+		trade: trade,
+		legs: map[string]*tradeLeg{
+			first:     spot,
+			legFuture: future,
+		},
+	}
+}
+
+// spotLeg is the leg recorded in the trade's spot fields: the spot leg, or on
+// a roll the expiring future
+func (ts *tradeState) spotLeg() *tradeLeg {
+	if leg, ok := ts.legs[legExpiring]; ok {
+		return leg
+	}
+	return ts.legs[legSpot]
+}
+
+// filled is the leg's net size executed in its own direction; unwind orders
+// count against it
+func (l *tradeLeg) filled() float64 {
+	var net float64
+	for _, o := range l.orders {
+		if o.side == l.side {
+			net += o.filledSize
+		} else {
+			net -= o.filledSize
+		}
+	}
+	return net
+}
+
+// avgPrice is the average execution price of the leg's orders in its own
+// direction. Orders are valued at the average fill price the exchange
+// reports, or failing that at their recorded fills, their limit price or,
+// for market orders, fallback.
+func (l *tradeLeg) avgPrice(fills []models.Fill, fallback float64) float64 {
+	var notional, size float64
+	for _, o := range l.orders {
+		if o.side != l.side || o.filledSize < sizeEpsilon {
+			continue
+		}
+		if o.avgFillPrice > 0 {
+			notional += o.avgFillPrice * o.filledSize
+			size += o.filledSize
+			continue
+		}
+
+		var fillNotional, fillSize float64
+		for _, f := range fills {
+			if f.ClientOrderID == o.clientOrderID || (o.orderID != "" && f.OrderID == o.orderID) {
+				fillNotional += f.Price * f.Size
+				fillSize += f.Size
+			}
+		}
+		if fillSize > sizeEpsilon {
+			notional += fillNotional
+			size += fillSize
+			continue
+		}
+
+		price := o.price
+		if price <= 0 {
+			price = fallback
+		}
+		notional += price * o.filledSize
+		size += o.filledSize
+	}
+
+	if size < sizeEpsilon {
+		return 0
+	}
+	return notional / size
+}
+
+// openOrder returns the leg's working order, if any
+func (l *tradeLeg) openOrder() *trackedOrder {
+	for _, o := range l.orders {
+		if o.open() {
+			return o
+		}
+	}
+	return nil
+}
+
+func (ts *tradeState) terminal() bool {
+	return ts.trade.Status == models.TradeStateComplete || ts.trade.Status == models.TradeStateFailed
+}
+
+// imbalance is how far the spot leg is ahead of the future leg
+func (ts *tradeState) imbalance() float64 {
+	return ts.spotLeg().filled() - ts.legs[legFuture].filled()
+}
+
+// referencePrice is the price the trade was decided at for a leg
+func (ts *tradeState) referencePrice(legName string) float64 {
+	if legName == legFuture {
+		return ts.trade.FuturePrice
+	}
+	return ts.trade.SpotPrice
+}
+
+// laggingLeg returns the leg with less filled
+func (ts *tradeState) laggingLeg() *tradeLeg {
+	if ts.imbalance() > 0 {
+		return ts.legs[legFuture]
+	}
+	return ts.spotLeg()
+}
+
+// SetOrderTimeout sets how long a leg may work before it is hedged at market
+func (bt *BasisTrader) SetOrderTimeout(timeout time.Duration) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if timeout > 0 {
+		bt.orderTimeout = timeout
+	}
+}
+
+// transitionLocked moves a trade to a new state and records why. Must be
+// called with bt.mu held.
+func (bt *BasisTrader) transitionLocked(ts *tradeState, to models.BasisTradeState, reason string) {
+	from := ts.trade.Status
+	now := bt.clock.Now()
+
+	ts.trade.Status = to
+	ts.trade.Transitions = append(ts.trade.Transitions, models.TradeTransition{
+		From:   from,
+		To:     to,
+		Reason: reason,
+		Time:   now,
+	})
+
+	switch to {
+	case models.TradeStateLeg1Filled:
+		ts.leg1FilledAt = now
+	case models.TradeStateHedging:
+		ts.hedgeStartedAt = now
+	case models.TradeStateFailed:
+		ts.trade.FailureReason = reason
+		ts.trade.CompletedAt = &now
+	case models.TradeStateComplete:
+		ts.trade.CompletedAt = &now
+	}
+	if ts.terminal() {
+		switch ts.trade.Side {
+		case tradeSideExit:
+			bt.closeEntriesLocked(ts)
+		case tradeSideRoll:
+			bt.settleRollLocked(ts)
+		}
+	}
+	bt.saveTradeLocked(ts.trade)
+
+	entry := bt.logger.WithFields(logrus.Fields{
+		"trade_id":    ts.trade.ID,
+		"strategy_id": ts.trade.StrategyID,
+		"from":        from,
+		"to":          to,
+		"reason":      reason,
+	})
+	if to == models.TradeStateFailed || to == models.TradeStateUnwinding {
+		entry.Warn("Basis trade state changed")
+	} else {
+		entry.Info("Basis trade state changed")
+	}
+}
+
+// syncLegLocked copies a leg's progress onto the trade. Must be called with
+// bt.mu held.
+func (bt *BasisTrader) syncLegLocked(ts *tradeState, legName string) {
+	leg := ts.legs[legName]
+
+	var status models.OrderStatus
+	if n := len(leg.orders); n > 0 {
+		status = leg.orders[n-1].status
+	}
+
+	if legName == legFuture {
+		ts.trade.FutureStatus = status
+		ts.trade.FutureFilledSize = leg.filled()
+		ts.trade.FutureAvgPrice = leg.avgPrice(ts.trade.Fills, ts.referencePrice(legFuture))
+	} else {
+		ts.trade.SpotStatus = status
+		ts.trade.SpotFilledSize = leg.filled()
+		ts.trade.SpotAvgPrice = leg.avgPrice(ts.trade.Fills, ts.referencePrice(legName))
+	}
+}
+
+// advanceLocked applies the transitions that follow directly from fills.
+// Must be called with bt.mu held.
+func (bt *BasisTrader) advanceLocked(ts *tradeState) {
+	spot, future := ts.spotLeg().filled(), ts.legs[legFuture].filled()
+	target := ts.trade.Size - sizeEpsilon
+	balanced := math.Abs(spot-future) < sizeEpsilon
+
+	switch ts.trade.Status {
+	case models.TradeStatePending:
+		switch {
+		case spot >= target && future >= target:
+			bt.transitionLocked(ts, models.TradeStateComplete, "both legs filled")
+		case spot >= target:
+			bt.transitionLocked(ts, models.TradeStateLeg1Filled, ts.spotLeg().name+" leg filled")
+		case future >= target:
+			bt.transitionLocked(ts, models.TradeStateLeg1Filled, "future leg filled")
+		}
+	case models.TradeStateLeg1Filled:
+		if spot >= target && future >= target {
+			bt.transitionLocked(ts, models.TradeStateComplete, "lagging leg filled")
+		}
+	case models.TradeStateHedging:
+		if balanced {
+			bt.transitionLocked(ts, models.TradeStateComplete, fmt.Sprintf("hedged at %.8f", spot))
+		}
+	case models.TradeStateUnwinding:
+		if balanced {
+			bt.transitionLocked(ts, models.TradeStateFailed, fmt.Sprintf("hedge failed, unwound to %.8f", spot))
+		}
+	}
+}
+
+// superviseTrades drives working trades through timeouts, chasing, hedging
+// and unwinding
+func (bt *BasisTrader) superviseTrades(ctx context.Context) {
+	ticker := bt.clock.NewTicker(tradeSupervisionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-bt.stopCh:
+			return
+		case <-ticker.C():
+			bt.manageTrades(ctx)
+		}
+	}
+}
+
+func (bt *BasisTrader) manageTrades(ctx context.Context) {
+	var active []*tradeState
+	bt.mu.Lock()
+	for _, ts := range bt.trades {
+		if !ts.terminal() && !ts.busy {
+			ts.busy = true
+			active = append(active, ts)
+		}
+	}
+	bt.mu.Unlock()
+
+	for _, ts := range active {
+		bt.manageTrade(ctx, ts)
+
+		bt.mu.Lock()
+		ts.busy = false
+		bt.mu.Unlock()
+	}
+}
+
+func (bt *BasisTrader) manageTrade(ctx context.Context, ts *tradeState) {
+	// Nothing is decided while a leg has an order that may or may not be live
+	if !bt.resolveUnknownOrders(ctx, ts) {
+		return
+	}
+
+	bt.mu.RLock()
+	state := ts.trade.Status
+	bt.mu.RUnlock()
+
+	switch state {
+	case models.TradeStatePending:
+		bt.managePending(ctx, ts)
+	case models.TradeStateLeg1Filled:
+		bt.manageLeg1Filled(ctx, ts)
+	case models.TradeStateHedging:
+		bt.manageHedging(ctx, ts)
+	case models.TradeStateUnwinding:
+		bt.manageUnwinding(ctx, ts)
+	}
+}
+
+// managePending cancels both legs once the order timeout passes or either leg
+// dies unfilled, then hedges whatever imbalance the partial fills left
+func (bt *BasisTrader) managePending(ctx context.Context, ts *tradeState) {
+	bt.mu.RLock()
+	timeout := bt.orderTimeout
+	expired := bt.clock.Since(ts.trade.CreatedAt) > timeout
+	spotLeg := ts.spotLeg()
+	dead := spotLeg.openOrder() == nil || ts.legs[legFuture].openOrder() == nil
+	bt.mu.RUnlock()
+
+	if !expired && !dead {
+		return
+	}
+
+	bt.cancelLegOrders(ctx, ts, spotLeg.name, legFuture)
+
+	bt.mu.Lock()
+	if ts.trade.Status != models.TradeStatePending {
+		// A fill raced the cancel and already moved the trade on
+		bt.mu.Unlock()
+		return
+	}
+
+	reason := "order timeout"
+	if !expired {
+		reason = "leg cancelled or rejected"
+	}
+
+	spot, future := spotLeg.filled(), ts.legs[legFuture].filled()
+	hedge := false
+	switch {
+	case spot < sizeEpsilon && future < sizeEpsilon:
+		bt.transitionLocked(ts, models.TradeStateFailed, reason+" before any fill")
+	case math.Abs(spot-future) < sizeEpsilon:
+		bt.transitionLocked(ts, models.TradeStateComplete, fmt.Sprintf("%s, legs matched at %.8f", reason, spot))
+	default:
+		bt.transitionLocked(ts, models.TradeStateHedging, fmt.Sprintf("%s with legs at %.8f/%.8f", reason, spot, future))
+		hedge = true
+	}
+	bt.mu.Unlock()
+
+	if hedge {
+		bt.placeHedge(ctx, ts)
+	}
+}
+
+// manageLeg1Filled chases the lagging leg to the touch while within the order
+// timeout, and hedges it at market once the timeout passes or it dies
+func (bt *BasisTrader) manageLeg1Filled(ctx context.Context, ts *tradeState) {
+	bt.mu.RLock()
+	timeout := bt.orderTimeout
+	lagging := ts.laggingLeg()
+	open := lagging.openOrder()
+	expired := bt.clock.Since(ts.leg1FilledAt) > timeout
+	chaseDue := bt.clock.Since(ts.lastChase) >= timeout/4
+	bt.mu.RUnlock()
+
+	if open == nil || expired {
+		reason := "order timeout on " + lagging.name + " leg"
+		if open == nil {
+			reason = lagging.name + " leg cancelled or rejected"
+		}
+
+		bt.cancelLegOrders(ctx, ts, lagging.name)
+
+		bt.mu.Lock()
+		if ts.trade.Status != models.TradeStateLeg1Filled {
+			bt.mu.Unlock()
+			return
+		}
+		bt.transitionLocked(ts, models.TradeStateHedging, reason)
+		bt.mu.Unlock()
+
+		bt.placeHedge(ctx, ts)
+		return
+	}
+
+	if !chaseDue {
+		return
+	}
+
+	price, ok := bt.chasePrice(lagging.symbol, lagging.side)
+	if !ok || !behindTouch(open, price) {
+		return
+	}
+
+	bt.cancelLegOrders(ctx, ts, lagging.name)
+
+	bt.mu.Lock()
+	if ts.trade.Status != models.TradeStateLeg1Filled {
+		bt.mu.Unlock()
+		return
+	}
+	remaining := math.Abs(ts.imbalance())
+	ts.lastChase = bt.clock.Now()
+	bt.mu.Unlock()
+
+	if remaining < sizeEpsilon {
+		return
+	}
+
+	bt.logger.WithFields(logrus.Fields{
+		"trade_id": ts.trade.ID,
+		"leg":      lagging.name,
+		"from":     open.price,
+		"to":       price,
+		"size":     remaining,
+	}).Info("Chasing lagging leg")
+
+	if _, err := bt.placeLegOrder(ctx, ts, lagging.name, lagging.side, models.OrderTypeLimit, price, remaining); err != nil {
+		// The dead leg is hedged on the next pass
+		bt.logger.WithError(err).WithField("trade_id", ts.trade.ID).Error("Failed to re-place lagging leg")
+	}
+}
+
+// manageHedging unwinds the trade if the hedge order dies or does not fill
+// within the order timeout
+func (bt *BasisTrader) manageHedging(ctx context.Context, ts *tradeState) {
+	bt.mu.RLock()
+	lagging := ts.laggingLeg()
+	open := lagging.openOrder()
+	expired := bt.clock.Since(ts.hedgeStartedAt) > bt.orderTimeout
+	bt.mu.RUnlock()
+
+	if open != nil && !expired {
+		return
+	}
+
+	bt.cancelLegOrders(ctx, ts, lagging.name)
+
+	bt.mu.Lock()
+	if ts.trade.Status != models.TradeStateHedging {
+		bt.mu.Unlock()
+		return
+	}
+	reason := "hedge order not filled within order timeout"
+	if open == nil {
+		reason = "hedge order cancelled or rejected"
+	}
+	bt.transitionLocked(ts, models.TradeStateUnwinding, reason)
+	bt.mu.Unlock()
+
+	bt.placeUnwind(ctx, ts)
+}
+
+// manageUnwinding retries the unwind if the previous unwind order died
+func (bt *BasisTrader) manageUnwinding(ctx context.Context, ts *tradeState) {
+	bt.mu.RLock()
+	open := ts.spotLeg().openOrder() != nil || ts.legs[legFuture].openOrder() != nil
+	bt.mu.RUnlock()
+
+	if !open {
+		bt.placeUnwind(ctx, ts)
+	}
+}
+
+// placeHedge completes the lagging leg at market for the imbalance
+func (bt *BasisTrader) placeHedge(ctx context.Context, ts *tradeState) {
+	bt.mu.RLock()
+	lagging := ts.laggingLeg()
+	size := math.Abs(ts.imbalance())
+	bt.mu.RUnlock()
+
+	if size < sizeEpsilon {
+		bt.mu.Lock()
+		bt.advanceLocked(ts)
+		bt.mu.Unlock()
+		return
+	}
+
+	if _, err := bt.placeLegOrder(ctx, ts, lagging.name, lagging.side, models.OrderTypeMarket, 0, size); err != nil {
+		bt.mu.Lock()
+		bt.transitionLocked(ts, models.TradeStateUnwinding, fmt.Sprintf("hedge order failed: %v", err))
+		bt.mu.Unlock()
+
+		bt.placeUnwind(ctx, ts)
+	}
+}
+
+// placeUnwind closes out the leg that is ahead at market, leaving the trade
+// balanced. After maxUnwindAttempts the trade is failed as unhedged.
+func (bt *BasisTrader) placeUnwind(ctx context.Context, ts *tradeState) {
+	bt.mu.Lock()
+	imbalance := ts.imbalance()
+	if math.Abs(imbalance) < sizeEpsilon {
+		bt.advanceLocked(ts)
+		bt.mu.Unlock()
+		return
+	}
+	if ts.unwindAttempts >= maxUnwindAttempts {
+		bt.transitionLocked(ts, models.TradeStateFailed,
+			fmt.Sprintf("unwind failed after %d attempts, legs unhedged by %.8f", ts.unwindAttempts, imbalance))
+		bt.mu.Unlock()
+		bt.logger.WithField("trade_id", ts.trade.ID).Error("Basis trade left unhedged, manual intervention required")
+		return
+	}
+	ts.unwindAttempts++
+
+	ahead := ts.spotLeg()
+	if imbalance < 0 {
+		ahead = ts.legs[legFuture]
+	}
+	bt.mu.Unlock()
+
+	if _, err := bt.placeLegOrder(ctx, ts, ahead.name, oppositeSide(ahead.side), models.OrderTypeMarket, 0, math.Abs(imbalance)); err != nil {
+		// Retried by manageUnwinding on the next pass
+		bt.logger.WithError(err).WithFields(logrus.Fields{
+			"trade_id": ts.trade.ID,
+			"leg":      ahead.name,
+		}).Error("Failed to place unwind order")
+	}
+}
+
+// chasePrice is the marketable price for a side: the best ask for buys and
+// the best bid for sells
+func (bt *BasisTrader) chasePrice(symbol string, side models.OrderSide) (float64, bool) {
+	ticker, age, ok := bt.marketData.ticker(symbol)
+	if !ok || age > maxTickerAge {
+		return 0, false
+	}
+
+	price := ticker.AskPrice
+	if side == models.OrderSideSell {
+		price = ticker.BidPrice
+	}
+	if price <= 0 {
+		price = ticker.LastPrice
+	}
+	return price, price > 0
+}
+
+// behindTouch reports whether a working limit order is priced away from the
+// marketable price
+func behindTouch(order *trackedOrder, price float64) bool {
+	if order.orderType != models.OrderTypeLimit {
+		return false
+	}
+	if order.side == models.OrderSideBuy {
+		return order.price < price
+	}
+	return order.price > price
+}
+
+func oppositeSide(side models.OrderSide) models.OrderSide {
+	if side == models.OrderSideBuy {
+		return models.OrderSideSell
+	}
+	return models.OrderSideBuy
+}
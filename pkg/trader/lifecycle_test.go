@@ -0,0 +1,225 @@
+package trader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/clock"
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// stubClient is an exchange whose orders rest until the test fills them
+type stubClient struct {
+	mu     sync.Mutex
+	orders map[string]*models.Order
+	placed []string // order IDs, in order
+	reject map[models.OrderSide]bool
+}
+
+func newStubClient() *stubClient {
+	return &stubClient{orders: make(map[string]*models.Order), reject: make(map[models.OrderSide]bool)}
+}
+
+func (c *stubClient) PlaceOrder(ctx context.Context, req *models.OrderRequest) (*models.Order, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reject[req.Side] {
+		return nil, errors.New("rejected")
+	}
+	order := &models.Order{
+		OrderID:       fmt.Sprintf("o-%d", len(c.placed)+1),
+		ClientOrderID: req.ClientOrderID,
+		Symbol:        req.Symbol,
+		Side:          req.Side,
+		Type:          req.Type,
+		Price:         req.Price,
+		Size:          req.Size,
+		Status:        models.OrderStatusNew,
+	}
+	c.orders[order.OrderID] = order
+	c.placed = append(c.placed, order.OrderID)
+	copied := *order
+	return &copied, nil
+}
+
+func (c *stubClient) CancelOrder(ctx context.Context, orderID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	order, ok := c.orders[orderID]
+	if !ok {
+		return coinbase.ErrOrderNotFound
+	}
+	if !isTerminalStatus(order.Status) {
+		order.Status = models.OrderStatusCancelled
+	}
+	return nil
+}
+
+func (c *stubClient) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	order, ok := c.orders[orderID]
+	if !ok {
+		return nil, coinbase.ErrOrderNotFound
+	}
+	copied := *order
+	return &copied, nil
+}
+
+// fill executes size more of the nth order placed and returns its state
+func (c *stubClient) fill(n int, size float64) models.Order {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	order := c.orders[c.placed[n]]
+	order.FilledSize += size
+	order.Status = models.OrderStatusPartiallyFilled
+	if order.FilledSize >= order.Size-sizeEpsilon {
+		order.Status = models.OrderStatusFilled
+	}
+	return *order
+}
+
+func (c *stubClient) GetTicker(ctx context.Context, symbol string) (*models.Ticker, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *stubClient) GetOrderBook(ctx context.Context, symbol string, level int) (*models.OrderBook, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *stubClient) GetPositions(ctx context.Context) ([]models.Position, error) {
+	return nil, nil
+}
+
+func (c *stubClient) GetOpenOrders(ctx context.Context, symbols []string) ([]models.Order, error) {
+	return nil, nil
+}
+
+func (c *stubClient) Subscribe(channels []string, symbols []string) error {
+	return nil
+}
+
+func TestTradeStateMachine(t *testing.T) {
+	// Orders are placed spot (0) then future (1), followed by any hedge or
+	// unwind orders
+	type step struct {
+		fill    int
+		size    float64
+		advance time.Duration // then supervise
+	}
+	fill := func(n int, size float64) step { return step{fill: n, size: size} }
+	wait := func(d time.Duration) step { return step{fill: -1, advance: d} }
+
+	tests := []struct {
+		name         string
+		rejectFuture bool
+		steps        []step
+		want         []models.BasisTradeState
+		spot, future float64
+	}{
+		{
+			name:   "both legs fill",
+			steps:  []step{fill(0, 1), fill(1, 1)},
+			want:   []models.BasisTradeState{models.TradeStatePending, models.TradeStateLeg1Filled, models.TradeStateComplete},
+			spot:   1,
+			future: -1,
+		},
+		{
+			name:  "timeout before any fill",
+			steps: []step{wait(time.Second), wait(time.Minute)},
+			want:  []models.BasisTradeState{models.TradeStatePending, models.TradeStateFailed},
+		},
+		{
+			name:         "future leg rejected",
+			rejectFuture: true,
+			steps:        []step{wait(time.Second)},
+			want:         []models.BasisTradeState{models.TradeStatePending, models.TradeStateFailed},
+		},
+		{
+			name:   "partial fills hedged after timeout",
+			steps:  []step{fill(0, 0.4), fill(1, 0.1), wait(time.Minute + time.Second), fill(2, 0.3)},
+			want:   []models.BasisTradeState{models.TradeStatePending, models.TradeStateHedging, models.TradeStateComplete},
+			spot:   0.4,
+			future: -0.4,
+		},
+		{
+			name:   "partial fills matched at timeout",
+			steps:  []step{fill(0, 0.4), fill(1, 0.4), wait(time.Minute + time.Second)},
+			want:   []models.BasisTradeState{models.TradeStatePending, models.TradeStateComplete},
+			spot:   0.4,
+			future: -0.4,
+		},
+		{
+			name:   "lagging leg hedged after timeout",
+			steps:  []step{fill(0, 1), wait(time.Minute + time.Second), fill(2, 1)},
+			want:   []models.BasisTradeState{models.TradeStatePending, models.TradeStateLeg1Filled, models.TradeStateHedging, models.TradeStateComplete},
+			spot:   1,
+			future: -1,
+		},
+		{
+			name:  "unfilled hedge unwound",
+			steps: []step{fill(0, 1), wait(time.Minute + time.Second), wait(time.Minute + time.Second), fill(3, 1)},
+			want: []models.BasisTradeState{
+				models.TradeStatePending, models.TradeStateLeg1Filled, models.TradeStateHedging,
+				models.TradeStateUnwinding, models.TradeStateFailed,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			clk := clock.NewSimulated(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			client := newStubClient()
+			client.reject[models.OrderSideSell] = tt.rejectFuture
+
+			bt := NewBasisTrader(client, client, testLogger())
+			bt.SetClock(clk)
+			strategy := &models.BasisStrategy{ID: "s-1"}
+			plan := &executionPlan{
+				spot:        &tradeLeg{client: client, symbol: "BTC-USD", side: models.OrderSideBuy},
+				future:      &tradeLeg{client: client, symbol: "BTC-PERP", side: models.OrderSideSell},
+				spotQuote:   legQuote{price: 100, limit: 100},
+				futureQuote: legQuote{price: 101, limit: 101},
+				size:        1,
+			}
+			ts := newTradeState(newTrade(strategy, plan, tradeSideEnter, clk.Now()), plan.spot, plan.future)
+			bt.openTrade(ctx, strategy, plan, ts, "test")
+
+			for _, s := range tt.steps {
+				if s.fill < 0 {
+					clk.Advance(s.advance)
+					bt.manageTrades(ctx)
+					continue
+				}
+				bt.handleOrderUpdate(client.fill(s.fill, s.size))
+			}
+
+			trade, ok := bt.GetTrade(ts.trade.ID)
+			if !ok {
+				t.Fatal("trade not tracked")
+			}
+			var got []models.BasisTradeState
+			for _, transition := range trade.Transitions {
+				got = append(got, transition.To)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("states = %v, want %v", got, tt.want)
+			}
+
+			pos := bt.ledger.position(strategy.ID)
+			if pos.spot.size != tt.spot || pos.future.size != tt.future {
+				t.Errorf("position = %g/%g, want %g/%g", pos.spot.size, pos.future.size, tt.spot, tt.future)
+			}
+		})
+	}
+}
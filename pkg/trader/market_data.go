@@ -0,0 +1,227 @@
+package trader
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/clock"
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// marketDataFeedName is the feed carrying public market data channels
+	marketDataFeedName = "market_data"
+
+	// derivativesDataFeedName is the Advanced Trade feed carrying market data
+	// for futures, which the Exchange feed does not list
+	derivativesDataFeedName = "derivatives_market_data"
+
+	// defaultTickerChannel streams a ticker on every trade
+	defaultTickerChannel = "ticker"
+
+	// tickerPollAfter is how long a symbol may go without a streamed update
+	// before it is polled over REST, even while the stream is up
+	tickerPollAfter = 5 * time.Second
+
+	// maxTickerAge is the oldest price strategies are evaluated against
+	maxTickerAge = 10 * time.Second
+)
+
+type MarketDataManager struct {
+	tickers         map[string]*models.Ticker
+	updatedAt       map[string]time.Time
+	funding         map[string]models.FundingRate
+	fundingAt       map[string]time.Time
+	books           *coinbase.BookBuilder
+	feed            *coinbase.WebSocketClient
+	derivativesFeed *coinbase.WebSocketClient
+	tickerChannel   string
+	streamedBy      map[string]string // symbol -> name of the feed streaming it
+	polling         map[string]bool
+	clock           clock.Clock
+	mu              sync.RWMutex
+}
+
+func newMarketDataManager(logger *logrus.Logger, clk clock.Clock) *MarketDataManager {
+	return &MarketDataManager{
+		tickers:       make(map[string]*models.Ticker),
+		updatedAt:     make(map[string]time.Time),
+		funding:       make(map[string]models.FundingRate),
+		fundingAt:     make(map[string]time.Time),
+		books:         coinbase.NewBookBuilder(logger),
+		tickerChannel: defaultTickerChannel,
+		streamedBy:    make(map[string]string),
+		polling:       make(map[string]bool),
+		clock:         clk,
+	}
+}
+
+// updateTicker stores a ticker, overwriting the existing one in place
+func (m *MarketDataManager) updateTicker(ticker models.Ticker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.tickers[ticker.Symbol]; ok {
+		*existing = ticker
+	} else {
+		m.tickers[ticker.Symbol] = &ticker
+	}
+	m.updatedAt[ticker.Symbol] = m.clock.Now()
+}
+
+// ticker returns a copy of the latest ticker for symbol and its age
+func (m *MarketDataManager) ticker(symbol string) (models.Ticker, time.Duration, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.tickers[symbol]
+	if !ok {
+		return models.Ticker{}, 0, false
+	}
+	return *t, m.clock.Since(m.updatedAt[symbol]), true
+}
+
+// SetMarketDataFeed attaches the websocket that streams public market data.
+// Strategy symbols are subscribed to its ticker channel, which drives
+// strategy evaluation, and to its level2 channel, which is maintained as
+// local order books. tickerChannel may be "ticker" or "ticker_batch"; empty
+// selects "ticker".
+func (bt *BasisTrader) SetMarketDataFeed(client *coinbase.WebSocketClient, tickerChannel string) {
+	bt.AttachFeed(marketDataFeedName, client)
+	bt.SetMarketDataSource(client)
+
+	bt.marketData.mu.Lock()
+	bt.marketData.feed = client
+	if tickerChannel != "" {
+		bt.marketData.tickerChannel = tickerChannel
+	}
+	bt.marketData.mu.Unlock()
+}
+
+// SetDerivativesMarketDataFeed attaches an Advanced Trade websocket that
+// streams tickers and books for future symbols. It is needed when the market
+// data feed is an Exchange one, which does not list derivatives; without it
+// futures are polled over REST.
+func (bt *BasisTrader) SetDerivativesMarketDataFeed(client *coinbase.WebSocketClient) error {
+	if client.Feed() != coinbase.FeedAdvancedTrade {
+		return fmt.Errorf("derivatives market data needs an Advanced Trade feed, got %s", client.Feed())
+	}
+
+	bt.AttachFeed(derivativesDataFeedName, client)
+	bt.SetMarketDataSource(client)
+
+	bt.marketData.mu.Lock()
+	bt.marketData.derivativesFeed = client
+	bt.marketData.mu.Unlock()
+	return nil
+}
+
+// SetMarketDataSource decodes tickers and books from a websocket client that
+// is never connected or subscribed by the trader, such as one replaying a
+// recording through Dispatch
+func (bt *BasisTrader) SetMarketDataSource(client *coinbase.WebSocketClient) {
+	bt.marketData.books.Attach(client)
+	client.OnTicker(bt.handleTicker)
+}
+
+// UpdateTicker applies a ticker from a source other than the attached feeds,
+// such as a backtest replay, and wakes strategy evaluation
+func (bt *BasisTrader) UpdateTicker(ticker models.Ticker) {
+	bt.handleTicker(ticker)
+}
+
+// handleTicker applies a streamed ticker and wakes strategy evaluation
+func (bt *BasisTrader) handleTicker(ticker models.Ticker) {
+	bt.marketData.updateTicker(ticker)
+
+	select {
+	case bt.priceUpdates <- struct{}{}:
+	default:
+		// An evaluation is already pending and will see this price
+	}
+}
+
+// subscribeMarketData starts streaming tickers and books for a strategy's
+// symbols. Futures go to the derivatives feed if one is attached, or to the
+// market data feed only if it is an Advanced Trade one.
+func (bt *BasisTrader) subscribeMarketData(strategy *models.BasisStrategy) {
+	bt.marketData.books.Track(strategy.SpotSymbol, bt.spotClient)
+	bt.marketData.books.Track(strategy.FutureSymbol, bt.futureClient)
+
+	bt.marketData.mu.Lock()
+	feed, derivativesFeed, tickerChannel := bt.marketData.feed, bt.marketData.derivativesFeed, bt.marketData.tickerChannel
+	if feed == nil && derivativesFeed == nil {
+		bt.marketData.mu.Unlock()
+		return
+	}
+
+	subscriptions := make(map[*coinbase.WebSocketClient][]string)
+	if feed != nil {
+		subscriptions[feed] = append(subscriptions[feed], strategy.SpotSymbol)
+		bt.marketData.streamedBy[strategy.SpotSymbol] = marketDataFeedName
+	}
+	switch {
+	case derivativesFeed != nil:
+		subscriptions[derivativesFeed] = append(subscriptions[derivativesFeed], strategy.FutureSymbol)
+		bt.marketData.streamedBy[strategy.FutureSymbol] = derivativesDataFeedName
+	case feed.Feed() == coinbase.FeedAdvancedTrade:
+		subscriptions[feed] = append(subscriptions[feed], strategy.FutureSymbol)
+		bt.marketData.streamedBy[strategy.FutureSymbol] = marketDataFeedName
+	default:
+		bt.logger.WithFields(logrus.Fields{
+			"strategy_id": strategy.ID,
+			"symbol":      strategy.FutureSymbol,
+			"feed":        feed.Feed(),
+		}).Error("No Advanced Trade feed for derivatives market data, future will only be polled over REST without an order book")
+	}
+	bt.marketData.mu.Unlock()
+
+	for client, symbols := range subscriptions {
+		channels := []string{tickerChannel, client.Level2Channel()}
+		if err := client.Subscribe(channels, symbols); err != nil {
+			bt.logger.WithError(err).WithFields(logrus.Fields{
+				"strategy_id": strategy.ID,
+				"symbols":     symbols,
+			}).Error("Failed to subscribe to market data")
+		}
+	}
+}
+
+// streamingFeed returns the name of the feed subscribed to symbol
+func (m *MarketDataManager) streamingFeed(symbol string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name, ok := m.streamedBy[symbol]
+	return name, ok
+}
+
+// notePolling records whether symbol is being polled over REST, logging when
+// it starts and stops so a lost stream is visible per symbol
+func (bt *BasisTrader) notePolling(symbol string, polling bool, reason string) {
+	bt.marketData.mu.Lock()
+	changed := bt.marketData.polling[symbol] != polling
+	bt.marketData.polling[symbol] = polling
+	bt.marketData.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if polling {
+		bt.logger.WithFields(logrus.Fields{
+			"symbol": symbol,
+			"reason": reason,
+		}).Warn("Symbol fell back to REST ticker polling")
+		return
+	}
+	bt.logger.WithField("symbol", symbol).Info("Symbol streaming again, stopped REST ticker polling")
+}
+
+// GetOrderBook returns up to depth levels per side of the live order book for
+// symbol. ok is false if the book has not been built or is being resynced.
+func (bt *BasisTrader) GetOrderBook(symbol string, depth int) (book *models.OrderBook, ok bool) {
+	return bt.marketData.books.Book(symbol, depth)
+}
@@ -0,0 +1,453 @@
+package trader
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// userFeedName is the feed carrying authenticated order updates and fills
+	userFeedName = "user"
+
+	// orderPollInterval is how often open orders are checked for staleness
+	orderPollInterval = 2 * time.Second
+
+	// orderPollAfter is how long an open order may go without an update
+	// before it is polled over REST, even while the user stream is up. Orders
+	// on venues the stream does not cover are picked up this way.
+	orderPollAfter = 10 * time.Second
+
+	// unknownOrderTimeout is how long an order whose placement outcome is
+	// unknown is looked for by client order ID before it is taken never to
+	// have reached the exchange
+	unknownOrderTimeout = time.Minute
+)
+
+// trackedOrder is an order placed for one leg of a basis trade. Orphans
+// adopted during reconciliation belong to a strategy but no trade.
+type trackedOrder struct {
+	tradeID       string
+	strategyID    string
+	leg           string
+	symbol        string
+	client        coinbase.Client
+	orderID       string
+	clientOrderID string
+	side          models.OrderSide
+	orderType     models.OrderType
+	price         float64
+	size          float64
+	status        models.OrderStatus
+	filledSize    float64
+	avgFillPrice  float64 // as reported by the exchange, zero if unknown
+	bookedSize    float64 // filled size attributed to the position ledger
+	bookedValue   float64 // bookedSize at the prices it was booked at
+	reduceOnly    bool
+	createdAt     time.Time
+	updatedAt     time.Time
+}
+
+func (o *trackedOrder) open() bool {
+	return !isTerminalStatus(o.status)
+}
+
+// fillNotifier is implemented by clients that report their own fills, such
+// as the paper-trading simulator, which no user channel covers
+type fillNotifier interface {
+	OnFill(handler func(models.Fill))
+}
+
+// SetUserFeed attaches an authenticated websocket whose user channel reports
+// order updates and fills for trade legs. Without it, or while it is down,
+// open orders are polled with GetOrder.
+func (bt *BasisTrader) SetUserFeed(client *coinbase.WebSocketClient) {
+	bt.AttachFeed(userFeedName, client)
+	client.OnOrderUpdate(bt.handleOrderUpdate, bt.handleFill)
+
+	bt.mu.Lock()
+	bt.userFeed = client
+	bt.mu.Unlock()
+}
+
+// subscribeOrderUpdates subscribes a strategy's symbols on the user channel
+func (bt *BasisTrader) subscribeOrderUpdates(strategy *models.BasisStrategy) {
+	bt.mu.RLock()
+	feed := bt.userFeed
+	bt.mu.RUnlock()
+	if feed == nil {
+		return
+	}
+
+	symbols := []string{strategy.SpotSymbol, strategy.FutureSymbol}
+	if err := feed.Subscribe([]string{"user"}, symbols); err != nil {
+		bt.logger.WithError(err).WithField("strategy_id", strategy.ID).Error("Failed to subscribe to order updates")
+	}
+}
+
+// placeLegOrder places an order for a trade leg. The order is tracked before
+// placement so updates racing the placement response can be matched by
+// client order ID. An order the exchange may have accepted despite the
+// error stays tracked in the unknown status until it is resolved.
+func (bt *BasisTrader) placeLegOrder(ctx context.Context, ts *tradeState, legName string, side models.OrderSide,
+	orderType models.OrderType, price, size float64) (*trackedOrder, error) {
+	leg := ts.legs[legName]
+	req := &models.OrderRequest{
+		ClientOrderID: newClientOrderID(),
+		Symbol:        leg.symbol,
+		Side:          side,
+		Type:          orderType,
+		Price:         price,
+		Size:          bt.instruments.toContracts(leg.symbol, size),
+		ReduceOnly:    leg.reduceOnly && side == leg.side,
+	}
+
+	tracked := &trackedOrder{
+		tradeID:       ts.trade.ID,
+		strategyID:    ts.trade.StrategyID,
+		leg:           legName,
+		symbol:        leg.symbol,
+		client:        leg.client,
+		clientOrderID: req.ClientOrderID,
+		side:          side,
+		orderType:     orderType,
+		price:         price,
+		size:          size,
+		status:        models.OrderStatusNew,
+		reduceOnly:    req.ReduceOnly,
+		createdAt:     bt.clock.Now(),
+		updatedAt:     bt.clock.Now(),
+	}
+
+	bt.mu.Lock()
+	leg.orders = append(leg.orders, tracked)
+	bt.orders[tracked.clientOrderID] = tracked
+	bt.saveOrderLocked(ts, tracked)
+	bt.mu.Unlock()
+
+	order, err := leg.client.PlaceOrder(ctx, req)
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	// An update that raced the response has already identified the order
+	if err != nil && tracked.orderID == "" {
+		if !errors.Is(err, coinbase.ErrOrderStatusUnknown) {
+			tracked.status = models.OrderStatusRejected
+			delete(bt.orders, tracked.clientOrderID)
+			bt.syncLegLocked(ts, legName)
+			bt.saveOrderLocked(ts, tracked)
+			return nil, err
+		}
+
+		tracked.status = models.OrderStatusUnknown
+		bt.syncLegLocked(ts, legName)
+		bt.saveOrderLocked(ts, tracked)
+		bt.logger.WithError(err).WithFields(logrus.Fields{
+			"trade_id":        ts.trade.ID,
+			"leg":             legName,
+			"client_order_id": tracked.clientOrderID,
+		}).Warn("Order placement outcome unknown, tracking by client order ID")
+		return tracked, nil
+	}
+
+	if tracked.orderID == "" {
+		tracked.orderID = order.OrderID
+		bt.orderIDs[order.OrderID] = tracked.clientOrderID
+		bt.saveOrderLocked(ts, tracked)
+	}
+	bt.syncLegLocked(ts, legName)
+	return tracked, nil
+}
+
+// cancelLegOrders cancels the open orders of the named legs and refreshes
+// them, so fills that raced the cancel are counted before acting on the
+// remainder
+func (bt *BasisTrader) cancelLegOrders(ctx context.Context, ts *tradeState, legNames ...string) {
+	var open []*trackedOrder
+	bt.mu.RLock()
+	for _, name := range legNames {
+		for _, o := range ts.legs[name].orders {
+			if o.open() && o.orderID != "" {
+				open = append(open, o)
+			}
+		}
+	}
+	bt.mu.RUnlock()
+
+	for _, o := range open {
+		if err := o.client.CancelOrder(ctx, o.orderID); err != nil && !errors.Is(err, coinbase.ErrOrderNotFound) {
+			bt.logger.WithError(err).WithField("order_id", o.orderID).Error("Failed to cancel order")
+		}
+
+		order, err := o.client.GetOrder(ctx, o.orderID)
+		if err != nil {
+			bt.logger.WithError(err).WithField("order_id", o.orderID).Error("Failed to refresh cancelled order")
+			continue
+		}
+		bt.handleOrderUpdate(*order)
+	}
+}
+
+// resolveUnknownOrders looks up the orders of a trade whose placement outcome
+// is unknown, and reports whether none remain
+func (bt *BasisTrader) resolveUnknownOrders(ctx context.Context, ts *tradeState) bool {
+	var unknown []*trackedOrder
+	bt.mu.RLock()
+	for _, leg := range ts.legs {
+		for _, o := range leg.orders {
+			if o.status == models.OrderStatusUnknown {
+				unknown = append(unknown, o)
+			}
+		}
+	}
+	bt.mu.RUnlock()
+
+	resolved := true
+	for _, o := range unknown {
+		order, err := bt.lookupExchangeOrder(ctx, o)
+		switch {
+		case err == nil:
+			bt.handleOrderUpdate(*order)
+		case errors.Is(err, coinbase.ErrOrderNotFound):
+			bt.mu.Lock()
+			if o.status == models.OrderStatusUnknown && bt.clock.Since(o.createdAt) > unknownOrderTimeout {
+				bt.applyOrderUpdateLocked(o, models.Order{
+					ClientOrderID: o.clientOrderID,
+					FilledSize:    o.filledSize,
+					Status:        models.OrderStatusRejected,
+				})
+			}
+			bt.mu.Unlock()
+		default:
+			bt.logger.WithError(err).WithField("client_order_id", o.clientOrderID).Error("Failed to look up order")
+		}
+
+		bt.mu.RLock()
+		if o.status == models.OrderStatusUnknown {
+			resolved = false
+		}
+		bt.mu.RUnlock()
+	}
+	return resolved
+}
+
+// lookupOrderLocked finds an open tracked order by client or exchange order
+// ID. Must be called with bt.mu held.
+func (bt *BasisTrader) lookupOrderLocked(orderID, clientOrderID string) (*trackedOrder, bool) {
+	if tracked, ok := bt.orders[clientOrderID]; ok && clientOrderID != "" {
+		return tracked, true
+	}
+	if key, ok := bt.orderIDs[orderID]; ok {
+		tracked, ok := bt.orders[key]
+		return tracked, ok
+	}
+	return nil, false
+}
+
+// handleOrderUpdate applies an order state from the user stream or a poll to
+// the trade it belongs to
+func (bt *BasisTrader) handleOrderUpdate(order models.Order) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	tracked, ok := bt.lookupOrderLocked(order.OrderID, order.ClientOrderID)
+	if !ok {
+		return
+	}
+
+	// Futures are reported in contracts, and tracked in the underlying
+	order.FilledSize = bt.instruments.fromContracts(tracked.symbol, order.FilledSize)
+	bt.applyOrderUpdateLocked(tracked, order)
+}
+
+// applyOrderUpdateLocked applies an order state, with sizes in the
+// underlying, to a tracked order and its trade. Must be called with bt.mu
+// held.
+func (bt *BasisTrader) applyOrderUpdateLocked(tracked *trackedOrder, order models.Order) {
+	if tracked.orderID == "" && order.OrderID != "" {
+		tracked.orderID = order.OrderID
+		bt.orderIDs[order.OrderID] = tracked.clientOrderID
+	}
+
+	// Updates can arrive out of order between the stream and polling
+	if order.FilledSize < tracked.filledSize {
+		return
+	}
+
+	tracked.status = order.Status
+	tracked.filledSize = order.FilledSize
+	if order.AvgFillPrice > 0 {
+		tracked.avgFillPrice = order.AvgFillPrice
+	}
+	tracked.updatedAt = bt.clock.Now()
+
+	if !tracked.open() {
+		delete(bt.orders, tracked.clientOrderID)
+		delete(bt.orderIDs, tracked.orderID)
+	}
+
+	ts, ok := bt.trades[tracked.tradeID]
+	if !ok {
+		bt.bookExecutionLocked(tracked, order.Price)
+		return
+	}
+	bt.bookExecutionLocked(tracked, ts.referencePrice(tracked.leg))
+	bt.syncLegLocked(ts, tracked.leg)
+	bt.saveOrderLocked(ts, tracked)
+	bt.advanceLocked(ts)
+	bt.saveTradeLocked(ts.trade)
+}
+
+// handleFill records an execution against a trade leg. Only the part of it
+// not already booked from a polled or refreshed order state is booked.
+func (bt *BasisTrader) handleFill(fill models.Fill) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	tracked, ok := bt.lookupOrderLocked(fill.OrderID, fill.ClientOrderID)
+	if !ok {
+		return
+	}
+	fill.Size = bt.instruments.fromContracts(tracked.symbol, fill.Size)
+	fill.OrderFilledSize = bt.instruments.fromContracts(tracked.symbol, fill.OrderFilledSize)
+
+	filled := fill.OrderFilledSize
+	if filled <= 0 {
+		filled = tracked.bookedSize + fill.Size
+	}
+	if filled > tracked.filledSize {
+		tracked.filledSize = filled
+	}
+	if size := math.Min(fill.Size, tracked.filledSize-tracked.bookedSize); size >= sizeEpsilon {
+		bt.bookFillLocked(tracked, size, fill.Price)
+	}
+
+	ts, ok := bt.trades[tracked.tradeID]
+	if !ok {
+		return
+	}
+
+	ts.trade.Fills = append(ts.trade.Fills, fill)
+	bt.syncLegLocked(ts, tracked.leg)
+	bt.saveOrderLocked(ts, tracked)
+	bt.saveFillLocked(ts.trade.ID, fill)
+	bt.logger.WithFields(logrus.Fields{
+		"trade_id": ts.trade.ID,
+		"leg":      tracked.leg,
+		"order_id": fill.OrderID,
+		"price":    fill.Price,
+		"size":     fill.Size,
+	}).Info("Order filled")
+}
+
+// monitorOrders polls open orders that the user stream is not keeping fresh
+func (bt *BasisTrader) monitorOrders(ctx context.Context) {
+	ticker := bt.clock.NewTicker(orderPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-bt.stopCh:
+			return
+		case <-ticker.C():
+			bt.pollOrders(ctx)
+		}
+	}
+}
+
+func (bt *BasisTrader) pollOrders(ctx context.Context) {
+	streaming := bt.feedUp(userFeedName)
+
+	var targets []*trackedOrder
+	bt.mu.RLock()
+	for _, tracked := range bt.orders {
+		// Unplaced legs have no exchange ID to look up yet
+		if tracked.orderID == "" {
+			continue
+		}
+		if streaming && bt.clock.Since(tracked.updatedAt) < orderPollAfter {
+			continue
+		}
+		targets = append(targets, tracked)
+	}
+	bt.mu.RUnlock()
+
+	for _, target := range targets {
+		order, err := target.client.GetOrder(ctx, target.orderID)
+		if err != nil {
+			bt.logger.WithError(err).WithField("order_id", target.orderID).Error("Failed to poll order")
+			continue
+		}
+		bt.handleOrderUpdate(*order)
+	}
+}
+
+// GetTrades returns every basis trade, most recent first
+func (bt *BasisTrader) GetTrades() []models.BasisTrade {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	trades := make([]models.BasisTrade, 0, len(bt.trades))
+	for _, ts := range bt.trades {
+		trades = append(trades, copyTrade(ts.trade))
+	}
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].CreatedAt.After(trades[j].CreatedAt)
+	})
+	return trades
+}
+
+// GetTrade returns a basis trade with its fills and state transitions
+func (bt *BasisTrader) GetTrade(tradeID string) (models.BasisTrade, bool) {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	ts, ok := bt.trades[tradeID]
+	if !ok {
+		return models.BasisTrade{}, false
+	}
+	return copyTrade(ts.trade), true
+}
+
+func copyTrade(trade *models.BasisTrade) models.BasisTrade {
+	t := *trade
+	t.Fills = append([]models.Fill(nil), trade.Fills...)
+	t.Transitions = append([]models.TradeTransition(nil), trade.Transitions...)
+	t.Closes = append([]models.TradeLink(nil), trade.Closes...)
+	return t
+}
+
+// entryBlockedLocked reports whether a strategy has a trade that has not
+// settled, or one that failed within the last order timeout. Must be called
+// with bt.mu held.
+func (bt *BasisTrader) entryBlockedLocked(strategyID string) bool {
+	for _, ts := range bt.trades {
+		if ts.trade.StrategyID != strategyID {
+			continue
+		}
+		if ts.trade.CompletedAt == nil {
+			return true
+		}
+		if ts.trade.Status == models.TradeStateFailed && bt.clock.Since(*ts.trade.CompletedAt) < bt.orderTimeout {
+			return true
+		}
+	}
+	return false
+}
+
+func isTerminalStatus(status models.OrderStatus) bool {
+	return status == models.OrderStatusFilled || isCancelledStatus(status)
+}
+
+func isCancelledStatus(status models.OrderStatus) bool {
+	return status == models.OrderStatusCancelled || status == models.OrderStatusRejected
+}
@@ -0,0 +1,111 @@
+package trader
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// trackTestOrder tracks a working order to buy 1 BTC-USD for strategy s-1,
+// outside any trade
+func trackTestOrder(bt *BasisTrader) *trackedOrder {
+	tracked := &trackedOrder{
+		strategyID:    "s-1",
+		leg:           legSpot,
+		symbol:        "BTC-USD",
+		orderID:       "o-1",
+		clientOrderID: "c-1",
+		side:          models.OrderSideBuy,
+		size:          1,
+		status:        models.OrderStatusNew,
+	}
+	bt.orders[tracked.clientOrderID] = tracked
+	bt.orderIDs[tracked.orderID] = tracked.clientOrderID
+	return tracked
+}
+
+func TestFillsAreBookedOnce(t *testing.T) {
+	update := func(filled float64) func(bt *BasisTrader) {
+		return func(bt *BasisTrader) {
+			bt.handleOrderUpdate(models.Order{OrderID: "o-1", FilledSize: filled, Price: 100, Status: models.OrderStatusPartiallyFilled})
+		}
+	}
+	fill := func(size, filled float64) func(bt *BasisTrader) {
+		return func(bt *BasisTrader) {
+			bt.handleFill(models.Fill{OrderID: "o-1", Size: size, Price: 100, OrderFilledSize: filled})
+		}
+	}
+
+	tests := []struct {
+		name   string
+		events []func(bt *BasisTrader)
+		want   float64
+	}{
+		{name: "stream fill then order state", events: []func(*BasisTrader){fill(1, 1), update(1)}, want: 1},
+		{name: "polled state then stream fill", events: []func(*BasisTrader){update(1), fill(1, 1)}, want: 1},
+		{name: "poll between partial fills", events: []func(*BasisTrader){fill(0.4, 0.4), update(1), fill(0.6, 1)}, want: 1},
+		{name: "repeated fill", events: []func(*BasisTrader){fill(0.5, 0.5), fill(0.5, 0.5)}, want: 0.5},
+		{name: "fill without order size", events: []func(*BasisTrader){fill(0.5, 0), fill(0.5, 0)}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bt := NewBasisTrader(nil, nil, testLogger())
+			trackTestOrder(bt)
+
+			for _, event := range tt.events {
+				event(bt)
+			}
+			if got := bt.ledger.position("s-1").spot.size; math.Abs(got-tt.want) > sizeEpsilon {
+				t.Errorf("booked %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolledFillsAreBookedAtAverageFillPrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		streamed []models.Fill
+		order    models.Order
+		want     float64
+	}{
+		{
+			name:  "average fill price",
+			order: models.Order{Price: 101, FilledSize: 1, AvgFillPrice: 99.5},
+			want:  99.5,
+		},
+		{
+			name:     "remainder after streamed fills",
+			streamed: []models.Fill{{Size: 0.4, Price: 98, OrderFilledSize: 0.4}},
+			order:    models.Order{Price: 101, FilledSize: 1, AvgFillPrice: 99.5},
+			want:     99.5,
+		},
+		{
+			name:  "no average reported",
+			order: models.Order{Price: 101, FilledSize: 1},
+			want:  101,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bt := NewBasisTrader(nil, nil, testLogger())
+			tracked := trackTestOrder(bt)
+
+			for _, fill := range tt.streamed {
+				fill.OrderID = tracked.orderID
+				bt.handleFill(fill)
+			}
+			order := tt.order
+			order.OrderID, order.Status = tracked.orderID, models.OrderStatusFilled
+			bt.handleOrderUpdate(order)
+
+			spot := bt.ledger.position("s-1").spot
+			if math.Abs(spot.size-1) > sizeEpsilon || math.Abs(spot.avgPrice-tt.want) > 1e-9 {
+				t.Errorf("booked %g at %g, want 1 at %g", spot.size, spot.avgPrice, tt.want)
+			}
+		})
+	}
+}
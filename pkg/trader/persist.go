@@ -0,0 +1,246 @@
+package trader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gregtusar/basis/internal/storage"
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+const (
+	// persistQueueSize bounds the writes waiting on the store
+	persistQueueSize = 1024
+
+	// persistTimeout bounds a single write to the store
+	persistTimeout = 5 * time.Second
+
+	// defaultSnapshotInterval is how often basis snapshots are recorded
+	defaultSnapshotInterval = time.Minute
+)
+
+// persistOp is a write to the store. It must only touch data copied while
+// bt.mu was held.
+type persistOp func(ctx context.Context, store storage.Repository) error
+
+// SetStore persists strategies, trades, orders, fills and basis history to
+// store. Strategy changes are written synchronously; trade activity is
+// written in order by a background writer so the store never stalls trading.
+func (bt *BasisTrader) SetStore(store storage.Repository) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	bt.store = store
+	bt.persistQueue = make(chan persistOp, persistQueueSize)
+	bt.persistDone = make(chan struct{})
+	go bt.runPersister(store, bt.persistQueue, bt.persistDone)
+}
+
+// SetSnapshotInterval sets how often basis snapshots are recorded to the store
+func (bt *BasisTrader) SetSnapshotInterval(interval time.Duration) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if interval > 0 {
+		bt.snapshotInterval = interval
+	}
+}
+
+// LoadStrategies adds the strategies persisted in the store. Start calls it
+// during reconciliation.
+func (bt *BasisTrader) LoadStrategies(ctx context.Context) error {
+	bt.mu.RLock()
+	store := bt.store
+	bt.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+
+	strategies, err := store.ListStrategies(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load strategies: %w", err)
+	}
+
+	var added []*models.BasisStrategy
+	bt.mu.Lock()
+	for i := range strategies {
+		if _, exists := bt.strategies[strategies[i].ID]; exists {
+			continue
+		}
+		bt.strategies[strategies[i].ID] = &strategies[i]
+
+		// A strategy whose model cannot be built is kept, so its trades and
+		// positions are still tracked, but never trades
+		if model, err := newStrategy(&strategies[i]); err != nil {
+			bt.logger.WithError(err).WithField("strategy_id", strategies[i].ID).Error("Strategy will not trade")
+		} else {
+			bt.runners[strategies[i].ID] = &strategyRunner{model: model}
+		}
+		added = append(added, &strategies[i])
+	}
+	running := bt.running
+	bt.mu.Unlock()
+
+	bt.logger.WithField("count", len(added)).Info("Loaded strategies")
+
+	for _, strategy := range added {
+		bt.warmUp(ctx, store, strategy)
+	}
+
+	if running {
+		for _, strategy := range added {
+			bt.subscribeMarketData(strategy)
+			bt.subscribeOrderUpdates(strategy)
+		}
+	}
+	return nil
+}
+
+// runPersister applies queued writes until the trader stops, then drains the
+// queue
+func (bt *BasisTrader) runPersister(store storage.Repository, queue chan persistOp, done chan struct{}) {
+	defer close(done)
+
+	for {
+		select {
+		case op := <-queue:
+			bt.applyPersist(store, op)
+		case <-bt.stopCh:
+			for {
+				select {
+				case op := <-queue:
+					bt.applyPersist(store, op)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (bt *BasisTrader) applyPersist(store storage.Repository, op persistOp) {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	if err := op(ctx, store); err != nil {
+		bt.logger.WithError(err).Error("Failed to persist trader state")
+	}
+}
+
+// persistLocked queues a write. It blocks while the queue is full, which the
+// writer drains without taking bt.mu. Must be called with bt.mu held.
+func (bt *BasisTrader) persistLocked(op persistOp) {
+	if bt.store == nil {
+		return
+	}
+
+	select {
+	case bt.persistQueue <- op:
+	case <-bt.persistDone:
+		// Stopped; the write is dropped
+	}
+}
+
+// saveTradeLocked queues a write of the trade. Must be called with bt.mu
+// held.
+func (bt *BasisTrader) saveTradeLocked(trade *models.BasisTrade) {
+	t := copyTrade(trade)
+	bt.persistLocked(func(ctx context.Context, store storage.Repository) error {
+		return store.SaveTrade(ctx, &t)
+	})
+}
+
+// saveOrderLocked queues a write of a leg order. Must be called with bt.mu
+// held.
+func (bt *BasisTrader) saveOrderLocked(ts *tradeState, o *trackedOrder) {
+	record := storage.OrderRecord{
+		ClientOrderID: o.clientOrderID,
+		OrderID:       o.orderID,
+		TradeID:       ts.trade.ID,
+		Leg:           o.leg,
+		Symbol:        o.symbol,
+		Side:          o.side,
+		Type:          o.orderType,
+		Price:         o.price,
+		Size:          o.size,
+		FilledSize:    o.filledSize,
+		AvgFillPrice:  o.avgFillPrice,
+		Status:        o.status,
+		ReduceOnly:    o.reduceOnly,
+		CreatedAt:     o.createdAt,
+		UpdatedAt:     o.updatedAt,
+	}
+	bt.persistLocked(func(ctx context.Context, store storage.Repository) error {
+		return store.SaveOrder(ctx, &record)
+	})
+}
+
+// saveFillLocked queues a write of a fill. Must be called with bt.mu held.
+func (bt *BasisTrader) saveFillLocked(tradeID string, fill models.Fill) {
+	bt.persistLocked(func(ctx context.Context, store storage.Repository) error {
+		return store.SaveFill(ctx, tradeID, &fill)
+	})
+}
+
+// recordSnapshots periodically records each strategy's basis
+func (bt *BasisTrader) recordSnapshots(ctx context.Context) {
+	bt.mu.RLock()
+	interval := bt.snapshotInterval
+	bt.mu.RUnlock()
+
+	ticker := bt.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-bt.stopCh:
+			return
+		case <-ticker.C():
+			bt.recordSnapshot()
+		}
+	}
+}
+
+// recordSnapshotIfDue records a snapshot once the snapshot interval has
+// passed since the last. Step calls it in place of recordSnapshots' ticker.
+func (bt *BasisTrader) recordSnapshotIfDue() {
+	bt.mu.RLock()
+	due := bt.lastSnapshot.IsZero() || bt.clock.Since(bt.lastSnapshot) >= bt.snapshotInterval
+	bt.mu.RUnlock()
+
+	if due {
+		bt.recordSnapshot()
+	}
+}
+
+// recordSnapshot passes each strategy's basis to its model, active or not,
+// and stores it
+func (bt *BasisTrader) recordSnapshot() {
+	bt.mu.Lock()
+	bt.lastSnapshot = bt.clock.Now()
+	strategies := make([]*models.BasisStrategy, 0, len(bt.strategies))
+	for _, s := range bt.strategies {
+		strategies = append(strategies, s)
+	}
+	bt.mu.Unlock()
+
+	for _, strategy := range strategies {
+		basis := bt.calculateBasis(strategy)
+		if basis == nil {
+			continue
+		}
+		if runner := bt.runnerFor(strategy.ID); runner != nil {
+			runner.observe(*basis)
+		}
+
+		record := storage.SnapshotRecord{StrategyID: strategy.ID, BasisSnapshot: *basis}
+		bt.mu.RLock()
+		bt.persistLocked(func(ctx context.Context, store storage.Repository) error {
+			return store.SaveSnapshot(ctx, &record)
+		})
+		bt.mu.RUnlock()
+	}
+}
@@ -0,0 +1,189 @@
+package trader
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// defaultMaxSlippage is the furthest, as a fraction of the mid price, the
+// expected average execution price of a leg may be from the mid
+const defaultMaxSlippage = 0.01
+
+// legQuote is the expected execution of one leg of a trade
+type legQuote struct {
+	price    float64 // volume-weighted average
+	limit    float64 // limit price the order is placed at
+	mid      float64
+	slippage float64 // of price from mid, as a fraction of mid
+	size     float64 // what the book can absorb within the slippage limit
+}
+
+// executionPlan is a trade priced against the books: the size both legs can
+// absorb, and for entries and exits the strategy's basis at their expected
+// execution prices
+type executionPlan struct {
+	spot, future           *tradeLeg
+	spotQuote, futureQuote legQuote
+	size                   float64
+	basis                  *models.BasisSnapshot
+}
+
+// SetMaxSlippage sets how far, as a fraction of the mid price, a leg's
+// expected average execution price may be from the mid. Trades are sized
+// down to what the books can absorb within it.
+func (bt *BasisTrader) SetMaxSlippage(maxSlippage float64) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if maxSlippage > 0 {
+		bt.maxSlippage = maxSlippage
+	}
+}
+
+// planTrade prices up to size on both legs against their books, sizing down
+// to what both can absorb within the slippage limit. It fails if either leg
+// has no price or cannot absorb any size.
+func (bt *BasisTrader) planTrade(spot, future *tradeLeg, size float64) (*executionPlan, error) {
+	bt.mu.RLock()
+	maxSlippage := bt.maxSlippage
+	bt.mu.RUnlock()
+
+	plan := &executionPlan{spot: spot, future: future, size: size}
+	for {
+		var err error
+		if plan.spotQuote, err = bt.quoteLeg(spot.symbol, spot.side, plan.size, maxSlippage); err != nil {
+			return nil, err
+		}
+		if plan.futureQuote, err = bt.quoteLeg(future.symbol, future.side, plan.size, maxSlippage); err != nil {
+			return nil, err
+		}
+
+		absorbed := math.Min(plan.spotQuote.size, plan.futureQuote.size)
+		if absorbed >= plan.size-sizeEpsilon {
+			return plan, nil
+		}
+		// Requote the smaller size, which moves both averages
+		plan.size = absorbed
+	}
+}
+
+// quoteLeg walks symbol's order book, or the top of book in its ticker when
+// no book is maintained, for size on side
+func (bt *BasisTrader) quoteLeg(symbol string, side models.OrderSide, size, maxSlippage float64) (legQuote, error) {
+	levels, mid, fromBook, err := bt.depth(symbol, side)
+	if err != nil {
+		return legQuote{}, err
+	}
+
+	filled, price, worst := walkBook(levels, side, size, mid, maxSlippage)
+	if filled < sizeEpsilon || bt.instruments.toContracts(symbol, filled) < sizeEpsilon {
+		return legQuote{}, fmt.Errorf("%s book cannot absorb any size within %.4f%% slippage", symbol, maxSlippage*100)
+	}
+
+	quote := legQuote{
+		price:    price,
+		limit:    worst,
+		mid:      mid,
+		slippage: math.Abs(price-mid) / mid,
+		size:     filled,
+	}
+	if !fromBook {
+		// The ticker may lag the book, so leave room for it to have moved
+		quote.limit = throughMarket(worst, side)
+	}
+	return quote, nil
+}
+
+// depth returns the levels an order on side of symbol would take, best
+// first with sizes in the underlying, and the mid price. Without a book the
+// ticker's top of book is used; a touch with no size has no known liquidity,
+// so no trade is sized against it.
+func (bt *BasisTrader) depth(symbol string, side models.OrderSide) (levels []models.OrderBookLevel, mid float64, fromBook bool, err error) {
+	if book, ok := bt.marketData.books.Book(symbol, 0); ok && len(book.Bids) > 0 && len(book.Asks) > 0 {
+		levels = book.Asks
+		if side == models.OrderSideSell {
+			levels = book.Bids
+		}
+		for i := range levels {
+			levels[i].Size = bt.instruments.fromContracts(symbol, levels[i].Size)
+		}
+		return levels, (book.Bids[0].Price + book.Asks[0].Price) / 2, true, nil
+	}
+
+	ticker, age, ok := bt.marketData.ticker(symbol)
+	if !ok || age > maxTickerAge {
+		return nil, 0, false, fmt.Errorf("no recent price for %s", symbol)
+	}
+
+	touch := models.OrderBookLevel{Price: ticker.AskPrice, Size: ticker.AskSize}
+	if side == models.OrderSideSell {
+		touch = models.OrderBookLevel{Price: ticker.BidPrice, Size: ticker.BidSize}
+	}
+	mid = ticker.LastPrice
+	if ticker.BidPrice > 0 && ticker.AskPrice > 0 {
+		mid = (ticker.BidPrice + ticker.AskPrice) / 2
+	}
+	if touch.Price <= 0 {
+		touch = models.OrderBookLevel{Price: ticker.LastPrice}
+	}
+	if touch.Price <= 0 || mid <= 0 {
+		return nil, 0, false, fmt.Errorf("no price for %s", symbol)
+	}
+
+	touch.Size = bt.instruments.fromContracts(symbol, touch.Size)
+	if touch.Size <= 0 {
+		return nil, 0, false, fmt.Errorf("no order book for %s and its ticker has no size at the touch", symbol)
+	}
+	return []models.OrderBookLevel{touch}, mid, false, nil
+}
+
+// walkBook fills up to size against levels, best first, stopping where the
+// average price would move more than maxSlippage from mid. It returns the
+// size filled, its average price and the worst price reached. A maxSlippage
+// of zero is unlimited.
+func walkBook(levels []models.OrderBookLevel, side models.OrderSide, size, mid, maxSlippage float64) (filled, price, worst float64) {
+	bound := math.Inf(1)
+	if side == models.OrderSideSell {
+		bound = math.Inf(-1)
+	}
+	if maxSlippage > 0 {
+		bound = mid * (1 + maxSlippage)
+		if side == models.OrderSideSell {
+			bound = mid * (1 - maxSlippage)
+		}
+	}
+
+	var notional float64
+	for _, level := range levels {
+		if filled >= size-sizeEpsilon {
+			break
+		}
+
+		take := math.Min(level.Size, size-filled)
+		beyond := level.Price > bound
+		if side == models.OrderSideSell {
+			beyond = level.Price < bound
+		}
+		if beyond {
+			// Take only what keeps the average at the bound
+			take = math.Min(take, (bound*filled-notional)/(level.Price-bound))
+		}
+		if take < sizeEpsilon {
+			break
+		}
+
+		notional += take * level.Price
+		filled += take
+		worst = level.Price
+		if beyond {
+			break
+		}
+	}
+
+	if filled < sizeEpsilon {
+		return 0, 0, 0
+	}
+	return filled, notional / filled, worst
+}
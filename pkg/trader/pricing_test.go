@@ -0,0 +1,129 @@
+package trader
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+func TestWalkBook(t *testing.T) {
+	asks := []models.OrderBookLevel{{Price: 100, Size: 1}, {Price: 101, Size: 1}, {Price: 102, Size: 1}}
+	bids := []models.OrderBookLevel{{Price: 100, Size: 1}, {Price: 98, Size: 2}}
+
+	tests := []struct {
+		name        string
+		levels      []models.OrderBookLevel
+		side        models.OrderSide
+		size        float64
+		mid         float64
+		maxSlippage float64
+		filled      float64
+		price       float64
+		worst       float64
+	}{
+		{name: "within first level", levels: asks, side: models.OrderSideBuy, size: 0.5, mid: 99.9, filled: 0.5, price: 100, worst: 100},
+		{name: "volume-weighted across levels", levels: asks, side: models.OrderSideBuy, size: 2.5, mid: 99.9, filled: 2.5, price: 100.8, worst: 102},
+		{name: "more than the book holds", levels: asks, side: models.OrderSideBuy, size: 5, mid: 99.9, filled: 3, price: 101, worst: 102},
+		{name: "buy capped at max slippage", levels: asks, side: models.OrderSideBuy, size: 3, mid: 100, maxSlippage: 0.005, filled: 2, price: 100.5, worst: 101},
+		{name: "sell capped at max slippage", levels: bids, side: models.OrderSideSell, size: 3, mid: 100, maxSlippage: 0.01, filled: 2, price: 99, worst: 98},
+		{name: "touch beyond max slippage", levels: asks, side: models.OrderSideBuy, size: 1, mid: 95, maxSlippage: 0.01},
+		{name: "empty book", side: models.OrderSideSell, size: 1, mid: 100, maxSlippage: 0.01},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filled, price, worst := walkBook(tt.levels, tt.side, tt.size, tt.mid, tt.maxSlippage)
+			if math.Abs(filled-tt.filled) > sizeEpsilon || math.Abs(price-tt.price) > 1e-9 || worst != tt.worst {
+				t.Errorf("walkBook = %g at %g to %g, want %g at %g to %g", filled, price, worst, tt.filled, tt.price, tt.worst)
+			}
+		})
+	}
+}
+
+func TestPlanTrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		spot, perp  models.Ticker
+		size        float64
+		wantSize    float64
+		wantErr     string
+		spotLimit   float64
+		futurePrice float64
+	}{
+		{
+			name:        "full size at the touch",
+			spot:        models.Ticker{BidPrice: 99.9, BidSize: 5, AskPrice: 100, AskSize: 5, LastPrice: 100},
+			perp:        models.Ticker{BidPrice: 101, BidSize: 5, AskPrice: 101.1, AskSize: 5, LastPrice: 101},
+			size:        1,
+			wantSize:    1,
+			spotLimit:   100 * 1.001,
+			futurePrice: 101,
+		},
+		{
+			name:        "sized down to the smaller touch",
+			spot:        models.Ticker{BidPrice: 99.9, BidSize: 5, AskPrice: 100, AskSize: 5, LastPrice: 100},
+			perp:        models.Ticker{BidPrice: 101, BidSize: 0.4, AskPrice: 101.1, AskSize: 5, LastPrice: 101},
+			size:        1,
+			wantSize:    0.4,
+			spotLimit:   100 * 1.001,
+			futurePrice: 101,
+		},
+		{
+			name:    "missing touch size",
+			spot:    models.Ticker{BidPrice: 99.9, BidSize: 5, AskPrice: 100, LastPrice: 100},
+			perp:    models.Ticker{BidPrice: 101, BidSize: 5, AskPrice: 101.1, AskSize: 5, LastPrice: 101},
+			size:    1,
+			wantErr: "no size at the touch",
+		},
+		{
+			name:    "missing touch falls back to last price without size",
+			spot:    models.Ticker{LastPrice: 100, LastSize: 3},
+			perp:    models.Ticker{BidPrice: 101, BidSize: 5, AskPrice: 101.1, AskSize: 5, LastPrice: 101},
+			size:    1,
+			wantErr: "no size at the touch",
+		},
+		{
+			name:    "touch beyond max slippage",
+			spot:    models.Ticker{BidPrice: 90, BidSize: 5, AskPrice: 110, AskSize: 5, LastPrice: 100},
+			perp:    models.Ticker{BidPrice: 101, BidSize: 5, AskPrice: 101.1, AskSize: 5, LastPrice: 101},
+			size:    1,
+			wantErr: "cannot absorb",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bt := NewBasisTrader(nil, nil, testLogger())
+			tt.spot.Symbol, tt.spot.Timestamp = "BTC-USD", time.Now()
+			tt.perp.Symbol, tt.perp.Timestamp = "BTC-PERP", time.Now()
+			bt.UpdateTicker(tt.spot)
+			bt.UpdateTicker(tt.perp)
+
+			spot := &tradeLeg{name: legSpot, symbol: "BTC-USD", side: models.OrderSideBuy}
+			future := &tradeLeg{name: legFuture, symbol: "BTC-PERP", side: models.OrderSideSell}
+			plan, err := bt.planTrade(spot, future, tt.size)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("planTrade error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("planTrade: %v", err)
+			}
+
+			if math.Abs(plan.size-tt.wantSize) > sizeEpsilon {
+				t.Errorf("size = %g, want %g", plan.size, tt.wantSize)
+			}
+			if math.Abs(plan.spotQuote.limit-tt.spotLimit) > 1e-9 {
+				t.Errorf("spot limit = %g, want %g through the ask", plan.spotQuote.limit, tt.spotLimit)
+			}
+			if math.Abs(plan.futureQuote.price-tt.futurePrice) > 1e-9 {
+				t.Errorf("future price = %g, want %g", plan.futureQuote.price, tt.futurePrice)
+			}
+		})
+	}
+}
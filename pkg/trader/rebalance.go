@@ -0,0 +1,77 @@
+package trader
+
+import (
+	"math"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// SetRebalanceThreshold sets how far, as a fraction of MaxPosition, a
+// strategy's position may drift from its model's target before it is traded
+// back, for strategies that do not set RebalanceThreshold
+func (bt *BasisTrader) SetRebalanceThreshold(threshold float64) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if threshold >= 0 {
+		bt.rebalanceThreshold = threshold
+	}
+}
+
+// rebalanceBand is how far, in the underlying, a strategy's position may
+// drift from its target before it is traded back
+func (bt *BasisTrader) rebalanceBand(strategy *models.BasisStrategy) float64 {
+	threshold := strategy.RebalanceThreshold
+	if threshold <= 0 {
+		bt.mu.RLock()
+		threshold = bt.rebalanceThreshold
+		bt.mu.RUnlock()
+	}
+	return threshold * strategy.MaxPosition
+}
+
+// rebalanceChunk is the most a strategy trades per evaluation while
+// rebalancing
+func rebalanceChunk(strategy *models.BasisStrategy) float64 {
+	if strategy.RebalanceChunk > 0 {
+		return strategy.RebalanceChunk
+	}
+	return strategy.MinTradeSize
+}
+
+// rebalanceStep returns the signed size of a strategy's next step from
+// position toward target, within MaxPosition, zero if none is due. Exits are
+// never held back by the band.
+func (bt *BasisTrader) rebalanceStep(strategy *models.BasisStrategy, runner *strategyRunner, position float64, target *Target) float64 {
+	goal := math.Max(0, math.Min(target.Size, strategy.MaxPosition))
+	band := bt.rebalanceBand(strategy)
+	if goal == 0 || target.Exit {
+		band = 0
+	}
+	return runner.rebalance(position, goal, band, rebalanceChunk(strategy))
+}
+
+// rebalance returns the signed size of the next step from position toward
+// target, zero if none is due. Rebalancing starts once the position is more
+// than band from the target, and continues one chunk at a time until the
+// target is reached, even once back within the band. A target that moves to
+// the other side of the position must clear the band again.
+func (r *strategyRunner) rebalance(position, target, band, chunk float64) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deviation := target - position
+	if math.Abs(deviation) < sizeEpsilon {
+		r.rebalancing = 0
+		return 0
+	}
+	if math.Signbit(deviation) != math.Signbit(r.rebalancing) {
+		r.rebalancing = 0
+	}
+	if r.rebalancing == 0 && math.Abs(deviation) <= band {
+		return 0
+	}
+
+	r.rebalancing = math.Copysign(1, deviation)
+	return math.Copysign(math.Min(math.Abs(deviation), chunk), deviation)
+}
@@ -0,0 +1,99 @@
+package trader
+
+import (
+	"io"
+	"math"
+	"testing"
+
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestRunnerRebalance(t *testing.T) {
+	type step struct {
+		position, target float64
+		want             float64
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "within band",
+			steps: []step{{position: 0, target: 0.2, want: 0}},
+		},
+		{
+			name:  "beyond band trades a chunk",
+			steps: []step{{position: 0, target: 1, want: 0.3}},
+		},
+		{
+			name:  "last step stops at the target",
+			steps: []step{{position: 0.9, target: 0.3, want: -0.3}, {position: 0.6, target: 0.3, want: -0.3}},
+		},
+		{
+			name: "continues within band until reached",
+			steps: []step{
+				{position: 0, target: 0.5, want: 0.3},
+				{position: 0.3, target: 0.5, want: 0.2},
+				{position: 0.5, target: 0.5, want: 0},
+				{position: 0.5, target: 0.7, want: 0},
+			},
+		},
+		{
+			name: "reversal clears the band again",
+			steps: []step{
+				{position: 0, target: 0.5, want: 0.3},
+				{position: 0.3, target: 0.2, want: 0},
+				{position: 0.3, target: 0, want: -0.3},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &strategyRunner{}
+			for i, s := range tt.steps {
+				if got := runner.rebalance(s.position, s.target, 0.25, 0.3); math.Abs(got-s.want) > sizeEpsilon {
+					t.Fatalf("step %d: rebalance(%g, %g) = %g, want %g", i, s.position, s.target, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRebalanceStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunk    float64
+		position float64
+		target   Target
+		want     float64
+	}{
+		{name: "drift within band", position: 0.9, target: Target{Size: 1}, want: 0},
+		{name: "drift beyond band", position: 0.5, target: Target{Size: 1}, want: 0.1},
+		{name: "chunk", chunk: 0.4, position: 0.5, target: Target{Size: 1}, want: 0.4},
+		{name: "target above max position", chunk: 2, position: 0, target: Target{Size: 3}, want: 1},
+		{name: "exit to zero within band", position: 0.05, target: Target{}, want: -0.05},
+		{name: "exit signal within band", position: 0.6, target: Target{Size: 0.5, Exit: true}, want: -0.1},
+		{name: "reduction within band", position: 0.6, target: Target{Size: 0.5}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bt := NewBasisTrader(nil, nil, testLogger())
+			bt.SetRebalanceThreshold(0.2)
+			strategy := &models.BasisStrategy{MaxPosition: 1, MinTradeSize: 0.1, RebalanceChunk: tt.chunk}
+
+			got := bt.rebalanceStep(strategy, &strategyRunner{}, tt.position, &tt.target)
+			if math.Abs(got-tt.want) > sizeEpsilon {
+				t.Errorf("rebalanceStep(%g, %+v) = %g, want %g", tt.position, tt.target, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,583 @@
+package trader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gregtusar/basis/internal/storage"
+	"github.com/gregtusar/basis/pkg/coinbase"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+// OrphanPolicy decides what reconciliation does with working exchange orders
+// that no persisted trade owns
+type OrphanPolicy string
+
+const (
+	// OrphanPolicyCancel cancels orphaned orders
+	OrphanPolicyCancel OrphanPolicy = "cancel"
+
+	// OrphanPolicyAdopt tracks orphaned orders and books their fills to the
+	// strategy trading the symbol. Orders on symbols shared by several
+	// strategies cannot be attributed and block trading.
+	OrphanPolicyAdopt OrphanPolicy = "adopt"
+)
+
+const (
+	// reconcileRetryInterval is how often an inconsistent reconciliation is
+	// retried
+	reconcileRetryInterval = 30 * time.Second
+
+	// clientOrderLookback widens the search for orders placed just before
+	// their trade was recorded
+	clientOrderLookback = time.Minute
+)
+
+// clientOrderFinder is implemented by clients that can look up an order by
+// client order ID, for orders recorded before the exchange acknowledged them
+type clientOrderFinder interface {
+	GetOrderByClientID(ctx context.Context, symbol, clientOrderID string, since time.Time) (*models.Order, error)
+}
+
+// SetOrphanPolicy sets how reconciliation treats orphaned exchange orders
+func (bt *BasisTrader) SetOrphanPolicy(policy OrphanPolicy) error {
+	switch policy {
+	case OrphanPolicyCancel, OrphanPolicyAdopt:
+	case "":
+		policy = OrphanPolicyCancel
+	default:
+		return fmt.Errorf("unknown orphan order policy %q", policy)
+	}
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	bt.orphanPolicy = policy
+	return nil
+}
+
+// GetReconcileResult returns the latest reconciliation, if one has run
+func (bt *BasisTrader) GetReconcileResult() (models.ReconcileResult, bool) {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	if bt.reconcileResult == nil {
+		return models.ReconcileResult{}, false
+	}
+	return *bt.reconcileResult, true
+}
+
+// TradingEnabled reports whether reconciliation has passed and strategies
+// may open and close positions
+func (bt *BasisTrader) TradingEnabled() bool {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	return bt.reconciled
+}
+
+// Reconcile rebuilds trader state from the store and matches it against
+// working orders and positions on both exchanges. Trading is enabled only if
+// the result is consistent. With acceptPositions, position mismatches are
+// recorded as external inventory instead of blocking trading. Live trading
+// also needs a durable store, so there is state to reconcile after a restart.
+func (bt *BasisTrader) Reconcile(ctx context.Context, acceptPositions bool) models.ReconcileResult {
+	bt.reconcileMu.Lock()
+	defer bt.reconcileMu.Unlock()
+
+	result := models.ReconcileResult{StartedAt: bt.clock.Now()}
+	problem := func(format string, args ...interface{}) {
+		result.Problems = append(result.Problems, fmt.Sprintf(format, args...))
+	}
+
+	bt.mu.RLock()
+	store, restored := bt.store, bt.restored
+	bt.mu.RUnlock()
+
+	if store != nil && !restored {
+		if err := bt.LoadStrategies(ctx); err != nil {
+			problem("%v", err)
+		}
+		if err := bt.restoreTrades(ctx, store, &result); err != nil {
+			problem("%v", err)
+		}
+		if err := bt.restoreFunding(ctx, store); err != nil {
+			problem("%v", err)
+		}
+		if len(result.Problems) == 0 {
+			bt.mu.Lock()
+			bt.restored = true
+			bt.mu.Unlock()
+		}
+	}
+
+	if _, memory := store.(*storage.MemoryStore); (store == nil || memory) && !bt.simulated() {
+		problem("no durable store is configured, live orders and positions would be lost on restart")
+	}
+
+	bt.mu.RLock()
+	result.Strategies = len(bt.strategies)
+	bt.mu.RUnlock()
+
+	bt.refreshRestoredOrders(ctx, &result, problem)
+	bt.resolveOrphans(ctx, &result, problem)
+	bt.checkPositions(ctx, &result, acceptPositions, problem)
+
+	result.CompletedAt = bt.clock.Now()
+	result.Consistent = len(result.Problems) == 0
+
+	bt.mu.Lock()
+	bt.reconciled = result.Consistent
+	bt.reconcileResult = &result
+	bt.mu.Unlock()
+
+	entry := bt.logger.WithFields(logrus.Fields{
+		"strategies":       result.Strategies,
+		"trades_restored":  len(result.TradesRestored),
+		"orders_refreshed": result.OrdersRefreshed,
+		"orders_adopted":   len(result.OrdersAdopted),
+		"orders_cancelled": len(result.OrdersCancelled),
+	})
+	if result.Consistent {
+		entry.Info("Reconciliation complete, trading enabled")
+	} else {
+		entry.WithField("problems", result.Problems).Error("Reconciliation found inconsistencies, trading halted")
+	}
+	return result
+}
+
+// simulated reports whether both legs trade against the paper simulator, as
+// in backtests and paper trading, so no state needs to survive a restart
+func (bt *BasisTrader) simulated() bool {
+	_, spotPaper := bt.spotClient.(*coinbase.PaperClient)
+	_, futurePaper := bt.futureClient.(*coinbase.PaperClient)
+	return spotPaper && futurePaper
+}
+
+// retryReconcile re-runs reconciliation until it is consistent
+func (bt *BasisTrader) retryReconcile(ctx context.Context) {
+	ticker := bt.clock.NewTicker(reconcileRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-bt.stopCh:
+			return
+		case <-ticker.C():
+			if bt.TradingEnabled() {
+				return
+			}
+			if bt.Reconcile(ctx, false).Consistent {
+				return
+			}
+		}
+	}
+}
+
+// restoreTrades loads unsettled trades, settled entries that are still open,
+// and settled rolls of a roll still in progress, with their orders. Fills
+// recorded on those orders are booked to the position ledger.
+func (bt *BasisTrader) restoreTrades(ctx context.Context, store storage.Repository, result *models.ReconcileResult) error {
+	trades, err := store.ListTrades(ctx, storage.TradeQuery{})
+	if err != nil {
+		return fmt.Errorf("failed to load trades: %w", err)
+	}
+
+	for i := range trades {
+		trade := &trades[i]
+		unsettled := trade.CompletedAt == nil
+		open := trade.Side == tradeSideEnter && matchedSize(trade)-trade.ClosedSize > sizeEpsilon
+		if trade.Side == tradeSideRoll {
+			// The rolled size has left the future the strategy still trades
+			bt.mu.RLock()
+			strategy, ok := bt.strategies[trade.StrategyID]
+			open = ok && strategy.FutureSymbol == trade.SpotSymbol
+			bt.mu.RUnlock()
+		}
+		if !unsettled && !open {
+			continue
+		}
+
+		// Settled entries only need their open size; their orders are done
+		var orders []storage.OrderRecord
+		if unsettled {
+			orders, err = store.ListOrders(ctx, trade.ID)
+			if err != nil {
+				return fmt.Errorf("failed to load orders for trade %s: %w", trade.ID, err)
+			}
+		}
+
+		bt.mu.Lock()
+		if _, exists := bt.trades[trade.ID]; !exists {
+			bt.restoreTradeLocked(trade, orders)
+			if unsettled {
+				result.TradesRestored = append(result.TradesRestored, trade.ID)
+			}
+		}
+		bt.mu.Unlock()
+	}
+	return nil
+}
+
+// restoreTradeLocked rebuilds a trade's working state from its persisted
+// orders. A settled entry books only the size exits have not closed, since
+// the exits that closed the rest are not restored, and books it to the
+// strategy's current future, since completed rolls that moved it are not
+// restored either. Must be called with bt.mu held.
+func (bt *BasisTrader) restoreTradeLocked(trade *models.BasisTrade, orders []storage.OrderRecord) {
+	spotSide, futureSide := models.OrderSideBuy, models.OrderSideSell
+	if trade.Side == tradeSideExit {
+		spotSide, futureSide = futureSide, spotSide
+	}
+
+	var spotSymbol, futureSymbol string
+	if strategy, ok := bt.strategies[trade.StrategyID]; ok {
+		spotSymbol, futureSymbol = strategy.SpotSymbol, strategy.FutureSymbol
+	}
+	if trade.CompletedAt == nil || trade.Side == tradeSideRoll {
+		if trade.SpotSymbol != "" {
+			spotSymbol = trade.SpotSymbol
+		}
+		if trade.FutureSymbol != "" {
+			futureSymbol = trade.FutureSymbol
+		}
+	}
+	for _, o := range orders {
+		if o.Leg == legFuture {
+			futureSymbol = o.Symbol
+		} else {
+			spotSymbol = o.Symbol
+		}
+	}
+
+	spotClient := bt.spotClient
+	if trade.Side == tradeSideRoll {
+		spotClient = bt.futureClient
+	}
+	ts := newTradeState(trade,
+		&tradeLeg{client: spotClient, symbol: spotSymbol, side: spotSide, reduceOnly: trade.Side == tradeSideRoll},
+		&tradeLeg{client: bt.futureClient, symbol: futureSymbol, side: futureSide, reduceOnly: trade.Side == tradeSideExit},
+	)
+
+	if trade.CompletedAt != nil {
+		open := matchedSize(trade) - trade.ClosedSize
+		bt.ledger.book(trade.StrategyID, ts.spotLeg().name, spotSymbol, spotSide, open, trade.SpotAvgPrice)
+		bt.ledger.book(trade.StrategyID, legFuture, futureSymbol, futureSide, open, trade.FutureAvgPrice)
+		bt.trades[trade.ID] = ts
+		return
+	}
+
+	// Timeouts run from when the trade last changed state
+	if n := len(trade.Transitions); n > 0 {
+		last := trade.Transitions[n-1].Time
+		ts.leg1FilledAt, ts.hedgeStartedAt, ts.lastChase = last, last, last
+	}
+	for _, t := range trade.Transitions {
+		if t.From == models.TradeStateHedging && t.To == models.TradeStateUnwinding {
+			ts.unwindAttempts++
+		}
+	}
+
+	tracked := make(map[string]*trackedOrder)
+	for _, o := range orders {
+		leg := ts.legs[o.Leg]
+		if leg == nil {
+			continue
+		}
+		order := &trackedOrder{
+			tradeID:       trade.ID,
+			strategyID:    trade.StrategyID,
+			leg:           o.Leg,
+			symbol:        o.Symbol,
+			client:        leg.client,
+			orderID:       o.OrderID,
+			clientOrderID: o.ClientOrderID,
+			side:          o.Side,
+			orderType:     o.Type,
+			price:         o.Price,
+			size:          o.Size,
+			status:        o.Status,
+			filledSize:    o.FilledSize,
+			avgFillPrice:  o.AvgFillPrice,
+			reduceOnly:    o.ReduceOnly,
+			createdAt:     o.CreatedAt,
+			updatedAt:     o.UpdatedAt,
+		}
+		leg.orders = append(leg.orders, order)
+		tracked[order.clientOrderID] = order
+		if order.orderID != "" {
+			tracked[order.orderID] = order
+		}
+
+		if order.open() {
+			bt.orders[order.clientOrderID] = order
+			if order.orderID != "" {
+				bt.orderIDs[order.orderID] = order.clientOrderID
+			}
+		}
+	}
+
+	// Book recorded fills at their prices, up to the filled size of their
+	// orders, then whatever the order states report beyond them
+	for _, fill := range trade.Fills {
+		order, ok := tracked[fill.OrderID]
+		if !ok {
+			order, ok = tracked[fill.ClientOrderID]
+		}
+		if !ok {
+			continue
+		}
+		size := math.Min(fill.Size, order.filledSize-order.bookedSize)
+		if size < sizeEpsilon {
+			continue
+		}
+		bt.ledger.book(order.strategyID, order.leg, order.symbol, order.side, size, fill.Price)
+		order.bookedSize += size
+		order.bookedValue += size * fill.Price
+	}
+	for _, o := range orders {
+		if order, ok := tracked[o.ClientOrderID]; ok {
+			bt.bookExecutionLocked(order, ts.referencePrice(o.Leg))
+		}
+	}
+
+	bt.trades[trade.ID] = ts
+}
+
+// refreshRestoredOrders brings restored working orders up to date. Orders the
+// exchange never acknowledged are marked rejected.
+func (bt *BasisTrader) refreshRestoredOrders(ctx context.Context, result *models.ReconcileResult,
+	problem func(string, ...interface{})) {
+	var targets []*trackedOrder
+	bt.mu.RLock()
+	for _, o := range bt.orders {
+		if o.tradeID != "" {
+			targets = append(targets, o)
+		}
+	}
+	bt.mu.RUnlock()
+
+	for _, o := range targets {
+		order, err := bt.lookupExchangeOrder(ctx, o)
+		if errors.Is(err, coinbase.ErrOrderNotFound) {
+			bt.mu.Lock()
+			bt.applyOrderUpdateLocked(o, models.Order{
+				OrderID:       o.orderID,
+				ClientOrderID: o.clientOrderID,
+				FilledSize:    o.filledSize,
+				Status:        models.OrderStatusRejected,
+			})
+			bt.mu.Unlock()
+			result.OrdersRefreshed++
+			continue
+		}
+		if err != nil {
+			problem("order %s: %v", o.clientOrderID, err)
+			continue
+		}
+		bt.handleOrderUpdate(*order)
+		result.OrdersRefreshed++
+	}
+}
+
+func (bt *BasisTrader) lookupExchangeOrder(ctx context.Context, o *trackedOrder) (*models.Order, error) {
+	if o.orderID != "" {
+		return o.client.GetOrder(ctx, o.orderID)
+	}
+
+	finder, ok := o.client.(clientOrderFinder)
+	if !ok {
+		return nil, fmt.Errorf("order was never acknowledged and the client cannot search by client order ID")
+	}
+	return finder.GetOrderByClientID(ctx, o.symbol, o.clientOrderID, o.createdAt.Add(-clientOrderLookback))
+}
+
+// resolveOrphans handles working exchange orders on strategy symbols that no
+// trade owns, per the orphan policy
+func (bt *BasisTrader) resolveOrphans(ctx context.Context, result *models.ReconcileResult,
+	problem func(string, ...interface{})) {
+	bt.mu.RLock()
+	policy := bt.orphanPolicy
+	owners := make(map[string][]*models.BasisStrategy)
+	var spotSymbols, futureSymbols []string
+	for _, s := range bt.strategies {
+		if len(owners[s.SpotSymbol]) == 0 {
+			spotSymbols = append(spotSymbols, s.SpotSymbol)
+		}
+		if len(owners[s.FutureSymbol]) == 0 {
+			futureSymbols = append(futureSymbols, s.FutureSymbol)
+		}
+		owners[s.SpotSymbol] = append(owners[s.SpotSymbol], s)
+		owners[s.FutureSymbol] = append(owners[s.FutureSymbol], s)
+	}
+	bt.mu.RUnlock()
+
+	venues := []struct {
+		leg     string
+		client  coinbase.Client
+		symbols []string
+	}{
+		{legSpot, bt.spotClient, spotSymbols},
+		{legFuture, bt.futureClient, futureSymbols},
+	}
+
+	for _, venue := range venues {
+		if len(venue.symbols) == 0 {
+			continue
+		}
+
+		open, err := venue.client.GetOpenOrders(ctx, venue.symbols)
+		if err != nil {
+			problem("failed to list open %s orders: %v", venue.leg, err)
+			continue
+		}
+
+		for i := range open {
+			order := &open[i]
+			bt.mu.RLock()
+			_, owned := bt.lookupOrderLocked(order.OrderID, order.ClientOrderID)
+			bt.mu.RUnlock()
+			if owned {
+				continue
+			}
+
+			if policy == OrphanPolicyAdopt {
+				if strategies := owners[order.Symbol]; len(strategies) == 1 {
+					bt.adoptOrder(venue.leg, venue.client, strategies[0].ID, order)
+					result.OrdersAdopted = append(result.OrdersAdopted, order.OrderID)
+				} else {
+					problem("orphaned order %s on %s is shared by %d strategies and cannot be adopted",
+						order.OrderID, order.Symbol, len(strategies))
+				}
+				continue
+			}
+
+			if err := venue.client.CancelOrder(ctx, order.OrderID); err != nil && !errors.Is(err, coinbase.ErrOrderNotFound) {
+				problem("failed to cancel orphaned order %s: %v", order.OrderID, err)
+				continue
+			}
+			result.OrdersCancelled = append(result.OrdersCancelled, order.OrderID)
+			bt.logger.WithFields(logrus.Fields{
+				"order_id": order.OrderID,
+				"symbol":   order.Symbol,
+			}).Warn("Cancelled orphaned order")
+		}
+	}
+}
+
+// adoptOrder tracks an orphaned order on behalf of a strategy. It belongs to
+// no trade, so its fills only reach the position ledger.
+func (bt *BasisTrader) adoptOrder(legName string, client coinbase.Client, strategyID string, order *models.Order) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	tracked := &trackedOrder{
+		strategyID:    strategyID,
+		leg:           legName,
+		symbol:        order.Symbol,
+		client:        client,
+		orderID:       order.OrderID,
+		clientOrderID: order.ClientOrderID,
+		side:          order.Side,
+		orderType:     order.Type,
+		price:         order.Price,
+		size:          bt.instruments.fromContracts(order.Symbol, order.Size),
+		status:        order.Status,
+		filledSize:    bt.instruments.fromContracts(order.Symbol, order.FilledSize),
+		avgFillPrice:  order.AvgFillPrice,
+		createdAt:     order.CreatedAt,
+		updatedAt:     bt.clock.Now(),
+	}
+	if tracked.clientOrderID == "" {
+		tracked.clientOrderID = order.OrderID
+	}
+
+	bt.orders[tracked.clientOrderID] = tracked
+	bt.orderIDs[tracked.orderID] = tracked.clientOrderID
+	bt.bookExecutionLocked(tracked, order.Price)
+
+	bt.logger.WithFields(logrus.Fields{
+		"order_id":    order.OrderID,
+		"symbol":      order.Symbol,
+		"strategy_id": strategyID,
+	}).Warn("Adopted orphaned order")
+}
+
+// checkPositions compares the ledger with exchange positions. Futures
+// positions must match the strategy allocations exactly. Spot balances may
+// hold inventory the strategies never traded, which is recorded as external.
+func (bt *BasisTrader) checkPositions(ctx context.Context, result *models.ReconcileResult, accept bool,
+	problem func(string, ...interface{})) {
+	spot, err := bt.spotClient.GetPositions(ctx)
+	if err != nil {
+		problem("failed to get spot positions: %v", err)
+		return
+	}
+	future, err := bt.futureClient.GetPositions(ctx)
+	if err != nil {
+		problem("failed to get future positions: %v", err)
+		return
+	}
+	bt.positionUnits(future)
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	exchange := append(bt.spotPositionsLocked(spot), future...)
+	held := make(map[string]float64)
+	for _, p := range exchange {
+		held[p.Symbol] += p.Size
+	}
+
+	ledger := bt.ledger.allocated()
+	external := make(map[string]float64)
+	futures := make(map[string]bool)
+	for _, s := range bt.strategies {
+		external[s.SpotSymbol] = held[s.SpotSymbol] - ledger[s.SpotSymbol]
+		futures[s.FutureSymbol] = true
+	}
+	// Including futures a strategy is rolling out of or into
+	for symbol := range ledger {
+		if bt.instruments.lookup(symbol).Type != models.MarketTypeSpot {
+			futures[symbol] = true
+		}
+	}
+
+	for symbol := range futures {
+		diff := held[symbol] - ledger[symbol]
+		if math.Abs(diff) <= positionTolerance {
+			external[symbol] = 0
+			continue
+		}
+
+		result.Positions = append(result.Positions, models.PositionDiscrepancy{
+			Symbol:     symbol,
+			Ledger:     ledger[symbol],
+			Exchange:   held[symbol],
+			Difference: diff,
+			DetectedAt: bt.clock.Now(),
+		})
+		if accept {
+			external[symbol] = diff
+			continue
+		}
+		problem("%s position %.8f does not match strategy allocations %.8f", symbol, held[symbol], ledger[symbol])
+	}
+
+	if len(result.Problems) > 0 {
+		return
+	}
+
+	// Runtime reconciliation measures drift from here
+	for symbol, size := range external {
+		bt.ledger.external[symbol] = size
+		bt.logExternalLocked(symbol, size)
+	}
+	bt.ledger.exchange = exchange
+}
@@ -0,0 +1,182 @@
+package trader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gregtusar/basis/internal/storage"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultRollDays is how many days before a dated future expires the
+// position in it is rolled, for strategies that do not set RollDays
+const defaultRollDays = 2
+
+// rollPeriod is how long before expiry a strategy's position is rolled
+func rollPeriod(strategy *models.BasisStrategy) time.Duration {
+	days := strategy.RollDays
+	if days <= 0 {
+		days = defaultRollDays
+	}
+	return time.Duration(days * float64(24*time.Hour))
+}
+
+// rollSymbolsLocked returns the futures strategies are rolling into. Must be
+// called with bt.mu held.
+func (bt *BasisTrader) rollSymbolsLocked() []string {
+	symbols := make([]string, 0, len(bt.rolls))
+	for _, symbol := range bt.rolls {
+		if symbol != "" {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// manageRoll rolls a strategy's dated future position into the next expiry
+// once it is within the roll period, in MinTradeSize steps: each roll buys
+// back the expiring future and sells the next. When nothing is left in the
+// expiring future the strategy switches to trading the next one. It reports
+// whether the strategy is rolling, during which it neither enters nor exits.
+func (bt *BasisTrader) manageRoll(ctx context.Context, strategy *models.BasisStrategy) bool {
+	current := bt.instruments.lookup(strategy.FutureSymbol)
+	if !current.Expires() {
+		return false
+	}
+	now := bt.clock.Now()
+	if now.Before(current.Expiry.Add(-rollPeriod(strategy))) {
+		return false
+	}
+
+	target, ok := bt.instruments.rollTarget(current, current.Expiry)
+
+	bt.mu.Lock()
+	previous, rolling := bt.rolls[strategy.ID]
+	if !ok {
+		bt.rolls[strategy.ID] = ""
+		bt.mu.Unlock()
+		if !rolling {
+			bt.logger.WithFields(logrus.Fields{
+				"strategy_id": strategy.ID,
+				"symbol":      current.Symbol,
+				"expiry":      current.Expiry,
+			}).Warn("No later expiry listed to roll into")
+		}
+		return true
+	}
+	bt.rolls[strategy.ID] = target.Symbol
+	held := bt.ledger.held(strategy.ID, current.Symbol)
+	blocked := bt.entryBlockedLocked(strategy.ID)
+	bt.mu.Unlock()
+
+	if previous != target.Symbol {
+		bt.logger.WithFields(logrus.Fields{
+			"strategy_id": strategy.ID,
+			"from":        current.Symbol,
+			"to":          target.Symbol,
+			"expiry":      current.Expiry,
+		}).Info("Rolling strategy into next expiry")
+		bt.subscribeRollTarget(strategy, target.Symbol)
+	}
+
+	// Wait for the previous trade to settle, or back off after a failure
+	if blocked {
+		return true
+	}
+
+	// Only a short future position is rolled; once it is gone the strategy
+	// moves on
+	if held > -sizeEpsilon {
+		bt.switchFuture(strategy, target)
+		return true
+	}
+
+	size := -held
+	if strategy.MinTradeSize > 0 {
+		size = math.Min(strategy.MinTradeSize, size)
+	}
+
+	// Buy back the expiring future and sell the next. The buy is reduce-only
+	// so it can never leave the expiring future net long.
+	plan, err := bt.planTrade(
+		&tradeLeg{client: bt.futureClient, symbol: current.Symbol, side: models.OrderSideBuy, reduceOnly: true},
+		&tradeLeg{client: bt.futureClient, symbol: target.Symbol, side: models.OrderSideSell},
+		size,
+	)
+	if err != nil {
+		bt.logger.WithError(err).WithField("strategy_id", strategy.ID).Debug("Roll not executable")
+		return true
+	}
+
+	bt.logger.WithFields(logrus.Fields{
+		"strategy_id": strategy.ID,
+		"from":        current.Symbol,
+		"to":          target.Symbol,
+		"spread":      plan.futureQuote.price - plan.spotQuote.price,
+		"size":        plan.size,
+		"held":        -held,
+	}).Info("Placing roll trade")
+
+	ts := newTradeState(newTrade(strategy, plan, tradeSideRoll, now), plan.spot, plan.future)
+	bt.openTrade(ctx, strategy, plan, ts, fmt.Sprintf("%s expires %s, rolling into %s",
+		current.Symbol, current.Expiry.Format(time.RFC3339), target.Symbol))
+	return true
+}
+
+// subscribeRollTarget streams market data and order updates for the future a
+// strategy is rolling into
+func (bt *BasisTrader) subscribeRollTarget(strategy *models.BasisStrategy, symbol string) {
+	rolling := *strategy
+	rolling.FutureSymbol = symbol
+	bt.subscribeMarketData(&rolling)
+	bt.subscribeOrderUpdates(&rolling)
+}
+
+// switchFuture moves a strategy that has rolled out of its future on to the
+// next. The strategy is replaced rather than modified, as it is read without
+// bt.mu held.
+func (bt *BasisTrader) switchFuture(strategy *models.BasisStrategy, target models.Instrument) {
+	rolled := *strategy
+	rolled.FutureSymbol = target.Symbol
+	rolled.UpdatedAt = bt.clock.Now()
+
+	bt.mu.Lock()
+	if bt.strategies[strategy.ID] != strategy {
+		// Removed or already switched
+		bt.mu.Unlock()
+		return
+	}
+	bt.strategies[strategy.ID] = &rolled
+	delete(bt.rolls, strategy.ID)
+	bt.persistLocked(func(ctx context.Context, store storage.Repository) error {
+		return store.SaveStrategy(ctx, &rolled)
+	})
+	bt.mu.Unlock()
+
+	bt.logger.WithFields(logrus.Fields{
+		"strategy_id": strategy.ID,
+		"from":        strategy.FutureSymbol,
+		"to":          target.Symbol,
+	}).Info("Strategy rolled into next expiry")
+}
+
+// settleRollLocked books the spread a settled roll locked in: the price the
+// next future was sold at over the price the expiring one was bought back at,
+// on the size both legs filled. Must be called with bt.mu held.
+func (bt *BasisTrader) settleRollLocked(ts *tradeState) {
+	trade := ts.trade
+	size := matchedSize(trade)
+	trade.RealizedPnL = size * (trade.FutureAvgPrice - trade.SpotAvgPrice)
+
+	bt.logger.WithFields(logrus.Fields{
+		"trade_id":    trade.ID,
+		"strategy_id": trade.StrategyID,
+		"from":        trade.SpotSymbol,
+		"to":          trade.FutureSymbol,
+		"size":        size,
+		"spread":      trade.RealizedPnL,
+	}).Info("Roll settled")
+}
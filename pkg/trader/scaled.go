@@ -0,0 +1,85 @@
+package trader
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// StrategyTypeScaled holds a position that scales with the entry signal
+const StrategyTypeScaled = "scaled"
+
+// scaledParams are the scaled model's parameters. FullTarget is the entry
+// signal value at and above which the full MaxPosition is held; the
+// strategy's own target is where the position starts.
+type scaledParams struct {
+	FullTarget float64 `json:"full_target"`
+}
+
+// scaledStrategy wants a position scaling linearly with the strategy's entry
+// signal, from nothing at its target to MaxPosition at FullTarget. It adds
+// toward the size the entry signal calls for and reduces toward the size the
+// exit signal calls for, holding while the position is between the two.
+type scaledStrategy struct {
+	params scaledParams
+}
+
+func newScaledStrategy(strategy *models.BasisStrategy) (Strategy, error) {
+	if err := checkEntrySignal(strategy); err != nil {
+		return nil, err
+	}
+
+	var params scaledParams
+	if err := DecodeStrategyParams(strategy, &params); err != nil {
+		return nil, err
+	}
+	if target := signalTarget(strategy); params.FullTarget <= target {
+		return nil, fmt.Errorf("full_target %g must be above the entry target %g", params.FullTarget, target)
+	}
+	return &scaledStrategy{params: params}, nil
+}
+
+func (m *scaledStrategy) OnMarketData(update MarketUpdate) *Target {
+	strategy := update.Strategy
+
+	if update.Entry != nil {
+		if value, target, ok := entrySignal(strategy, update.Entry, tradeSideEnter); ok {
+			if size := m.size(strategy, value, target); size > update.Position+sizeEpsilon {
+				return &Target{
+					Size:   size,
+					Reason: fmt.Sprintf("%s scales position up to %g", describeSignal(strategy, update.Entry, tradeSideEnter), size),
+				}
+			}
+		}
+	}
+
+	if update.Exit != nil {
+		if value, target, ok := entrySignal(strategy, update.Exit, tradeSideExit); ok {
+			if size := m.size(strategy, value, target); size < update.Position-sizeEpsilon {
+				return &Target{
+					Size:   size,
+					Exit:   true,
+					Reason: fmt.Sprintf("%s scales position down to %g", describeSignal(strategy, update.Exit, tradeSideExit), size),
+				}
+			}
+		}
+	}
+
+	return &Target{Size: update.Position, Reason: "position within the scaled range"}
+}
+
+func (m *scaledStrategy) OnFill(StrategyFill) *Target {
+	return nil
+}
+
+func (m *scaledStrategy) OnTimer(time.Time) *Target {
+	return nil
+}
+
+// size is the position the signal value calls for
+func (m *scaledStrategy) size(strategy *models.BasisStrategy, value, target float64) float64 {
+	fraction := (value - target) / (m.params.FullTarget - target)
+	return strategy.MaxPosition * math.Max(0, math.Min(1, fraction))
+}
@@ -0,0 +1,301 @@
+package trader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gregtusar/basis/internal/storage"
+	"github.com/gregtusar/basis/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Strategy is a signal model: it decides the hedged position, long spot
+// against short future, a BasisStrategy should hold. The trader calls it as
+// market data, fills and timer ticks arrive and trades toward the latest
+// target it returned, within MaxPosition, once the position has drifted from
+// it by more than the rebalance threshold, or at once for an exit. A nil
+// target keeps the previous one.
+//
+// Calls for one strategy are never concurrent. They may be made with the
+// trader's locks held, so a Strategy must not call back into the trader.
+type Strategy interface {
+	// OnMarketData is called on each evaluation of an active strategy whose
+	// legs have current quotes, even when neither an entry nor an exit can
+	// be priced
+	OnMarketData(update MarketUpdate) *Target
+
+	// OnFill is called for each fill booked on one of the strategy's orders
+	OnFill(fill StrategyFill) *Target
+
+	// OnTimer is called on each evaluation, before any market data
+	OnTimer(now time.Time) *Target
+}
+
+// MarketUpdate is what a Strategy sees of the market on an evaluation
+type MarketUpdate struct {
+	Strategy *models.BasisStrategy
+
+	// Basis is at the legs' latest quotes
+	Basis *models.BasisSnapshot
+
+	// Entry and Exit are the basis at the prices a MinTradeSize entry or
+	// exit would execute at. Either is nil when that trade cannot be
+	// priced, and Exit when nothing is open; both may be nil.
+	Entry *models.BasisSnapshot
+	Exit  *models.BasisSnapshot
+
+	// Position is the hedged size settled entries hold
+	Position float64
+
+	Time time.Time
+}
+
+// StrategyFill is a fill on one leg of a strategy's trade, in the underlying
+type StrategyFill struct {
+	StrategyID string
+	TradeID    string
+	Leg        string
+	Symbol     string
+	Side       models.OrderSide
+	Size       float64
+	Price      float64
+	Time       time.Time
+}
+
+// Target is the hedged position a Strategy wants held
+type Target struct {
+	Size float64
+
+	// Exit marks a target the exit signal called for. It is traded toward
+	// however close the position is, as is a target of zero.
+	Exit bool
+
+	// Reason is recorded on the trades that move toward it
+	Reason string
+}
+
+// Warmer is implemented by strategies that need basis history before they
+// trade. When a persisted strategy is loaded, its snapshots recorded over the
+// WarmUpPeriod are passed to WarmUp, oldest first, before it is evaluated.
+type Warmer interface {
+	WarmUpPeriod() time.Duration
+	WarmUp(history []models.BasisSnapshot)
+}
+
+// Observer is implemented by strategies that keep statistics of the basis.
+// Every snapshot recorded for the strategy is passed to Observe, whether or
+// not it is active or can trade.
+type Observer interface {
+	Observe(snapshot models.BasisSnapshot)
+}
+
+// Annotator is implemented by strategies that add their own measures to the
+// strategy's basis snapshots
+type Annotator interface {
+	Annotate(snapshot *models.BasisSnapshot)
+}
+
+// StrategyFactory builds the model for a BasisStrategy, decoding and
+// validating its Params
+type StrategyFactory func(strategy *models.BasisStrategy) (Strategy, error)
+
+// StrategyTypeThreshold is the model strategies without a Type run
+const StrategyTypeThreshold = "threshold"
+
+var strategyTypes = struct {
+	mu        sync.RWMutex
+	factories map[string]StrategyFactory
+}{
+	factories: map[string]StrategyFactory{
+		StrategyTypeThreshold: newThresholdStrategy,
+		StrategyTypeZScore:    newZScoreStrategy,
+		StrategyTypeScaled:    newScaledStrategy,
+	},
+}
+
+// RegisterStrategy makes a model available to strategies by Type. It panics
+// if the name is already registered.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategyTypes.mu.Lock()
+	defer strategyTypes.mu.Unlock()
+
+	if _, exists := strategyTypes.factories[name]; exists {
+		panic(fmt.Sprintf("strategy type %s already registered", name))
+	}
+	strategyTypes.factories[name] = factory
+}
+
+// StrategyTypes returns the registered model names, sorted
+func StrategyTypes() []string {
+	strategyTypes.mu.RLock()
+	defer strategyTypes.mu.RUnlock()
+
+	names := make([]string, 0, len(strategyTypes.factories))
+	for name := range strategyTypes.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newStrategy builds the model a strategy's Type names
+func newStrategy(strategy *models.BasisStrategy) (Strategy, error) {
+	name := strategy.Type
+	if name == "" {
+		name = StrategyTypeThreshold
+	}
+
+	strategyTypes.mu.RLock()
+	factory, ok := strategyTypes.factories[name]
+	strategyTypes.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy type %q", name)
+	}
+
+	model, err := factory(strategy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s strategy: %w", name, err)
+	}
+	return model, nil
+}
+
+// DecodeStrategyParams decodes a strategy's Params into params, which holds
+// the defaults for anything they leave out. Unknown parameters are an error.
+func DecodeStrategyParams(strategy *models.BasisStrategy, params any) error {
+	if len(bytes.TrimSpace(strategy.Params)) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(strategy.Params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(params); err != nil {
+		return fmt.Errorf("failed to decode params: %w", err)
+	}
+	return nil
+}
+
+// strategyRunner hosts a strategy's model, serializing calls into it and
+// keeping the latest target it returned and the direction, if any, the
+// position is being rebalanced toward it in
+type strategyRunner struct {
+	mu          sync.Mutex
+	model       Strategy
+	target      *Target
+	rebalancing float64 // 1 adding, -1 reducing, 0 within the band
+}
+
+func (r *strategyRunner) onMarketData(update MarketUpdate) *Target {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.update(r.model.OnMarketData(update))
+}
+
+func (r *strategyRunner) onFill(fill StrategyFill) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.update(r.model.OnFill(fill))
+}
+
+func (r *strategyRunner) onTimer(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.update(r.model.OnTimer(now))
+}
+
+func (r *strategyRunner) warmUp(history []models.BasisSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if warmer, ok := r.model.(Warmer); ok {
+		warmer.WarmUp(history)
+	}
+}
+
+func (r *strategyRunner) observe(snapshot models.BasisSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if observer, ok := r.model.(Observer); ok {
+		observer.Observe(snapshot)
+	}
+}
+
+func (r *strategyRunner) annotate(snapshot *models.BasisSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if annotator, ok := r.model.(Annotator); ok {
+		annotator.Annotate(snapshot)
+	}
+}
+
+// update keeps target, if set, and returns the latest. Must be called with
+// r.mu held.
+func (r *strategyRunner) update(target *Target) *Target {
+	if target != nil {
+		r.target = target
+	}
+	return r.target
+}
+
+// runnerFor returns the runner hosting a strategy's model
+func (bt *BasisTrader) runnerFor(strategyID string) *strategyRunner {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	return bt.runners[strategyID]
+}
+
+// warmUp passes a strategy's model the basis history it asks for
+func (bt *BasisTrader) warmUp(ctx context.Context, store storage.Repository, strategy *models.BasisStrategy) {
+	runner := bt.runnerFor(strategy.ID)
+	if runner == nil {
+		return
+	}
+	warmer, ok := runner.model.(Warmer)
+	if !ok {
+		return
+	}
+
+	records, err := store.ListSnapshots(ctx, storage.SnapshotQuery{
+		StrategyID: strategy.ID,
+		Since:      bt.clock.Now().Add(-warmer.WarmUpPeriod()),
+	})
+	if err != nil {
+		bt.logger.WithError(err).WithField("strategy_id", strategy.ID).Warn("Failed to load basis history to warm up on")
+		return
+	}
+
+	history := make([]models.BasisSnapshot, len(records))
+	for i := range records {
+		history[i] = records[i].BasisSnapshot
+	}
+	runner.warmUp(history)
+
+	bt.logger.WithFields(logrus.Fields{
+		"strategy_id": strategy.ID,
+		"snapshots":   len(history),
+	}).Info("Warmed up strategy on basis history")
+}
+
+// bookFillLocked books a fill on a tracked order to the position ledger and
+// passes it to the strategy's model. Must be called with bt.mu held.
+func (bt *BasisTrader) bookFillLocked(tracked *trackedOrder, size, price float64) {
+	bt.ledger.book(tracked.strategyID, tracked.leg, tracked.symbol, tracked.side, size, price)
+	tracked.bookedSize += size
+	tracked.bookedValue += size * price
+
+	if runner, ok := bt.runners[tracked.strategyID]; ok {
+		runner.onFill(StrategyFill{
+			StrategyID: tracked.strategyID,
+			TradeID:    tracked.tradeID,
+			Leg:        tracked.leg,
+			Symbol:     tracked.symbol,
+			Side:       tracked.side,
+			Size:       size,
+			Price:      price,
+			Time:       bt.clock.Now(),
+		})
+	}
+}
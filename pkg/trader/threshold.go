@@ -0,0 +1,72 @@
+package trader
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// defaultExitFraction is the fraction of the entry target the exit signal
+// must fall to before the threshold model unwinds
+const defaultExitFraction = 0.5
+
+// thresholdParams are the threshold model's parameters. The entry target is
+// the strategy's own, for its EntrySignal.
+type thresholdParams struct {
+	ExitFraction float64 `json:"exit_fraction"`
+}
+
+// thresholdStrategy builds up to MaxPosition while the entry signal is at or
+// above target and unwinds while the exit signal is at or below a fraction
+// of it. In between it holds what it has.
+type thresholdStrategy struct {
+	params thresholdParams
+}
+
+func newThresholdStrategy(strategy *models.BasisStrategy) (Strategy, error) {
+	if err := checkEntrySignal(strategy); err != nil {
+		return nil, err
+	}
+
+	params := thresholdParams{ExitFraction: defaultExitFraction}
+	if err := DecodeStrategyParams(strategy, &params); err != nil {
+		return nil, err
+	}
+	if params.ExitFraction < 0 || params.ExitFraction > 1 {
+		return nil, fmt.Errorf("exit_fraction must be between 0 and 1, got %g", params.ExitFraction)
+	}
+	return &thresholdStrategy{params: params}, nil
+}
+
+func (m *thresholdStrategy) OnMarketData(update MarketUpdate) *Target {
+	strategy := update.Strategy
+
+	if update.Entry != nil {
+		if value, target, ok := entrySignal(strategy, update.Entry, tradeSideEnter); ok && value >= target {
+			return &Target{
+				Size:   strategy.MaxPosition,
+				Reason: fmt.Sprintf("%s at or above target", describeSignal(strategy, update.Entry, tradeSideEnter)),
+			}
+		}
+	}
+
+	if update.Exit != nil {
+		if value, target, ok := entrySignal(strategy, update.Exit, tradeSideExit); ok && value <= target*m.params.ExitFraction {
+			return &Target{
+				Exit:   true,
+				Reason: fmt.Sprintf("%s compressed below exit threshold", describeSignal(strategy, update.Exit, tradeSideExit)),
+			}
+		}
+	}
+
+	return &Target{Size: update.Position, Reason: "holding between entry and exit thresholds"}
+}
+
+func (m *thresholdStrategy) OnFill(StrategyFill) *Target {
+	return nil
+}
+
+func (m *thresholdStrategy) OnTimer(time.Time) *Target {
+	return nil
+}
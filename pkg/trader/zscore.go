@@ -0,0 +1,258 @@
+package trader
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+// StrategyTypeZScore enters and exits on the basis's z-score against its own
+// recent history
+const StrategyTypeZScore = "zscore"
+
+// z-score statistics methods
+const (
+	zScoreRolling = "rolling"
+	zScoreEWMA    = "ewma"
+)
+
+// zScoreParams are the z-score model's parameters. Sample counts are in
+// samples taken every Interval seconds from the recorded basis snapshots, so
+// an Interval shorter than the snapshot interval samples every snapshot.
+type zScoreParams struct {
+	// Method is "rolling", a simple mean and deviation over the last Window
+	// samples, or "ewma", exponentially weighted with a HalfLife
+	Method   string  `json:"method"`
+	Window   int     `json:"window"`
+	HalfLife float64 `json:"half_life"`
+	Interval float64 `json:"interval_seconds"`
+
+	// MinSamples must be taken before the model trades
+	MinSamples int `json:"min_samples"`
+
+	// EntryZ is the z-score of the entry basis at or above which the model
+	// enters, and ExitZ that of the exit basis at or below which it exits
+	EntryZ float64 `json:"entry_z"`
+	ExitZ  float64 `json:"exit_z"`
+}
+
+// zScoreStrategy builds up to MaxPosition while the entry basis is rich
+// against the mean of the mid basis, and unwinds once the exit basis has
+// reverted toward it. Statistics are kept per spot and future pair, so a
+// roll into the next expiry starts a new series.
+type zScoreStrategy struct {
+	params zScoreParams
+	series map[string]*basisStats
+}
+
+func newZScoreStrategy(strategy *models.BasisStrategy) (Strategy, error) {
+	params := zScoreParams{
+		Method:     zScoreRolling,
+		Window:     240,
+		HalfLife:   60,
+		Interval:   defaultSnapshotInterval.Seconds(),
+		MinSamples: 60,
+		EntryZ:     2,
+		ExitZ:      0,
+	}
+	if err := DecodeStrategyParams(strategy, &params); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case params.Method != zScoreRolling && params.Method != zScoreEWMA:
+		return nil, fmt.Errorf("method must be %q or %q, got %q", zScoreRolling, zScoreEWMA, params.Method)
+	case params.Method == zScoreRolling && params.Window < 2:
+		return nil, fmt.Errorf("window must be at least 2 samples, got %d", params.Window)
+	case params.Method == zScoreEWMA && params.HalfLife <= 0:
+		return nil, fmt.Errorf("half_life must be positive, got %g", params.HalfLife)
+	case params.Interval <= 0:
+		return nil, fmt.Errorf("interval_seconds must be positive, got %g", params.Interval)
+	case params.MinSamples < 2:
+		return nil, fmt.Errorf("min_samples must be at least 2, got %d", params.MinSamples)
+	case params.Method == zScoreRolling && params.MinSamples > params.Window:
+		return nil, fmt.Errorf("min_samples %d exceeds the window of %d", params.MinSamples, params.Window)
+	case params.ExitZ >= params.EntryZ:
+		return nil, fmt.Errorf("exit_z %g must be below entry_z %g", params.ExitZ, params.EntryZ)
+	}
+
+	return &zScoreStrategy{params: params, series: make(map[string]*basisStats)}, nil
+}
+
+func (m *zScoreStrategy) OnMarketData(update MarketUpdate) *Target {
+	strategy := update.Strategy
+	stats := m.seriesFor(strategy.SpotSymbol, strategy.FutureSymbol)
+	mean, stdDev, ok := stats.meanStdDev(m.params.MinSamples)
+	if !ok {
+		return &Target{Size: update.Position, Reason: "warming up"}
+	}
+
+	if update.Entry != nil {
+		if z := (update.Entry.EntryBasisPercent - mean) / stdDev; z >= m.params.EntryZ {
+			return &Target{
+				Size:   strategy.MaxPosition,
+				Reason: fmt.Sprintf("entry basis z-score %.2f at or above %.2f", z, m.params.EntryZ),
+			}
+		}
+	}
+
+	if update.Exit != nil {
+		if z := (update.Exit.ExitBasisPercent - mean) / stdDev; z <= m.params.ExitZ {
+			return &Target{
+				Exit:   true,
+				Reason: fmt.Sprintf("exit basis z-score %.2f reverted to %.2f", z, m.params.ExitZ),
+			}
+		}
+	}
+
+	return &Target{Size: update.Position, Reason: "holding between z-score bands"}
+}
+
+func (m *zScoreStrategy) OnFill(StrategyFill) *Target {
+	return nil
+}
+
+func (m *zScoreStrategy) OnTimer(time.Time) *Target {
+	return nil
+}
+
+// WarmUpPeriod covers the samples the statistics are built from
+func (m *zScoreStrategy) WarmUpPeriod() time.Duration {
+	samples := float64(m.params.Window)
+	if m.params.Method == zScoreEWMA {
+		// Weights older than five half-lives are negligible
+		samples = math.Max(5*m.params.HalfLife, float64(m.params.MinSamples))
+	}
+	return time.Duration(samples * m.params.Interval * float64(time.Second))
+}
+
+func (m *zScoreStrategy) WarmUp(history []models.BasisSnapshot) {
+	for _, snapshot := range history {
+		m.Observe(snapshot)
+	}
+}
+
+// Observe samples the snapshot's mid basis into its pair's series
+func (m *zScoreStrategy) Observe(snapshot models.BasisSnapshot) {
+	m.seriesFor(snapshot.SpotSymbol, snapshot.FutureSymbol).observe(snapshot.Timestamp, sampleBasis(&snapshot))
+}
+
+// Annotate adds the z-score of the snapshot's mid basis and the bands the
+// model trades at
+func (m *zScoreStrategy) Annotate(snapshot *models.BasisSnapshot) {
+	mean, stdDev, ok := m.seriesFor(snapshot.SpotSymbol, snapshot.FutureSymbol).meanStdDev(m.params.MinSamples)
+	if !ok {
+		return
+	}
+
+	snapshot.ZScore = (sampleBasis(snapshot) - mean) / stdDev
+	snapshot.BasisMean = mean
+	snapshot.BasisStdDev = stdDev
+	snapshot.EntryBand = mean + m.params.EntryZ*stdDev
+	snapshot.ExitBand = mean + m.params.ExitZ*stdDev
+	snapshot.HasZScore = true
+}
+
+func (m *zScoreStrategy) seriesFor(spotSymbol, futureSymbol string) *basisStats {
+	pair := spotSymbol + "/" + futureSymbol
+	stats, ok := m.series[pair]
+	if !ok {
+		stats = newBasisStats(m.params)
+		m.series[pair] = stats
+	}
+	return stats
+}
+
+// sampleBasis is the figure the statistics track: the mid basis percent, or
+// for snapshots recorded before it was, the last price basis percent
+func sampleBasis(snapshot *models.BasisSnapshot) float64 {
+	if snapshot.MidBasis == 0 && snapshot.MidBasisPercent == 0 {
+		return snapshot.BasisPercent
+	}
+	return snapshot.MidBasisPercent
+}
+
+// basisStats is the mean and standard deviation of a basis series sampled at
+// most once per interval, over a rolling window or exponentially weighted
+type basisStats struct {
+	interval time.Duration
+	last     time.Time
+	count    int
+
+	// Rolling window, oldest first
+	window  int
+	samples []float64
+
+	// Exponentially weighted, when alpha is set
+	alpha    float64
+	mean     float64
+	variance float64
+}
+
+func newBasisStats(params zScoreParams) *basisStats {
+	s := &basisStats{
+		interval: time.Duration(params.Interval * float64(time.Second)),
+		window:   params.Window,
+	}
+	if params.Method == zScoreEWMA {
+		s.alpha = 1 - math.Pow(0.5, 1/params.HalfLife)
+	}
+	return s
+}
+
+// observe takes value as a sample, unless the last was taken less than an
+// interval before at
+func (s *basisStats) observe(at time.Time, value float64) {
+	if s.count > 0 && at.Sub(s.last) < s.interval {
+		return
+	}
+	s.last = at
+	s.count++
+
+	if s.alpha == 0 {
+		s.samples = append(s.samples, value)
+		if len(s.samples) > s.window {
+			s.samples = s.samples[len(s.samples)-s.window:]
+		}
+		return
+	}
+
+	if s.count == 1 {
+		s.mean = value
+		return
+	}
+	diff := value - s.mean
+	increment := s.alpha * diff
+	s.mean += increment
+	s.variance = (1 - s.alpha) * (s.variance + diff*increment)
+}
+
+// meanStdDev returns the series' mean and standard deviation. ok is false
+// until minSamples have been taken, or while the series has not varied.
+func (s *basisStats) meanStdDev(minSamples int) (mean, stdDev float64, ok bool) {
+	if s.count < minSamples {
+		return 0, 0, false
+	}
+
+	if s.alpha == 0 {
+		for _, v := range s.samples {
+			mean += v
+		}
+		mean /= float64(len(s.samples))
+
+		var sumSq float64
+		for _, v := range s.samples {
+			sumSq += (v - mean) * (v - mean)
+		}
+		stdDev = math.Sqrt(sumSq / float64(len(s.samples)-1))
+	} else {
+		mean, stdDev = s.mean, math.Sqrt(s.variance)
+	}
+
+	if stdDev < 1e-12 {
+		return 0, 0, false
+	}
+	return mean, stdDev, true
+}
@@ -0,0 +1,56 @@
+package trader
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gregtusar/basis/pkg/clock"
+	"github.com/gregtusar/basis/pkg/models"
+)
+
+func TestZScoreStatisticsFollowRecordedSnapshots(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewSimulated(start)
+
+	bt := NewBasisTrader(nil, nil, testLogger())
+	bt.SetClock(clk)
+	strategy := &models.BasisStrategy{
+		ID:           "s-1",
+		Type:         StrategyTypeZScore,
+		SpotSymbol:   "BTC-USD",
+		FutureSymbol: "BTC-PERP",
+		MaxPosition:  1,
+		MinTradeSize: 0.1,
+		Params:       json.RawMessage(`{"window": 10, "min_samples": 3}`),
+	}
+	if err := bt.AddStrategy(strategy); err != nil {
+		t.Fatalf("AddStrategy: %v", err)
+	}
+	model := bt.runnerFor(strategy.ID).model.(*zScoreStrategy)
+	stats := model.seriesFor(strategy.SpotSymbol, strategy.FutureSymbol)
+
+	// The strategy is inactive and trading is not enabled, so it is never
+	// evaluated; every 20 seconds for five minutes records one snapshot a
+	// minute
+	for i := 0; i < 15; i++ {
+		future := 100 + float64(i)/10
+		bt.UpdateTicker(models.Ticker{Symbol: "BTC-USD", BidPrice: 99.9, AskPrice: 100.1, LastPrice: 100, Timestamp: clk.Now()})
+		bt.UpdateTicker(models.Ticker{Symbol: "BTC-PERP", BidPrice: future - 0.1, AskPrice: future + 0.1, LastPrice: future, Timestamp: clk.Now()})
+		bt.recordSnapshotIfDue()
+		clk.Advance(20 * time.Second)
+	}
+
+	if stats.count != 5 {
+		t.Errorf("sampled %d snapshots, want 5", stats.count)
+	}
+	if _, _, ok := stats.meanStdDev(model.params.MinSamples); !ok {
+		t.Error("statistics not ready after 5 samples")
+	}
+
+	// Model evaluations no longer sample
+	model.OnMarketData(MarketUpdate{Strategy: strategy, Basis: &models.BasisSnapshot{MidBasisPercent: 5}, Time: clk.Now().Add(time.Hour)})
+	if stats.count != 5 {
+		t.Errorf("evaluation sampled the basis, count %d", stats.count)
+	}
+}
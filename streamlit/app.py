@@ -91,19 +91,37 @@ def fetch_data(endpoint):
         return []
 
 def create_basis_chart(snapshots_df):
-    """Create basis percentage chart"""
+    """Create entry, mid and exit basis percentage chart"""
     fig = go.Figure()
     
     for symbol_pair in snapshots_df['pair'].unique():
         pair_data = snapshots_df[snapshots_df['pair'] == symbol_pair]
-        fig.add_trace(go.Scatter(
-            x=pair_data['timestamp'],
-            y=pair_data['basis_percent'],
-            mode='lines+markers',
-            name=symbol_pair,
-            line=dict(width=2),
-            marker=dict(size=6)
-        ))
+        for column, label, dash in [('entry_basis_percent', 'entry', 'solid'),
+                                    ('mid_basis_percent', 'mid', 'dot'),
+                                    ('exit_basis_percent', 'exit', 'dash')]:
+            if column not in pair_data:
+                continue
+            fig.add_trace(go.Scatter(
+                x=pair_data['timestamp'],
+                y=pair_data[column],
+                mode='lines+markers',
+                name=f"{symbol_pair} {label}",
+                line=dict(width=2, dash=dash),
+                marker=dict(size=6)
+            ))
+        
+        # Z-score model bands, where the strategy runs one
+        if 'has_z_score' in pair_data:
+            banded = pair_data[pair_data['has_z_score']]
+            for column, label in [('entry_band', 'entry band'), ('exit_band', 'exit band')]:
+                if not banded.empty:
+                    fig.add_trace(go.Scatter(
+                        x=banded['timestamp'],
+                        y=banded[column],
+                        mode='lines',
+                        name=f"{symbol_pair} {label}",
+                        line=dict(width=1, dash='longdash')
+                    ))
     
     fig.update_layout(
         title="Basis Percentage Over Time",
@@ -157,7 +175,8 @@ if health:
 if snapshots:
     latest_snapshot = snapshots[0] if snapshots else {}
     with col2:
-        st.metric("Latest Basis", f"{latest_snapshot.get('basis_percent', 0):.2f}%")
+        st.metric("Entry Basis", f"{latest_snapshot.get('entry_basis_percent', 0):.2f}%",
+                 delta=f"exit {latest_snapshot.get('exit_basis_percent', 0):.2f}%", delta_color="off")
     with col3:
         st.metric("Spot Price", f"${latest_snapshot.get('spot_price', 0):,.2f}")
     with col4:
@@ -174,6 +193,19 @@ if snapshots:
         
         basis_chart = create_basis_chart(snapshots_df)
         st.plotly_chart(basis_chart, use_container_width=True)
+        
+        # Side-aware basis, spreads and quote ages (durations arrive in nanoseconds)
+        quotes_df = snapshots_df.copy()
+        for column in ['spot_quote_age', 'future_quote_age']:
+            if column in quotes_df:
+                quotes_df[column + '_s'] = quotes_df[column] / 1e9
+        quote_columns = ['pair', 'entry_basis_percent', 'mid_basis_percent', 'exit_basis_percent',
+                         'spot_spread', 'future_spread', 'spot_quote_age_s', 'future_quote_age_s',
+                         'z_score', 'entry_band', 'exit_band']
+        st.dataframe(
+            quotes_df[[c for c in quote_columns if c in quotes_df]],
+            use_container_width=True
+        )
 else:
     st.info("No basis data available yet")
 